@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	feature "github.com/eclipse-kanto/software-update/internal"
 	"github.com/eclipse-kanto/software-update/internal/logger"
@@ -31,6 +32,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Check the configuration and exit, if requested.
+	if cfg.SelfCheck {
+		errs := cfg.Check()
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("configuration check passed")
+		os.Exit(0)
+	}
+
+	// Verify the staged artifacts against a manifest and exit, if requested.
+	if cfg.VerifyManifest != "" {
+		ok, err := cfg.VerifyStorage(os.Stdout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Initialize logs.
 	loggerOut := logger.SetupLogger(&cfg.LogConfig)
 	defer loggerOut.Close()
@@ -46,9 +73,15 @@ func main() {
 		logger.Errorf("failed to create script-based software updatable: %v", err)
 		os.Exit(1)
 	}
-	defer edgeCtr.Close() // not nil
-
 	chWaitCtrlC := make(chan os.Signal, 1)
-	signal.Notify(chWaitCtrlC, os.Interrupt)
-	<-chWaitCtrlC
+	signal.Notify(chWaitCtrlC, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-chWaitCtrlC:
+		edgeCtr.Close()
+	case err := <-edgeCtr.Fatal():
+		logger.Errorf("connection to MQTT broker cannot be restored: %v", err)
+		edgeCtr.Close()
+		loggerOut.Close()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,23 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package hawkbit
+
+// ArtifactBlocks represents the datatype for the checksums of the consecutive fixed-size blocks of an artifact.
+type ArtifactBlocks struct {
+	// Size of the blocks in bytes. The last block of the file can be shorter.
+	Size int `json:"size"`
+	// Algorithm of the block checksums.
+	Algorithm Hash `json:"algorithm"`
+	// Checksums of the blocks in the file order.
+	Checksums []string `json:"checksums"`
+}
@@ -21,6 +21,9 @@ type DownloadHandler func(update *SoftwareUpdateAction, softwareUpdatable *Softw
 // CancelHandler represents a callback handler that is called on each received cancel message.
 type CancelHandler func(update *SoftwareUpdateAction, softwareUpdatable *SoftwareUpdatable)
 
+// ProceedHandler represents a callback handler that is called on each received proceed message.
+type ProceedHandler func(update *SoftwareUpdateAction, softwareUpdatable *SoftwareUpdatable)
+
 // RemoveHandler represents a callback handler that is called on each received remove message.
 type RemoveHandler func(software *SoftwareRemoveAction, softwareUpdatable *SoftwareUpdatable)
 
@@ -20,6 +20,22 @@ type SoftwareArtifactAction struct {
 	Download map[Protocol]*Links `json:"download,omitempty"`
 	// Checksums to verify the proper download.
 	Checksums map[Hash]string `json:"checksums"`
+	// ChecksumsEncoding is the optional encoding of the checksums: hex or base64. It is detected by the checksum
+	// length, if not set.
+	ChecksumsEncoding string `json:"checksumsEncoding,omitempty"`
+	// DecompressedChecksums are the optional checksums of the gzip decompressed content of the file, encoded as
+	// the checksums. The file itself is kept compressed.
+	DecompressedChecksums map[Hash]string `json:"decompressedChecksums,omitempty"`
 	// Size of the file in bytes.
 	Size int `json:"size"`
+	// MinSize is the optional minimal size of the file in bytes, when the exact size is not known in advance.
+	MinSize int `json:"minSize,omitempty"`
+	// MaxSize is the optional maximal size of the file in bytes, when the exact size is not known in advance.
+	MaxSize int `json:"maxSize,omitempty"`
+	// ETag is the optional expected entity tag of the file, returned by the artifacts server.
+	ETag string `json:"etag,omitempty"`
+	// Blocks holds the optional checksums of the file blocks, verified as the blocks are downloaded.
+	Blocks *ArtifactBlocks `json:"blocks,omitempty"`
+	// Priority is the optional download priority of the artifact within its module, higher first.
+	Priority int `json:"priority,omitempty"`
 }
@@ -29,11 +29,13 @@ type SoftwareUpdatable struct {
 	featureID   string
 
 	//handlers
-	downloadHandler     DownloadHandler
-	installHandler      InstallHandler
-	cancelHandler       CancelHandler
-	removeHandler       RemoveHandler
-	cancelRemoveHandler CancelRemoveHandler
+	downloadHandler       DownloadHandler
+	installHandler        InstallHandler
+	cancelHandler         CancelHandler
+	cancelPropertyHandler CancelHandler
+	proceedHandler        ProceedHandler
+	removeHandler         RemoveHandler
+	cancelRemoveHandler   CancelRemoveHandler
 
 	statusLock sync.Mutex
 	status     *softwareUpdatableStatus
@@ -49,14 +51,16 @@ func NewSoftwareUpdatable(cfg *Configuration) (*SoftwareUpdatable, error) {
 		return nil, err
 	}
 	return &SoftwareUpdatable{
-		dittoClient:         cfg.dittoClient,
-		thingID:             cfg.thingID,
-		featureID:           cfg.featureID,
-		downloadHandler:     cfg.downloadHandler,
-		installHandler:      cfg.installHandler,
-		cancelHandler:       cfg.cancelHandler,
-		removeHandler:       cfg.removeHandler,
-		cancelRemoveHandler: cfg.cancelRemoveHandler,
+		dittoClient:           cfg.dittoClient,
+		thingID:               cfg.thingID,
+		featureID:             cfg.featureID,
+		downloadHandler:       cfg.downloadHandler,
+		installHandler:        cfg.installHandler,
+		cancelHandler:         cfg.cancelHandler,
+		cancelPropertyHandler: cfg.cancelPropertyHandler,
+		proceedHandler:        cfg.proceedHandler,
+		removeHandler:         cfg.removeHandler,
+		cancelRemoveHandler:   cfg.cancelRemoveHandler,
 		status: &softwareUpdatableStatus{
 			SoftwareModuleType:    cfg.softwareType,
 			InstalledDependencies: map[string]*DependencyDescription{},
@@ -132,6 +136,37 @@ func (su *SoftwareUpdatable) SetContextDependencies(deps ...*DependencyDescripti
 	return su.setProperty(suPropertyContextDependencies, su.status.ContextDependencies)
 }
 
+// LastOperation returns the last operation of underlying SoftwareUpdatable feature.
+func (su *SoftwareUpdatable) LastOperation() *OperationStatus {
+	// Do not allow multiple goroutes to access SU status!
+	su.statusLock.Lock()
+	defer su.statusLock.Unlock()
+
+	return su.status.LastOperation
+}
+
+// LastFailedOperation returns the last failed operation of underlying SoftwareUpdatable feature.
+func (su *SoftwareUpdatable) LastFailedOperation() *OperationStatus {
+	// Do not allow multiple goroutes to access SU status!
+	su.statusLock.Lock()
+	defer su.statusLock.Unlock()
+
+	return su.status.LastFailedOperation
+}
+
+// SetLastFailedOperation set the last failed operation of underlying SoftwareUpdatable feature,
+// without changing its last operation.
+// Note: Involking this function before the feature activation will change
+// its initial last failed operation value.
+func (su *SoftwareUpdatable) SetLastFailedOperation(os *OperationStatus) error {
+	// Do not allow multiple goroutes to access SU status!
+	su.statusLock.Lock()
+	defer su.statusLock.Unlock()
+
+	su.status.LastFailedOperation = os
+	return su.setProperty(suPropertyLastFailedOperation, su.status.LastFailedOperation)
+}
+
 // SetLastOperation set the last operation and last failed operation (if needed) of
 // underlying SoftwareUpdatable feature.
 // Note: Involking this function before the feature activation will change
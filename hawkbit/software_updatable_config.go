@@ -27,11 +27,13 @@ type Configuration struct {
 	softwareType string
 
 	//handlers
-	downloadHandler     DownloadHandler
-	installHandler      InstallHandler
-	cancelHandler       CancelHandler
-	removeHandler       RemoveHandler
-	cancelRemoveHandler CancelRemoveHandler
+	downloadHandler       DownloadHandler
+	installHandler        InstallHandler
+	cancelHandler         CancelHandler
+	cancelPropertyHandler CancelHandler
+	proceedHandler        ProceedHandler
+	removeHandler         RemoveHandler
+	cancelRemoveHandler   CancelRemoveHandler
 }
 
 // NewConfiguration returns a SoftwareUpdatable Configuration with the default feature ID.
@@ -81,6 +83,20 @@ func (cfg *Configuration) WithCancelHandler(handler CancelHandler) *Configuratio
 	return cfg
 }
 
+// WithCancelPropertyHandler configures the handler to be notified when the cancel property of the SoftwareUpdatable
+// is changed, e.g. by a backend, which cancels the operations with a twin property instead of a cancel message.
+// The property value is the correlation ID of the operation to cancel or a cancel request with it.
+func (cfg *Configuration) WithCancelPropertyHandler(handler CancelHandler) *Configuration {
+	cfg.cancelPropertyHandler = handler
+	return cfg
+}
+
+// WithProceedHandler configures the handler to be notified when the SoftwareUpdatable receive proceed request.
+func (cfg *Configuration) WithProceedHandler(handler ProceedHandler) *Configuration {
+	cfg.proceedHandler = handler
+	return cfg
+}
+
 // WithRemoveHandler configures the handler to be notified when the SoftwareUpdatable receive remove request.
 func (cfg *Configuration) WithRemoveHandler(handler RemoveHandler) *Configuration {
 	cfg.removeHandler = handler
@@ -41,6 +41,7 @@ func TestConfiguration(t *testing.T) {
 		WithDownloadHandler(fUpdate).
 		WithRemoveHandler(fRemove).
 		WithCancelHandler(fUpdate).
+		WithCancelPropertyHandler(fUpdate).
 		WithCancelRemoveHandler(fRemove)
 
 	if cfg.thingID.Namespace != thingNamespace {
@@ -70,6 +71,9 @@ func TestConfiguration(t *testing.T) {
 	if cfg.cancelHandler == nil {
 		t.Error("missing cancel handler")
 	}
+	if cfg.cancelPropertyHandler == nil {
+		t.Error("missing cancel property handler")
+	}
 	if cfg.cancelRemoveHandler == nil {
 		t.Error("missing cancel remove handler")
 	}
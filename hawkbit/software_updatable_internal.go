@@ -32,6 +32,9 @@ const (
 	suPropertyLastFailedOperation   = suPropertyStatus + "/lastFailedOperation"
 	suPropertyInstalledDependencies = suPropertyStatus + "/installedDependencies"
 	suPropertyContextDependencies   = suPropertyStatus + "/contextDependencies"
+
+	// suPropertyCancel is set by the backends, which cancel the operations with a twin property change.
+	suPropertyCancel = "cancel"
 )
 
 func (su *SoftwareUpdatable) messagesHandler(requestID string, msg *protocol.Envelope) {
@@ -53,6 +56,12 @@ func (su *SoftwareUpdatable) messagesHandlerRoute(requestID string, msg *protoco
 		} else if su.cancelHandler != nil &&
 			msg.Path == fmt.Sprintf("/features/%s/inbox/messages/cancel", su.featureID) {
 			su.processCancel(requestID, msg)
+		} else if su.cancelPropertyHandler != nil && isPropertyChange(msg.Topic) &&
+			msg.Path == fmt.Sprintf("/features/%s/properties/%s", su.featureID, suPropertyCancel) {
+			su.processCancelProperty(msg)
+		} else if su.proceedHandler != nil &&
+			msg.Path == fmt.Sprintf("/features/%s/inbox/messages/proceed", su.featureID) {
+			su.processProceed(requestID, msg)
 		} else if su.removeHandler != nil &&
 			msg.Path == fmt.Sprintf("/features/%s/inbox/messages/remove", su.featureID) {
 			su.processRemove(requestID, msg)
@@ -60,10 +69,12 @@ func (su *SoftwareUpdatable) messagesHandlerRoute(requestID string, msg *protoco
 			msg.Path == fmt.Sprintf("/features/%s/inbox/messages/cancelRemove", su.featureID) {
 			su.processCancelRemove(requestID, msg)
 		} else {
-			DEBUG.Println("There is no handler for a message - skipping processing")
+			// Messages to the other features of the thing are handled by other device agents.
+			DEBUG.Printf("There is no handler for a message (%s) - skipping processing\n", msg.Path)
 		}
 	} else {
-		DEBUG.Println("Message to unknown Thing - skipping processing")
+		WARN.Printf("[%s] Message to unknown Thing (%s:%s) - rejecting processing\n",
+			requestID, msg.Topic.Namespace, msg.Topic.EntityID)
 	}
 }
 
@@ -88,6 +99,38 @@ func (su *SoftwareUpdatable) processCancel(requestID string, msg *protocol.Envel
 	}
 }
 
+// processCancelProperty processes the change of the cancel property, set to the correlation ID of the operation or
+// to a cancel request with it. The twin property changes are not replied and the property changes without
+// correlation ID, e.g. on its reset, are skipped.
+func (su *SoftwareUpdatable) processCancelProperty(msg *protocol.Envelope) {
+	ua := &SoftwareUpdateAction{}
+	if cid, ok := msg.Value.(string); ok {
+		ua.CorrelationID = cid
+	} else {
+		bytes, err := json.Marshal(msg.Value)
+		if err == nil {
+			err = json.Unmarshal(bytes, ua)
+		}
+		if err != nil {
+			ERROR.Println(fmt.Errorf("failed to parse cancel property value: %v", err))
+			return
+		}
+	}
+	if ua.CorrelationID == "" {
+		DEBUG.Printf("Cancel property without correlation id - skipping processing")
+		return
+	}
+	DEBUG.Printf("Cancel operation with id: %s, requested by property change", ua.CorrelationID)
+	su.cancelPropertyHandler(ua, su)
+}
+
+func (su *SoftwareUpdatable) processProceed(requestID string, msg *protocol.Envelope) {
+	ua := &SoftwareUpdateAction{}
+	if su.prepare(requestID, msg, "proceed", ua) {
+		su.proceedHandler(ua, su)
+	}
+}
+
 func (su *SoftwareUpdatable) processRemove(requestID string, msg *protocol.Envelope) {
 	ra := &SoftwareRemoveAction{}
 	if su.prepare(requestID, msg, "remove", ra) {
@@ -104,26 +147,68 @@ func (su *SoftwareUpdatable) processCancelRemove(requestID string, msg *protocol
 
 func (su *SoftwareUpdatable) prepare(requestID string, msg *protocol.Envelope, operation string, to interface{}) bool {
 	DEBUG.Printf("Parse message value: %v", msg.Value)
-	respReq := msg.Headers.IsResponseRequired()
+	respReq := isResponseRequired(msg.Headers)
+	cid := correlationID(msg.Headers)
 	bytes, err := json.Marshal(msg.Value)
 	if err == nil {
 		err = json.Unmarshal(bytes, to)
 	}
 	if err == nil {
 		if respReq {
-			su.reply(requestID, msg.Headers.CorrelationID(), operation, 204, nil)
+			su.reply(requestID, cid, operation, 204, nil)
 		}
-		DEBUG.Printf("Start %s operation with id: %s", operation, msg.Headers.CorrelationID())
+		DEBUG.Printf("Start %s operation with id: %s", operation, cid)
 		return true
 	}
 	supErr := newMessagesParameterInvalidError(err.Error())
 	ERROR.Println(fmt.Errorf("failed to parse message value: %v", supErr))
 	if respReq {
-		su.reply(requestID, msg.Headers.CorrelationID(), operation, supErr.Status, supErr)
+		su.reply(requestID, cid, operation, supErr.Status, supErr)
 	}
 	return false
 }
 
+// isPropertyChange reports if the message is a twin command or event, which creates or modifies a property.
+func isPropertyChange(topic *protocol.Topic) bool {
+	if topic == nil || topic.Channel != protocol.ChannelTwin {
+		return false
+	}
+	switch topic.Criterion {
+	case protocol.CriterionCommands:
+		return topic.Action == protocol.ActionCreate || topic.Action == protocol.ActionModify
+	case protocol.CriterionEvents:
+		return topic.Action == protocol.ActionCreated || topic.Action == protocol.ActionModified
+	default:
+		return false
+	}
+}
+
+// isResponseRequired reports if the message sender waits for a response. As defined by Ditto,
+// a response is required, unless explicitly disabled with the response-required header.
+func isResponseRequired(headers *protocol.Headers) bool {
+	if headers == nil {
+		return true
+	}
+	switch v := headers.Values[protocol.HeaderResponseRequired].(type) {
+	case bool:
+		return v
+	case string:
+		required, err := strconv.ParseBool(v)
+		return err != nil || required
+	default:
+		return true
+	}
+}
+
+// correlationID returns the correlation-id header of the message, which has to be used in the response.
+func correlationID(headers *protocol.Headers) string {
+	if headers == nil {
+		return ""
+	}
+	cid, _ := headers.Values[protocol.HeaderCorrelationID].(string)
+	return cid
+}
+
 func (su *SoftwareUpdatable) reply(requestID string, cid string, cmd string, status int, payload interface{}) {
 	bHeadersOpts := [3]protocol.HeaderOpt{protocol.WithCorrelationID(cid), protocol.WithResponseRequired(false)}
 	headerOpts := bHeadersOpts[:2]
@@ -94,6 +94,11 @@ func TestSoftwareUpdatable(t *testing.T) {
 		t.Fatalf("last failed operation mishmash: %v != %v", status.LastFailedOperation, fop)
 	}
 
+	// 5.5 Validate last operation and last failed operation getters.
+	if su.LastOperation() != fop || su.LastFailedOperation() != fop {
+		t.Fatalf("last operations mishmash: %v, %v != %v", su.LastOperation(), su.LastFailedOperation(), fop)
+	}
+
 	// 6. Test software updatable second activation.
 	if err := su.Activate(); err != nil {
 		t.Fatalf("unexpected error during the second activation")
@@ -139,6 +144,18 @@ func TestSoftwareUpdatable(t *testing.T) {
 		t.Fatalf("last operation mishmash: %v != %v", ops, fop)
 	}
 
+	// 9.3 Test last failed operation modification without the last operation.
+	if err := su.SetLastFailedOperation(sop); err != nil {
+		t.Fatalf("unexpected error during last failed operation modification")
+	}
+	ops = getOperationStatus(t, mc.value(t))
+	if !reflect.DeepEqual(ops, sop) {
+		t.Fatalf("last failed operation mishmash: %v != %v", ops, sop)
+	}
+	if su.LastOperation() != fop {
+		t.Fatalf("last operation mishmash: %v != %v", su.LastOperation(), fop)
+	}
+
 	// 10. Test SetLastOperation for error.
 	mc.err = errors.New("test")
 	if err := su.SetLastOperation(fop); err == nil {
@@ -154,7 +171,7 @@ func TestSoftwareUpdatable(t *testing.T) {
 	}
 }
 
-// TestUpdateHandler tests the install, download and cancel handlers.
+// TestUpdateHandler tests the install, download, cancel, cancel property and proceed handlers.
 func TestUpdateHandler(t *testing.T) {
 	// Prepare update handler data.
 	actual := make(chan interface{}, 1)
@@ -187,6 +204,34 @@ func TestUpdateHandler(t *testing.T) {
 		su.messagesHandler(rid, msg.Inbox("cancel").Envelope(cid))
 		validateHandler(t, actual, expected)
 	})
+
+	// 4. Test proceed handler.
+	t.Run("proceed", func(t *testing.T) {
+		su, _ := mock(t, NewConfiguration().WithProceedHandler(handler))
+		su.messagesHandler(rid, msg.Inbox("proceed").Envelope(cid))
+		validateHandler(t, actual, expected)
+	})
+
+	// 5. Test cancel property handler.
+	t.Run("cancelProperty", func(t *testing.T) {
+		su, _ := mock(t, NewConfiguration().WithCancelPropertyHandler(handler))
+		expected := &SoftwareUpdateAction{CorrelationID: "cancel-id"}
+		su.messagesHandler(rid, things.NewCommand(tid).FeatureProperty(suDefinitionName, suPropertyCancel).
+			Modify(expected).Twin().Envelope())
+		validateHandler(t, actual, expected)
+		su.messagesHandler(rid, things.NewEvent(tid).FeatureProperty(suDefinitionName, suPropertyCancel).
+			Modified("cancel-id").Twin().Envelope())
+		validateHandler(t, actual, expected)
+
+		// Property resets, live commands and the other properties are skipped.
+		su.messagesHandler(rid, things.NewCommand(tid).FeatureProperty(suDefinitionName, suPropertyCancel).
+			Modify("").Twin().Envelope())
+		su.messagesHandler(rid, things.NewCommand(tid).FeatureProperty(suDefinitionName, suPropertyCancel).
+			Modify(expected).Live().Envelope())
+		su.messagesHandler(rid, things.NewCommand(tid).FeatureProperty(suDefinitionName, suPropertyStatus).
+			Modify(expected).Twin().Envelope())
+		validateHandlerTimeout(t, actual)
+	})
 }
 
 // TestRemoveHandler tests the remove and cancelRemove handlers.
@@ -248,6 +293,105 @@ func TestRemoveHandler(t *testing.T) {
 	})
 }
 
+// TestMessageTarget tests that only the messages to the SoftwareUpdatable thing and feature are processed.
+func TestMessageTarget(t *testing.T) {
+	// Prepare install handler data.
+	actual := make(chan interface{}, 1)
+	handler := func(update *SoftwareUpdateAction, softwareUpdatable *SoftwareUpdatable) {
+		actual <- update
+	}
+	rid := "request-id"
+	cid := protocol.WithCorrelationID("correlation-id")
+	expected := &SoftwareUpdateAction{}
+	su, _ := mock(t, NewConfiguration().WithInstallHandler(handler))
+
+	// 1. Test message to the SoftwareUpdatable thing and feature.
+	t.Run("targeted", func(t *testing.T) {
+		msg := things.NewMessage(model.NewNamespacedID(topicNamespace, topicEntryID)).Feature(suDefinitionName)
+		su.messagesHandler(rid, msg.WithPayload(expected).Inbox("install").Envelope(cid))
+		validateHandler(t, actual, expected)
+	})
+
+	// 2. Test message to another thing.
+	t.Run("wrongThing", func(t *testing.T) {
+		msg := things.NewMessage(model.NewNamespacedID(topicNamespace, "other")).Feature(suDefinitionName)
+		su.messagesHandler(rid, msg.WithPayload(expected).Inbox("install").Envelope(cid))
+		validateHandlerTimeout(t, actual)
+	})
+
+	// 3. Test message to another feature of the thing.
+	t.Run("wrongFeature", func(t *testing.T) {
+		msg := things.NewMessage(model.NewNamespacedID(topicNamespace, topicEntryID)).Feature("Other")
+		su.messagesHandler(rid, msg.WithPayload(expected).Inbox("install").Envelope(cid))
+		validateHandlerTimeout(t, actual)
+	})
+}
+
+// TestMessageResponse tests that the operation messages are acknowledged with correlated responses.
+func TestMessageResponse(t *testing.T) {
+	// Prepare install handler data.
+	actual := make(chan interface{}, 1)
+	handler := func(update *SoftwareUpdateAction, softwareUpdatable *SoftwareUpdatable) {
+		actual <- update
+	}
+	rid := "request-id"
+	expected := &SoftwareUpdateAction{}
+	msg := things.NewMessage(model.NewNamespacedID(topicNamespace, topicEntryID)).Feature(suDefinitionName).
+		WithPayload(expected).Inbox("install")
+
+	// 1. Test response, when explicitly required.
+	t.Run("required", func(t *testing.T) {
+		su, mc := mock(t, NewConfiguration().WithInstallHandler(handler))
+		su.messagesHandler(rid, msg.Envelope(protocol.WithCorrelationID(cid), protocol.WithResponseRequired(true)))
+		validateHandler(t, actual, expected)
+		validateResponse(t, mc, "command///res/request-id/204", 204)
+	})
+
+	// 2. Test response, when not specified if required.
+	t.Run("default", func(t *testing.T) {
+		su, mc := mock(t, NewConfiguration().WithInstallHandler(handler))
+		su.messagesHandler(rid, msg.Envelope(protocol.WithCorrelationID(cid)))
+		validateHandler(t, actual, expected)
+		validateResponse(t, mc, "command///res/request-id/204", 204)
+	})
+
+	// 3. Test no response, when not required.
+	t.Run("notRequired", func(t *testing.T) {
+		su, mc := mock(t, NewConfiguration().WithInstallHandler(handler))
+		su.messagesHandler(rid, msg.Envelope(protocol.WithCorrelationID(cid), protocol.WithResponseRequired(false)))
+		validateHandler(t, actual, expected)
+		if len(mc.topics) > 0 {
+			t.Fatalf("unexpected response published to: %s", <-mc.topics)
+		}
+	})
+
+	// 4. Test error response, when the message payload is invalid.
+	t.Run("wrongPayload", func(t *testing.T) {
+		su, mc := mock(t, NewConfiguration().WithInstallHandler(handler))
+		su.messagesHandler(rid, things.NewMessage(model.NewNamespacedID(topicNamespace, topicEntryID)).
+			Feature(suDefinitionName).WithPayload("wrong").Inbox("install").Envelope(protocol.WithCorrelationID(cid)))
+		validateResponse(t, mc, "command///res/request-id/400", 400)
+		validateHandlerTimeout(t, actual)
+	})
+}
+
+// validateResponse validates the published install response topic, status and correlation.
+func validateResponse(t *testing.T, mc *mockedClient, expectedTopic string, expectedStatus int) {
+	topic, env := mc.response(t)
+	if topic != expectedTopic {
+		t.Fatalf("response topic mishmash: %s != %s", topic, expectedTopic)
+	}
+	if env.Status != expectedStatus {
+		t.Fatalf("response status mishmash: %d != %d", env.Status, expectedStatus)
+	}
+	if env.Headers.CorrelationID() != cid {
+		t.Fatalf("response correlation-id mishmash: %s != %s", env.Headers.CorrelationID(), cid)
+	}
+	if env.Path != "/features/SoftwareUpdatable/outbox/messages/install" {
+		t.Fatalf("unexpected response path: %s", env.Path)
+	}
+}
+
 // validateHandlerTimeout validates a handler with expected timeout.
 func validateHandlerTimeout(t *testing.T, value chan interface{}) {
 	select {
@@ -310,7 +454,7 @@ func convert(t *testing.T, v interface{}, to interface{}) {
 // mock create new SoftwareUpdatable with mocked MQTT clients.
 func mock(t *testing.T, cfg *Configuration) (*SoftwareUpdatable, *mockedClient) {
 	// Create mocked Ditto and MQTT clients.
-	mc := &mockedClient{payload: make(chan interface{}, 2)}
+	mc := &mockedClient{payload: make(chan interface{}, 2), topics: make(chan string, 2)}
 	dc, _ := ditto.NewClientMqtt(mc, nil)
 
 	// Create hawkBit SoftwareUpdatable feature configuration.
@@ -333,6 +477,23 @@ func mock(t *testing.T, cfg *Configuration) (*SoftwareUpdatable, *mockedClient)
 type mockedClient struct {
 	err     error
 	payload chan interface{}
+	topics  chan string
+}
+
+// response returns the published message response or waits 5sec for it.
+func (client *mockedClient) response(t *testing.T) (string, *protocol.Envelope) {
+	select {
+	case topic := <-client.topics:
+		env := &protocol.Envelope{}
+		if err := json.Unmarshal((<-client.payload).([]byte), env); err != nil {
+			t.Fatalf("unexpected error during response unmarshal: %v", err)
+		}
+		return topic, env
+	case <-time.After(5 * time.Second):
+		// Fail after the timeout.
+		t.Fatal("failed to retrieve published response")
+	}
+	return "", nil
 }
 
 // value returns last payload value or waits 10sec for new payload.
@@ -387,6 +548,10 @@ func (client *mockedClient) Disconnect(quiesce uint) {
 
 // Publish returns finished token and set client topic and payload.
 func (client *mockedClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	select {
+	case client.topics <- topic:
+	default:
+	}
 	client.payload <- payload
 	return &mockedToken{err: client.err}
 }
@@ -0,0 +1,239 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// auditLogName is the name of the audit log of the completed operations in the storage location.
+const auditLogName = "audit.log"
+
+// auditLog is the append-only log of the completed download and install operations, persisted on the device
+// as JSON lines. It keeps at most limit entries, dropping the oldest ones.
+type auditLog struct {
+	lock  sync.Mutex
+	path  string
+	limit int
+}
+
+// auditEntry is the JSON representation of a completed operation in the audit log.
+type auditEntry struct {
+	Operation       string         `json:"operation"`
+	CorrelationID   string         `json:"correlationId"`
+	Status          hawkbit.Status `json:"status"`
+	Started         time.Time      `json:"started"`
+	Finished        time.Time      `json:"finished"`
+	DurationMs      int64          `json:"durationMs"`
+	SoftwareModules []*auditModule `json:"softwareModules"`
+}
+
+// auditModule is the JSON representation of the final status of an operation module and its artifacts.
+type auditModule struct {
+	Name       string           `json:"name"`
+	Version    string           `json:"version"`
+	Status     hawkbit.Status   `json:"status"`
+	StatusCode string           `json:"statusCode,omitempty"`
+	Message    string           `json:"message,omitempty"`
+	Artifacts  []*auditArtifact `json:"artifacts,omitempty"`
+}
+
+// auditArtifact is the JSON representation of a module artifact and its digests.
+type auditArtifact struct {
+	FileName string          `json:"fileName"`
+	Size     int             `json:"size"`
+	Digests  []*storage.Hash `json:"digests,omitempty"`
+}
+
+// newAuditLog returns the audit log in the storage location, or nil if no entries are kept.
+func newAuditLog(location string, limit int) *auditLog {
+	if limit <= 0 {
+		return nil
+	}
+	return &auditLog{path: filepath.Join(location, auditLogName), limit: limit}
+}
+
+// newEntry returns a new entry of the operation, started now, or nil if the audit log is disabled.
+// The operations, resumed after restart, are started again.
+func (l *auditLog) newEntry(operation string, cid string) *auditEntry {
+	if l == nil {
+		return nil
+	}
+	return &auditEntry{Operation: operation, CorrelationID: cid, Started: clock.Now()}
+}
+
+// add adds the final status of an operation module and the digests of its artifacts to the entry.
+func (e *auditEntry) add(module *storage.Module, status *hawkbit.OperationStatus) {
+	if e == nil {
+		return
+	}
+	am := &auditModule{Name: module.Name, Version: module.Version}
+	if status != nil {
+		am.Status, am.StatusCode, am.Message = status.Status, status.StatusCode, status.Message
+	}
+	for _, sa := range module.Artifacts {
+		aa := &auditArtifact{FileName: sa.FileName, Size: sa.Size}
+		if sa.HashValue != "" {
+			aa.Digests = append(aa.Digests, &storage.Hash{Type: sa.HashType, Value: sa.HashValue})
+		}
+		aa.Digests = append(aa.Digests, sa.Hashes...)
+		am.Artifacts = append(am.Artifacts, aa)
+	}
+	e.SoftwareModules = append(e.SoftwareModules, am)
+}
+
+// auditStatus returns the operation status of the modules: failed, if any module failed, canceled,
+// if any module is canceled, and successful otherwise.
+func auditStatus(modules []*auditModule) hawkbit.Status {
+	status := hawkbit.StatusFinishedSuccess
+	for _, am := range modules {
+		switch am.Status {
+		case hawkbit.StatusFinishedSuccess:
+		case hawkbit.StatusFinishedCanceled:
+			status = hawkbit.StatusFinishedCanceled
+		default:
+			return hawkbit.StatusFinishedError
+		}
+	}
+	return status
+}
+
+// write completes the entry now and appends it to the audit log, flushed to the storage before returning.
+// Once the limit is reached, the audit log is rewritten to a temporary file without its oldest entries
+// and renamed, so that it is never left incomplete. Failed writes are only logged.
+func (l *auditLog) write(e *auditEntry) {
+	if l == nil || e == nil {
+		return
+	}
+	e.Finished = clock.Now()
+	e.DurationMs = e.Finished.Sub(e.Started).Milliseconds()
+	e.Status = auditStatus(e.SoftwareModules)
+	line, err := json.Marshal(e)
+	if err != nil {
+		logger.Errorf("failed to create audit log entry of operation %s: %v", e.CorrelationID, err)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	lines, torn, err := l.read()
+	if err != nil {
+		logger.Errorf("failed to read audit log %s: %v", l.path, err)
+		return
+	}
+	if !torn && len(lines) < l.limit {
+		err = appendSync(l.path, append(line, '\n'))
+	} else {
+		if len(lines) >= l.limit {
+			lines = lines[len(lines)-l.limit+1:]
+		}
+		var data bytes.Buffer
+		for _, old := range lines {
+			data.Write(old)
+			data.WriteByte('\n')
+		}
+		data.Write(line)
+		data.WriteByte('\n')
+		err = replaceSync(l.path, data.Bytes())
+	}
+	if err != nil {
+		logger.Errorf("failed to write audit log %s: %v", l.path, err)
+	}
+}
+
+// entries returns the audit log entries of the given correlation identifier, or all of them if not set,
+// from the oldest to the newest.
+func (l *auditLog) entries(cid string) ([]*auditEntry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	lines, _, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	entries := []*auditEntry{}
+	for _, line := range lines {
+		e := &auditEntry{}
+		if err := json.Unmarshal(line, e); err == nil && (cid == "" || e.CorrelationID == cid) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// read returns the complete lines of the audit log. A line, left incomplete by a crash while appending it,
+// is skipped and reported as torn, so that the audit log is rewritten without it on the next write.
+func (l *auditLog) read() (lines [][]byte, torn bool, err error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); json.Valid(line) {
+			lines = append(lines, append([]byte(nil), line...))
+		} else {
+			torn = true
+		}
+	}
+	return lines, torn, scanner.Err()
+}
+
+// appendSync appends the data to the file and flushes it to the storage.
+func appendSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// replaceSync replaces the file with the data, written and flushed to a temporary file first.
+func replaceSync(name string, data []byte) error {
+	tmp := name + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestAuditLog tests that the audit log entries are persisted, pruned at the entries limit and recovered
+// after an incomplete write.
+func TestAuditLog(t *testing.T) {
+	fake := useFakeClock(t)
+	dir := t.TempDir()
+	if newAuditLog(dir, 0) != nil {
+		t.Fatal("audit log is expected to be disabled without entries limit")
+	}
+	audit := newAuditLog(dir, 2)
+	module := &storage.Module{Name: testModuleName, Version: testModuleVersion, Artifacts: []*storage.Artifact{{
+		FileName: "app.bin", Size: 42, HashType: "SHA256", HashValue: "sha256-value",
+		Hashes: []*storage.Hash{{Type: "MD5", Value: "md5-value"}},
+	}}}
+	smID := &hawkbit.SoftwareModuleID{Name: testModuleName, Version: testModuleVersion}
+
+	write := func(cid string, status hawkbit.Status) {
+		entry := audit.newEntry("install", cid)
+		<-fake.After(3 * time.Second)
+		entry.add(module, hawkbit.NewOperationStatusUpdate(cid, status, smID).WithStatusCode(codeDownload))
+		audit.write(entry)
+	}
+	write("cid-1", hawkbit.StatusFinishedSuccess)
+	write("cid-2", hawkbit.StatusFinishedError)
+	assertAuditLog(t, audit, "", "cid-1", "cid-2")
+	entries := assertAuditLog(t, audit, "cid-2", "cid-2")
+	if e := entries[0]; e.Operation != "install" || e.Status != hawkbit.StatusFinishedError || e.DurationMs != 3000 ||
+		!e.Finished.Equal(e.Started.Add(3*time.Second)) || len(e.SoftwareModules) != 1 {
+		t.Fatalf("unexpected audit log entry: %+v", e)
+	}
+	expected := &auditModule{Name: testModuleName, Version: testModuleVersion, Status: hawkbit.StatusFinishedError,
+		StatusCode: codeDownload, Artifacts: []*auditArtifact{{FileName: "app.bin", Size: 42, Digests: []*storage.Hash{
+			{Type: "SHA256", Value: "sha256-value"}, {Type: "MD5", Value: "md5-value"}}}}}
+	if !reflect.DeepEqual(entries[0].SoftwareModules[0], expected) {
+		t.Fatalf("unexpected audit log module: %+v != %+v", entries[0].SoftwareModules[0], expected)
+	}
+
+	// Prune the oldest entry at the entries limit.
+	write("cid-3", hawkbit.StatusFinishedCanceled)
+	assertAuditLog(t, audit, "", "cid-2", "cid-3")
+
+	// Drop the entry, left incomplete by a crash, on the next write.
+	file, err := os.OpenFile(audit.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	file.WriteString(`{"operation":"download","correlationId":"cid-torn"`)
+	file.Close()
+	assertAuditLog(t, audit, "", "cid-2", "cid-3")
+	write("cid-4", hawkbit.StatusFinishedSuccess)
+	assertAuditLog(t, audit, "", "cid-3", "cid-4")
+	data, err := os.ReadFile(audit.path)
+	if err != nil || strings.Contains(string(data), "cid-torn") || strings.Count(string(data), "\n") != 2 {
+		t.Fatalf("unexpected audit log content: %s, %v", data, err)
+	}
+}
+
+// TestAuditLogOperations tests that the audit log entries are written for the successful and the failed
+// operations and are queried on the diagnostics endpoint.
+func TestAuditLogOperations(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-audit-log", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.audit = newAuditLog(dir, 10)
+	feature.installCommand = &command{cmd: "/bin/sh", args: []string{"-c", "true"}}
+
+	// 1. Install a module successfully.
+	path, hash := createLocalArtifact(t, tmpDir, "app.bin", "application")
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, path), "app.bin", hash, len("application")),
+	}, "*")
+	sua.CorrelationID = "test-installed"
+	feature.installHandler(sua, feature.su)
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("expected install operation to succeed: %v", lo)
+	}
+	waitForAuditLog(t, feature.audit, 1)
+
+	// 2. Fail to download a corrupted module.
+	_, corrupted := createLocalArtifact(t, tmpDir, "corrupted.bin", "corrupted")
+	sua = prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, path), "app.bin", corrupted, len("application")),
+	}, "*")
+	sua.CorrelationID = "test-failed"
+	feature.downloadHandler(sua, feature.su)
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedError) {
+		t.Fatalf("expected download operation to fail: %v", lo)
+	}
+	waitForAuditLog(t, feature.audit, 2)
+
+	d, err := newDiagnostics("127.0.0.1:0", feature)
+	if err != nil {
+		t.Fatalf("failed to start diagnostics endpoint: %v", err)
+	}
+	defer d.close()
+	url := "http://" + d.listener.Addr().String() + "/audit"
+
+	var entries []*auditEntry
+	if err := json.Unmarshal([]byte(httpGet(t, url, 200)), &entries); err != nil || len(entries) != 2 {
+		t.Fatalf("unexpected audit log entries: %v, %v", entries, err)
+	}
+	for i, expected := range []struct {
+		operation string
+		cid       string
+		status    hawkbit.Status
+		digest    string
+	}{
+		{"install", "test-installed", hawkbit.StatusFinishedSuccess, hash},
+		{"download", "test-failed", hawkbit.StatusFinishedError, corrupted},
+	} {
+		e := entries[i]
+		if e.Operation != expected.operation || e.CorrelationID != expected.cid || e.Status != expected.status ||
+			e.Started.IsZero() || e.Finished.Before(e.Started) || len(e.SoftwareModules) != 1 {
+			t.Fatalf("unexpected %s audit log entry: %+v", expected.cid, e)
+		}
+		m := e.SoftwareModules[0]
+		if m.Name != "test" || m.Version != "1.0.0" || m.Status != expected.status || len(m.Artifacts) != 1 ||
+			m.Artifacts[0].FileName != "app.bin" || len(m.Artifacts[0].Digests) != 1 ||
+			m.Artifacts[0].Digests[0].Value != expected.digest {
+			t.Fatalf("unexpected %s audit log module: %+v", expected.cid, m)
+		}
+	}
+	if m := entries[1].SoftwareModules[0]; m.StatusCode != codeDownloadChecksumMismatch {
+		t.Fatalf("unexpected failed module status code: %s", m.StatusCode)
+	}
+
+	// Query the entries of an operation.
+	if err := json.Unmarshal([]byte(httpGet(t, url+"?correlationId=test-failed", 200)), &entries); err != nil ||
+		len(entries) != 1 || entries[0].CorrelationID != "test-failed" {
+		t.Fatalf("unexpected filtered audit log entries: %v, %v", entries, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, auditLogName)); err != nil {
+		t.Fatalf("audit log is not persisted in the storage location: %v", err)
+	}
+	feature.audit = nil
+	httpGet(t, url, 404)
+}
+
+func assertAuditLog(t *testing.T, audit *auditLog, cid string, expected ...string) []*auditEntry {
+	t.Helper()
+
+	entries, err := audit.entries(cid)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	var actual []string
+	for _, e := range entries {
+		actual = append(actual, e.CorrelationID)
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected audit log entries: %v != %v", actual, expected)
+	}
+	return entries
+}
+
+func waitForAuditLog(t *testing.T, audit *auditLog, count int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if entries, err := audit.entries(""); err == nil && len(entries) == count {
+			return
+		}
+	}
+	t.Fatalf("audit log does not have %d entries", count)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// clock provides the time of the reconnect delays, replaced in tests to assert them without waiting.
+var clock = storage.SystemClock
+
+// backoff calculates the delays between reconnect attempts. The delay is doubled on each attempt up to
+// the maximum interval and randomized with jitter, so that many clients do not reconnect at the same time.
+type backoff struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	attempt     int
+}
+
+// next returns the delay before the next attempt, between the half and the whole exponential interval.
+func (b *backoff) next() time.Duration {
+	delay := b.maxInterval
+	if b.attempt < 32 {
+		if d := b.interval << b.attempt; d > 0 && d < b.maxInterval {
+			delay = d
+		}
+	}
+	b.attempt++
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBackoff tests that the reconnect delays grow exponentially with jitter up to the maximum interval.
+func TestBackoff(t *testing.T) {
+	b := &backoff{interval: time.Second, maxInterval: 10 * time.Second}
+	expected := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}
+	for i, interval := range expected {
+		if delay := b.next(); delay < interval/2 || delay > interval {
+			t.Errorf("unexpected delay of attempt %d: %v not in [%v, %v]", i+1, delay, interval/2, interval)
+		}
+	}
+
+	// Do not overflow on many attempts.
+	b.attempt = 100
+	if delay := b.next(); delay < 5*time.Second || delay > 10*time.Second {
+		t.Errorf("unexpected delay after many attempts: %v", delay)
+	}
+}
@@ -15,18 +15,27 @@ package feature
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
 )
 
+// outputDrainTimeout is the maximal time to forward the output of the command children, after the command exits.
+const outputDrainTimeout = time.Second
+
 // command is custom type of command name and arguments of command in order to add json unmarshal support
 type command struct {
-	cmd  string
-	args []string
+	cmd     string
+	args    []string
+	allowed commandAllowList
 }
 
 // String is representation of command as combination of name and arguments of the command
@@ -56,7 +65,18 @@ func (i *command) setCommand(value string) {
 	}
 }
 
-func (i *command) run(dir string, def string) (err error) {
+// run executes the command in the given directory. Closing the cancel channel terminates the command:
+// it is signaled with its children to terminate and killed, if still running after the grace period.
+func (i *command) run(dir string, def string, cancel chan struct{}, gracePeriod time.Duration) error {
+	return i.runWithInput(dir, def, nil, nil, cancel, gracePeriod)
+}
+
+// runWithInput executes the command in the given directory, like run, with the data written by the input
+// function as its standard input. The standard input is closed, when the input function succeeds. Otherwise,
+// the command is killed with its children, before they read the end of the truncated input, and the input error
+// is returned. The standard output and error of the command are written to output, if set, until it exits.
+func (i *command) runWithInput(dir string, def string, input func(w io.Writer) error, output io.Writer,
+	cancel chan struct{}, gracePeriod time.Duration) (err error) {
 	script := i.cmd
 	args := i.args
 	if script == "" {
@@ -72,12 +92,129 @@ func (i *command) run(dir string, def string) (err error) {
 	}
 
 	c := exec.Command(script, args...)
-	c.Dir, err = filepath.Abs(dir)
-	if err == nil {
-		logger.Infof("Execute [%s] in directory: %v\n", c.Args, c.Dir)
-		err = c.Run()
+	setProcessGroup(c)
+	if c.Dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+	if err = i.allowed.check(script, args, c.Dir); err != nil {
+		logger.Errorf("Reject [%s] in directory %v: %v", c.Args, c.Dir, err)
+		return err
+	}
+	select {
+	case <-cancel:
+		return storage.ErrCanceled
+	default:
+	}
+	var out *outputPipe
+	if output != nil {
+		if out, err = newOutputPipe(output); err != nil {
+			return err
+		}
+		defer out.close()
+		c.Stdout, c.Stderr = out.w, out.w
+	}
+	var stdin io.WriteCloser
+	if input != nil {
+		if stdin, err = c.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	logger.Infof("Execute [%s] in directory: %v\n", c.Args, c.Dir)
+	if err = c.Start(); err != nil {
+		return err
+	}
+	if out != nil {
+		out.forward()
+	}
+	streamed := make(chan error, 1)
+	if input != nil {
+		go func() {
+			err := input(stdin)
+			if err == nil {
+				err = stdin.Close()
+			} else if err != storage.ErrCanceled && err != storage.ErrCancel {
+				logger.Infof("Kill [%s], its input failed: %v", c.Args, err)
+				if err := signalGroup(c, syscall.SIGKILL); err != nil {
+					logger.Errorf("failed to kill [%s]: %v", c.Args, err)
+				}
+			}
+			streamed <- err
+		}()
+	} else {
+		streamed <- nil
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- c.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+		if inErr := <-streamed; inErr != nil {
+			return inErr
+		}
+		return err
+	case <-cancel:
+	}
+	logger.Infof("Terminate [%s] with grace period of %v", c.Args, gracePeriod)
+	if err = signalGroup(c, syscall.SIGTERM); err != nil { // Not supported on Windows, kill the process.
+		logger.Debugf("failed to signal [%s] to terminate: %v", c.Args, err)
+		gracePeriod = 0
+	}
+	select {
+	case <-exited:
+	case <-time.After(gracePeriod):
+		logger.Infof("Kill [%s], still running after the grace period", c.Args)
+		if err = signalGroup(c, syscall.SIGKILL); err != nil {
+			logger.Errorf("failed to kill [%s]: %v", c.Args, err)
+		}
+		<-exited
+	}
+	return storage.ErrCanceled
+}
+
+// outputPipe forwards the standard output and error of a command to a writer. Unlike the pipes of exec.Cmd,
+// it is not waited for the background children of the command, which inherit it, e.g. started daemons:
+// their output is dropped, once the command exits and its output is drained.
+type outputPipe struct {
+	r, w   *os.File
+	output io.Writer
+	copied chan struct{}
+}
+
+func newOutputPipe(output io.Writer) (*outputPipe, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &outputPipe{r: r, w: w, output: output}, nil
+}
+
+// forward forwards the output of the started command, closing the write end inherited by the command.
+func (p *outputPipe) forward() {
+	p.w.Close()
+	p.copied = make(chan struct{})
+	go func() {
+		defer close(p.copied)
+		io.Copy(p.output, p.r)
+	}()
+}
+
+// close closes the pipe of the exited command. The output is forwarded until the pipe is closed by all
+// command children or until the drain timeout elapses.
+func (p *outputPipe) close() {
+	p.w.Close()
+	if p.copied != nil {
+		select {
+		case <-p.copied:
+		case <-time.After(outputDrainTimeout):
+			logger.Debugf("dropping the output of the running command children")
+		}
+	}
+	p.r.Close()
+	if p.copied != nil {
+		<-p.copied
 	}
-	return err
 }
 
 // UnmarshalJSON unmarshal command type
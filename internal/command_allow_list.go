@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errCommandNotAllowed is returned, when the executable of a command is not allowed by the command allow list.
+var errCommandNotAllowed = errors.New("command is not allowed")
+
+// commandAllowList restricts the executables of the install, scan, health, version, continue and rollback commands to the listed
+// absolute paths of executables and of directories, allowing all executables in them and their subdirectories.
+// Shell scripts, run with /bin/sh, must be allowed as well: the allowed shells are run only with an allowed script
+// as their first argument, e.g. not with commands given by the -c option. Symbolic links are resolved, before the
+// paths are checked. All commands are allowed, if the list is empty.
+type commandAllowList []string
+
+// restrictCommands applies the command allow list to the configured commands.
+func (scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) restrictCommands() {
+	allowed := commandAllowList(scriptSUPConfig.CommandAllowList)
+	scriptSUPConfig.InstallCommand.allowed = allowed
+	scriptSUPConfig.ScanCommand.allowed = allowed
+	scriptSUPConfig.HealthCommand.allowed = allowed
+	scriptSUPConfig.VersionCommand.allowed = allowed
+	scriptSUPConfig.ContinueCommand.allowed = allowed
+	if scriptSUPConfig.InstallCommands == nil {
+		return
+	}
+	restricted := make(installCommands, len(scriptSUPConfig.InstallCommands))
+	for artifactType, cmd := range scriptSUPConfig.InstallCommands {
+		cmd.allowed = allowed
+		restricted[artifactType] = cmd
+	}
+	scriptSUPConfig.InstallCommands = restricted
+}
+
+// validate checks that the allow list entries are absolute paths.
+func (l commandAllowList) validate() error {
+	for _, entry := range l {
+		if !filepath.IsAbs(entry) {
+			return fmt.Errorf("invalid command allow list entry - %s, must be an absolute path", entry)
+		}
+	}
+	return nil
+}
+
+// check verifies that the executable and the shell script, if any, of the command, run in the given directory,
+// are allowed and returns errCommandNotAllowed otherwise.
+func (l commandAllowList) check(script string, args []string, dir string) error {
+	if len(l) == 0 {
+		return nil
+	}
+	path, err := l.checkExecutable(script, dir)
+	if err != nil || !isShell(script) && !isShell(path) {
+		return err
+	}
+	// The shells run commands from their options or their standard input, unless given a script.
+	if len(args) == 0 {
+		return fmt.Errorf("%w: %s without script", errCommandNotAllowed, script)
+	}
+	if strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[0], "+") {
+		return fmt.Errorf("%w: %s", errCommandNotAllowed, args[0])
+	}
+	// The shell script is run from the directory of the command.
+	path = args[0]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil || !l.allows(path) {
+		return fmt.Errorf("%w: %s", errCommandNotAllowed, args[0])
+	}
+	return nil
+}
+
+// checkExecutable verifies that the executable of the command, run in the given directory, is allowed and
+// returns its resolved path, without verifying its arguments.
+func (l commandAllowList) checkExecutable(script string, dir string) (string, error) {
+	if len(l) == 0 {
+		return script, nil
+	}
+	path, err := executablePath(script, dir)
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil || !l.allows(path) {
+		return "", fmt.Errorf("%w: %s", errCommandNotAllowed, script)
+	}
+	return path, nil
+}
+
+// isShell returns whether the executable is a shell, which runs the commands of its arguments.
+func isShell(path string) bool {
+	switch strings.TrimSuffix(filepath.Base(path), ".exe") {
+	case "sh", "bash", "dash", "ash", "ksh", "mksh", "zsh", "busybox":
+		return true
+	}
+	return false
+}
+
+// allows returns whether the absolute path, with resolved symbolic links, is an allowed executable or is located
+// in an allowed directory.
+func (l commandAllowList) allows(path string) bool {
+	path = filepath.Clean(path)
+	for _, entry := range l {
+		if resolved, err := filepath.EvalSymlinks(entry); err == nil {
+			entry = resolved
+		}
+		entry = filepath.Clean(entry)
+		if path == entry || strings.HasPrefix(path, strings.TrimSuffix(entry, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// executablePath returns the absolute path of the executable, looked up in PATH, if given by name only,
+// or resolved against the directory, where the command is run, if relative.
+func executablePath(name string, dir string) (string, error) {
+	if !strings.ContainsRune(name, filepath.Separator) && !strings.ContainsRune(name, '/') {
+		return exec.LookPath(name)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	return filepath.Abs(name)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestCommandAllowList tests that only the commands with allowed executables and shell scripts are run.
+func TestCommandAllowList(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "install.sh")
+	if err := os.WriteFile(script, []byte("echo installed > installed.txt"), 0755); err != nil {
+		t.Fatalf("failed to write install script: %v", err)
+	}
+
+	other := t.TempDir()
+	otherScript := filepath.Join(other, "install.bash")
+	if err := os.WriteFile(otherScript, []byte("echo installed > installed.txt"), 0755); err != nil {
+		t.Fatalf("failed to write install script: %v", err)
+	}
+	link := filepath.Join(dir, "link.sh")
+	if err := os.Symlink(otherScript, link); err != nil {
+		t.Fatalf("failed to link install script: %v", err)
+	}
+	shells := commandAllowList{"/bin/sh", "/usr/bin/sh", "/bin/bash", "/usr/bin/bash"}
+
+	tests := map[string]struct {
+		cmd      command
+		allowed  commandAllowList
+		rejected string
+	}{
+		"noAllowList":       {cmd: command{cmd: "/bin/sh", args: []string{script}}},
+		"allowedExecutable": {cmd: command{cmd: "touch", args: []string{"installed.txt"}}, allowed: commandAllowList{"/bin/touch", "/usr/bin/touch"}},
+		"allowedDirectory":  {cmd: command{cmd: "/bin/sh", args: []string{script}}, allowed: commandAllowList{"/bin/sh", dir + "/"}},
+		"allowedModule":     {cmd: command{}, allowed: commandAllowList{"/bin/sh", dir}},
+		"notAllowed":        {cmd: command{cmd: "/bin/sh", args: []string{script}}, allowed: commandAllowList{"/usr/bin/dpkg"}, rejected: "/bin/sh"},
+		"scriptNotAllowed":  {cmd: command{cmd: "/bin/sh", args: []string{script}}, allowed: commandAllowList{"/bin/sh"}, rejected: script},
+		"moduleNotAllowed":  {cmd: command{}, allowed: commandAllowList{"/bin/sh", dir + "-other"}, rejected: "install.sh"},
+		"shellCommand":      {cmd: command{cmd: "sh", args: []string{"-c", "echo installed > installed.txt"}}, allowed: append(shells, dir), rejected: "-c"},
+		"shellStdin":        {cmd: command{cmd: "/bin/sh"}, allowed: append(shells, dir), rejected: "/bin/sh without script"},
+		"otherScript":       {cmd: command{cmd: "/bin/sh", args: []string{otherScript}}, allowed: append(shells, dir), rejected: otherScript},
+		"otherShell":        {cmd: command{cmd: "bash", args: []string{otherScript}}, allowed: append(shells, dir), rejected: otherScript},
+		"linkedScript":      {cmd: command{cmd: "/bin/sh", args: []string{link}}, allowed: append(shells, dir), rejected: link},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			os.Remove(filepath.Join(dir, "installed.txt"))
+			test.cmd.allowed = test.allowed
+			err := test.cmd.run(dir, "install", nil, 0)
+			_, statErr := os.Stat(filepath.Join(dir, "installed.txt"))
+			if test.rejected == "" {
+				if err != nil || statErr != nil {
+					t.Fatalf("allowed command is not run: %v, %v", err, statErr)
+				}
+				return
+			}
+			if !errors.Is(err, errCommandNotAllowed) || !strings.HasSuffix(err.Error(), ": "+test.rejected) {
+				t.Fatalf("unexpected error of not allowed command: %v", err)
+			}
+			if statErr == nil {
+				t.Fatal("not allowed command is run")
+			}
+			if msg := toStatusMessage(errInstallScript, err); msg != errInstallScript+" - command is not allowed: "+test.rejected {
+				t.Fatalf("unexpected status message of not allowed command: %s", msg)
+			}
+		})
+	}
+}
+
+// TestCommandAllowListInvalid tests that relative allow list entries are rejected.
+func TestCommandAllowListInvalid(t *testing.T) {
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	cfg.CommandAllowList = []string{"/usr/bin", "bin/sh"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expecting error when validating relative command allow list entry")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is not supported on windows, the command is started in the process group of the agent.
+func setProcessGroup(c *exec.Cmd) {
+}
+
+// signalGroup kills the started command on windows, the other signals are not supported.
+func signalGroup(c *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return c.Process.Kill()
+	}
+	return c.Process.Signal(sig)
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestRunWithFailedInput tests that the children of a command, killed on input failure, are killed as well,
+// instead of reading the end of the truncated input.
+func TestRunWithFailedInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on windows")
+	}
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	installed := filepath.Join(dir, "installed")
+	cmd := &command{cmd: "/bin/sh", args: []string{"-c",
+		"cat | { touch " + ready + "; cat > /dev/null; echo installed > " + installed + "; }"}}
+
+	failed := errors.New("input failed")
+	err := cmd.runWithInput(dir, "install", func(w io.Writer) error {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(ready); err == nil {
+				break
+			}
+		}
+		if _, err := w.Write([]byte("truncated")); err != nil {
+			return err
+		}
+		return failed
+	}, nil, nil, 0)
+	if err != failed {
+		t.Fatalf("expected input error, got: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if _, err := os.Stat(installed); !os.IsNotExist(err) {
+		t.Fatalf("truncated input is installed by the command children: %v", err)
+	}
+}
+
+// TestRunWithBackgroundChild tests that a command is not waited for its background children, which inherit its output.
+func TestRunWithBackgroundChild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	var output bytes.Buffer
+	cmd := &command{cmd: "/bin/sh", args: []string{"-c", "echo started; sleep 10 &"}}
+
+	start := time.Now()
+	if err := cmd.runWithInput(t.TempDir(), "install", nil, &output, nil, 0); err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("command is waited for its background child: %v", elapsed)
+	}
+	if output.String() != "started\n" {
+		t.Fatalf("unexpected command output: %q", output.String())
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const diagnosticsShutdownTimeout = 5 * time.Second
+
+// diagnostics is the local HTTP endpoint, exposing the Script-Based SoftwareUpdatable state for diagnostics.
+type diagnostics struct {
+	feature  *ScriptBasedSoftwareUpdatable
+	listener net.Listener
+	server   *http.Server
+}
+
+// diagnosticsState is the JSON representation of the Script-Based SoftwareUpdatable state.
+type diagnosticsState struct {
+	Connection string                  `json:"connection"`
+	Operation  *diagnosticsOperation   `json:"operation"`
+	LastError  *diagnosticsOperation   `json:"lastError"`
+	Storage    diagnosticsStorageUsage `json:"storage"`
+	// DownloadRestarts is the number of partial downloads, abandoned and restarted from the beginning.
+	DownloadRestarts uint32 `json:"downloadRestarts"`
+}
+
+// diagnosticsOperation is the JSON representation of an operation state.
+type diagnosticsOperation struct {
+	CorrelationID string         `json:"correlationId"`
+	Phase         hawkbit.Status `json:"phase"`
+	Progress      int            `json:"progress"`
+	Active        bool           `json:"active"`
+	Message       string         `json:"message,omitempty"`
+	StatusCode    string         `json:"statusCode,omitempty"`
+}
+
+// diagnosticsStorageUsage is the JSON representation of the local storage usage.
+type diagnosticsStorageUsage struct {
+	UsedBytes int64  `json:"usedBytes"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newDiagnostics starts the diagnostics HTTP endpoint on the given address.
+func newDiagnostics(address string, feature *ScriptBasedSoftwareUpdatable) (*diagnostics, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start diagnostics endpoint on %s: %v", address, err)
+	}
+	d := &diagnostics{feature: feature, listener: listener}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", d.status)
+	mux.HandleFunc("/healthz", d.healthz)
+	mux.HandleFunc("/metrics", d.metrics)
+	mux.HandleFunc("/audit", d.audit)
+	d.server = &http.Server{Handler: mux}
+	go func() {
+		if err := d.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("diagnostics endpoint stopped: %v", err)
+		}
+	}()
+	logger.Infof("Diagnostics endpoint started on %s", listener.Addr())
+	return d, nil
+}
+
+// close stops the diagnostics HTTP endpoint.
+func (d *diagnostics) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsShutdownTimeout)
+	defer cancel()
+	if err := d.server.Shutdown(ctx); err != nil {
+		logger.Errorf("failed to stop diagnostics endpoint: %v", err)
+	}
+}
+
+// state returns the current Script-Based SoftwareUpdatable state.
+func (d *diagnostics) state() *diagnosticsState {
+	state := &diagnosticsState{Connection: connectionOffline}
+	f := d.feature
+	f.lock.Lock()
+	client, su := f.mqttClient, f.su
+	f.lock.Unlock()
+
+	if client != nil && client.IsConnected() {
+		state.Connection = connectionOnline
+	}
+	if su != nil {
+		state.Operation = toDiagnosticsOperation(su.LastOperation())
+		state.LastError = toDiagnosticsOperation(su.LastFailedOperation())
+	}
+	if used, err := f.store.Usage(); err != nil {
+		state.Storage.Error = err.Error()
+	} else {
+		state.Storage.UsedBytes = used
+	}
+	state.DownloadRestarts = atomic.LoadUint32(&f.downloadRestarts)
+	return state
+}
+
+// status writes the current state as JSON.
+func (d *diagnostics) status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.state()); err != nil {
+		logger.Errorf("failed to write diagnostics status: %v", err)
+	}
+}
+
+// healthz reports if the agent is connected to the MQTT broker.
+func (d *diagnostics) healthz(w http.ResponseWriter, r *http.Request) {
+	if d.state().Connection != connectionOnline {
+		http.Error(w, connectionOffline, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// audit writes the audit log entries as JSON, filtered by the correlationId query parameter, if given.
+func (d *diagnostics) audit(w http.ResponseWriter, r *http.Request) {
+	if d.feature.audit == nil {
+		http.Error(w, "audit log is disabled", http.StatusNotFound)
+		return
+	}
+	entries, err := d.feature.audit.entries(r.URL.Query().Get("correlationId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logger.Errorf("failed to write audit log: %v", err)
+	}
+}
+
+// metrics writes the current state in the Prometheus text format.
+func (d *diagnostics) metrics(w http.ResponseWriter, r *http.Request) {
+	state := d.state()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	connected, active, progress := 0, 0, 0
+	if state.Connection == connectionOnline {
+		connected = 1
+	}
+	if state.Operation != nil {
+		progress = state.Operation.Progress
+		if state.Operation.Active {
+			active = 1
+		}
+	}
+	writeMetric(w, "software_update_connected", "Connection status to the MQTT broker.", "gauge", connected)
+	writeMetric(w, "software_update_operation_active", "Whether an operation is in progress.", "gauge", active)
+	writeMetric(w, "software_update_operation_progress", "Progress of the last operation in percentage.", "gauge", progress)
+	writeMetric(w, "software_update_storage_used_bytes", "Size of the local storage.", "gauge", state.Storage.UsedBytes)
+	writeMetric(w, "software_update_download_restarts_total", "Partial downloads, restarted from the beginning.", "counter",
+		state.DownloadRestarts)
+}
+
+func writeMetric(w http.ResponseWriter, name string, help string, metricType string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, metricType, name, value)
+}
+
+func toDiagnosticsOperation(os *hawkbit.OperationStatus) *diagnosticsOperation {
+	if os == nil {
+		return nil
+	}
+	return &diagnosticsOperation{
+		CorrelationID: os.CorrelationID,
+		Phase:         os.Status,
+		Progress:      os.Progress,
+		Active:        !strings.HasPrefix(string(os.Status), "FINISHED_") && os.Status != hawkbit.StatusCancelRejected,
+		Message:       os.Message,
+		StatusCode:    os.StatusCode,
+	}
+}
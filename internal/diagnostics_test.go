@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestDiagnostics tests the diagnostics endpoint during an in-progress download operation.
+func TestDiagnostics(t *testing.T) {
+	useFakeClock(t)
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	d, err := newDiagnostics("127.0.0.1:0", feature)
+	if err != nil {
+		t.Fatalf("failed to start diagnostics endpoint: %v", err)
+	}
+	defer d.close()
+	url := "http://" + d.listener.Addr().String()
+
+	// Prepare a failed and an in-progress download operation with partially downloaded artifact.
+	module := &storage.Module{Name: testModuleName, Version: testModuleVersion}
+	smID := &hawkbit.SoftwareModuleID{Name: testModuleName, Version: testModuleVersion}
+	setLastOS(feature.su, hawkbit.NewOperationStatusUpdate("failed-cid", hawkbit.StatusFinishedError, smID).
+		WithMessage(errDownload).WithStatusCode(codeDownload))
+	mc.pullLastOperationStatus()
+	newDownloadProgress(testCid, module, feature.su, 0).update(42, 420, 1000)
+	mc.pullLastOperationStatus()
+	if err := os.WriteFile(filepath.Join(feature.store.DownloadPath, "artifact.bin"), make([]byte, 420), 0644); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	feature.countDownloadRestart(&storage.Artifact{FileName: "artifact.bin"}, storage.RestartETagChanged)
+
+	// 1. Get the current status.
+	var state map[string]interface{}
+	if err := json.Unmarshal([]byte(httpGet(t, url+"/status", http.StatusOK)), &state); err != nil {
+		t.Fatalf("failed to parse status: %v", err)
+	}
+	if state["connection"] != connectionOnline {
+		t.Fatalf("unexpected connection status: %v", state["connection"])
+	}
+	assertDiagnosticsOperation(t, state["operation"], map[string]interface{}{
+		"correlationId": testCid, "phase": string(hawkbit.StatusDownloading), "progress": float64(42), "active": true,
+		"message": "downloaded 420 of 1000 bytes",
+	})
+	assertDiagnosticsOperation(t, state["lastError"], map[string]interface{}{
+		"correlationId": "failed-cid", "phase": string(hawkbit.StatusFinishedError), "progress": float64(0), "active": false,
+		"message": errDownload, "statusCode": codeDownload,
+	})
+	usage, ok := state["storage"].(map[string]interface{})
+	if !ok || usage["usedBytes"] != float64(420) {
+		t.Fatalf("unexpected storage usage: %v", state["storage"])
+	}
+	if state["downloadRestarts"] != float64(1) {
+		t.Fatalf("unexpected download restarts: %v", state["downloadRestarts"])
+	}
+
+	// 2. Check the health and the metrics.
+	httpGet(t, url+"/healthz", http.StatusOK)
+	metrics := httpGet(t, url+"/metrics", http.StatusOK)
+	for _, metric := range []string{"software_update_connected 1", "software_update_operation_active 1",
+		"software_update_operation_progress 42", "software_update_storage_used_bytes 420",
+		"software_update_download_restarts_total 1"} {
+		if !strings.Contains(metrics, metric+"\n") {
+			t.Fatalf("missing metric %s in:\n%s", metric, metrics)
+		}
+	}
+
+	// 3. Report unhealthy, when the connection is lost.
+	mc.connected = false
+	httpGet(t, url+"/healthz", http.StatusServiceUnavailable)
+}
+
+func assertDiagnosticsOperation(t *testing.T, actual interface{}, expected map[string]interface{}) {
+	t.Helper()
+
+	operation, ok := actual.(map[string]interface{})
+	if !ok || len(operation) != len(expected) {
+		t.Fatalf("unexpected operation: %v != %v", actual, expected)
+	}
+	for key, value := range expected {
+		if operation[key] != value {
+			t.Fatalf("unexpected operation %s: %v != %v", key, operation[key], value)
+		}
+	}
+}
+
+func httpGet(t *testing.T, url string, expectedStatus int) string {
+	t.Helper()
+
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", url, err)
+	}
+	if response.StatusCode != expectedStatus {
+		t.Fatalf("unexpected %s status: %d != %d", url, response.StatusCode, expectedStatus)
+	}
+	return string(body)
+}
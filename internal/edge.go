@@ -14,7 +14,9 @@ package feature
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/eclipse-kanto/software-update/internal/logger"
 	"github.com/eclipse-kanto/software-update/util/tls"
@@ -24,7 +26,14 @@ import (
 )
 
 const (
-	topic = "edge/thing/response"
+	topic        = "edge/thing/response"
+	topicRequest = "edge/thing/request"
+
+	// topicConnection is the topic of the retained agent connection status. The broker publishes
+	// the offline status as last will, when the agent is disconnected ungracefully.
+	topicConnection   = "edge/software-update/connection"
+	connectionOnline  = "online"
+	connectionOffline = "offline"
 )
 
 // edgeConfiguration represents local Edge Thing configuration. Its device, tenant and policy identifiers.
@@ -37,26 +46,41 @@ type edgeConfiguration struct {
 // EdgeConnector listens for Edge Thing configuration changes and notifies the corresponding edgeClient.
 // It is used in the main package.
 type EdgeConnector struct {
-	mqttClient MQTT.Client
-	cfg        *edgeConfiguration
-	edgeClient edgeClient
+	mqttClient      MQTT.Client
+	cfg             *edgeConfiguration
+	edgeClient      edgeClient
+	scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig
+	topics          *topics
+	diagnostics     *diagnostics
+	closed          chan struct{}
+	fatal           chan error
 }
 
 // edgeClient receives notifications of Edge Thing configuration changes from EdgeConnector
 type edgeClient interface {
 	Connect(client MQTT.Client, scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, cfg *edgeConfiguration) error
+	Reconnect() error
 	Disconnect(closeStorage bool)
 }
 
 // newEdgeConnector creates EdgeConnector with the provided configuration for the given edgeClient
 func newEdgeConnector(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, ecl edgeClient) (*EdgeConnector, error) {
-	logger.Infof("creating edge connector with configuration: %s", scriptSUPConfig)
+	logger.Infof("creating edge connector with configuration: %+v", maskSecrets(scriptSUPConfig))
+	p := &EdgeConnector{
+		edgeClient:      ecl,
+		scriptSUPConfig: scriptSUPConfig,
+		topics:          newTopics(scriptSUPConfig),
+		closed:          make(chan struct{}),
+		fatal:           make(chan error, 1),
+	}
 	opts := MQTT.NewClientOptions().
 		AddBroker(scriptSUPConfig.Broker).
 		SetClientID(uuid.New().String()).
 		SetKeepAlive(defaultKeepAlive).
 		SetCleanSession(true).
-		SetAutoReconnect(true)
+		SetAutoReconnect(false).
+		SetConnectionLostHandler(p.connectionLost).
+		SetWill(p.topics.name(topicConnection), connectionOffline, 1, true)
 	if len(scriptSUPConfig.Username) > 0 {
 		opts = opts.SetUsername(scriptSUPConfig.Username).SetPassword(scriptSUPConfig.Password)
 	}
@@ -65,19 +89,36 @@ func newEdgeConnector(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, ecl e
 		return nil, err
 	}
 	if isConnectionSecure(u.Scheme) {
-		tlsConfig, err := tls.NewTLSConfig(scriptSUPConfig.CACert, scriptSUPConfig.Cert, scriptSUPConfig.Key)
+		tlsConfig, err := tls.NewConfig(&tls.Config{
+			CACert:        scriptSUPConfig.CACert,
+			Cert:          scriptSUPConfig.Cert,
+			Key:           scriptSUPConfig.Key,
+			KeyPassphrase: scriptSUPConfig.KeyPassphrase,
+			MinVersion:    scriptSUPConfig.TLSMinVersion,
+			PinnedKeys:    scriptSUPConfig.TLSPinnedKeys,
+		})
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid MQTT broker TLS configuration: %v", err)
 		}
 		opts.SetTLSConfig(tlsConfig)
 	}
 
-	p := &EdgeConnector{mqttClient: MQTT.NewClient(opts), edgeClient: ecl}
+	p.mqttClient = MQTT.NewClient(opts)
 	if token := p.mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+		return nil, fmt.Errorf("fail to connect to MQTT broker %s: %v", scriptSUPConfig.Broker, token.Error())
+	}
+	if err := p.publishConnection(connectionOnline); err != nil {
+		return nil, err
 	}
+	if err := p.subscribe(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
 
-	if token := p.mqttClient.Subscribe(topic, 1, func(client MQTT.Client, message MQTT.Message) {
+// subscribe for the Edge Thing configuration and request it.
+func (p *EdgeConnector) subscribe() error {
+	if token := p.mqttClient.Subscribe(p.topics.name(topic), 1, func(client MQTT.Client, message MQTT.Message) {
 		localCfg := &edgeConfiguration{}
 		err := json.Unmarshal(message.Payload(), localCfg)
 		if err != nil {
@@ -91,7 +132,7 @@ func newEdgeConnector(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, ecl e
 				p.edgeClient.Disconnect(false)
 			}
 			p.cfg = localCfg
-			err = ecl.Connect(p.mqttClient, scriptSUPConfig, p.cfg)
+			err = p.edgeClient.Connect(p.mqttClient, p.scriptSUPConfig, p.cfg)
 			if err != nil {
 				logger.Errorf("error connecting to broker: %v", err)
 			} else {
@@ -100,15 +141,74 @@ func newEdgeConnector(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, ecl e
 		}
 	}); token.Wait() && token.Error() != nil {
 		logger.Errorf("fail to subscribe for %s topic: %v", topic, token.Error())
-		return nil, token.Error()
+		return token.Error()
 	}
 	logger.Info("ditto client subscribed")
 
-	if token := p.mqttClient.Publish("edge/thing/request", 1, false, ""); token.Wait() && token.Error() != nil {
-		logger.Errorf("fail to publish a message with %s topic: %v", topic, token.Error())
-		return nil, token.Error()
+	if token := p.mqttClient.Publish(p.topics.name(topicRequest), 1, false, ""); token.Wait() && token.Error() != nil {
+		logger.Errorf("fail to publish a message with %s topic: %v", topicRequest, token.Error())
+		return token.Error()
 	}
-	return p, nil
+	return nil
+}
+
+// publishConnection publishes the retained agent connection status.
+func (p *EdgeConnector) publishConnection(status string) error {
+	if token := p.mqttClient.Publish(p.topics.name(topicConnection), 1, true, status); token.Wait() && token.Error() != nil {
+		logger.Errorf("fail to publish a message with %s topic: %v", topicConnection, token.Error())
+		return token.Error()
+	}
+	return nil
+}
+
+// connectionLost starts reconnecting to the MQTT broker.
+func (p *EdgeConnector) connectionLost(client MQTT.Client, err error) {
+	logger.Errorf("connection to MQTT broker lost: %v", err)
+	go p.reconnect()
+}
+
+// reconnect tries to restore the connection to the MQTT broker with exponential backoff. When the connection
+// is restored, the Edge Thing configuration subscription and the edgeClient are restored too. If the connection
+// cannot be restored with the configured number of attempts, the error is sent to the fatal channel.
+func (p *EdgeConnector) reconnect() {
+	b := &backoff{
+		interval:    time.Duration(p.scriptSUPConfig.ReconnectInterval),
+		maxInterval: time.Duration(p.scriptSUPConfig.ReconnectMaxInterval),
+	}
+	var err error
+	for attempt := 1; p.scriptSUPConfig.ReconnectMaxAttempts == 0 || attempt <= p.scriptSUPConfig.ReconnectMaxAttempts; attempt++ {
+		delay := b.next()
+		logger.Infof("reconnect to MQTT broker in %v [attempt: %d]", delay, attempt)
+		select {
+		case <-p.closed:
+			return
+		case <-clock.After(delay):
+		}
+		if token := p.mqttClient.Connect(); token.Wait() && token.Error() != nil {
+			err = token.Error()
+			logger.Errorf("fail to reconnect to MQTT broker: %v", err)
+			continue
+		}
+		logger.Info("reconnected to MQTT broker")
+		if err := p.publishConnection(connectionOnline); err != nil {
+			logger.Errorf("fail to publish connection status: %v", err)
+		}
+		if p.cfg != nil {
+			if err := p.edgeClient.Reconnect(); err != nil {
+				logger.Errorf("error reconnecting to ditto endpoint: %v", err)
+			}
+		}
+		if err := p.subscribe(); err != nil {
+			logger.Errorf("fail to restore edge configuration subscription: %v", err)
+		}
+		return
+	}
+	p.fatal <- fmt.Errorf("fail to reconnect to MQTT broker after %d attempts: %v", p.scriptSUPConfig.ReconnectMaxAttempts, err)
+}
+
+// Fatal returns a channel, which receives an error when the connection to the MQTT broker cannot be restored.
+func (p *EdgeConnector) Fatal() <-chan error {
+	return p.fatal
 }
 
 func isConnectionSecure(schema string) bool {
@@ -122,11 +222,16 @@ func isConnectionSecure(schema string) bool {
 
 // Close the EdgeConnector
 func (p *EdgeConnector) Close() {
+	close(p.closed)
+	if p.diagnostics != nil {
+		p.diagnostics.close()
+	}
 	if p.cfg != nil {
 		p.edgeClient.Disconnect(true)
 	}
 
-	p.mqttClient.Unsubscribe(topic)
+	p.publishConnection(connectionOffline)
+	p.mqttClient.Unsubscribe(p.topics.name(topic))
 	p.mqttClient.Disconnect(200)
 
 	logger.Info("disconnected from MQTT broker")
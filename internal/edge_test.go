@@ -0,0 +1,490 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tlsutil "github.com/eclipse-kanto/software-update/util/tls"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+const (
+	testEdgeConfiguration = `{"deviceId":"my-namespace.id:thing.id","tenantId":"test-tenant-id"}`
+	willPrefix            = "will:"
+)
+
+// TestEdgeConnectorReconnect tests that the connection is restored after the broker is restarted.
+func TestEdgeConnectorReconnect(t *testing.T) {
+	broker := newTestBroker(t)
+	broker.start()
+	defer broker.drop()
+
+	ecl := newTestEdgeClient()
+	ec, err := newEdgeConnector(testReconnectConfig(broker, 0), ecl)
+	if err != nil {
+		t.Fatalf("failed to create edge connector: %v", err)
+	}
+	defer ec.Close()
+
+	// 1. Subscribe for the edge configuration and apply it.
+	expectTopic(t, broker.published, topicConnection, "connection status")
+	expectTopic(t, broker.subscribed, topic, "edge configuration subscription")
+	expectTopic(t, broker.published, "edge/thing/request", "edge configuration request")
+	broker.send(topic, testEdgeConfiguration)
+	expectNotified(t, ecl.connected, "edge client connect")
+
+	// 2. Restart the broker and restore the connection.
+	broker.drop()
+	time.Sleep(300 * time.Millisecond)
+	broker.start()
+	expectTopic(t, broker.published, topicConnection, "connection status")
+	expectNotified(t, ecl.reconnected, "edge client reconnect")
+	expectTopic(t, broker.subscribed, topic, "edge configuration subscription")
+	expectTopic(t, broker.published, "edge/thing/request", "edge configuration request")
+}
+
+// TestEdgeConnectorReconnectFatal tests that an error is surfaced, when the connection cannot be restored.
+func TestEdgeConnectorReconnectFatal(t *testing.T) {
+	broker := newTestBroker(t)
+	broker.start()
+
+	ec, err := newEdgeConnector(testReconnectConfig(broker, 2), newTestEdgeClient())
+	if err != nil {
+		t.Fatalf("failed to create edge connector: %v", err)
+	}
+	defer ec.Close()
+
+	fake := useFakeClock(t)
+
+	broker.drop()
+	select {
+	case err := <-ec.Fatal():
+		if err == nil {
+			t.Fatal("reconnect error expected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconnect error not surfaced")
+	}
+
+	// The delays are between the half and the whole doubled interval.
+	waits := fake.Waits()
+	if len(waits) != 2 {
+		t.Fatalf("unexpected reconnect delays: %v", waits)
+	}
+	for i, wait := range waits {
+		interval := 50 * time.Millisecond << i
+		if wait < interval/2 || wait > interval {
+			t.Fatalf("unexpected reconnect delays: %v", waits)
+		}
+	}
+}
+
+// TestEdgeConnectorConnectionStatus tests that the offline will is registered and the online status is published.
+func TestEdgeConnectorConnectionStatus(t *testing.T) {
+	broker := newTestBroker(t)
+	broker.start()
+	defer broker.drop()
+
+	ec, err := newEdgeConnector(testReconnectConfig(broker, 0), newTestEdgeClient())
+	if err != nil {
+		t.Fatalf("failed to create edge connector: %v", err)
+	}
+
+	// 1. Register the offline status as will and publish the online status on connect.
+	expectTopic(t, broker.published, topicConnection, "connection status")
+	expectTopic(t, broker.published, "edge/thing/request", "edge configuration request")
+	if will := broker.retainedMessage(willPrefix + topicConnection); will != connectionOffline {
+		t.Fatalf("unexpected last will: %s", will)
+	}
+	if status := broker.retainedMessage(topicConnection); status != connectionOnline {
+		t.Fatalf("unexpected connection status on connect: %s", status)
+	}
+
+	// 2. Publish the offline status on close.
+	ec.Close()
+	expectTopic(t, broker.published, topicConnection, "connection status")
+	if status := broker.retainedMessage(topicConnection); status != connectionOffline {
+		t.Fatalf("unexpected connection status on close: %s", status)
+	}
+}
+
+// TestEdgeConnectorTLS tests the mutual TLS connection to the MQTT broker.
+func TestEdgeConnectorTLS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "_tmp-tls")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certs := newTestCertificates(t, dir)
+
+	tests := map[string]struct {
+		update func(cfg *ScriptBasedSoftwareUpdatableConfig)
+		err    string
+	}{
+		"clientCert": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {}},
+		"caDir": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.CACert = filepath.Dir(certs.caCert)
+		}},
+		"minVersion": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.TLSMinVersion = "1.3"
+		}},
+		"pinnedKey": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.TLSPinnedKeys = []string{certs.caPin}
+		}},
+		"noClientCert": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.Cert, cfg.Key = "", ""
+		}, err: "fail to connect to MQTT broker"},
+		"untrustedClientCert": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.Cert, cfg.Key = certs.untrustedCert, certs.untrustedKey
+		}, err: "fail to connect to MQTT broker"},
+		"wrongPinnedKey": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.TLSPinnedKeys = []string{certs.untrustedPin}
+		}, err: "fail to connect to MQTT broker"},
+		"invalidPinnedKey": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.TLSPinnedKeys = []string{"invalid"}
+		}, err: "invalid MQTT broker TLS configuration"},
+		"missingClientKey": {update: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.Key = filepath.Join(dir, "missing.key")
+		}, err: "invalid MQTT broker TLS configuration"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			broker := newTestBroker(t)
+			broker.tlsConfig = certs.serverConfig
+			broker.start()
+			defer broker.drop()
+
+			cfg := testReconnectConfig(broker, 0)
+			cfg.Broker = "ssl://" + broker.addr
+			cfg.CACert, cfg.Cert, cfg.Key = certs.caCert, certs.clientCert, certs.clientKey
+			test.update(cfg)
+
+			ec, err := newEdgeConnector(cfg, newTestEdgeClient())
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("failed to connect with TLS: %v", err)
+				}
+				ec.Close()
+				return
+			}
+			if err == nil {
+				ec.Close()
+				t.Fatalf("expected connect error: %s", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("unexpected connect error: %v", err)
+			}
+		})
+	}
+}
+
+func testReconnectConfig(broker *testBroker, maxAttempts int) *ScriptBasedSoftwareUpdatableConfig {
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	cfg.Broker = "tcp://" + broker.addr
+	cfg.ReconnectInterval = durationTime(50 * time.Millisecond)
+	cfg.ReconnectMaxInterval = durationTime(200 * time.Millisecond)
+	cfg.ReconnectMaxAttempts = maxAttempts
+	return &cfg
+}
+
+func expectTopic(t *testing.T, topics chan string, expected string, name string) {
+	t.Helper()
+
+	select {
+	case topic := <-topics:
+		if topic != expected {
+			t.Fatalf("unexpected %s topic: %s != %s", name, topic, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("missing %s", name)
+	}
+}
+
+func expectNotified(t *testing.T, notifications chan struct{}, name string) {
+	t.Helper()
+
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("missing %s", name)
+	}
+}
+
+// testEdgeClient records the edgeClient notifications.
+type testEdgeClient struct {
+	connected   chan struct{}
+	reconnected chan struct{}
+}
+
+func newTestEdgeClient() *testEdgeClient {
+	return &testEdgeClient{connected: make(chan struct{}, 10), reconnected: make(chan struct{}, 10)}
+}
+
+func (ecl *testEdgeClient) Connect(client MQTT.Client, scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, cfg *edgeConfiguration) error {
+	ecl.connected <- struct{}{}
+	return nil
+}
+
+func (ecl *testEdgeClient) Reconnect() error {
+	ecl.reconnected <- struct{}{}
+	return nil
+}
+
+func (ecl *testEdgeClient) Disconnect(closeStorage bool) {
+	// Do nothing.
+}
+
+// testBroker is a minimal MQTT broker, which can be stopped and started again on the same address.
+type testBroker struct {
+	t          *testing.T
+	addr       string
+	tlsConfig  *tls.Config
+	lock       sync.Mutex
+	listener   net.Listener
+	conns      []net.Conn
+	subscribed chan string
+	published  chan string
+	retained   map[string]string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find available address: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return &testBroker{t: t, addr: addr, subscribed: make(chan string, 10), published: make(chan string, 10),
+		retained: map[string]string{}}
+}
+
+// retainedMessage returns the retained message of the topic. The retained will messages are prefixed.
+func (b *testBroker) retainedMessage(topic string) string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.retained[topic]
+}
+
+func (b *testBroker) start() {
+	listener, err := net.Listen("tcp", b.addr)
+	if err != nil {
+		b.t.Fatalf("failed to start test broker: %v", err)
+	}
+	if b.tlsConfig != nil {
+		listener = tls.NewListener(listener, b.tlsConfig)
+	}
+	b.lock.Lock()
+	b.listener = listener
+	b.lock.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.lock.Lock()
+			b.conns = append(b.conns, conn)
+			b.lock.Unlock()
+			go b.serve(conn)
+		}
+	}()
+}
+
+func (b *testBroker) drop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.listener != nil {
+		b.listener.Close()
+		b.listener = nil
+	}
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+func (b *testBroker) send(topic string, payload string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = topic
+	pub.Payload = []byte(payload)
+	for _, conn := range b.conns {
+		pub.Write(conn)
+	}
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			reply = packets.NewControlPacket(packets.Connack)
+			if p.WillFlag && p.WillRetain {
+				b.lock.Lock()
+				b.retained[willPrefix+p.WillTopic] = string(p.WillMessage)
+				b.lock.Unlock()
+			}
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = p.Qoss
+			reply = suback
+			for _, topic := range p.Topics {
+				b.subscribed <- topic
+			}
+		case *packets.UnsubscribePacket:
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			reply = unsuback
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				reply = puback
+			}
+			if p.Retain {
+				b.lock.Lock()
+				b.retained[p.TopicName] = string(p.Payload)
+				b.lock.Unlock()
+			}
+			b.published <- p.TopicName
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if reply != nil {
+			b.lock.Lock()
+			err = reply.Write(conn)
+			b.lock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// testCertificates are the generated test certificates and keys files.
+type testCertificates struct {
+	caCert        string
+	caPin         string
+	clientCert    string
+	clientKey     string
+	untrustedCert string
+	untrustedKey  string
+	untrustedPin  string
+	serverConfig  *tls.Config
+}
+
+// newTestCertificates generates a CA, a server and a client certificate, issued by the CA,
+// and a self-signed untrusted certificate in the given directory.
+func newTestCertificates(t *testing.T, dir string) *testCertificates {
+	ca, caKey := newTestCertificate(t, "test-ca", nil, nil)
+	server, serverKey := newTestCertificate(t, "localhost", ca, caKey)
+	client, clientKey := newTestCertificate(t, "test-client", ca, caKey)
+	untrusted, untrustedKey := newTestCertificate(t, "test-untrusted", nil, nil)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+	certs := &testCertificates{
+		caCert:        writeTestPEM(t, filepath.Join(dir, "ca", "ca.crt"), "CERTIFICATE", ca.Raw),
+		caPin:         tlsutil.PublicKeyPin(ca),
+		clientCert:    writeTestPEM(t, filepath.Join(dir, "client.crt"), "CERTIFICATE", client.Raw),
+		clientKey:     writeTestKey(t, filepath.Join(dir, "client.key"), clientKey),
+		untrustedCert: writeTestPEM(t, filepath.Join(dir, "untrusted.crt"), "CERTIFICATE", untrusted.Raw),
+		untrustedKey:  writeTestKey(t, filepath.Join(dir, "untrusted.key"), untrustedKey),
+		untrustedPin:  tlsutil.PublicKeyPin(untrusted),
+		serverConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{server.Raw, ca.Raw}, PrivateKey: serverKey}},
+			ClientCAs:    caPool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}
+	return certs
+}
+
+// newTestCertificate generates a certificate, issued by the given parent or self-signed, if no parent is given.
+func newTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func writeTestKey(t *testing.T, file string, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return writeTestPEM(t, file, "EC PRIVATE KEY", der)
+}
+
+func writeTestPEM(t *testing.T, file string, blockType string, der []byte) string {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+	return file
+}
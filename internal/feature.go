@@ -13,7 +13,13 @@
 package feature
 
 import (
+	"crypto"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,8 +27,10 @@ import (
 	"github.com/eclipse-kanto/software-update/hawkbit"
 	"github.com/eclipse-kanto/software-update/internal/logger"
 	"github.com/eclipse-kanto/software-update/internal/storage"
+	"github.com/eclipse-kanto/software-update/util/tls"
 
 	"github.com/eclipse/ditto-clients-golang"
+	"github.com/eclipse/ditto-clients-golang/model"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
@@ -34,29 +42,75 @@ const (
 	typeArchive = "archive"
 	typePlain   = "plain"
 
+	metadataInstallMode = "install-mode"
+	installModeStream   = "stream"
+
 	defaultDisconnectTimeout     = 250 * time.Millisecond
 	defaultKeepAlive             = 20 * time.Second
+	defaultReconnectInterval     = "1s"
+	defaultReconnectMaxInterval  = "2m"
+	defaultReconnectMaxAttempts  = 0
+	defaultStatusQoS             = 1
+	defaultStatusRetained        = false
+	defaultStatusCompressSize    = 0
+	defaultCommandsQoS           = 1
+	defaultTopicPrefix           = ""
+	defaultEventsTopic           = "e"
+	defaultCommandsTopic         = "command"
 	defaultBroker                = "tcp://localhost:1883"
 	defaultUsername              = ""
 	defaultPassword              = ""
 	defaultCACert                = ""
 	defaultCert                  = ""
 	defaultKey                   = ""
+	defaultTLSMinVersion         = "1.2"
 	defaultStorageLocation       = "."
+	defaultStorageMode           = "0755"
+	defaultStorageLayout         = storage.LayoutModule
 	defaultFeatureID             = "SoftwareUpdatable"
 	defaultModuleType            = "software"
 	defaultArtifactType          = "archive"
 	defaultServerCert            = ""
 	defaultDownloadRetryCount    = 0
 	defaultDownloadRetryInterval = "5s"
+	defaultDownloadBufferSize    = storage.DefaultBufferSize
+	defaultDownloadBuffers       = 0
+	defaultDownloadMaxRedirects  = storage.DefaultMaxRedirects
+	defaultDownloadMaxRestarts   = 5
+	defaultCircuitThreshold      = 0
+	defaultCircuitCooldown       = "1m"
+	defaultDownloadHashing       = storage.HashingInline
+	defaultDuplicateArtifacts    = storage.DuplicatesRename
+	defaultArchiveSymlinks       = storage.SymlinksReject
+	defaultMissingChecksum       = storage.MissingChecksumFail
+	defaultProgressInterval      = "1s"
+	defaultGracePeriod           = "10s"
+	defaultShutdownGracePeriod   = "30s"
+	defaultMaxOperationTimeout   = "24h"
+	defaultMaxTotalBytes         = 0
+	defaultMaxArtifacts          = 0
 	defaultInstallDirs           = ""
 	defaultMode                  = modeStrict
 	defaultInstallCommand        = ""
+	defaultScanTimeout           = "5m"
+	defaultInstallLockTimeout    = "5m"
+	defaultApprovalAction        = approvalActionCancel
+	defaultHealthTimeout         = "5m"
+	defaultHealthInterval        = "5s"
+	defaultVersionTimeout        = "1m"
+	defaultContinueInterval      = "10s"
+	defaultCleanupPolicy         = storage.CleanupDeleteArtifacts
+	defaultReportMethod          = http.MethodPut
+	defaultReportLogSize         = 64 * 1024
+	defaultDiagnosticsAddress    = ""
+	defaultAuditLogEntries       = 0
 	defaultLogFile               = "log/software-update.log"
 	defaultLogLevel              = "INFO"
 	defaultLogFileSize           = 2
 	defaultLogFileCount          = 5
 	defaultLogFileMaxAge         = 28
+	defaultLogFileCompress       = true
+	defaultLogFormat             = "text"
 )
 
 var (
@@ -70,22 +124,116 @@ type operationFunc func() bool
 
 // ScriptBasedSoftwareUpdatableConfig provides the Script-Based SoftwareUpdatable configuration.
 type ScriptBasedSoftwareUpdatableConfig struct {
-	Broker                string       `json:"broker,omitempty"`
-	Username              string       `json:"username,omitempty"`
-	Password              string       `json:"password,omitempty"`
-	CACert                string       `json:"caCert,omitempty"`
-	Cert                  string       `json:"cert,omitempty"`
-	Key                   string       `json:"key,omitempty"`
-	StorageLocation       string       `json:"storageLocation,omitempty"`
-	FeatureID             string       `json:"featureId,omitempty"`
-	ModuleType            string       `json:"moduleType,omitempty"`
-	ArtifactType          string       `json:"artifactType,omitempty"`
-	ServerCert            string       `json:"serverCert,omitempty"`
-	DownloadRetryCount    int          `json:"downloadRetryCount,omitempty"`
-	DownloadRetryInterval durationTime `json:"downloadRetryInterval,omitempty"`
-	InstallDirs           []string     `json:"installDirs,omitempty"`
-	Mode                  string       `json:"mode,omitempty"`
-	InstallCommand        command      `json:"install,omitempty"`
+	Broker                string          `json:"broker,omitempty"`
+	Username              string          `json:"username,omitempty"`
+	Password              string          `json:"password,omitempty"`
+	CACert                string          `json:"caCert,omitempty"`
+	Cert                  string          `json:"cert,omitempty"`
+	Key                   string          `json:"key,omitempty"`
+	KeyPassphrase         string          `json:"keyPassphrase,omitempty"`
+	TLSMinVersion         string          `json:"tlsMinVersion,omitempty"`
+	TLSPinnedKeys         []string        `json:"tlsPinnedKeys,omitempty"`
+	ReconnectInterval     durationTime    `json:"reconnectInterval,omitempty"`
+	ReconnectMaxInterval  durationTime    `json:"reconnectMaxInterval,omitempty"`
+	ReconnectMaxAttempts  int             `json:"reconnectMaxAttempts,omitempty"`
+	StatusQoS             int             `json:"statusQos,omitempty"`
+	StatusRetained        bool            `json:"statusRetained,omitempty"`
+	StatusCompressSize    int             `json:"statusCompressSize,omitempty"`
+	CommandsQoS           int             `json:"commandsQos,omitempty"`
+	TopicPrefix           string          `json:"topicPrefix,omitempty"`
+	EventsTopic           string          `json:"eventsTopic,omitempty"`
+	CommandsTopic         string          `json:"commandsTopic,omitempty"`
+	StorageLocation       string          `json:"storageLocation,omitempty"`
+	StorageMode           string          `json:"storageMode,omitempty"`
+	StorageLayout         string          `json:"storageLayout,omitempty"`
+	MmapChecksum          bool            `json:"mmapChecksum,omitempty"`
+	VerifyArchives        bool            `json:"verifyArchives,omitempty"`
+	TUFRoot               string          `json:"tufRoot,omitempty"`
+	TUFRepository         string          `json:"tufRepository,omitempty"`
+	ThingID               string          `json:"thingId,omitempty"`
+	ThingNamespace        string          `json:"thingNamespace,omitempty"`
+	FeatureID             string          `json:"featureId,omitempty"`
+	ModuleType            string          `json:"moduleType,omitempty"`
+	ArtifactType          string          `json:"artifactType,omitempty"`
+	ServerCert            string          `json:"serverCert,omitempty"`
+	ServerName            string          `json:"serverName,omitempty"`
+	InsecureSkipVerify    bool            `json:"insecureSkipVerify,omitempty"`
+	ServerToken           string          `json:"serverToken,omitempty"`
+	DisableHTTP2          bool            `json:"disableHttp2,omitempty"`
+	DisableCompression    bool            `json:"disableCompression,omitempty"`
+	ManifestKey           string          `json:"manifestKey,omitempty"`
+	SFTPKnownHosts        string          `json:"sftpKnownHosts,omitempty"`
+	SFTPUsername          string          `json:"sftpUsername,omitempty"`
+	SFTPPassword          string          `json:"sftpPassword,omitempty"`
+	SFTPKey               string          `json:"sftpKey,omitempty"`
+	DownloadAllowList     []string        `json:"downloadAllowList,omitempty"`
+	DownloadMaxRedirects  int             `json:"downloadMaxRedirects"`
+	DownloadSameHost      bool            `json:"downloadSameHostRedirects,omitempty"`
+	DownloadRedirectList  []string        `json:"downloadRedirectAllowList,omitempty"`
+	DownloadRetryCount    int             `json:"downloadRetryCount,omitempty"`
+	DownloadRetryInterval durationTime    `json:"downloadRetryInterval,omitempty"`
+	DownloadMaxRestarts   int             `json:"downloadMaxRestarts"`
+	ChecksumRetries       int             `json:"downloadChecksumRetries,omitempty"`
+	DownloadMirrors       []string        `json:"downloadMirrors,omitempty"`
+	DownloadRewrites      []string        `json:"downloadRewrites,omitempty"`
+	DownloadDNSWait       durationTime    `json:"downloadDnsWait,omitempty"`
+	DownloadStartJitter   durationTime    `json:"downloadStartJitter,omitempty"`
+	CoalesceWindow        durationTime    `json:"coalesceWindow,omitempty"`
+	DownloadBufferSize    int             `json:"downloadBufferSize,omitempty"`
+	DownloadBuffers       int             `json:"downloadBuffers,omitempty"`
+	DownloadInPlace       bool            `json:"downloadInPlace,omitempty"`
+	DownloadPreempt       bool            `json:"downloadPreempt,omitempty"`
+	DownloadNoResume      bool            `json:"downloadNoResume,omitempty"`
+	DownloadTrustLocal    bool            `json:"downloadTrustLocal,omitempty"`
+	DownloadReadBuffer    int             `json:"downloadReadBuffer,omitempty"`
+	DownloadDSCP          int             `json:"downloadDscp,omitempty"`
+	CircuitThreshold      int             `json:"downloadCircuitThreshold,omitempty"`
+	CircuitCooldown       durationTime    `json:"downloadCircuitCooldown,omitempty"`
+	DownloadHashing       string          `json:"downloadHashing,omitempty"`
+	TrailerChecksum       bool            `json:"downloadTrailerChecksum,omitempty"`
+	DownloadPreallocate   bool            `json:"downloadPreallocate,omitempty"`
+	DuplicateArtifacts    string          `json:"duplicateArtifacts,omitempty"`
+	ArchiveSymlinks       string          `json:"archiveSymlinks,omitempty"`
+	MissingChecksum       string          `json:"missingChecksum,omitempty"`
+	ProgressInterval      durationTime    `json:"progressInterval,omitempty"`
+	GracePeriod           durationTime    `json:"gracePeriod,omitempty"`
+	ShutdownGracePeriod   durationTime    `json:"shutdownGracePeriod,omitempty"`
+	OperationTimeout      durationTime    `json:"operationTimeout,omitempty"`
+	MaxOperationTimeout   durationTime    `json:"maxOperationTimeout,omitempty"`
+	MaxTotalBytes         int64           `json:"maxTotalBytes,omitempty"`
+	MaxArtifacts          int             `json:"maxArtifacts,omitempty"`
+	InstallDirs           []string        `json:"installDirs,omitempty"`
+	Mode                  string          `json:"mode,omitempty"`
+	InstallCommand        command         `json:"install,omitempty"`
+	InstallCommands       installCommands `json:"installCommands,omitempty"`
+	InstallLock           string          `json:"installLock,omitempty"`
+	InstallLockTimeout    durationTime    `json:"installLockTimeout,omitempty"`
+	ScanCommand           command         `json:"scanCommand,omitempty"`
+	ScanTimeout           durationTime    `json:"scanTimeout,omitempty"`
+	ApprovalTimeout       durationTime    `json:"approvalTimeout,omitempty"`
+	ApprovalAction        string          `json:"approvalAction,omitempty"`
+	HealthCommand         command         `json:"healthCommand,omitempty"`
+	HealthTimeout         durationTime    `json:"healthTimeout,omitempty"`
+	HealthInterval        durationTime    `json:"healthInterval,omitempty"`
+	VersionCommand        command         `json:"versionCommand,omitempty"`
+	VersionTimeout        durationTime    `json:"versionTimeout,omitempty"`
+	ContinueCommand       command         `json:"continueCommand,omitempty"`
+	ContinueInterval      durationTime    `json:"continueInterval,omitempty"`
+	CleanupPolicy         string          `json:"cleanupPolicy,omitempty"`
+	ReportURL             string          `json:"reportUrl,omitempty"`
+	ReportMethod          string          `json:"reportMethod,omitempty"`
+	ReportManifest        bool            `json:"reportManifest,omitempty"`
+	ReportLogSize         int             `json:"reportLogSize,omitempty"`
+	DiagnosticsAddress    string          `json:"diagnosticsAddress,omitempty"`
+	AuditLogEntries       int             `json:"auditLogEntries,omitempty"`
+	// ContinuePolicy decides whether the running operations can continue, overriding the continue command.
+	ContinuePolicy storage.ContinuePolicy `json:"-"`
+	// ProgressListeners are notified with the module download progress, besides the operation status updates,
+	// e.g. by a local UI or a metrics exporter. Slow listeners receive only the latest progress.
+	ProgressListeners []storage.Progress `json:"-"`
+	// CommandAllowList restricts the executables of the commands. It is given only on the command line and
+	// is not loaded from the configuration file, so that a compromised configuration cannot extend it.
+	CommandAllowList []string `json:"-"`
 }
 
 // ScriptBasedSoftwareUpdatable is the Script-Based SoftwareUpdatable actual implementation.
@@ -97,19 +245,60 @@ type ScriptBasedSoftwareUpdatable struct {
 	dittoClient           *ditto.Client
 	mqttClient            MQTT.Client
 	artifactType          string
-	serverCert            string
+	server                storage.ServerConfig
 	downloadRetryCount    int
 	downloadRetryInterval time.Duration
+	downloadStartJitter   time.Duration
+	coalesceWindow        time.Duration
+	progressInterval      time.Duration
+	progressListeners     []storage.Progress
+	gracePeriod           time.Duration
+	shutdownGracePeriod   time.Duration
+	operationTimeout      time.Duration
+	maxOperationTimeout   time.Duration
+	maxTotalBytes         int64
+	maxArtifacts          int
 	installDirs           []string
 	accessMode            string
 	installCommand        *command
+	installCommands       installCommands
+	installLock           string
+	installLockTimeout    time.Duration
+	scanCommand           *command
+	scanTimeout           time.Duration
+	healthCommand         *command
+	healthTimeout         time.Duration
+	healthInterval        time.Duration
+	versionCommand        *command
+	versionTimeout        time.Duration
+	cleanupPolicy         string
+	reportURL             string
+	reportMethod          string
+	reportManifest        bool
+	reportLogSize         int
+	audit                 *auditLog
+	duplicateArtifacts    string
+	archiveSymlinks       string
+	cancelLock            sync.Mutex
+	cancels               map[string]chan struct{}
+	timeouts              map[string]operationTimer
+	approvals             map[string]chan struct{}
+	approvalTimeout       time.Duration
+	approvalAction        string
+	downloadRestarts      uint32
+	coalesceLock          sync.Mutex
+	latest                map[string]string
 }
 
 // BasicConfig combine ScriptBaseSoftwareUpdatable configuration and Log configuration
 type BasicConfig struct {
 	ScriptBasedSoftwareUpdatableConfig
 	logger.LogConfig
-	ConfigFile string `json:"configFile,omitempty"`
+	ConfigFile        string `json:"configFile,omitempty"`
+	SelfCheck         bool   `json:"-"`
+	VerifyManifest    string `json:"-"`
+	VerifyDir         string `json:"-"`
+	VerifyConcurrency int    `json:"-"`
 }
 
 // NewDefaultConfig returns a default mqtt client connection config instance
@@ -118,6 +307,58 @@ func NewDefaultConfig() *BasicConfig {
 	if err != nil {
 		duration = 0
 	}
+	progressInterval, err := time.ParseDuration(defaultProgressInterval)
+	if err != nil {
+		progressInterval = 0
+	}
+	gracePeriod, err := time.ParseDuration(defaultGracePeriod)
+	if err != nil {
+		gracePeriod = 0
+	}
+	shutdownGracePeriod, err := time.ParseDuration(defaultShutdownGracePeriod)
+	if err != nil {
+		shutdownGracePeriod = 0
+	}
+	maxOperationTimeout, err := time.ParseDuration(defaultMaxOperationTimeout)
+	if err != nil {
+		maxOperationTimeout = 0
+	}
+	scanTimeout, err := time.ParseDuration(defaultScanTimeout)
+	if err != nil {
+		scanTimeout = 0
+	}
+	installLockTimeout, err := time.ParseDuration(defaultInstallLockTimeout)
+	if err != nil {
+		installLockTimeout = 0
+	}
+	healthTimeout, err := time.ParseDuration(defaultHealthTimeout)
+	if err != nil {
+		healthTimeout = 0
+	}
+	healthInterval, err := time.ParseDuration(defaultHealthInterval)
+	if err != nil {
+		healthInterval = 0
+	}
+	versionTimeout, err := time.ParseDuration(defaultVersionTimeout)
+	if err != nil {
+		versionTimeout = 0
+	}
+	circuitCooldown, err := time.ParseDuration(defaultCircuitCooldown)
+	if err != nil {
+		circuitCooldown = 0
+	}
+	continueInterval, err := time.ParseDuration(defaultContinueInterval)
+	if err != nil {
+		continueInterval = 0
+	}
+	reconnectInterval, err := time.ParseDuration(defaultReconnectInterval)
+	if err != nil {
+		reconnectInterval = 0
+	}
+	reconnectMaxInterval, err := time.ParseDuration(defaultReconnectMaxInterval)
+	if err != nil {
+		reconnectMaxInterval = 0
+	}
 	return &BasicConfig{
 		ScriptBasedSoftwareUpdatableConfig: ScriptBasedSoftwareUpdatableConfig{
 			Broker:                defaultBroker,
@@ -126,7 +367,20 @@ func NewDefaultConfig() *BasicConfig {
 			CACert:                defaultCACert,
 			Cert:                  defaultCert,
 			Key:                   defaultKey,
-			StorageLocation:       defaultStorageLocation,
+			TLSMinVersion:         defaultTLSMinVersion,
+			ReconnectInterval:     durationTime(reconnectInterval),
+			ReconnectMaxInterval:  durationTime(reconnectMaxInterval),
+			ReconnectMaxAttempts:  defaultReconnectMaxAttempts,
+			StatusQoS:             defaultStatusQoS,
+			StatusRetained:        defaultStatusRetained,
+			StatusCompressSize:    defaultStatusCompressSize,
+			CommandsQoS:           defaultCommandsQoS,
+			TopicPrefix:           defaultTopicPrefix,
+			EventsTopic:           defaultEventsTopic,
+			CommandsTopic:         defaultCommandsTopic,
+			StorageLocation:       defaultStorage(),
+			StorageMode:           defaultStorageMode,
+			StorageLayout:         defaultStorageLayout,
 			FeatureID:             defaultFeatureID,
 			ModuleType:            defaultModuleType,
 			ArtifactType:          defaultArtifactType,
@@ -134,15 +388,46 @@ func NewDefaultConfig() *BasicConfig {
 			DownloadRetryCount:    defaultDownloadRetryCount,
 			Mode:                  defaultMode,
 			DownloadRetryInterval: durationTime(duration),
+			DownloadBufferSize:    defaultDownloadBufferSize,
+			DownloadBuffers:       defaultDownloadBuffers,
+			DownloadMaxRedirects:  defaultDownloadMaxRedirects,
+			DownloadMaxRestarts:   defaultDownloadMaxRestarts,
+			CircuitThreshold:      defaultCircuitThreshold,
+			CircuitCooldown:       durationTime(circuitCooldown),
+			DownloadHashing:       defaultDownloadHashing,
+			DuplicateArtifacts:    defaultDuplicateArtifacts,
+			ArchiveSymlinks:       defaultArchiveSymlinks,
+			MissingChecksum:       defaultMissingChecksum,
+			ProgressInterval:      durationTime(progressInterval),
+			GracePeriod:           durationTime(gracePeriod),
+			ShutdownGracePeriod:   durationTime(shutdownGracePeriod),
+			MaxOperationTimeout:   durationTime(maxOperationTimeout),
+			MaxTotalBytes:         defaultMaxTotalBytes,
+			MaxArtifacts:          defaultMaxArtifacts,
 			InstallDirs:           make([]string, 0),
+			InstallLockTimeout:    durationTime(installLockTimeout),
+			ScanTimeout:           durationTime(scanTimeout),
+			ApprovalAction:        defaultApprovalAction,
+			HealthTimeout:         durationTime(healthTimeout),
+			HealthInterval:        durationTime(healthInterval),
+			VersionTimeout:        durationTime(versionTimeout),
+			ContinueInterval:      durationTime(continueInterval),
+			CleanupPolicy:         defaultCleanupPolicy,
+			ReportMethod:          defaultReportMethod,
+			ReportLogSize:         defaultReportLogSize,
+			DiagnosticsAddress:    defaultDiagnosticsAddress,
+			AuditLogEntries:       defaultAuditLogEntries,
 		},
 		LogConfig: logger.LogConfig{
-			LogFile:       defaultLogFile,
-			LogLevel:      defaultLogLevel,
-			LogFileSize:   defaultLogFileSize,
-			LogFileCount:  defaultLogFileCount,
-			LogFileMaxAge: defaultLogFileMaxAge,
+			LogFile:         defaultLogFile,
+			LogLevel:        defaultLogLevel,
+			LogFileSize:     defaultLogFileSize,
+			LogFileCount:    defaultLogFileCount,
+			LogFileMaxAge:   defaultLogFileMaxAge,
+			LogFileCompress: defaultLogFileCompress,
+			LogFormat:       defaultLogFormat,
 		},
+		VerifyConcurrency: defaultVerifyConcurrency,
 	}
 }
 
@@ -150,23 +435,112 @@ func NewDefaultConfig() *BasicConfig {
 func InitScriptBasedSU(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) (*EdgeConnector, error) {
 	logger.Infof("New Script-Based SoftwareUpdatable [Broker: %s, Type: %s]",
 		scriptSUPConfig.Broker, scriptSUPConfig.ModuleType)
+	if scriptSUPConfig.InsecureSkipVerify {
+		logger.Warn("INSECURE: certificate verification of the artifact downloads is disabled, never use insecureSkipVerify in production")
+	}
+
+	// Resolve the secret references of the credentials
+	resolved := *scriptSUPConfig
+	if err := resolved.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	scriptSUPConfig = &resolved
+	scriptSUPConfig.restrictCommands()
+
+	// Load the public key of the module manifest signatures
+	manifestKey, err := loadManifestKey(scriptSUPConfig.ManifestKey)
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize local storage and load installed dependencies
-	localStorage, err := storage.NewStorage(scriptSUPConfig.StorageLocation)
+	if err := storage.InitLocation(scriptSUPConfig.StorageLocation, storageMode(scriptSUPConfig.StorageMode)); err != nil {
+		return nil, err
+	}
+	localStorage, err := storage.NewStorageWithFileSystem(scriptSUPConfig.StorageLocation,
+		storage.OSFileSystem{Root: scriptSUPConfig.StorageLocation, MmapChecksum: scriptSUPConfig.MmapChecksum})
 	if err != nil {
 		return nil, err
 	}
+	localStorage.Layout = scriptSUPConfig.StorageLayout
 	feature := &ScriptBasedSoftwareUpdatable{
 		// Initialize local storage and load installed dependencies
 		store: localStorage,
 		// Build install script command
 		installCommand: &scriptSUPConfig.InstallCommand,
-		// Server download certificate
-		serverCert: scriptSUPConfig.ServerCert,
+		// Install commands per module artifact type
+		installCommands: scriptSUPConfig.InstallCommands,
+		// Scan command of the downloaded artifacts, before their installation
+		scanCommand: &scriptSUPConfig.ScanCommand,
+		// Device-wide install lock, shared with the other installing processes, and the time to wait for it
+		installLock:        scriptSUPConfig.InstallLock,
+		installLockTimeout: time.Duration(scriptSUPConfig.InstallLockTimeout),
+		// Time to wait for the scan command to finish, before rejecting the artifact
+		scanTimeout: time.Duration(scriptSUPConfig.ScanTimeout),
+		// Time to wait for the approval of the staged installations and the action on its timeout
+		approvalTimeout: time.Duration(scriptSUPConfig.ApprovalTimeout),
+		approvalAction:  scriptSUPConfig.ApprovalAction,
+		// Health probe command of the installed modules, retried with backoff until it passes within the health timeout
+		healthCommand:  &scriptSUPConfig.HealthCommand,
+		healthTimeout:  time.Duration(scriptSUPConfig.HealthTimeout),
+		healthInterval: time.Duration(scriptSUPConfig.HealthInterval),
+		// Version command of the installed modules, verifying that they report the installed version within the version timeout
+		versionCommand: &scriptSUPConfig.VersionCommand,
+		versionTimeout: time.Duration(scriptSUPConfig.VersionTimeout),
+		// Cleanup policy of the successfully installed modules
+		cleanupPolicy: scriptSUPConfig.CleanupPolicy,
+		// Upload of the install log and result manifest of the completed install operations
+		reportURL:      scriptSUPConfig.ReportURL,
+		reportMethod:   scriptSUPConfig.ReportMethod,
+		reportManifest: scriptSUPConfig.ReportManifest,
+		reportLogSize:  scriptSUPConfig.ReportLogSize,
+		// Audit log of the completed operations in the storage location
+		audit: newAuditLog(scriptSUPConfig.StorageLocation, scriptSUPConfig.AuditLogEntries),
+		// Policy for the module artifacts with the same file name
+		duplicateArtifacts: scriptSUPConfig.DuplicateArtifacts,
+		// Policy for the symbolic link entries of the module archives
+		archiveSymlinks: scriptSUPConfig.ArchiveSymlinks,
+		// Server download certificate and its verification, authorization token, connection settings and DSCP marking, SFTP credentials, allowed links and redirects, checksum retries and mirrors, link rewrites, in-place and not resumed downloads, maximal restarts, trusted local artifacts, missing checksum policy, hashing mode, trailer checksums, preallocated downloads, module manifest key, shared copy buffers and their preemption, circuit breaker and continue policy
+		server: storage.ServerConfig{Cert: scriptSUPConfig.ServerCert, ServerName: scriptSUPConfig.ServerName,
+			InsecureSkipVerify: scriptSUPConfig.InsecureSkipVerify, AuthToken: scriptSUPConfig.ServerToken, DNSWait: time.Duration(scriptSUPConfig.DownloadDNSWait),
+			DisableHTTP2: scriptSUPConfig.DisableHTTP2, DisableCompression: scriptSUPConfig.DisableCompression,
+			ReadBufferSize: scriptSUPConfig.DownloadReadBuffer, DSCP: scriptSUPConfig.DownloadDSCP, AllowList: scriptSUPConfig.DownloadAllowList,
+			Redirects: redirectPolicy(scriptSUPConfig), InPlace: scriptSUPConfig.DownloadInPlace,
+			Preempt: scriptSUPConfig.DownloadPreempt, ManifestKey: manifestKey,
+			NoResume: scriptSUPConfig.DownloadNoResume, TrustLocal: scriptSUPConfig.DownloadTrustLocal, Hashing: scriptSUPConfig.DownloadHashing,
+			TrailerChecksum: scriptSUPConfig.TrailerChecksum, Preallocate: scriptSUPConfig.DownloadPreallocate,
+			MissingChecksum: scriptSUPConfig.MissingChecksum, MaxRestarts: scriptSUPConfig.DownloadMaxRestarts,
+			ChecksumRetries: scriptSUPConfig.ChecksumRetries, Mirrors: scriptSUPConfig.DownloadMirrors,
+			RewriteLink: storage.PrefixRewriter(scriptSUPConfig.DownloadRewrites),
+			SFTP: storage.SFTPConfig{KnownHosts: scriptSUPConfig.SFTPKnownHosts, Username: scriptSUPConfig.SFTPUsername,
+				Password: scriptSUPConfig.SFTPPassword, Key: scriptSUPConfig.SFTPKey},
+			Buffers:          storage.NewBufferPool(scriptSUPConfig.DownloadBufferSize, scriptSUPConfig.DownloadBuffers),
+			Breaker:          storage.NewCircuitBreaker(scriptSUPConfig.CircuitThreshold, time.Duration(scriptSUPConfig.CircuitCooldown)),
+			Continue:         continuePolicy(scriptSUPConfig),
+			ContinueInterval: time.Duration(scriptSUPConfig.ContinueInterval)},
 		// Number of download reattempts
 		downloadRetryCount: scriptSUPConfig.DownloadRetryCount,
 		// Interval between download reattempts
 		downloadRetryInterval: time.Duration(scriptSUPConfig.DownloadRetryInterval),
+		// Maximal random delay before starting an operation
+		downloadStartJitter: time.Duration(scriptSUPConfig.DownloadStartJitter),
+		// Time to wait for newer operations of the same modules, superseding the just-received operations
+		coalesceWindow: time.Duration(scriptSUPConfig.CoalesceWindow),
+		// Minimal interval between download progress updates
+		progressInterval: time.Duration(scriptSUPConfig.ProgressInterval),
+		// Additional listeners of the download progress
+		progressListeners: scriptSUPConfig.ProgressListeners,
+		// Time to wait for canceled install script to terminate, before killing it
+		gracePeriod: time.Duration(scriptSUPConfig.GracePeriod),
+		// Time to wait for the running operation to finish on shutdown, before canceling it
+		shutdownGracePeriod: time.Duration(scriptSUPConfig.ShutdownGracePeriod),
+		// Overall time of a download or install operation, before canceling it
+		operationTimeout: time.Duration(scriptSUPConfig.OperationTimeout),
+		// Maximal overall time of an operation, supplied by the backend
+		maxOperationTimeout: time.Duration(scriptSUPConfig.MaxOperationTimeout),
+		// Maximal total declared size and count of the artifacts of an operation
+		maxTotalBytes: scriptSUPConfig.MaxTotalBytes,
+		maxArtifacts:  scriptSUPConfig.MaxArtifacts,
 		// Install locations for local artifacts
 		installDirs: scriptSUPConfig.InstallDirs,
 		// Access mode for local artifacts
@@ -176,6 +550,16 @@ func InitScriptBasedSU(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) (*Ed
 		// Create queue with size 10
 		queue: make(chan operationFunc, 10),
 	}
+	// Count the partial downloads, restarted from the beginning
+	feature.server.OnRestart = feature.countDownloadRestart
+	feature.server.OnTimings = logDownloadTimings
+	// Verify the downloaded artifacts, also against the signed targets of the TUF repository, if configured
+	tuf, err := loadTUFVerifier(scriptSUPConfig, feature.server)
+	if err != nil {
+		localStorage.Close()
+		return nil, err
+	}
+	feature.server.Verify = artifactVerifier(scriptSUPConfig.VerifyArchives, tuf)
 
 	// Get the local edge configuration.
 	edge, err := newEdgeConnector(scriptSUPConfig, feature)
@@ -183,6 +567,13 @@ func InitScriptBasedSU(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) (*Ed
 		localStorage.Close()
 		return nil, err
 	}
+	// Start the local diagnostics endpoint, if enabled.
+	if scriptSUPConfig.DiagnosticsAddress != "" {
+		if edge.diagnostics, err = newDiagnostics(scriptSUPConfig.DiagnosticsAddress, feature); err != nil {
+			edge.Close()
+			return nil, err
+		}
+	}
 	return edge, nil
 }
 
@@ -196,7 +587,9 @@ func (f *ScriptBasedSoftwareUpdatable) setAvailable(available bool) {
 // If any error occurs during the connection's initiation - it's returned here.
 func (f *ScriptBasedSoftwareUpdatable) Connect(client MQTT.Client, scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, edgeCfg *edgeConfiguration) error {
 	logger.Infof("Connecting to ditto endpoint with configuration - %v", edgeCfg)
+	f.lock.Lock()
 	f.mqttClient = client
+	f.lock.Unlock()
 	done = make(chan struct{})
 	f.queue = make(chan operationFunc, 10)
 	err := f.init(scriptSUPConfig, edgeCfg)
@@ -211,13 +604,23 @@ func (f *ScriptBasedSoftwareUpdatable) Connect(client MQTT.Client, scriptSUPConf
 	return nil
 }
 
-// Disconnect the client from the configured Ditto endpoint.
+// Reconnect the client to the configured Ditto endpoint, after the MQTT connection is restored.
+// The Ditto commands subscription is restored and the feature is announced again.
+func (f *ScriptBasedSoftwareUpdatable) Reconnect() error {
+	logger.Info("Reconnecting to ditto endpoint")
+	f.su.Deactivate()
+	return f.dittoClient.Connect()
+}
+
+// Disconnect the client from the configured Ditto endpoint. On close, the running operation has
+// the shutdown grace period to finish, the queued operations are resumed on the next start.
 func (f *ScriptBasedSoftwareUpdatable) Disconnect(closeStorage bool) {
 	f.setAvailable(false)
+	close(done)
 	if closeStorage {
+		f.drain()
 		f.store.Close()
 	}
-	close(done)
 	wg.Wait()
 	f.su.Deactivate()
 	logger.Info("ditto client unsubscribed")
@@ -228,15 +631,230 @@ func (f *ScriptBasedSoftwareUpdatable) Disconnect(closeStorage bool) {
 
 // Validate the software updatable configuration
 func (scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) Validate() error {
+	if scriptSUPConfig.ReconnectInterval < 0 {
+		return fmt.Errorf("negative reconnect interval value - %v", scriptSUPConfig.ReconnectInterval)
+	}
+	if mode, err := strconv.ParseUint(scriptSUPConfig.StorageMode, 8, 32); err != nil || mode&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("invalid storage mode value - (%s), must be octal permission bits, e.g. 0750", scriptSUPConfig.StorageMode)
+	}
+	if scriptSUPConfig.ReconnectMaxInterval < 0 {
+		return fmt.Errorf("negative reconnect max interval value - %v", scriptSUPConfig.ReconnectMaxInterval)
+	}
+	if scriptSUPConfig.ReconnectMaxAttempts < 0 {
+		return fmt.Errorf("negative reconnect max attempts value - %d", scriptSUPConfig.ReconnectMaxAttempts)
+	}
+	if scriptSUPConfig.StatusQoS < 0 || scriptSUPConfig.StatusQoS > 2 {
+		return fmt.Errorf("invalid status QoS value - %d, must be 0, 1 or 2", scriptSUPConfig.StatusQoS)
+	}
+	if scriptSUPConfig.StatusCompressSize < 0 {
+		return fmt.Errorf("negative status compress size value - %d", scriptSUPConfig.StatusCompressSize)
+	}
+	if scriptSUPConfig.CommandsQoS < 0 || scriptSUPConfig.CommandsQoS > 2 {
+		return fmt.Errorf("invalid commands QoS value - %d, must be 0, 1 or 2", scriptSUPConfig.CommandsQoS)
+	}
+	if _, err := tls.ParseVersion(scriptSUPConfig.TLSMinVersion); err != nil {
+		return err
+	}
+	if err := validateTopicPrefix(scriptSUPConfig.TopicPrefix); err != nil {
+		return err
+	}
+	if err := validateTopicSegment("events", scriptSUPConfig.EventsTopic); err != nil {
+		return err
+	}
+	if err := validateTopicSegment("commands", scriptSUPConfig.CommandsTopic); err != nil {
+		return err
+	}
+	if scriptSUPConfig.ThingID != "" && model.NewNamespacedIDFrom(scriptSUPConfig.ThingID) == nil {
+		return fmt.Errorf("invalid thing identifier - %s, must be in the form namespace:name", scriptSUPConfig.ThingID)
+	}
+	if scriptSUPConfig.ThingNamespace != "" {
+		if scriptSUPConfig.ThingID != "" {
+			return fmt.Errorf("thing namespace cannot be combined with thing identifier")
+		}
+		if model.NewNamespacedIDFrom(scriptSUPConfig.ThingNamespace+":name") == nil {
+			return fmt.Errorf("invalid thing namespace - %s", scriptSUPConfig.ThingNamespace)
+		}
+	}
+	if err := storage.ValidateAllowList(scriptSUPConfig.DownloadAllowList); err != nil {
+		return err
+	}
+	if scriptSUPConfig.DownloadMaxRedirects < 0 {
+		return fmt.Errorf("negative download max redirects value - %d", scriptSUPConfig.DownloadMaxRedirects)
+	}
+	if err := storage.ValidateAllowList(scriptSUPConfig.DownloadRedirectList); err != nil {
+		return err
+	}
 	if scriptSUPConfig.DownloadRetryCount < 0 {
 		return fmt.Errorf("negative download retry count value - %d", scriptSUPConfig.DownloadRetryCount)
 	}
+	if scriptSUPConfig.DownloadMaxRestarts < 0 {
+		return fmt.Errorf("negative download max restarts value - %d", scriptSUPConfig.DownloadMaxRestarts)
+	}
+	if scriptSUPConfig.ChecksumRetries < 0 {
+		return fmt.Errorf("negative download checksum retries value - %d", scriptSUPConfig.ChecksumRetries)
+	}
+	if err := storage.ValidateMirrors(scriptSUPConfig.DownloadMirrors); err != nil {
+		return err
+	}
+	if err := storage.ValidateRewrites(scriptSUPConfig.DownloadRewrites); err != nil {
+		return err
+	}
+	if scriptSUPConfig.DownloadBufferSize <= 0 {
+		return fmt.Errorf("non-positive download buffer size value - %d", scriptSUPConfig.DownloadBufferSize)
+	}
+	if scriptSUPConfig.DownloadBuffers < 0 {
+		return fmt.Errorf("negative download buffers value - %d", scriptSUPConfig.DownloadBuffers)
+	}
+	if scriptSUPConfig.DownloadReadBuffer < 0 {
+		return fmt.Errorf("negative download read buffer value - %d", scriptSUPConfig.DownloadReadBuffer)
+	}
+	if err := storage.ValidateDSCP(scriptSUPConfig.DownloadDSCP); err != nil {
+		return err
+	}
+	if (scriptSUPConfig.TUFRoot == "") != (scriptSUPConfig.TUFRepository == "") {
+		return fmt.Errorf("TUF root (%s) and repository (%s) must be given together", scriptSUPConfig.TUFRoot,
+			scriptSUPConfig.TUFRepository)
+	}
+	if scriptSUPConfig.CircuitThreshold < 0 {
+		return fmt.Errorf("negative download circuit threshold value - %d", scriptSUPConfig.CircuitThreshold)
+	}
+	if scriptSUPConfig.CircuitCooldown < 0 {
+		return fmt.Errorf("negative download circuit cooldown value - %v", scriptSUPConfig.CircuitCooldown)
+	}
+	if scriptSUPConfig.DownloadHashing != storage.HashingInline && scriptSUPConfig.DownloadHashing != storage.HashingOverlapped {
+		return fmt.Errorf("invalid download hashing mode - (%s), must be either %s or %s", scriptSUPConfig.DownloadHashing,
+			storage.HashingInline, storage.HashingOverlapped)
+	}
+	if scriptSUPConfig.DuplicateArtifacts != storage.DuplicatesRename && scriptSUPConfig.DuplicateArtifacts != storage.DuplicatesReject {
+		return fmt.Errorf("invalid duplicate artifacts policy - (%s), must be either %s or %s", scriptSUPConfig.DuplicateArtifacts,
+			storage.DuplicatesRename, storage.DuplicatesReject)
+	}
+	if err := storage.ValidateSymlinks(scriptSUPConfig.ArchiveSymlinks); err != nil {
+		return err
+	}
+	if err := storage.ValidateLayout(scriptSUPConfig.StorageLayout); err != nil {
+		return err
+	}
+	if scriptSUPConfig.MissingChecksum != storage.MissingChecksumFail && scriptSUPConfig.MissingChecksum != storage.MissingChecksumSkip {
+		return fmt.Errorf("invalid missing checksum policy - (%s), must be either %s or %s", scriptSUPConfig.MissingChecksum,
+			storage.MissingChecksumFail, storage.MissingChecksumSkip)
+	}
+	if scriptSUPConfig.DownloadDNSWait < 0 {
+		return fmt.Errorf("negative download DNS wait value - %v", scriptSUPConfig.DownloadDNSWait)
+	}
+	if scriptSUPConfig.DownloadStartJitter < 0 {
+		return fmt.Errorf("negative download start jitter value - %v", scriptSUPConfig.DownloadStartJitter)
+	}
+	if scriptSUPConfig.CoalesceWindow < 0 {
+		return fmt.Errorf("negative coalesce window value - %v", scriptSUPConfig.CoalesceWindow)
+	}
+	if scriptSUPConfig.ProgressInterval < 0 {
+		return fmt.Errorf("negative progress interval value - %v", scriptSUPConfig.ProgressInterval)
+	}
+	if scriptSUPConfig.InstallLock != "" && runtime.GOOS == "windows" {
+		return fmt.Errorf("install lock is not supported on windows - %s", scriptSUPConfig.InstallLock)
+	}
+	if scriptSUPConfig.InstallLockTimeout < 0 {
+		return fmt.Errorf("negative install lock timeout value - %v", scriptSUPConfig.InstallLockTimeout)
+	}
+	if scriptSUPConfig.ScanTimeout < 0 {
+		return fmt.Errorf("negative scan timeout value - %v", scriptSUPConfig.ScanTimeout)
+	}
+	if scriptSUPConfig.ApprovalTimeout < 0 {
+		return fmt.Errorf("negative approval timeout value - %v", scriptSUPConfig.ApprovalTimeout)
+	}
+	if scriptSUPConfig.ApprovalAction != approvalActionCancel && scriptSUPConfig.ApprovalAction != approvalActionProceed {
+		return fmt.Errorf("invalid approval action value - %s, must be either %s or %s",
+			scriptSUPConfig.ApprovalAction, approvalActionCancel, approvalActionProceed)
+	}
+	if scriptSUPConfig.HealthTimeout < 0 {
+		return fmt.Errorf("negative health timeout value - %v", scriptSUPConfig.HealthTimeout)
+	}
+	if scriptSUPConfig.HealthInterval < 0 {
+		return fmt.Errorf("negative health interval value - %v", scriptSUPConfig.HealthInterval)
+	}
+	if scriptSUPConfig.VersionTimeout < 0 {
+		return fmt.Errorf("negative version timeout value - %v", scriptSUPConfig.VersionTimeout)
+	}
+	if scriptSUPConfig.OperationTimeout < 0 {
+		return fmt.Errorf("negative operation timeout value - %v", scriptSUPConfig.OperationTimeout)
+	}
+	if scriptSUPConfig.MaxOperationTimeout < 0 {
+		return fmt.Errorf("negative maximal operation timeout value - %v", scriptSUPConfig.MaxOperationTimeout)
+	}
+	if scriptSUPConfig.MaxTotalBytes < 0 {
+		return fmt.Errorf("negative maximal total bytes value - %d", scriptSUPConfig.MaxTotalBytes)
+	}
+	if scriptSUPConfig.MaxArtifacts < 0 {
+		return fmt.Errorf("negative maximal artifacts value - %d", scriptSUPConfig.MaxArtifacts)
+	}
+	if scriptSUPConfig.ContinueInterval < 0 {
+		return fmt.Errorf("negative continue interval value - %v", scriptSUPConfig.ContinueInterval)
+	}
+	if scriptSUPConfig.ReportMethod != http.MethodPut && scriptSUPConfig.ReportMethod != http.MethodPost {
+		return fmt.Errorf("invalid report method value - %s, must be either PUT or POST", scriptSUPConfig.ReportMethod)
+	}
+	if scriptSUPConfig.ReportLogSize <= 0 {
+		return fmt.Errorf("non-positive report log size value - %d", scriptSUPConfig.ReportLogSize)
+	}
+	if scriptSUPConfig.AuditLogEntries < 0 {
+		return fmt.Errorf("negative audit log entries value - %d", scriptSUPConfig.AuditLogEntries)
+	}
+	if scriptSUPConfig.GracePeriod < 0 {
+		return fmt.Errorf("negative grace period value - %v", scriptSUPConfig.GracePeriod)
+	}
+	if scriptSUPConfig.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("negative shutdown grace period value - %v", scriptSUPConfig.ShutdownGracePeriod)
+	}
 	if !strings.EqualFold(modeStrict, scriptSUPConfig.Mode) && !strings.EqualFold(modeScoped, scriptSUPConfig.Mode) && !strings.EqualFold(modeLax, scriptSUPConfig.Mode) {
 		return fmt.Errorf("invalid mode value, must be either strict, scoped or lax")
 	}
 	if scriptSUPConfig.ArtifactType != typeArchive && scriptSUPConfig.ArtifactType != typePlain {
 		return fmt.Errorf("invalid artifact type - (%s), must be either %s or %s", scriptSUPConfig.ArtifactType, typeArchive, typePlain)
 	}
+	if scriptSUPConfig.CleanupPolicy != storage.CleanupKeep && scriptSUPConfig.CleanupPolicy != storage.CleanupDeleteArtifacts &&
+		scriptSUPConfig.CleanupPolicy != storage.CleanupDeleteOnNextSuccess {
+		return fmt.Errorf("invalid cleanup policy - (%s), must be either %s, %s or %s", scriptSUPConfig.CleanupPolicy,
+			storage.CleanupKeep, storage.CleanupDeleteArtifacts, storage.CleanupDeleteOnNextSuccess)
+	}
+	if err := scriptSUPConfig.InstallCommands.validate(); err != nil {
+		return err
+	}
+	if err := commandAllowList(scriptSUPConfig.CommandAllowList).validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadManifestKey loads the PEM encoded public key of the module manifest signatures, if the key file is set.
+func loadManifestKey(file string) (crypto.PublicKey, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module manifest key: %v", err)
+	}
+	key, err := storage.ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module manifest key %s: %v", file, err)
+	}
+	return key, nil
+}
+
+// validateTopicPrefix validates that the topic prefix is a valid topic name, without leading and trailing slash.
+func validateTopicPrefix(prefix string) error {
+	if strings.ContainsAny(prefix, "+#") || strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("invalid topic prefix - %s, must not contain wildcards or start and end with /", prefix)
+	}
+	return nil
+}
+
+// validateTopicSegment validates that the topic is a single topic level.
+func validateTopicSegment(name string, topic string) error {
+	if topic == "" || strings.ContainsAny(topic, "/+#") {
+		return fmt.Errorf("invalid %s topic - %s, must be a single topic level without wildcards", name, topic)
+	}
 	return nil
 }
 
@@ -246,3 +864,71 @@ func initAccessMode(accessMode string) string {
 	}
 	return accessMode
 }
+
+// artifactVerifier returns the verifier of the downloaded artifacts, nil if no verification is enabled.
+// The archives are verified before the TUF targets.
+func artifactVerifier(verifyArchives bool, tuf *storage.TUFVerifier) storage.ArtifactVerifier {
+	var verifiers []storage.ArtifactVerifier
+	if verifyArchives {
+		verifiers = append(verifiers, storage.VerifyArchive)
+	}
+	if tuf != nil {
+		verifiers = append(verifiers, tuf.Verify)
+	}
+	if len(verifiers) == 0 {
+		return nil
+	}
+	return func(artifact *storage.Artifact, data io.ReaderAt, size int64) error {
+		for _, verify := range verifiers {
+			if err := verify(artifact, data, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// loadTUFVerifier loads the trusted TUF root metadata and returns the verifier of the artifacts against the signed
+// targets of the TUF repository, nil if not configured.
+func loadTUFVerifier(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, server storage.ServerConfig) (*storage.TUFVerifier, error) {
+	if scriptSUPConfig.TUFRoot == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(scriptSUPConfig.TUFRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TUF root metadata: %v", err)
+	}
+	verifier, err := storage.NewTUFVerifier(data, scriptSUPConfig.TUFRepository, server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TUF root metadata %s: %v", scriptSUPConfig.TUFRoot, err)
+	}
+	return verifier, nil
+}
+
+// defaultStorage returns the state directory, given by the service manager, e.g. with StateDirectory= of systemd,
+// or the current directory otherwise.
+func defaultStorage() string {
+	if dirs := os.Getenv("STATE_DIRECTORY"); dirs != "" {
+		return strings.Split(dirs, string(os.PathListSeparator))[0]
+	}
+	return defaultStorageLocation
+}
+
+// storageMode returns the permission mode of the created storage location, the default one if not valid.
+func storageMode(mode string) os.FileMode {
+	if perm, err := strconv.ParseUint(mode, 8, 32); err == nil && perm&^uint64(os.ModePerm) == 0 {
+		return os.FileMode(perm)
+	}
+	return storage.DefaultLocationMode
+}
+
+// redirectPolicy returns the redirect policy of the downloads. Redirects are not followed, if the maximal
+// number of redirects is set to 0.
+func redirectPolicy(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) storage.RedirectPolicy {
+	policy := storage.RedirectPolicy{Max: scriptSUPConfig.DownloadMaxRedirects,
+		SameHost: scriptSUPConfig.DownloadSameHost, AllowList: scriptSUPConfig.DownloadRedirectList}
+	if policy.Max == 0 {
+		policy.Max = -1
+	}
+	return policy
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+const (
+	// metadataApproval is the operation metadata key, which value approvalRequired gates the installation of
+	// the downloaded modules on a separate proceed message with the operation correlation id.
+	metadataApproval = "approval"
+	approvalRequired = "required"
+
+	// Actions on the approval timeout: cancel the operation or proceed with the installation.
+	approvalActionCancel  = "cancel"
+	approvalActionProceed = "proceed"
+
+	msgWaitingForApproval = "waiting for approval"
+)
+
+// proceedHandler approves the installation of the queued or running install operation with the same correlation id.
+func (f *ScriptBasedSoftwareUpdatable) proceedHandler(
+	update *hawkbit.SoftwareUpdateAction, su *hawkbit.SoftwareUpdatable) {
+	f.cancelLock.Lock()
+	_, ok := f.cancels[update.CorrelationID]
+	if ok {
+		f.approve(update.CorrelationID)
+	}
+	f.cancelLock.Unlock()
+
+	if ok {
+		logger.Infof("Proceed with operation with id: %s", update.CorrelationID)
+		return
+	}
+	logger.Infof("No operation with id %s to proceed with", update.CorrelationID)
+}
+
+// approval returns the channel, closed when the operation with the given correlation id is approved.
+// It must be called with the cancel lock held.
+func (f *ScriptBasedSoftwareUpdatable) approval(cid string) chan struct{} {
+	if f.approvals == nil {
+		f.approvals = map[string]chan struct{}{}
+	}
+	approved, ok := f.approvals[cid]
+	if !ok {
+		approved = make(chan struct{})
+		f.approvals[cid] = approved
+	}
+	return approved
+}
+
+// approve approves the operation with the given correlation id. It must be called with the cancel lock held.
+func (f *ScriptBasedSoftwareUpdatable) approve(cid string) {
+	approved := f.approval(cid)
+	if !isCanceled(approved) {
+		close(approved)
+	}
+}
+
+// waitForApproval waits for the approval of the operation, before installing its downloaded module, reporting
+// the module as waiting for approval meanwhile. Once approved, the other operation modules are installed without
+// waiting. Without approval within the approval timeout, the operation is either canceled or proceeds, according
+// to the approval action. Returns storage.ErrCanceled, if the operation is canceled, or storage.ErrCancel, if the
+// application is closing.
+func (f *ScriptBasedSoftwareUpdatable) waitForApproval(cid string, module *storage.Module,
+	su *hawkbit.SoftwareUpdatable, cancel chan struct{}) error {
+	f.cancelLock.Lock()
+	approved := f.approval(cid)
+	f.cancelLock.Unlock()
+	if isCanceled(approved) {
+		return nil
+	}
+
+	log := operationLog("install", cid, module)
+	log.Infof("Wait for approval to install the module")
+	setLastOS(su, newOS(cid, module, hawkbit.StatusInstallingWaiting).WithMessage(msgWaitingForApproval))
+	var expired <-chan time.Time
+	if f.approvalTimeout > 0 {
+		timer := time.NewTimer(f.approvalTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-approved:
+		log.Infof("Module installation approved")
+		return nil
+	case <-cancel:
+		return storage.ErrCanceled
+	case <-done:
+		return storage.ErrCancel
+	case <-expired:
+	}
+
+	f.cancelLock.Lock()
+	defer f.cancelLock.Unlock()
+	if f.approvalAction == approvalActionProceed {
+		log.Warnf("No approval within %v, proceed with the installation", f.approvalTimeout)
+		f.approve(cid)
+		return nil
+	}
+	log.Warnf("No approval within %v, cancel the operation", f.approvalTimeout)
+	if f.cancels[cid] == cancel {
+		delete(f.cancels, cid)
+		close(cancel)
+	}
+	return storage.ErrCanceled
+}
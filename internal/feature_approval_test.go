@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestApprovalProceed tests that the staged installation waits for the proceed message.
+func TestApprovalProceed(t *testing.T) {
+	testApproval(t, true, false, 0, approvalActionCancel, hawkbit.StatusFinishedSuccess)
+}
+
+// TestApprovalTimeoutCancel tests that the operation is canceled, if not approved within the approval timeout.
+func TestApprovalTimeoutCancel(t *testing.T) {
+	testApproval(t, false, false, 200*time.Millisecond, approvalActionCancel, hawkbit.StatusFinishedCanceled)
+}
+
+// TestApprovalTimeoutProceed tests that the installation proceeds, if not approved within the approval timeout
+// and configured so.
+func TestApprovalTimeoutProceed(t *testing.T) {
+	testApproval(t, false, false, 200*time.Millisecond, approvalActionProceed, hawkbit.StatusFinishedSuccess)
+}
+
+// TestApprovalStagedModified tests that the staged artifacts, truncated while waiting for approval, are downloaded
+// again before their installation.
+func TestApprovalStagedModified(t *testing.T) {
+	testApproval(t, true, true, 0, approvalActionCancel, hawkbit.StatusFinishedSuccess)
+}
+
+func testApproval(t *testing.T, proceed bool, modify bool, timeout time.Duration, action string, expected hawkbit.Status) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-approval", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.approvalTimeout = timeout
+	feature.approvalAction = action
+
+	installed := getAbsolutePath(t, filepath.Join(tmpDir, "installed"))
+	install := "echo installed > " + installed
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+	}, "*")
+	sua.Metadata = map[string]string{metadataApproval: approvalRequired}
+
+	feature.installHandler(sua, feature.su)
+	var lo map[string]interface{}
+	waiting := false
+	for {
+		if lo = mc.pullLastOperationStatus(); lo == nil {
+			t.Fatal("install operation not finished")
+		}
+		status := lo[statusParam]
+		if status == string(hawkbit.StatusInstallingWaiting) {
+			if lo["message"] != msgWaitingForApproval {
+				t.Fatalf("unexpected waiting status message: %v", lo["message"])
+			}
+			if _, err := os.Stat(installed); !os.IsNotExist(err) {
+				t.Fatalf("module installed before approval: %v", err)
+			}
+			waiting = true
+			if modify {
+				truncateStaged(t, feature.store.DownloadPath, "install.sh")
+			}
+			if proceed {
+				feature.proceedHandler(&hawkbit.SoftwareUpdateAction{CorrelationID: sua.CorrelationID}, feature.su)
+			}
+		}
+		if status == string(hawkbit.StatusFinishedSuccess) || status == string(hawkbit.StatusFinishedError) ||
+			status == string(hawkbit.StatusFinishedCanceled) {
+			break
+		}
+	}
+	if !waiting {
+		t.Fatal("module is not reported as waiting for approval")
+	}
+	if lo[statusParam] != string(expected) {
+		t.Fatalf("unexpected install operation status: %v != %v", lo[statusParam], expected)
+	}
+	if _, err := os.Stat(installed); (err == nil) != (expected == hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("unexpected installation state: %v", err)
+	}
+}
+
+func truncateStaged(t *testing.T, dir string, name string) {
+	t.Helper()
+
+	truncated := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() == name {
+			truncated = true
+			return os.Truncate(path, 0)
+		}
+		return err
+	})
+	if err != nil || !truncated {
+		t.Fatalf("failed to truncate staged artifact %s: %v", name, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// cancelHandler cancels the queued or running download or install operation with the same correlation id.
+func (f *ScriptBasedSoftwareUpdatable) cancelHandler(
+	update *hawkbit.SoftwareUpdateAction, su *hawkbit.SoftwareUpdatable) {
+	if f.cancel(update.CorrelationID) {
+		logger.Infof("Cancel operation with id: %s", update.CorrelationID)
+		return
+	}
+	logger.Infof("No operation with id %s to cancel", update.CorrelationID)
+	for _, module := range update.SoftwareModules {
+		setLastOS(su, hawkbit.NewOperationStatusUpdate(update.CorrelationID, hawkbit.StatusCancelRejected, module.SoftwareModule).
+			WithMessage(fmt.Sprintf("no operation with id %s to cancel", update.CorrelationID)))
+	}
+}
+
+// cancelPropertyHandler cancels the queued or running operation with the correlation id, set to the cancel property.
+// Unlike the cancel messages, the property changes for unknown operations are ignored, as the property can remain
+// set after the operation is finished.
+func (f *ScriptBasedSoftwareUpdatable) cancelPropertyHandler(
+	update *hawkbit.SoftwareUpdateAction, su *hawkbit.SoftwareUpdatable) {
+	if f.cancel(update.CorrelationID) {
+		logger.Infof("Cancel operation with id: %s, requested by property change", update.CorrelationID)
+		return
+	}
+	logger.Debugf("Ignore cancel property change for unknown operation with id: %s", update.CorrelationID)
+}
+
+// cancel closes the cancel channel of the operation with the given correlation id and reports if there was one.
+func (f *ScriptBasedSoftwareUpdatable) cancel(cid string) bool {
+	f.cancelLock.Lock()
+	defer f.cancelLock.Unlock()
+
+	cancel, ok := f.cancels[cid]
+	if ok {
+		delete(f.cancels, cid)
+		close(cancel)
+	}
+	return ok
+}
+
+// addCancel returns a new cancel channel for the operation with the given correlation id.
+func (f *ScriptBasedSoftwareUpdatable) addCancel(cid string) chan struct{} {
+	f.cancelLock.Lock()
+	defer f.cancelLock.Unlock()
+
+	if f.cancels == nil {
+		f.cancels = map[string]chan struct{}{}
+	}
+	cancel := make(chan struct{})
+	f.cancels[cid] = cancel
+	return cancel
+}
+
+// removeCancel removes the cancel channel and the approval of the finished operation with the given correlation id.
+func (f *ScriptBasedSoftwareUpdatable) removeCancel(cid string, cancel chan struct{}) {
+	f.cancelLock.Lock()
+	defer f.cancelLock.Unlock()
+
+	if f.cancels[cid] == cancel {
+		delete(f.cancels, cid)
+	}
+	if _, ok := f.cancels[cid]; !ok {
+		delete(f.approvals, cid)
+	}
+}
+
+// rollback executes the module rollback script, if available, after its installation is canceled.
+// The rollback script is restricted by the command allow list of the install command.
+func rollback(dir string, module *storage.Module, allowed commandAllowList) {
+	script := "rollback.sh"
+	if runtime.GOOS == "windows" {
+		script = "rollback.bat"
+	}
+	if _, err := os.Stat(filepath.Join(dir, script)); err != nil {
+		return
+	}
+	logger.Infof("[%s.%s] Rollback canceled module installation", module.Name, module.Version)
+	if err := (&command{allowed: allowed}).run(dir, "rollback", nil, 0); err != nil {
+		logger.Errorf("failed to rollback module [%s.%s]: %v", module.Name, module.Version, err)
+	}
+}
+
+func isCanceled(cancel chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
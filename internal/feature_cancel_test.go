@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse/ditto-clients-golang/model"
+	"github.com/eclipse/ditto-clients-golang/protocol/things"
+)
+
+// TestCancelInstall tests canceling of a running install script, which ignores the terminate signal.
+func TestCancelInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script cannot ignore the terminate signal on windows")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-cancel", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.gracePeriod = 500 * time.Millisecond
+
+	rolledBack := getAbsolutePath(t, filepath.Join(tmpDir, "rolledback"))
+	install := "trap '' TERM\necho started > started\nwhile true; do sleep 0.1; done"
+	rollback := fmt.Sprintf("echo rolled back > %s", rolledBack)
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	rollbackPath, rollbackHash := createLocalArtifact(t, tmpDir, "rollback.sh", rollback)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+		convertLocalArtifact(getAbsolutePath(t, rollbackPath), "rollback.sh", rollbackHash, len(rollback)),
+	}, "*")
+
+	// 1. Reject to cancel unknown operation.
+	feature.cancelHandler(sua, feature.su)
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusCancelRejected) {
+		t.Fatalf("expected cancel to be rejected: %v", lo)
+	}
+
+	// 2. Cancel the running install script.
+	feature.installHandler(sua, feature.su)
+	for {
+		lo := mc.pullLastOperationStatus()
+		if lo == nil {
+			t.Fatal("install operation not started")
+		}
+		if lo[statusParam] == string(hawkbit.StatusInstalling) {
+			break
+		}
+	}
+	waitForFile(t, filepath.Join(dir, "download", "*", "0", "started"))
+
+	start := time.Now()
+	feature.cancelHandler(sua, feature.su)
+	lo := mc.pullLastOperationStatus()
+	if lo == nil || lo[statusParam] != string(hawkbit.StatusFinishedCanceled) {
+		t.Fatalf("expected install to be canceled: %v", lo)
+	}
+	if elapsed := time.Since(start); elapsed < feature.gracePeriod {
+		t.Fatalf("install script killed before the grace period: %v", elapsed)
+	}
+	checkFileExistsWithContent(t, rolledBack, "rolled back")
+}
+
+// TestCancelProperty tests canceling of a running install operation by a cancel property change, ignoring the
+// changes for unrelated operations.
+func TestCancelProperty(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-cancel-property", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.gracePeriod = 500 * time.Millisecond
+
+	install := "echo started > started\nwhile true; do sleep 0.1; done"
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+	}, "*")
+
+	feature.installHandler(sua, feature.su)
+	for {
+		lo := mc.pullLastOperationStatus()
+		if lo == nil {
+			t.Fatal("install operation not started")
+		}
+		if lo[statusParam] == string(hawkbit.StatusInstalling) {
+			break
+		}
+	}
+	waitForFile(t, filepath.Join(dir, "download", "*", "0", "started"))
+
+	// 1. Ignore the cancel property change for unrelated operation, neither canceled nor rejected.
+	thingID := model.NewNamespacedID(testTopicNamespace, testTopicEntryID)
+	mc.sendCommand(t, things.NewCommand(thingID).FeatureProperty(NewDefaultConfig().FeatureID, "cancel").
+		Modify(&hawkbit.SoftwareUpdateAction{CorrelationID: "unrelated-id"}).Twin().Envelope())
+
+	// 2. Cancel the running install operation.
+	mc.sendCommand(t, things.NewCommand(thingID).FeatureProperty(NewDefaultConfig().FeatureID, "cancel").
+		Modify(sua.CorrelationID).Twin().Envelope())
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusFinishedCanceled) {
+		t.Fatalf("expected install to be canceled: %v", lo)
+	}
+}
+
+func waitForFile(t *testing.T, pattern string) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("file %s not created", pattern)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// receive records the operation with the given correlation id as the latest received operation of its modules,
+// superseding their older operations, which have not started yet, and returns its receive time.
+func (f *ScriptBasedSoftwareUpdatable) receive(cid string, modules []*hawkbit.SoftwareModuleAction) time.Time {
+	if f.coalesceWindow <= 0 {
+		return time.Time{}
+	}
+	f.coalesceLock.Lock()
+	defer f.coalesceLock.Unlock()
+
+	if f.latest == nil {
+		f.latest = map[string]string{}
+	}
+	for _, module := range modules {
+		if module.SoftwareModule != nil {
+			f.latest[module.SoftwareModule.Name] = cid
+		}
+	}
+	return clock.Now()
+}
+
+// coalesce waits until the coalesce window, since the operation was received, elapses and reports, if the operation
+// is superseded by newer operations, received in the meantime, which cover all of its modules. The canceled newer
+// operations do not supersede it. The superseded operations are reported as canceled and their working directory
+// is removed. Canceled operations stop waiting and are never superseded, so that their cancellation always takes
+// effect on processing. Returns true, if the application is closing.
+func (f *ScriptBasedSoftwareUpdatable) coalesce(name string, cid string, modules []*hawkbit.SoftwareModuleAction,
+	received time.Time, toDir string, cancel chan struct{}) (superseded bool, closing bool) {
+	if f.coalesceWindow <= 0 || received.IsZero() {
+		return false, false
+	}
+	if delay := received.Add(f.coalesceWindow).Sub(clock.Now()); delay > 0 {
+		operationLog(name, cid, nil).Debugf("Wait %v for newer operations of the same modules", delay)
+		select {
+		case <-done:
+			return false, true
+		case <-cancel:
+		case <-clock.After(delay):
+		}
+	}
+
+	f.coalesceLock.Lock()
+	newer := map[string]bool{}
+	covered := true
+	for _, module := range modules {
+		if module.SoftwareModule == nil {
+			continue
+		}
+		latest := f.latest[module.SoftwareModule.Name]
+		if latest == cid {
+			delete(f.latest, module.SoftwareModule.Name)
+		}
+		if latest == cid || latest == "" || !f.isQueued(latest) {
+			covered = false
+		} else {
+			newer[latest] = true
+		}
+	}
+	f.coalesceLock.Unlock()
+	if !covered || len(newer) == 0 || isCanceled(cancel) {
+		return false, false
+	}
+
+	by := make([]string, 0, len(newer))
+	for id := range newer {
+		by = append(by, id)
+	}
+	sort.Strings(by)
+
+	logger.Infof("Skip %s operation with id %s, superseded by operation with id %s", name, cid, strings.Join(by, ", "))
+	for _, module := range modules {
+		setLastOS(f.su, hawkbit.NewOperationStatusUpdate(cid, hawkbit.StatusFinishedCanceled, module.SoftwareModule).
+			WithMessage(fmt.Sprintf("superseded by operation with id %s", strings.Join(by, ", "))))
+	}
+	if err := os.RemoveAll(toDir); err != nil {
+		logger.Errorf("failed to remove directory [%s]: %v", toDir, err)
+	}
+	return true, false
+}
+
+// isQueued reports whether the operation with the given correlation id is queued or running and not canceled.
+func (f *ScriptBasedSoftwareUpdatable) isQueued(cid string) bool {
+	f.cancelLock.Lock()
+	defer f.cancelLock.Unlock()
+
+	_, ok := f.cancels[cid]
+	return ok
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestCoalesceSupersede tests that a newer operation of the same module, received within the coalesce window,
+// supersedes the older one before it is started.
+func TestCoalesceSupersede(t *testing.T) {
+	testCoalesce(t, "", false)
+}
+
+// TestCoalescePartial tests that a newer operation, which does not cover all modules of the older one,
+// does not supersede it.
+func TestCoalescePartial(t *testing.T) {
+	testCoalesce(t, "", true)
+}
+
+// TestCoalesceCancelWins tests that the cancellation of an older operation takes effect, even if it is superseded,
+// and that a canceled newer operation does not supersede the older one.
+func TestCoalesceCancelWins(t *testing.T) {
+	t.Run("older", func(t *testing.T) {
+		testCoalesce(t, "test-coalesce-older", false)
+	})
+	t.Run("newer", func(t *testing.T) {
+		testCoalesce(t, "test-coalesce-newer", false)
+	})
+}
+
+func testCoalesce(t *testing.T, canceled string, partial bool) {
+	feature, mc, fx := mockOperationFeature(t)
+	feature.coalesceWindow = time.Second
+
+	older, newer := installAction(fx, "test-coalesce-older"), installAction(fx, "test-coalesce-newer")
+	if partial {
+		other := *older.SoftwareModules[0]
+		other.SoftwareModule = &hawkbit.SoftwareModuleID{Name: "other", Version: "1.0.0"}
+		older.SoftwareModules = append(older.SoftwareModules, &other)
+	}
+	feature.installHandler(older, feature.su)
+	feature.installHandler(newer, feature.su)
+	if canceled != "" {
+		feature.cancelHandler(installAction(fx, canceled), feature.su)
+	}
+
+	final := map[string]map[string]interface{}{}
+	for i := 0; i < len(older.SoftwareModules)+len(newer.SoftwareModules); i++ {
+		lo := pullFinalOperationStatus(t, mc)
+		final[lo["correlationId"].(string)] = lo
+	}
+	olderStatus, newerStatus := final[older.CorrelationID], final[newer.CorrelationID]
+	superseded := canceled == "" && !partial
+	expected := hawkbit.StatusFinishedSuccess
+	if superseded || canceled == older.CorrelationID {
+		expected = hawkbit.StatusFinishedCanceled
+	}
+	if olderStatus[statusParam] != string(expected) {
+		t.Fatalf("unexpected older operation status: %v != %v", olderStatus, expected)
+	}
+	msg, _ := olderStatus[messageParam].(string)
+	if strings.Contains(msg, "superseded by operation with id "+newer.CorrelationID) != superseded {
+		t.Fatalf("unexpected older operation status message: %v", olderStatus)
+	}
+	expected = hawkbit.StatusFinishedSuccess
+	if canceled == newer.CorrelationID {
+		expected = hawkbit.StatusFinishedCanceled
+	}
+	if newerStatus[statusParam] != string(expected) {
+		t.Fatalf("unexpected newer operation status: %v != %v", newerStatus, expected)
+	}
+	installs := 0
+	if olderStatus[statusParam] == string(hawkbit.StatusFinishedSuccess) {
+		installs += len(older.SoftwareModules)
+	}
+	if newerStatus[statusParam] == string(hawkbit.StatusFinishedSuccess) {
+		installs += len(newer.SoftwareModules)
+	}
+	if count := fx.Installs(); count != installs {
+		t.Fatalf("expected %d installed modules, got: %d", installs, count)
+	}
+}
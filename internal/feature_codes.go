@@ -0,0 +1,203 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// Status codes, reported as statusCode of the failed operations. The codes are part of the
+// public contract with the backend: existing codes must never be changed or reused.
+const (
+	// codeRuntime is reported on unexpected internal errors.
+	codeRuntime = "RUNTIME_ERROR"
+	// codeDownload is reported on download errors, not covered by a more specific code.
+	codeDownload = "DOWNLOAD_ERROR"
+	// codeDownloadChecksumMismatch is reported when the downloaded artifact checksum does not match.
+	codeDownloadChecksumMismatch = "DOWNLOAD_CHECKSUM_MISMATCH"
+	// codeDownloadSizeExceeded is reported when the downloaded artifact is bigger than expected.
+	codeDownloadSizeExceeded = "DOWNLOAD_SIZE_EXCEEDED"
+	// codeDownloadSizeMismatch is reported when the downloaded artifact is smaller than expected.
+	codeDownloadSizeMismatch = "DOWNLOAD_SIZE_MISMATCH"
+	// codeDownloadETagMismatch is reported when the artifact server entity tag does not match the expected one.
+	codeDownloadETagMismatch = "DOWNLOAD_ETAG_MISMATCH"
+	// codeArtifactInvalid is reported when the downloaded artifact format or structure is not valid.
+	codeArtifactInvalid = "ARTIFACT_INVALID"
+	// codeDownloadHostKeyRejected is reported when the SFTP server host key does not match the known hosts.
+	codeDownloadHostKeyRejected = "DOWNLOAD_HOST_KEY_REJECTED"
+	// codeDownloadLinkNotAllowed is reported when the artifact link is not allowed by the download allow list.
+	codeDownloadLinkNotAllowed = "DOWNLOAD_LINK_NOT_ALLOWED"
+	// codeDownloadRedirectNotAllowed is reported when a redirect of the artifact download is not allowed by the redirect policy.
+	codeDownloadRedirectNotAllowed = "DOWNLOAD_REDIRECT_NOT_ALLOWED"
+	// codeManifestInvalid is reported when the module manifest is missing, not signed with the trusted key or not valid.
+	codeManifestInvalid = "MANIFEST_INVALID"
+	// codeDownloadTooManyRestarts is reported when the artifact download is restarted from the beginning too many times.
+	codeDownloadTooManyRestarts = "DOWNLOAD_TOO_MANY_RESTARTS"
+	// codeStoredSizeMismatch is reported when the stored artifact file size does not match the downloaded artifact.
+	codeStoredSizeMismatch = "STORED_SIZE_MISMATCH"
+	// codeDownloadNetworkError is reported when the artifact cannot be transferred from its server.
+	codeDownloadNetworkError = "DOWNLOAD_NETWORK_ERROR"
+	// codeDownloadTimeout is reported when the artifact server does not respond in time.
+	codeDownloadTimeout = "DOWNLOAD_TIMEOUT"
+	// codeInsufficientSpace is reported when there is no space left on the device.
+	codeInsufficientSpace = "INSUFFICIENT_SPACE"
+	// codeMultipleArchives is reported when an archive module has more than one artifact.
+	codeMultipleArchives = "MULTIPLE_ARCHIVES"
+	// codeArchiveExtract is reported when the module archive cannot be extracted.
+	codeArchiveExtract = "ARCHIVE_EXTRACT_ERROR"
+	// codeInstallScript is reported when the install script fails, suffixed with its exit code, if it exits.
+	codeInstallScript = "INSTALL_SCRIPT_ERROR"
+	// codeInstalledDeps is reported when the installed dependencies cannot be saved or refreshed.
+	codeInstalledDeps = "INSTALLED_DEPENDENCIES_ERROR"
+	// codeArtifactScan is reported when a downloaded artifact is rejected by the scan command.
+	codeArtifactScan = "ARTIFACT_SCAN_REJECTED"
+	// codeHealthProbe is reported when the installed module does not pass the health probe and is rolled back.
+	codeHealthProbe = "HEALTH_PROBE_FAILED"
+	// codeVersionMismatch is reported when the installed module does not report its version and is rolled back.
+	codeVersionMismatch = "VERSION_MISMATCH"
+	// codeUnsupportedArtifactType is reported when no install command is configured for the module artifact type.
+	codeUnsupportedArtifactType = "UNSUPPORTED_ARTIFACT_TYPE"
+	// codeCommandNotAllowed is reported when the executable of a command is not allowed by the command allow list.
+	codeCommandNotAllowed = "COMMAND_NOT_ALLOWED"
+	// codeOperationTimeout is reported when the operation does not finish within the overall operation timeout.
+	codeOperationTimeout = "OPERATION_TIMEOUT"
+	// codeInvalidOperationTimeout is reported when the operation timeout, supplied by the backend, is not valid or not allowed.
+	codeInvalidOperationTimeout = "INVALID_OPERATION_TIMEOUT"
+	// codeOperationLimitExceeded is reported when the operation exceeds the maximal total size or count of its artifacts.
+	codeOperationLimitExceeded = "OPERATION_LIMIT_EXCEEDED"
+	// codeInstallLocked is reported when the install lock cannot be acquired, e.g. held by another process beyond the install lock timeout.
+	codeInstallLocked = "INSTALL_LOCKED"
+)
+
+// messageCodes maps the operation error messages to their status codes.
+var messageCodes = map[string]string{
+	errRuntime:               codeRuntime,
+	errMultiArchives:         codeMultipleArchives,
+	errDownload:              codeDownload,
+	errExtractArchive:        codeArchiveExtract,
+	errInstallScript:         codeInstallScript,
+	errInstalledDepsSave:     codeInstalledDeps,
+	errInstalledDepsRefresh:  codeInstalledDeps,
+	errDetermineAbsolutePath: codeInstallScript,
+	errUnmappedArtifactType:  codeUnsupportedArtifactType,
+	errArtifactScan:          codeArtifactScan,
+	errHealthProbe:           codeHealthProbe,
+	errVersionCheck:          codeVersionMismatch,
+	errOperationTimeout:      codeOperationTimeout,
+	errInstallLock:           codeInstallLocked,
+}
+
+// toStatusCode returns the status code of an operation, failed with the given error message and cause.
+// The cause has priority, as it is more specific than the error message.
+func toStatusCode(msg string, err error) string {
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return codeInsufficientSpace
+		}
+		if errors.Is(err, storage.ErrChecksumMismatch) {
+			return codeDownloadChecksumMismatch
+		}
+		if errors.Is(err, storage.ErrFileSizeExceeded) {
+			return codeDownloadSizeExceeded
+		}
+		if errors.Is(err, storage.ErrFileSizeMismatch) {
+			return codeDownloadSizeMismatch
+		}
+		if errors.Is(err, storage.ErrETagMismatch) {
+			return codeDownloadETagMismatch
+		}
+		if errors.Is(err, storage.ErrArtifactInvalid) || errors.Is(err, storage.ErrTreeInvalid) ||
+			errors.Is(err, storage.ErrDuplicateFileName) {
+			return codeArtifactInvalid
+		}
+		if errors.Is(err, storage.ErrHostKeyRejected) {
+			return codeDownloadHostKeyRejected
+		}
+		if errors.Is(err, storage.ErrLinkNotAllowed) {
+			return codeDownloadLinkNotAllowed
+		}
+		if errors.Is(err, storage.ErrRedirectNotAllowed) {
+			return codeDownloadRedirectNotAllowed
+		}
+		if errors.Is(err, storage.ErrManifestInvalid) {
+			return codeManifestInvalid
+		}
+		if errors.Is(err, storage.ErrTooManyRestarts) {
+			return codeDownloadTooManyRestarts
+		}
+		if errors.Is(err, storage.ErrStoredSizeMismatch) {
+			return codeStoredSizeMismatch
+		}
+		if errors.Is(err, errCommandNotAllowed) {
+			return codeCommandNotAllowed
+		}
+		if errors.Is(err, errInvalidOperationTimeout) {
+			return codeInvalidOperationTimeout
+		}
+		if errors.Is(err, errOperationLimitExceeded) {
+			return codeOperationLimitExceeded
+		}
+		var urlErr *url.Error
+		var netErr net.Error
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) ||
+			errors.As(err, &netErr) && netErr.Timeout() {
+			return codeDownloadTimeout
+		}
+		if errors.Is(err, storage.ErrBadStatus) || errors.Is(err, storage.ErrCircuitOpen) || errors.As(err, &urlErr) ||
+			errors.As(err, &netErr) {
+			return codeDownloadNetworkError
+		}
+	}
+	if code, ok := messageCodes[msg]; ok {
+		return code
+	}
+	return codeRuntime
+}
+
+// toExitStatusCode returns the status code of the install script, failed with the given exit code, i.e. the
+// install script status code, suffixed with the exit code, if the script exits, e.g. INSTALL_SCRIPT_ERROR:2.
+func toExitStatusCode(exitCode int) string {
+	if exitCode < 0 {
+		return codeInstallScript
+	}
+	return codeInstallScript + ":" + strconv.Itoa(exitCode)
+}
+
+// toStatusMessage returns the status message of an operation, failed with the given error message and cause.
+// The failed artifacts are listed, if the module artifacts fail to download, the reached status is given,
+// if the operation times out, and the rejected command is given, if not allowed.
+func toStatusMessage(msg string, err error) string {
+	var timeoutErr *operationTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.Error()
+	}
+	if errors.Is(err, errCommandNotAllowed) || errors.Is(err, errHealthProbeFailed) || errors.Is(err, errVersionMismatch) ||
+		errors.Is(err, errInstallLocked) {
+		return fmt.Sprintf("%s - %v", msg, err)
+	}
+	var artifactsErr *storage.ArtifactsError
+	if errors.As(err, &artifactsErr) {
+		return fmt.Sprintf("%s - failed artifacts: %s", msg, strings.Join(artifactsErr.FileNames(), ", "))
+	}
+	return msg
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestToStatusCode tests the mapping of the operation errors to status codes.
+func TestToStatusCode(t *testing.T) {
+	tests := []struct {
+		msg  string
+		err  error
+		code string
+	}{
+		{errDownload, fmt.Errorf("%w: abc != def", storage.ErrChecksumMismatch), codeDownloadChecksumMismatch},
+		{errDownload, storage.ErrFileSizeExceeded, codeDownloadSizeExceeded},
+		{errDownload, fmt.Errorf("%w: 10 bytes, expected at least 20", storage.ErrFileSizeMismatch), codeDownloadSizeMismatch},
+		{errDownload, fmt.Errorf("%w: \"abc\" != \"def\"", storage.ErrETagMismatch), codeDownloadETagMismatch},
+		{errDownload, fmt.Errorf("%w: invalid zip archive", storage.ErrArtifactInvalid), codeArtifactInvalid},
+		{errDownload, &url.Error{Op: "Get", URL: "http://localhost", Err: fmt.Errorf("%w: http://other", storage.ErrLinkNotAllowed)}, codeDownloadLinkNotAllowed},
+		{errDownload, &url.Error{Op: "Get", URL: "http://localhost", Err: fmt.Errorf("%w: http://other", storage.ErrRedirectNotAllowed)}, codeDownloadRedirectNotAllowed},
+		{errDownload, fmt.Errorf("ssh: handshake failed: %w", storage.ErrHostKeyRejected), codeDownloadHostKeyRejected},
+		{errDownload, fmt.Errorf("%w: signature of manifest.sha256 does not match", storage.ErrManifestInvalid), codeManifestInvalid},
+		{errDownload, fmt.Errorf("%w: invalid path ../escape", storage.ErrTreeInvalid), codeArtifactInvalid},
+		{errRuntime, fmt.Errorf("%w: app.bin in module test:1.0.0", storage.ErrDuplicateFileName), codeArtifactInvalid},
+		{errDownload, fmt.Errorf("%w: 404", storage.ErrBadStatus), codeDownloadNetworkError},
+		{errDownload, fmt.Errorf("%w: cdn.example.com", storage.ErrCircuitOpen), codeDownloadNetworkError},
+		{errDownload, fmt.Errorf("%w: 5 restarts of app.bin", storage.ErrTooManyRestarts), codeDownloadTooManyRestarts},
+		{errDownload, fmt.Errorf("%w: app.bin - 10 bytes, expected 20", storage.ErrStoredSizeMismatch), codeStoredSizeMismatch},
+		{errDownload, &url.Error{Op: "Get", URL: "http://localhost", Err: syscall.ECONNREFUSED}, codeDownloadNetworkError},
+		{errDownload, &url.Error{Op: "Get", URL: "http://localhost", Err: context.DeadlineExceeded}, codeDownloadTimeout},
+		{errDownload, fmt.Errorf("read tcp: %w", os.ErrDeadlineExceeded), codeDownloadTimeout},
+		{errDownload, &os.PathError{Op: "write", Path: "file", Err: syscall.ENOSPC}, codeInsufficientSpace},
+		{errDownload, errors.New("unknown"), codeDownload},
+		{errMultiArchives, errors.New(errMultiArchives), codeMultipleArchives},
+		{errExtractArchive, errors.New("invalid archive"), codeArchiveExtract},
+		{errInstallScript, errors.New("not executable"), codeInstallScript},
+		{errDetermineAbsolutePath, errors.New("no path"), codeInstallScript},
+		{errInstalledDepsSave, errors.New("cannot save"), codeInstalledDeps},
+		{errInstalledDepsRefresh, errors.New("cannot refresh"), codeInstalledDeps},
+		{errArtifactScan, errScanTimeout, codeArtifactScan},
+		{errHealthProbe, fmt.Errorf("%w after 3 attempts within 1m0s: exit status 1", errHealthProbeFailed), codeHealthProbe},
+		{errVersionCheck, fmt.Errorf("%w: \"1.0.0\" instead of \"1.1.0\"", errVersionMismatch), codeVersionMismatch},
+		{errVersionCheck, errors.New("exit status 1"), codeVersionMismatch},
+		{errInstallScript, fmt.Errorf("%w: /usr/bin/curl", errCommandNotAllowed), codeCommandNotAllowed},
+		{errOperationTimeout, &operationTimeoutError{timeout: time.Minute}, codeOperationTimeout},
+		{errRuntime, fmt.Errorf("%w: timeout -1s is not positive", errInvalidOperationTimeout), codeInvalidOperationTimeout},
+		{errRuntime, fmt.Errorf("%w: 3 artifacts exceed the maximal 2 artifacts", errOperationLimitExceeded), codeOperationLimitExceeded},
+		{errInstallLock, fmt.Errorf("%w: /run/install.lock not acquired within 1m0s", errInstallLocked), codeInstallLocked},
+		{errInstallLock, errors.New("permission denied"), codeInstallLocked},
+		{errRuntime, errors.New("unexpected"), codeRuntime},
+		{"unknown error message", nil, codeRuntime},
+	}
+	for _, test := range tests {
+		if code := toStatusCode(test.msg, test.err); code != test.code {
+			t.Errorf("unexpected status code for [%s][%v]: %s != %s", test.msg, test.err, code, test.code)
+		}
+	}
+}
+
+// TestToExitStatusCode tests keeping the exit code of the failed install script in its status code.
+func TestToExitStatusCode(t *testing.T) {
+	if code := toExitStatusCode(2); code != codeInstallScript+":2" {
+		t.Errorf("unexpected status code of exit code 2: %s", code)
+	}
+	if code := toExitStatusCode(-1); code != codeInstallScript {
+		t.Errorf("unexpected status code of killed install script: %s", code)
+	}
+}
+
+// TestToStatusMessage tests listing the failed artifacts and the reached status in the operation status message.
+func TestToStatusMessage(t *testing.T) {
+	err := &storage.ArtifactsError{Total: 3, Failed: []*storage.ArtifactError{
+		{FileName: "a.txt", Err: storage.ErrChecksumMismatch},
+		{FileName: "c.txt", Err: storage.ErrFileSizeExceeded},
+	}}
+	if msg := toStatusMessage(errDownload, err); msg != errDownload+" - failed artifacts: a.txt, c.txt" {
+		t.Errorf("unexpected status message of failed artifacts: %s", msg)
+	}
+	timeoutErr := &operationTimeoutError{timeout: time.Minute}
+	if msg := toStatusMessage(errOperationTimeout, timeoutErr); msg != errOperationTimeout+" after 1m0s - module not started" {
+		t.Errorf("unexpected status message of not started module: %s", msg)
+	}
+	timeoutErr.last = (&hawkbit.OperationStatus{Status: hawkbit.StatusDownloading}).WithProgress(40).WithMessage("downloaded 40 of 100 bytes")
+	if msg := toStatusMessage(errOperationTimeout, timeoutErr); msg != errOperationTimeout+" after 1m0s - last status: DOWNLOADING, progress: 40%, downloaded 40 of 100 bytes" {
+		t.Errorf("unexpected status message of timed out module: %s", msg)
+	}
+	if msg := toStatusMessage(errDownload, storage.ErrChecksumMismatch); msg != errDownload {
+		t.Errorf("unexpected status message: %s", msg)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// Exit codes of the continue command, suspending and aborting the operations. Exit code 0 continues them.
+const (
+	suspendExitCode = 1
+	abortExitCode   = 2
+)
+
+const msgAborted = "aborted by the continue policy, resumed on the next start"
+
+// continuePolicy returns the configured continue policy or the policy of the continue command, if set.
+func continuePolicy(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) storage.ContinuePolicy {
+	if scriptSUPConfig.ContinuePolicy != nil {
+		return scriptSUPConfig.ContinuePolicy
+	}
+	if scriptSUPConfig.ContinueCommand.cmd == "" {
+		return nil
+	}
+	return commandPolicy(&scriptSUPConfig.ContinueCommand, time.Duration(scriptSUPConfig.ContinueInterval))
+}
+
+// commandPolicy returns a continue policy, running the command and deciding on its exit code. Commands, which
+// fail to run, exit with unknown code or do not finish within the timeout, let the operations continue.
+func commandPolicy(cmd *command, timeout time.Duration) storage.ContinuePolicy {
+	return func() storage.Decision {
+		terminate := make(chan struct{})
+		if timeout > 0 {
+			timer := time.AfterFunc(timeout, func() { close(terminate) })
+			defer timer.Stop()
+		}
+		err := cmd.run("", "continue", terminate, 0)
+		if err == nil {
+			return storage.DecisionContinue
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitCode() {
+			case suspendExitCode:
+				return storage.DecisionSuspend
+			case abortExitCode:
+				return storage.DecisionAbort
+			}
+		}
+		logger.Errorf("continue command %s failed, continue the operation: %v", cmd, err)
+		return storage.DecisionContinue
+	}
+}
+
+// waitToContinue consults the continue policy before the next step of the operation, waiting while the operation
+// is suspended. Returns storage.ErrAborted, if the operation is aborted, storage.ErrCancel, if the application is
+// closing, or storage.ErrCanceled, if the operation is canceled while suspended.
+func (f *ScriptBasedSoftwareUpdatable) waitToContinue(operation string, cid string, cancel chan struct{}) error {
+	if f.server.Continue == nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	released := make(chan struct{})
+	defer close(released)
+	go func() {
+		select {
+		case <-cancel:
+		case <-done:
+		case <-released:
+			return
+		}
+		close(stop)
+	}()
+	err := f.server.Continue.Wait(f.server.ContinueInterval, stop)
+	if err == storage.ErrCancel && !isShuttingDown() {
+		return storage.ErrCanceled
+	}
+	if err == storage.ErrAborted {
+		operationLog(operation, cid, nil).Warnf("Operation aborted by the continue policy, it is resumed on the next start")
+	}
+	return err
+}
+
+// reportAborted reports the module of the operation, aborted by the continue policy, with the given waiting status,
+// so that the backend is not left with its last progress status, until the operation is resumed on the next start.
+func reportAborted(cid string, module *storage.Module, status hawkbit.Status, su *hawkbit.SoftwareUpdatable) {
+	setLastOS(su, newOS(cid, module, status).WithMessage(msgAborted))
+}
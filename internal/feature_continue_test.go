@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestCommandPolicy tests the continue policy decisions on the continue command exit codes.
+func TestCommandPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("continue script is not supported on windows")
+	}
+	tests := map[string]storage.Decision{
+		"exit 0":   storage.DecisionContinue,
+		"exit 1":   storage.DecisionSuspend,
+		"exit 2":   storage.DecisionAbort,
+		"exit 3":   storage.DecisionContinue,
+		"sleep 10": storage.DecisionContinue,
+	}
+	for script, expected := range tests {
+		t.Run(script, func(t *testing.T) {
+			policy := commandPolicy(&command{cmd: "/bin/sh", args: []string{"-c", script}}, 500*time.Millisecond)
+			if decision := policy(); decision != expected {
+				t.Fatalf("unexpected decision: %v != %v", decision, expected)
+			}
+		})
+	}
+}
+
+// TestContinuePolicySuspend tests that the install operation is suspended and continued by the continue policy.
+func TestContinuePolicySuspend(t *testing.T) {
+	var calls int32
+	testContinuePolicy(t, func(downloaded string) storage.ContinuePolicy {
+		return func() storage.Decision {
+			if atomic.AddInt32(&calls, 1) <= 3 {
+				return storage.DecisionSuspend
+			}
+			return storage.DecisionContinue
+		}
+	}, false)
+	if atomic.LoadInt32(&calls) <= 3 {
+		t.Fatalf("expected the suspended operation to consult the policy, got %d calls", calls)
+	}
+}
+
+// TestContinuePolicyAbort tests that the install operation, aborted by the continue policy after its download,
+// is left to be resumed and installed without downloading its artifacts again.
+func TestContinuePolicyAbort(t *testing.T) {
+	testContinuePolicy(t, func(downloaded string) storage.ContinuePolicy {
+		return func() storage.Decision {
+			if _, err := os.Stat(downloaded); err == nil {
+				return storage.DecisionAbort
+			}
+			return storage.DecisionContinue
+		}
+	}, true)
+}
+
+func testContinuePolicy(t *testing.T, policy func(downloaded string) storage.ContinuePolicy, abort bool) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-continue", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	installed := getAbsolutePath(t, filepath.Join(tmpDir, "installed"))
+	install := "echo installed >> " + installed
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+	}, "*")
+	operationDir := filepath.Join(dir, "download", "0-"+sua.CorrelationID)
+	decide := policy(filepath.Join(operationDir, "0", "install.sh"))
+
+	var resumed int32
+	aborted := make(chan struct{}, 1)
+	feature.server.Continue = func() storage.Decision {
+		if atomic.LoadInt32(&resumed) == 1 {
+			return storage.DecisionContinue
+		}
+		decision := decide()
+		if decision == storage.DecisionAbort {
+			select {
+			case aborted <- struct{}{}:
+			default:
+			}
+		}
+		return decision
+	}
+	feature.server.ContinueInterval = 10 * time.Millisecond
+
+	feature.installHandler(sua, feature.su)
+	if abort {
+		for lo := mc.pullLastOperationStatus(); lo[statusParam] != string(hawkbit.StatusDownloaded); lo = mc.pullLastOperationStatus() {
+			if lo == nil {
+				t.Fatal("install operation not downloaded")
+			}
+		}
+		select {
+		case <-aborted:
+		case <-time.After(10 * time.Second):
+			t.Fatal("install operation is not aborted")
+		}
+		// The aborted operation is reported as waiting to be resumed.
+		if lo := mc.pullLastOperationStatus(); lo[statusParam] != string(hawkbit.StatusInstallingWaiting) ||
+			lo[messageParam] != msgAborted {
+			t.Fatalf("unexpected aborted install operation status: %v", lo)
+		}
+		// The aborted operation is kept with its downloaded artifact, but not installed.
+		if _, err := os.Stat(filepath.Join(operationDir, storage.SoftwareUpdatableName)); err != nil {
+			t.Fatalf("aborted operation is not kept: %v", err)
+		}
+		if _, err := os.Stat(installed); !os.IsNotExist(err) {
+			t.Fatalf("aborted operation must not be installed: %v", err)
+		}
+
+		// Resume the aborted operation, as on the next start.
+		atomic.StoreInt32(&resumed, 1)
+		feature.load()
+	}
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("unexpected install operation status: %v", lo)
+	}
+	checkFileExistsWithContent(t, installed, "installed")
+}
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 	"github.com/eclipse-kanto/software-update/internal/logger"
@@ -26,51 +27,75 @@ import (
 func (f *ScriptBasedSoftwareUpdatable) downloadHandler(
 	update *hawkbit.SoftwareUpdateAction, su *hawkbit.SoftwareUpdatable) {
 	// Create new operation wrapper.
-	op := func(dir string, updatable *storage.Updatable) bool {
-		return f.downloadModules(dir, updatable, su)
+	op := func(dir string, updatable *storage.Updatable, cancel chan struct{}) bool {
+		return f.downloadModules(dir, updatable, su, cancel)
 	}
-	f.prepare("download", update.CorrelationID, update.SoftwareModules, op)
+	f.prepare("download", update.CorrelationID, update.SoftwareModules, update.Metadata, op)
 }
 
 // downloadModule is called by download handler and after restart with remaining updatables.
 // returns true if canceled!
 func (f *ScriptBasedSoftwareUpdatable) downloadModules(
-	toDir string, updatable *storage.Updatable, su *hawkbit.SoftwareUpdatable) bool {
+	toDir string, updatable *storage.Updatable, su *hawkbit.SoftwareUpdatable, cancel chan struct{}) bool {
 	// Process download operation.
-	logger.Debugf("Process download operation with id: %s", updatable.CorrelationID)
+	log := operationLog("download", updatable.CorrelationID, nil)
+	log.Debugf("Process download operation")
 
-	// Download all modules.
+	// Wait for the scheduled start of the operation.
+	if f.waitForSchedule("download", updatable, su, cancel) {
+		return true // Cancel: application is closing!
+	}
+	// Spread the start of the operation across the devices.
+	if f.waitForJitter("download", updatable.CorrelationID, cancel) {
+		return true // Cancel: application is closing!
+	}
+	// Start the overall operation timeout, once the operation is started.
+	defer f.startOperationTimeout(toDir, updatable.CorrelationID, cancel, updatable.Metadata)()
+
+	// Download all modules, collecting their final status for the audit log.
+	audit := f.audit.newEntry("download", updatable.CorrelationID)
 	for i, module := range updatable.Modules {
 		select {
 		case <-done:
 			return true // Cancel: application is closing!
 		default:
-			if f.downloadModule(updatable.CorrelationID, module, filepath.Join(toDir, strconv.Itoa(i)), su) {
+			if err := f.waitToContinue("download", updatable.CorrelationID, cancel); err == storage.ErrAborted || err == storage.ErrCancel {
+				if err == storage.ErrAborted {
+					reportAborted(updatable.CorrelationID, module, hawkbit.StatusDownloadingWaiting, su)
+				}
+				return true // Abort: the operation is resumed on the next start!
+			}
+			if f.downloadModule(updatable.CorrelationID, module, filepath.Join(toDir, strconv.Itoa(i)), f.operationServer(updatable), su, cancel) {
 				return true // Cancel: application is closing!
 			}
+			audit.add(module, su.LastOperation())
 		}
 	}
+	f.audit.write(audit)
 
 	// Archive all modules.
 	for i, module := range updatable.Modules {
 		if err := f.store.ArchiveModule(filepath.Join(toDir, strconv.Itoa(i))); err != nil {
-			logger.Errorf("failed to archive module [%s.%s]: %v", module.Name, module.Version, err)
+			operationLog("download", updatable.CorrelationID, module).Errorf("failed to archive module: %v", err)
 		}
 	}
 
 	// Remove operation woring directory
-	logger.Debugf("Remove download operation working directory: %s", toDir)
+	log.Debugf("Remove download operation working directory: %s", toDir)
 	if err := os.RemoveAll(toDir); err != nil {
-		logger.Errorf("failed to remove directory [%s]: %v", toDir, err)
+		log.Errorf("failed to remove directory [%s]: %v", toDir, err)
 	}
+	f.store.PruneBlobs()
 	return false
 }
 
 // downloadModule returns true if canceled!
 func (f *ScriptBasedSoftwareUpdatable) downloadModule(
-	cid string, module *storage.Module, toDir string, su *hawkbit.SoftwareUpdatable) bool {
+	cid string, module *storage.Module, toDir string, server storage.ServerConfig, su *hawkbit.SoftwareUpdatable,
+	cancel chan struct{}) bool {
 	// Download module to directory.
-	logger.Infof("Download module [%s.%s] to directory: %s", module.Name, module.Version, toDir)
+	log := operationLog("download", cid, module)
+	log.Infof("Download module to directory: %s", toDir)
 	// Create few useful variables.
 	id := module.Name + ":" + module.Version
 	s := filepath.Join(toDir, storage.InternalStatusName)
@@ -79,16 +104,28 @@ func (f *ScriptBasedSoftwareUpdatable) downloadModule(
 
 	// Process final operation status in defer to also catch potential panic calls.
 	defer func() {
+		if opError == storage.ErrAborted {
+			reportAborted(cid, module, hawkbit.StatusDownloadingWaiting, su)
+			return // Abort: the operation is resumed on the next start!
+		}
 		if opError == storage.ErrCancel {
 			return // Cancel: application is closing!
 		}
+		if opError == storage.ErrCanceled && f.isTimedOut(cid) { // In case of timeout report how far it got
+			opError, opErrorMsg = f.timeoutError(cid, module, su), errOperationTimeout
+		}
 		storage.WriteLn(s, id)
 		if err := recover(); err != nil { // In case of panic report FinishedError
-			logger.Errorf("panic on module download [%s.%s] %v", module.Name, module.Version, err)
-			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).WithMessage(errRuntime))
+			log.Errorf("panic on module download: %v", err)
+			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).
+				WithStatusCode(codeRuntime).WithMessage(errRuntime))
+		} else if opError == storage.ErrCanceled { // In case of cancel report FinishedCanceled
+			log.Infof("module download canceled")
+			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedCanceled))
 		} else if opError != nil { // In case of error report FinishedError
-			logger.Errorf("failed to download module [%s.%s]: %v", module.Name, module.Version, opError)
-			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).WithMessage(opErrorMsg))
+			log.Errorf("failed to download module: %v", opError)
+			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).
+				WithStatusCode(toStatusCode(opErrorMsg, opError)).WithMessage(toStatusMessage(opErrorMsg, opError)))
 		} else { // Success
 			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedSuccess))
 		}
@@ -99,12 +136,24 @@ func (f *ScriptBasedSoftwareUpdatable) downloadModule(
 		return false
 	}
 
+	// Report the download progress
+	progress := newDownloadProgress(cid, module, su, f.progressInterval)
+	notify, stopListeners := f.withProgressListeners(progress.update)
+	defer stopListeners()
+
+	// Skip the module, if its operation is canceled
+	if isCanceled(cancel) {
+		opError = storage.ErrCanceled
+		return false
+	}
+
 	// Read previous module status
 	status, _ := storage.ReadLn(s)
 	switch status {
 	case string(hawkbit.StatusStarted):
 		goto Started
 	case string(hawkbit.StatusDownloading):
+		server.Force = false // Resume the partial downloads of the operation itself.
 		goto Downloading
 	case id:
 		return false
@@ -112,29 +161,41 @@ func (f *ScriptBasedSoftwareUpdatable) downloadModule(
 	}
 
 	// Started
-	logger.Debugf("[%s.%s] Module download started", module.Name, module.Version)
+	log.Debugf("Module download started")
 	setLastOS(su, newOS(cid, module, hawkbit.StatusStarted))
 	storage.WriteLn(s, string(hawkbit.StatusStarted))
 Started:
 
 	// Downloading
-	logger.Debugf("[%s.%s] Downloading module", module.Name, module.Version)
+	log.Debugf("Downloading module")
 	setLastOS(su, newOS(cid, module, hawkbit.StatusDownloading))
 	storage.WriteLn(s, string(hawkbit.StatusDownloading))
 Downloading:
-	if opError = f.store.DownloadModule(toDir, module, func(percent int) {
-		setLastOS(su, newOS(cid, module, hawkbit.StatusDownloading).WithProgress(percent))
-	}, f.serverCert, f.downloadRetryCount, f.downloadRetryInterval, func() error {
+	if opError = f.store.DownloadModule(toDir, module, notify, server, f.downloadRetryCount, f.downloadRetryInterval, func() error {
 		return f.validateLocalArtifacts(module)
-	}); opError != nil {
+	}, cancel); opError != nil {
 		opErrorMsg = errDownload
-		logger.Errorf("error downloading module [%s.%s] - %v", module.Name, module.Version, opError)
-		return opError == storage.ErrCancel
+		log.Errorf("error downloading module - %v", opError)
+		return opError == storage.ErrCancel || opError == storage.ErrAborted
 	}
 
 	// Downloaded
-	logger.Debugf("[%s.%s] Module download finished", module.Name, module.Version)
+	log.Debugf("Module download finished")
+	progress.complete()
 	setLastOS(su, newOS(cid, module, hawkbit.StatusDownloaded).WithProgress(100))
 	storage.WriteLn(s, string(hawkbit.StatusDownloaded))
 	return false
 }
+
+// countDownloadRestart counts the partial downloads, abandoned and restarted from the beginning.
+// The restarts are exposed by the diagnostics endpoint, e.g. to alert on an unstable artifacts server.
+func (f *ScriptBasedSoftwareUpdatable) countDownloadRestart(artifact *storage.Artifact, reason storage.RestartReason) {
+	atomic.AddUint32(&f.downloadRestarts, 1)
+}
+
+// logDownloadTimings logs the timing breakdown of the finished artifact downloads, e.g. for performance tuning.
+func logDownloadTimings(artifact *storage.Artifact, timings storage.Timings) {
+	logger.Infof("artifact %s downloaded %d and resumed %d bytes with %d retries: connect %v, transfer %v, verify %v",
+		artifact.FileName, timings.Downloaded, timings.Resumed, timings.Retries, timings.Connect, timings.Transfer,
+		timings.Verify)
+}
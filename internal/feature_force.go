@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"strconv"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// metadataForce is the operation metadata key, which forces a clean download of the operation artifacts, e.g.
+// after a known-bad artifacts server. Their partial downloads and the archived artifacts of their modules are
+// discarded, instead of being reused.
+const metadataForce = "force"
+
+// metadataTrustLocal is the operation metadata key, which skips the checksum validation of the operation local
+// artifacts, copied from a trusted file system with verified integrity. They are verified by their size only.
+const metadataTrustLocal = "trustLocal"
+
+// metadataQoSClass is the operation metadata key of the quality of service class of the operation downloads,
+// e.g. critical for security updates, which get the shared download buffers ahead of the other downloads.
+const metadataQoSClass = "qosClass"
+
+// operationServer returns the download server configuration of the operation, forcing its clean download or
+// trusting its local artifacts, if requested by the operation metadata, and with its QoS class.
+func (f *ScriptBasedSoftwareUpdatable) operationServer(updatable *storage.Updatable) storage.ServerConfig {
+	server := f.server
+	if force, _ := strconv.ParseBool(updatable.Metadata[metadataForce]); force {
+		server.Force = true
+	}
+	if trust, _ := strconv.ParseBool(updatable.Metadata[metadataTrustLocal]); trust {
+		server.TrustLocal = true
+	}
+	if class, ok := updatable.Metadata[metadataQoSClass]; ok {
+		if err := storage.ValidateQoSClass(class); err != nil {
+			logger.Warnf("ignoring operation metadata %s: %v", metadataQoSClass, err)
+		} else {
+			server.QoS = class
+		}
+	}
+	return server
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestOperationServer tests that the force operation metadata forces a clean download of the operation artifacts
+// and the trustLocal operation metadata trusts its local artifacts, and that the qosClass operation metadata sets
+// the QoS class of its downloads.
+func TestOperationServer(t *testing.T) {
+	f := &ScriptBasedSoftwareUpdatable{server: storage.ServerConfig{AuthToken: "token"}}
+	tests := map[string]struct {
+		metadata map[string]string
+		force    bool
+		trust    bool
+		qos      string
+	}{
+		"noMetadata":        {},
+		"noForce":           {metadata: map[string]string{"notBefore": "2021-01-01T00:00:00Z"}},
+		"force":             {metadata: map[string]string{metadataForce: "true"}, force: true},
+		"forceFalse":        {metadata: map[string]string{metadataForce: "false"}},
+		"forceInvalid":      {metadata: map[string]string{metadataForce: "yes"}},
+		"trustLocal":        {metadata: map[string]string{metadataTrustLocal: "true"}, trust: true},
+		"trustLocalFalse":   {metadata: map[string]string{metadataTrustLocal: "false"}},
+		"trustLocalInvalid": {metadata: map[string]string{metadataTrustLocal: "yes"}},
+		"qosCritical":       {metadata: map[string]string{metadataQoSClass: storage.QoSCritical}, qos: storage.QoSCritical},
+		"qosBackground":     {metadata: map[string]string{metadataQoSClass: storage.QoSBackground}, qos: storage.QoSBackground},
+		"qosInvalid":        {metadata: map[string]string{metadataQoSClass: "urgent"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := f.operationServer(&storage.Updatable{Metadata: test.metadata})
+			if server.Force != test.force {
+				t.Fatalf("unexpected force: %v != %v", server.Force, test.force)
+			}
+			if server.TrustLocal != test.trust {
+				t.Fatalf("unexpected trust local: %v != %v", server.TrustLocal, test.trust)
+			}
+			if server.QoS != test.qos {
+				t.Fatalf("unexpected QoS class: %s != %s", server.QoS, test.qos)
+			}
+			if server.AuthToken != "token" {
+				t.Fatalf("unexpected download server configuration: %+v", server)
+			}
+		})
+	}
+	if f.server.Force || f.server.TrustLocal || f.server.QoS != "" {
+		t.Fatal("operation metadata must not change the download server configuration of the other operations")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+var errHealthProbeFailed = errors.New("health probe failed")
+
+// probeHealth runs the configured health probe command in the module install directory, after the module is
+// installed, until it exits with zero code. The failed probes are retried with exponential backoff, starting at
+// the health interval, and the module is reported as not healthy, if the probe does not pass within the health
+// timeout. Closing the cancel channel or closing the storage on shutdown terminates the probe with storage.ErrCanceled.
+func (f *ScriptBasedSoftwareUpdatable) probeHealth(log *logger.Entry, dir string, cancel chan struct{}) error {
+	if f.healthCommand == nil || f.healthCommand.cmd == "" {
+		return nil
+	}
+	stop, release := f.stopOnShutdown(cancel)
+	defer release()
+
+	deadline := clock.Now().Add(f.healthTimeout)
+	retry := &backoff{interval: f.healthInterval, maxInterval: f.healthTimeout}
+	for attempt := 1; ; attempt++ {
+		err := f.runHealthProbe(dir, stop, deadline)
+		if err == nil {
+			log.Infof("health probe passed on attempt %d", attempt)
+			return nil
+		}
+		if err == storage.ErrCanceled && isCanceled(stop) {
+			return err
+		}
+		delay := retry.next()
+		if !clock.Now().Add(delay).Before(deadline) {
+			return fmt.Errorf("%w after %d attempts within %v: %v", errHealthProbeFailed, attempt, f.healthTimeout, err)
+		}
+		log.Infof("health probe attempt %d failed, retry in %v: %v", attempt, delay, err)
+		select {
+		case <-clock.After(delay):
+		case <-stop:
+			return storage.ErrCanceled
+		}
+	}
+}
+
+// runHealthProbe runs the health probe command once, terminating it, if still running at the deadline.
+func (f *ScriptBasedSoftwareUpdatable) runHealthProbe(dir string, stop chan struct{}, deadline time.Time) error {
+	expired := make(chan struct{})
+	timer := time.AfterFunc(deadline.Sub(clock.Now()), func() { close(expired) })
+	defer timer.Stop()
+	terminate := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+		case <-expired:
+		case <-finished:
+			return
+		}
+		close(terminate)
+	}()
+
+	err := f.healthCommand.run(dir, "health", terminate, f.gracePeriod)
+	if err == storage.ErrCanceled && isCanceled(expired) {
+		return fmt.Errorf("health probe did not finish in time")
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestHealthProbePass tests that the installed module succeeds, if the health probe passes immediately.
+func TestHealthProbePass(t *testing.T) {
+	testHealthProbe(t, 1, hawkbit.StatusFinishedSuccess)
+}
+
+// TestHealthProbeRetry tests that the failed health probe is retried until it passes.
+func TestHealthProbeRetry(t *testing.T) {
+	testHealthProbe(t, 3, hawkbit.StatusFinishedSuccess)
+}
+
+// TestHealthProbeFail tests that the installed module is rolled back and fails, if the health probe never passes.
+func TestHealthProbeFail(t *testing.T) {
+	testHealthProbe(t, 0, hawkbit.StatusFinishedError)
+}
+
+func testHealthProbe(t *testing.T, passOn int, expected hawkbit.Status) {
+	if runtime.GOOS == "windows" {
+		t.Skip("health probe script is not supported on windows")
+	}
+	fake := useFakeClock(t)
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-health", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	attempts := getAbsolutePath(t, filepath.Join(tmpDir, "attempts"))
+	rolledBack := getAbsolutePath(t, filepath.Join(tmpDir, "rolled-back"))
+	probe := fmt.Sprintf("echo attempt >> %s\ntest $(wc -l < %s) -eq %d", attempts, attempts, passOn)
+	probePath, _ := createLocalArtifact(t, tmpDir, "health.sh", probe)
+	feature.healthCommand = &command{cmd: "/bin/sh", args: []string{getAbsolutePath(t, probePath)}}
+	feature.healthTimeout = time.Minute
+	feature.healthInterval = time.Second
+
+	install := "exit 0"
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	rollback := "echo rolled back > " + rolledBack
+	rollbackPath, rollbackHash := createLocalArtifact(t, tmpDir, "rollback.sh", rollback)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+		convertLocalArtifact(getAbsolutePath(t, rollbackPath), "rollback.sh", rollbackHash, len(rollback)),
+	}, "*")
+
+	feature.installHandler(sua, feature.su)
+	var lo map[string]interface{}
+	installed := false
+	for {
+		if lo = mc.pullLastOperationStatus(); lo == nil {
+			t.Fatal("install operation not finished")
+		}
+		if lo[statusParam] == string(hawkbit.StatusInstalled) {
+			installed = true
+		}
+		if lo[statusParam] == string(hawkbit.StatusFinishedSuccess) || lo[statusParam] == string(hawkbit.StatusFinishedError) {
+			break
+		}
+	}
+	if installed != (passOn > 0) {
+		t.Fatalf("module installed status is reported: %v, but the module is healthy: %v", installed, passOn > 0)
+	}
+	if lo[statusParam] != string(expected) {
+		t.Fatalf("unexpected install operation status: %v != %v", lo[statusParam], expected)
+	}
+
+	data, err := os.ReadFile(attempts)
+	if err != nil {
+		t.Fatalf("health probe is not run: %v", err)
+	}
+	count := strings.Count(string(data), "attempt")
+	if passOn > 0 {
+		if count != passOn {
+			t.Fatalf("expected %d health probe attempts, got: %d", passOn, count)
+		}
+		if waits := fake.Waits(); len(waits) != passOn-1 {
+			t.Fatalf("expected %d backoff waits, got: %v", passOn-1, waits)
+		}
+		if _, err := os.Stat(rolledBack); !os.IsNotExist(err) {
+			t.Fatalf("healthy module is rolled back: %v", err)
+		}
+		return
+	}
+	if lo["statusCode"] != codeHealthProbe {
+		t.Fatalf("unexpected install operation status code: %v != %v", lo["statusCode"], codeHealthProbe)
+	}
+	if count < 2 {
+		t.Fatalf("failed health probe is not retried: %d attempts", count)
+	}
+	checkFileExistsWithContent(t, rolledBack, "rolled back")
+}
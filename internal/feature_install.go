@@ -14,14 +14,13 @@ package feature
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strconv"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
-	"github.com/eclipse-kanto/software-update/internal/logger"
 	"github.com/eclipse-kanto/software-update/internal/storage"
 )
 
@@ -29,44 +28,91 @@ import (
 func (f *ScriptBasedSoftwareUpdatable) installHandler(
 	update *hawkbit.SoftwareUpdateAction, su *hawkbit.SoftwareUpdatable) {
 	// Create new operation wrapper.
-	op := func(dir string, updatable *storage.Updatable) bool {
-		return f.installModules(dir, updatable, su)
+	op := func(dir string, updatable *storage.Updatable, cancel chan struct{}) bool {
+		return f.installModules(dir, updatable, su, cancel)
 	}
-	f.prepare("install", update.CorrelationID, update.SoftwareModules, op)
+	f.prepare("install", update.CorrelationID, update.SoftwareModules, update.Metadata, op)
 }
 
 // installModules is called by install handler and after restart with remaining updatables.
 // returns true if canceled!
 func (f *ScriptBasedSoftwareUpdatable) installModules(
-	toDir string, updatable *storage.Updatable, su *hawkbit.SoftwareUpdatable) bool {
+	toDir string, updatable *storage.Updatable, su *hawkbit.SoftwareUpdatable, cancel chan struct{}) bool {
 	// Process install operation.
-	logger.Debugf("Process install operation with id: %s", updatable.CorrelationID)
+	log := operationLog("install", updatable.CorrelationID, nil)
+	log.Debugf("Process install operation")
 
-	// Install all modules.
+	// Wait for the scheduled start of the operation.
+	if f.waitForSchedule("install", updatable, su, cancel) {
+		return true // Cancel: application is closing!
+	}
+	// Spread the start of the operation across the devices.
+	if f.waitForJitter("install", updatable.CorrelationID, cancel) {
+		return true // Cancel: application is closing!
+	}
+	// Start the overall operation timeout, once the operation is started.
+	defer f.startOperationTimeout(toDir, updatable.CorrelationID, cancel, updatable.Metadata)()
+
+	// Install all modules, collecting their install output and final status for the operation report and audit log.
+	report := f.newInstallReport()
+	audit := f.audit.newEntry("install", updatable.CorrelationID)
+	approval := updatable.Metadata[metadataApproval] == approvalRequired
 	for i, module := range updatable.Modules {
 		select {
 		case <-done:
 			return true // Cancel: application is closing!
 		default:
-			if f.installModule(updatable.CorrelationID, module, filepath.Join(toDir, fmt.Sprint(i)), su) {
+			if err := f.waitToContinue("install", updatable.CorrelationID, cancel); err == storage.ErrAborted || err == storage.ErrCancel {
+				if err == storage.ErrAborted {
+					reportAborted(updatable.CorrelationID, module, hawkbit.StatusInstallingWaiting, su)
+				}
+				return true // Abort: the operation is resumed on the next start!
+			}
+			if f.installModule(updatable.CorrelationID, module, filepath.Join(toDir, fmt.Sprint(i)), f.operationServer(updatable),
+				su, report.output(module), approval, cancel) {
 				return true // Cancel: application is closing!
 			}
+			report.add(su.LastOperation())
+			audit.add(module, su.LastOperation())
 		}
 	}
+	f.audit.write(audit)
+	f.uploadReport(updatable.CorrelationID, report)
 
 	// Remove operation woring directory
-	logger.Debugf("Remove install operation working directory: %s", toDir)
+	log.Debugf("Remove install operation working directory: %s", toDir)
 	if err := os.RemoveAll(toDir); err != nil {
-		logger.Errorf("failed to remove directory [%s]: %v", toDir, err)
+		log.Errorf("failed to remove directory [%s]: %v", toDir, err)
 	}
+	f.store.PruneBlobs()
 	return false
 }
 
-// installModule returns true if canceled!
-func (f *ScriptBasedSoftwareUpdatable) installModule(
-	cid string, module *storage.Module, dir string, su *hawkbit.SoftwareUpdatable) bool {
+// installCommandFor returns the install command of the given module artifact type. Archive and plain artifact
+// types fall back to the default install command, when not mapped.
+func (f *ScriptBasedSoftwareUpdatable) installCommandFor(artifactType string) (*command, error) {
+	if cmd, ok := f.installCommands[artifactType]; ok {
+		return &cmd, nil
+	}
+	if artifactType == typeArchive || artifactType == typePlain {
+		return f.installCommand, nil
+	}
+	return nil, fmt.Errorf("%s - %s", errUnmappedArtifactType, artifactType)
+}
+
+// isStreamed reports whether the module artifact is streamed to the standard input of the install command,
+// instead of being downloaded and passed as a file.
+func isStreamed(module *storage.Module) bool {
+	return module.Metadata != nil && module.Metadata[metadataInstallMode] == installModeStream
+}
+
+// installModule returns true if canceled! The install command output is written to output, if set.
+// The module is installed only after the approval of its operation, if required.
+func (f *ScriptBasedSoftwareUpdatable) installModule(cid string, module *storage.Module, dir string,
+	server storage.ServerConfig, su *hawkbit.SoftwareUpdatable, output io.Writer, approval bool, cancel chan struct{}) bool {
 	// Install module to directory.
-	logger.Infof("Install module [%s.%s] from directory: %s", module.Name, module.Version, dir)
+	log := operationLog("install", cid, module)
+	log.Infof("Install module from directory: %s", dir)
 	// Create few useful variables.
 	id := module.Name + ":" + module.Version
 	s := filepath.Join(dir, storage.InternalStatusName)
@@ -74,29 +120,45 @@ func (f *ScriptBasedSoftwareUpdatable) installModule(
 	opErrorMsg := errRuntime
 
 	execInstallScriptDir := dir
+	stream := isStreamed(module)
+	waiting := hawkbit.StatusDownloadingWaiting // Reported, if aborted by the continue policy
 
 	// Process final operation status in defer to also catch potential panic calls.
 	defer func() {
+		if opError == storage.ErrAborted {
+			reportAborted(cid, module, waiting, su)
+			return // Abort: the operation is resumed on the next start!
+		}
 		if opError == storage.ErrCancel {
 			return // Cancel: application is closing!
 		}
+		if opError == storage.ErrCanceled && f.isTimedOut(cid) { // In case of timeout report how far it got
+			opError, opErrorMsg = f.timeoutError(cid, module, su), errOperationTimeout
+		}
 		storage.WriteLn(s, id)
 		if err := recover(); err != nil { // In case of panic report FinishedError
-			logger.Errorf("panic in module installation [%s.%s]: %v", module.Name, module.Version, err)
-			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).WithMessage(errRuntime))
+			log.Errorf("panic in module installation: %v", err)
+			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).
+				WithStatusCode(codeRuntime).WithMessage(errRuntime))
+		} else if opError == storage.ErrCanceled { // In case of cancel report FinishedCanceled
+			log.Infof("module installation canceled")
+			setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedCanceled))
 		} else if opError != nil { // In case of error report FinishedError
 			if exiterr, ok := opError.(*exec.ExitError); ok {
-				logger.Errorf("failed to install module [%s.%s][ExitCode: %v]: %v",
-					module.Name, module.Version, exiterr.ExitCode(), opError)
+				log.Errorf("failed to install module [ExitCode: %v]: %v", exiterr.ExitCode(), opError)
 				setLastOS(su, newFileOS(execInstallScriptDir, cid, module, hawkbit.StatusFinishedError).
-					WithStatusCode(strconv.Itoa(exiterr.ExitCode())).
-					WithMessage(opErrorMsg))
+					WithStatusCode(toExitStatusCode(exiterr.ExitCode())).
+					WithMessage(fmt.Sprintf("%s [exit code: %d]", opErrorMsg, exiterr.ExitCode())))
 			} else {
-				logger.Errorf("failed to install module [%s.%s]: %v", module.Name, module.Version, opError)
-				setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).WithMessage(opErrorMsg))
+				log.Errorf("failed to install module: %v", opError)
+				setLastOS(su, newOS(cid, module, hawkbit.StatusFinishedError).
+					WithStatusCode(toStatusCode(opErrorMsg, opError)).WithMessage(toStatusMessage(opErrorMsg, opError)))
 			}
 		} else { // Success
 			setLastOS(su, newFileOS(execInstallScriptDir, cid, module, hawkbit.StatusFinishedSuccess))
+			if err := f.store.CleanupInstalledModule(dir, module, f.cleanupPolicy); err != nil {
+				log.Errorf("failed to cleanup installed module: %v", err)
+			}
 		}
 	}()
 
@@ -105,12 +167,24 @@ func (f *ScriptBasedSoftwareUpdatable) installModule(
 		return false
 	}
 
+	// Report the download progress
+	progress := newDownloadProgress(cid, module, su, f.progressInterval)
+	notify, stopListeners := f.withProgressListeners(progress.update)
+	defer stopListeners()
+
+	// Skip the module, if its operation is canceled
+	if isCanceled(cancel) {
+		opError = storage.ErrCanceled
+		return false
+	}
+
 	// Read previous module status
 	lStatus, _ := storage.ReadLn(s)
 	switch lStatus {
 	case string(hawkbit.StatusStarted):
 		goto Started
 	case string(hawkbit.StatusDownloading):
+		server.Force = false // Resume the partial downloads of the operation itself.
 		goto Downloading
 	case string(hawkbit.StatusDownloaded):
 		goto Downloaded
@@ -122,50 +196,123 @@ func (f *ScriptBasedSoftwareUpdatable) installModule(
 	}
 
 	// Started
-	logger.Debugf("[%s.%s] Module instalation started", module.Name, module.Version)
+	log.Debugf("Module instalation started")
 	setLastOS(su, newOS(cid, module, hawkbit.StatusStarted))
 	storage.WriteLn(s, string(hawkbit.StatusStarted))
 Started:
 	// Downloading
-	logger.Debugf("[%s.%s] Downloading module", module.Name, module.Version)
+	log.Debugf("Downloading module")
 	setLastOS(su, newOS(cid, module, hawkbit.StatusDownloading))
 	storage.WriteLn(s, string(hawkbit.StatusDownloading))
 Downloading:
-	if opError = f.store.DownloadModule(dir, module, func(progress int) {
-		setLastOS(su, newOS(cid, module, hawkbit.StatusDownloading).WithProgress(progress))
-	}, f.serverCert, f.downloadRetryCount, f.downloadRetryInterval, func() error {
-		return f.validateLocalArtifacts(module)
-	}); opError != nil {
-		opErrorMsg = errDownload
-		logger.Errorf("error downloading module [%s.%s] - %v", module.Name, module.Version, opError)
-		return opError == storage.ErrCancel
-	}
-
-	// Downloaded
-	logger.Debugf("[%s.%s] Module download finished", module.Name, module.Version)
-	setLastOS(su, newOS(cid, module, hawkbit.StatusDownloaded).WithProgress(100))
-	storage.WriteLn(s, string(hawkbit.StatusDownloaded))
+	if stream { // The artifact is downloaded and verified, while streamed to the install command
+		if opError = f.validateLocalArtifacts(module); opError != nil {
+			opErrorMsg = errDownload
+			return false
+		}
+	} else {
+		if opError = f.store.DownloadModule(dir, module, notify, server, f.downloadRetryCount, f.downloadRetryInterval, func() error {
+			return f.validateLocalArtifacts(module)
+		}, cancel); opError != nil {
+			opErrorMsg = errDownload
+			log.Errorf("error downloading module - %v", opError)
+			return opError == storage.ErrCancel || opError == storage.ErrAborted
+		}
+
+		// Downloaded
+		log.Debugf("Module download finished")
+		progress.complete()
+		setLastOS(su, newOS(cid, module, hawkbit.StatusDownloaded).WithProgress(100))
+		storage.WriteLn(s, string(hawkbit.StatusDownloaded))
+	}
 Downloaded:
 
+	// Scan the verified artifacts, before installing them. Streamed artifacts are not stored to be scanned.
+	if !stream {
+		if opError = f.scanArtifacts(dir, module, cancel); opError != nil {
+			if opError != storage.ErrCanceled {
+				opErrorMsg = errArtifactScan
+			}
+			return false
+		}
+	}
+
+	// Wait for the approval of the staged module installation, if required
+	if approval {
+		if opError = f.waitForApproval(cid, module, su, cancel); opError != nil {
+			return opError == storage.ErrCancel
+		}
+	}
+
+	// Continue with the installation, only if allowed by the continue policy
+	waiting = hawkbit.StatusInstallingWaiting
+	if opError = f.waitToContinue("install", cid, cancel); opError != nil {
+		return opError != storage.ErrCanceled
+	}
+
+	// Download again the staged artifacts, modified or truncated externally since their download, instead of
+	// failing the installation. The archived and the still valid artifacts are reused.
+	if !stream {
+		if err := f.store.VerifyModule(dir, module, server); err != nil {
+			log.Warnf("staged module artifacts are modified, downloading them again: %v", err)
+			setLastOS(su, newOS(cid, module, hawkbit.StatusDownloading))
+			server.Force = false
+			if opError = f.store.DownloadModule(dir, module, notify, server, f.downloadRetryCount, f.downloadRetryInterval,
+				nil, cancel); opError != nil {
+				opErrorMsg = errDownload
+				log.Errorf("error downloading modified module artifacts - %v", opError)
+				return opError == storage.ErrCancel || opError == storage.ErrAborted
+			}
+			setLastOS(su, newOS(cid, module, hawkbit.StatusDownloaded).WithProgress(100))
+			if opError = f.scanArtifacts(dir, module, cancel); opError != nil {
+				if opError != storage.ErrCanceled {
+					opErrorMsg = errArtifactScan
+				}
+				return false
+			}
+		}
+	}
+
 	// Installing
-	logger.Debugf("[%s.%s] Installing module", module.Name, module.Version)
-	setLastOS(su, newOS(cid, module, hawkbit.StatusInstalling).WithProgress(0))
-	storage.WriteLn(s, string(hawkbit.StatusInstalling))
+	log.Debugf("Installing module")
 Installing:
 
+	// Serialize the installation with the other installing processes on the device, e.g. the package managers,
+	// holding the install lock until the module is installed, verified or rolled back. The lock is acquired
+	// before INSTALLING is reported, so that waiting for it is not reported as installing.
+	releaseInstallLock, err := f.acquireInstallLock(log, cancel)
+	if err != nil {
+		opError = err
+		if err != storage.ErrCanceled {
+			opErrorMsg = errInstallLock
+		}
+		return err == storage.ErrCancel
+	}
+	defer releaseInstallLock()
+	if lStatus != string(hawkbit.StatusInstalling) {
+		setLastOS(su, newOS(cid, module, hawkbit.StatusInstalling).WithProgress(0))
+		storage.WriteLn(s, string(hawkbit.StatusInstalling))
+	}
+
 	// Get artifact type
 	artifactType := f.artifactType
 	if module.Metadata != nil && module.Metadata["artifact-type"] != "" {
 		artifactType = module.Metadata["artifact-type"]
 	}
-	if artifactType == typeArchive { // Extract if needed
+	installCommand, err := f.installCommandFor(artifactType)
+	if err != nil {
+		opError = err
+		opErrorMsg = errUnmappedArtifactType
+		return false
+	}
+	if artifactType == typeArchive && !stream { // Extract if needed
 		if len(module.Artifacts) > 1 { // Only one archive/artifact is allowed in archive modules
 			opErrorMsg = errMultiArchives
 			opError = fmt.Errorf(opErrorMsg)
 			return false
 		}
-		logger.Debugf("[%s.%s] Extract module archive(s) to: ", module.Name, module.Version)
-		if opError = storage.ExtractArchive(dir); opError != nil {
+		log.Debugf("Extract module archive(s) to: %s", dir)
+		if opError = storage.ExtractArchive(dir, module.Metadata[storage.MetadataArchiveManifest], f.archiveSymlinks); opError != nil {
 			opErrorMsg = errExtractArchive
 			return false
 		}
@@ -188,32 +335,65 @@ Installing:
 				return false
 			}
 			execInstallScriptDir = filepath.Dir(absExecPath)
-			logger.Debugf("install script %s will be ran in its original folder", installScriptExtLocation)
+			log.Debugf("install script %s will be ran in its original folder", installScriptExtLocation)
 		}
 	}
 
-	// Monitor install progress
-	monitor, err := (&monitor{
+	// Monitor install progress, written to the status file or printed as progress lines by the install script
+	progressMonitor := &monitor{
 		status: hawkbit.StatusInstalling,
 		su:     su,
 		cid:    cid,
 		module: &hawkbit.SoftwareModuleID{Name: module.Name, Version: module.Version},
-	}).waitFor(execInstallScriptDir)
+	}
+	monitor, err := progressMonitor.waitFor(execInstallScriptDir)
 	if err != nil {
-		logger.Errorf("fail to start progress monitor: %v", err)
+		log.Errorf("fail to start progress monitor: %v", err)
 	}
+	output = progressMonitor.output(output)
 
 	// Start install script
-	logger.Debugf("[%s.%s] Run module install script in %s", module.Name, module.Version, execInstallScriptDir)
-	if opError = f.installCommand.run(execInstallScriptDir, "install"); opError != nil {
-		opErrorMsg = errInstallScript
-		return false
+	log.Debugf("Run module install script in %s", execInstallScriptDir)
+	stop, release := f.stopOnShutdown(cancel)
+	if stream {
+		opError = installCommand.runWithInput(execInstallScriptDir, "install", func(w io.Writer) error {
+			return f.store.StreamModule(module, w, nil, server, f.downloadRetryCount, f.downloadRetryInterval, stop)
+		}, output, stop, f.gracePeriod)
+	} else {
+		opError = installCommand.runWithInput(execInstallScriptDir, "install", nil, output, stop, f.gracePeriod)
 	}
+	release()
 
 	// Stop progress monitoring
 	if monitor != nil {
 		close(monitor)
 	}
+	if opError != nil {
+		if opError == storage.ErrCanceled {
+			rollback(execInstallScriptDir, module, installCommand.allowed)
+		}
+		opErrorMsg = errInstallScript
+		if _, ok := opError.(*exec.ExitError); stream && !ok && opError != storage.ErrCanceled {
+			opErrorMsg = errDownload // The streamed artifact failed to download or verify
+		}
+		return false
+	}
+
+	// Report the module as installed, only if the installed module reports its version, and roll it back otherwise
+	if opError = f.checkVersion(log, execInstallScriptDir, module, cancel); opError != nil {
+		log.Errorf("installed module does not report its version: %v", opError)
+		rollback(execInstallScriptDir, module, installCommand.allowed)
+		opErrorMsg = errVersionCheck
+		return false
+	}
+
+	// Report the module as installed, only if the installed module becomes healthy, and roll it back otherwise
+	if opError = f.probeHealth(log, execInstallScriptDir, cancel); opError != nil {
+		log.Errorf("installed module is not healthy: %v", opError)
+		rollback(execInstallScriptDir, module, installCommand.allowed)
+		opErrorMsg = errHealthProbe
+		return false
+	}
 
 	// Move the predefined installed dependencies
 	if opError = f.store.MoveInstalledDeps(execInstallScriptDir, module.Metadata); opError != nil {
@@ -222,7 +402,7 @@ Installing:
 	}
 
 	// Installed
-	logger.Debugf("[%s.%s] Module installed", module.Name, module.Version)
+	log.Debugf("Module installed")
 	setLastOS(su, newFileOS(execInstallScriptDir, cid, module, hawkbit.StatusInstalled))
 
 	// Update installed dependencies
@@ -232,7 +412,7 @@ Installing:
 		opErrorMsg = errInstalledDepsRefresh
 		return false
 	}
-	logger.Debugf("[%s.%s] Set module installed dependencies", module.Name, module.Version)
+	log.Debugf("Set module installed dependencies")
 	f.su.SetInstalledDependencies(deps...)
 	return false
 }
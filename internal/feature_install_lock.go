@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// installLockInterval is the interval between the attempts to acquire the held install lock.
+const installLockInterval = 100 * time.Millisecond
+
+var errInstallLocked = errors.New("install lock is held by another process")
+
+// acquireInstallLock acquires the configured install lock, an exclusive advisory lock of the lock file, shared
+// with the other processes installing on the device, e.g. the package managers, so that the installations are
+// serialized device-wide. The held lock is retried until the install lock timeout, if set. Returns
+// storage.ErrCanceled, if the operation is canceled, or storage.ErrCancel, if the application is closing, while
+// waiting for the lock. The returned function releases the lock and is never nil.
+func (f *ScriptBasedSoftwareUpdatable) acquireInstallLock(log *logger.Entry, cancel chan struct{}) (func(), error) {
+	if f.installLock == "" {
+		return func() {}, nil
+	}
+	file, err := os.OpenFile(f.installLock, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return func() {}, err
+	}
+	var timeout <-chan time.Time
+	if f.installLockTimeout > 0 {
+		timeout = clock.After(f.installLockTimeout)
+	}
+	waiting := false
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return func() {}, err
+		}
+		if locked {
+			log.Debugf("Install lock acquired: %s", f.installLock)
+			return func() {
+				if err := unlock(file); err != nil {
+					log.Errorf("failed to release install lock %s: %v", f.installLock, err)
+				}
+				file.Close()
+			}, nil
+		}
+		if !waiting {
+			log.Infof("Waiting for install lock: %s", f.installLock)
+			waiting = true
+		}
+		select {
+		case <-clock.After(installLockInterval):
+		case <-timeout:
+			file.Close()
+			return func() {}, fmt.Errorf("%w: %s not acquired within %v", errInstallLocked, f.installLock, f.installLockTimeout)
+		case <-cancel:
+			file.Close()
+			return func() {}, storage.ErrCanceled
+		case <-done:
+			file.Close()
+			return func() {}, storage.ErrCancel
+		}
+	}
+}
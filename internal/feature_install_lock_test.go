@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestInstallLockAcquire tests that the acquired install lock is held until released.
+func TestInstallLockAcquire(t *testing.T) {
+	path := installLockPath(t)
+	f := newInstallLockFeature(path, time.Second)
+
+	release, err := f.acquireInstallLock(operationLog("install", "test", nil), make(chan struct{}))
+	if err != nil {
+		t.Fatalf("failed to acquire install lock: %v", err)
+	}
+	other := openInstallLock(t, path)
+	if locked, err := tryLock(other); locked || err != nil {
+		t.Fatalf("acquired install lock is not held: %v", err)
+	}
+	release()
+	if locked, err := tryLock(other); !locked || err != nil {
+		t.Fatalf("released install lock is still held: %v", err)
+	}
+}
+
+// TestInstallLockTimeout tests that the install lock, held by another process, is not acquired within the timeout.
+func TestInstallLockTimeout(t *testing.T) {
+	path := installLockPath(t)
+	holdInstallLock(t, path)
+	f := newInstallLockFeature(path, 5*time.Minute)
+	fake := useFakeClock(t)
+
+	release, err := f.acquireInstallLock(operationLog("install", "test", nil), make(chan struct{}))
+	release()
+	if !errors.Is(err, errInstallLocked) {
+		t.Fatalf("unexpected install lock error: %v", err)
+	}
+	if waits := fake.Waits(); len(waits) == 0 || waits[0] != f.installLockTimeout {
+		t.Fatalf("install lock is not timed out by the clock after %v: %v", f.installLockTimeout, waits)
+	}
+}
+
+// TestInstallLockWait tests that the install lock is acquired, once released by another process.
+func TestInstallLockWait(t *testing.T) {
+	path := installLockPath(t)
+	other := holdInstallLock(t, path)
+	f := newInstallLockFeature(path, 10*time.Second)
+	time.AfterFunc(300*time.Millisecond, func() { unlock(other) })
+
+	release, err := f.acquireInstallLock(operationLog("install", "test", nil), make(chan struct{}))
+	if err != nil {
+		t.Fatalf("failed to acquire released install lock: %v", err)
+	}
+	release()
+}
+
+// TestInstallLockCanceled tests that the waiting for the install lock stops, when the operation is canceled.
+func TestInstallLockCanceled(t *testing.T) {
+	path := installLockPath(t)
+	holdInstallLock(t, path)
+	f := newInstallLockFeature(path, 0)
+	cancel := make(chan struct{})
+	time.AfterFunc(300*time.Millisecond, func() { close(cancel) })
+
+	release, err := f.acquireInstallLock(operationLog("install", "test", nil), cancel)
+	release()
+	if err != storage.ErrCanceled {
+		t.Fatalf("unexpected install lock error: %v", err)
+	}
+}
+
+// TestInstallLocked tests that the module installation fails without running the install script, while the install
+// lock is held by another process, and that the module download does not require the lock.
+func TestInstallLocked(t *testing.T) {
+	path := installLockPath(t)
+	holdInstallLock(t, path)
+	feature, mc, fx := mockOperationFeature(t)
+	feature.installLock = path
+	feature.installLockTimeout = 300 * time.Millisecond
+
+	// 1. Download the module, without the install lock.
+	sua := installAction(fx, "test-downloaded")
+	feature.downloadHandler(sua, feature.su)
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("expected download operation to succeed: %v", lo)
+	}
+
+	// 2. Fail to install the module, without the install lock.
+	sua.CorrelationID = "test-locked"
+	feature.installHandler(sua, feature.su)
+	lo := pullFinalOperationStatus(t, mc)
+	if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeInstallLocked {
+		t.Fatalf("expected install operation to fail with %s: %v", codeInstallLocked, lo)
+	}
+	if fx.Installs() != 0 {
+		t.Fatal("install script is run without the install lock")
+	}
+}
+
+// newInstallLockFeature returns a not connected feature with the given install lock, which is not closing.
+func newInstallLockFeature(path string, timeout time.Duration) *ScriptBasedSoftwareUpdatable {
+	done = make(chan struct{})
+	return &ScriptBasedSoftwareUpdatable{installLock: path, installLockTimeout: timeout}
+}
+
+func installLockPath(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("install lock is not supported on windows")
+	}
+	return filepath.Join(t.TempDir(), "install.lock")
+}
+
+func openInstallLock(t *testing.T, path string) *os.File {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("failed to open install lock: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+// holdInstallLock acquires the install lock as another process would, until the end of the test.
+func holdInstallLock(t *testing.T, path string) *os.File {
+	file := openInstallLock(t, path)
+	if locked, err := tryLock(file); !locked || err != nil {
+		t.Fatalf("failed to hold install lock: %v", err)
+	}
+	return file
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestInstallCommands tests selecting the install command by the module artifact type.
+func TestInstallCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-install-commands", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	installed := getAbsolutePath(t, tmpDir)
+	feature.installCommands = installCommands{
+		"deb":    command{cmd: "/bin/sh", args: []string{"-c", fmt.Sprintf("cat package > %s", filepath.Join(installed, "deb"))}},
+		"script": command{cmd: "/bin/sh", args: []string{"-c", fmt.Sprintf("cat package > %s", filepath.Join(installed, "script"))}},
+	}
+
+	// 1. Install modules with mapped artifact types.
+	for _, artifactType := range []string{"deb", "script"} {
+		body := "installed by " + artifactType
+		path, hash := createLocalArtifact(t, tmpDir, artifactType+".pkg", body)
+		sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+			convertLocalArtifact(getAbsolutePath(t, path), "package", hash, len(body)),
+		}, "*")
+		sua.CorrelationID = "test-" + artifactType
+		sua.SoftwareModules[0].Metadata["artifact-type"] = artifactType
+
+		feature.installHandler(sua, feature.su)
+		if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+			t.Fatalf("expected %s module to be installed: %v", artifactType, lo)
+		}
+		checkFileExistsWithContent(t, filepath.Join(installed, artifactType), body)
+	}
+
+	// 2. Fail to install module with unmapped artifact type.
+	body := "unknown"
+	path, hash := createLocalArtifact(t, tmpDir, "unknown.pkg", body)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, path), "package", hash, len(body)),
+	}, "*")
+	sua.CorrelationID = "test-unknown"
+	sua.SoftwareModules[0].Metadata["artifact-type"] = "rpm"
+
+	feature.installHandler(sua, feature.su)
+	lo := pullFinalOperationStatus(t, mc)
+	if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeUnsupportedArtifactType ||
+		lo[messageParam] != errUnmappedArtifactType {
+		t.Fatalf("expected rpm module installation to fail: %v", lo)
+	}
+}
+
+// TestInstallFailedArtifacts tests that no install command runs, unless all module artifacts are verified, and
+// that every failed artifact is reported.
+func TestInstallFailedArtifacts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-install-failed-artifacts", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	installed := getAbsolutePath(t, filepath.Join(tmpDir, "installed"))
+	feature.installCommand = &command{cmd: "/bin/sh", args: []string{"-c", "echo installed > " + installed}}
+
+	var artifacts []*hawkbit.SoftwareArtifactAction
+	for _, name := range []string{"first.txt", "second.txt", "third.txt"} {
+		path, hash := createLocalArtifact(t, tmpDir, name, name)
+		if name == "second.txt" {
+			_, hash = createLocalArtifact(t, tmpDir, "corrupted.txt", "corrupted")
+		}
+		artifacts = append(artifacts, convertLocalArtifact(getAbsolutePath(t, path), name, hash, len(name)))
+	}
+	sua := prepareSoftwareUpdateAction(artifacts, "*")
+
+	feature.installHandler(sua, feature.su)
+	var lo map[string]interface{}
+	for lo == nil || lo[statusParam] != string(hawkbit.StatusFinishedError) {
+		if lo = mc.pullLastOperationStatus(); lo == nil {
+			t.Fatal("install operation not finished")
+		}
+		if lo[statusParam] == string(hawkbit.StatusInstalling) || lo[statusParam] == string(hawkbit.StatusFinishedSuccess) {
+			t.Fatalf("module with failed artifact must not be installed: %v", lo)
+		}
+	}
+	if lo["statusCode"] != codeDownloadChecksumMismatch || lo[messageParam] != errDownload+" - failed artifacts: second.txt" {
+		t.Fatalf("unexpected failed artifacts status: %v", lo)
+	}
+	if _, err := os.Stat(installed); !os.IsNotExist(err) {
+		t.Fatalf("install command executed with failed artifact: %v", err)
+	}
+}
+
+// TestInstallDuplicateArtifacts tests that the module artifacts with the same file name are renamed and installed
+// without overwriting each other, or rejected, if so configured.
+func TestInstallDuplicateArtifacts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-install-duplicate-artifacts", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	installed := getAbsolutePath(t, filepath.Join(tmpDir, "installed"))
+	feature.installCommand = &command{cmd: "/bin/sh", args: []string{"-c", "cat app.bin app-1.bin > " + installed}}
+
+	var artifacts []*hawkbit.SoftwareArtifactAction
+	for _, body := range []string{"first", "second"} {
+		path, hash := createLocalArtifact(t, tmpDir, body+".bin", body)
+		artifacts = append(artifacts, convertLocalArtifact(getAbsolutePath(t, path), "app.bin", hash, len(body)))
+	}
+
+	// 1. Rename the duplicate artifact by default.
+	feature.duplicateArtifacts = storage.DuplicatesRename
+	feature.installHandler(prepareSoftwareUpdateAction(artifacts, "*"), feature.su)
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("expected module with duplicate artifacts to be installed: %v", lo)
+	}
+	checkFileExistsWithContent(t, installed, "firstsecond")
+
+	// 2. Reject the operation with duplicate artifacts.
+	feature.duplicateArtifacts = storage.DuplicatesReject
+	sua := prepareSoftwareUpdateAction(artifacts, "*")
+	sua.CorrelationID = "test-reject"
+	feature.installHandler(sua, feature.su)
+	lo := mc.pullLastOperationStatus()
+	if lo == nil || lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeArtifactInvalid ||
+		!strings.Contains(fmt.Sprint(lo[messageParam]), "app.bin") {
+		t.Fatalf("expected operation with duplicate artifacts to be rejected: %v", lo)
+	}
+}
+
+// pullFinalOperationStatus returns the first reported finished operation status.
+func pullFinalOperationStatus(t *testing.T, mc *mockedClient) map[string]interface{} {
+	t.Helper()
+
+	for {
+		lo := mc.pullLastOperationStatus()
+		if lo == nil {
+			t.Fatal("operation not finished")
+		}
+		switch lo[statusParam] {
+		case string(hawkbit.StatusFinishedSuccess), string(hawkbit.StatusFinishedError),
+			string(hawkbit.StatusFinishedRejected), string(hawkbit.StatusFinishedCanceled):
+			return lo
+		}
+	}
+}
+
+// TestInstallProgressLines tests that the progress lines, printed by the install script, are forwarded as install
+// progress and the malformed ones are ignored.
+func TestInstallProgressLines(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-install-progress", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	install := "echo 'PROGRESS: 25 Unpacking'\necho 'PROGRESS: many'\necho 'PROGRESS: 150'\necho 'Installing'\n" +
+		"echo 'PROGRESS: 70 Configuring'\n"
+	path, hash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, path), "install.sh", hash, len(install)),
+	}, "*")
+
+	feature.installHandler(sua, feature.su)
+	var progress []string
+	for {
+		lo := mc.pullLastOperationStatus()
+		if lo == nil {
+			t.Fatal("install operation not finished")
+		}
+		if lo[statusParam] == string(hawkbit.StatusFinishedSuccess) {
+			break
+		}
+		if lo[statusParam] == string(hawkbit.StatusFinishedError) {
+			t.Fatalf("failed to install module: %v", lo)
+		}
+		if p, ok := lo[progressParam].(float64); ok && lo[statusParam] == string(hawkbit.StatusInstalling) && p > 0 {
+			progress = append(progress, fmt.Sprintf("%v %v", p, lo[messageParam]))
+		}
+	}
+	if expected := []string{"25 Unpacking", "70 Configuring"}; strings.Join(progress, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("expected install progress %v, got %v", expected, progress)
+	}
+}
@@ -13,9 +13,12 @@
 package feature
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 	"github.com/eclipse-kanto/software-update/internal/logger"
@@ -33,24 +36,41 @@ const (
 	errInstalledDepsSave     = "fail to save installed dependencies"
 	errInstalledDepsRefresh  = "fail to refresh installed dependencies"
 	errDetermineAbsolutePath = "fail to determine absolute path of install script %s - %v"
+	errUnmappedArtifactType  = "no install command configured for the module artifact type"
+	errArtifactScan          = "artifact rejected by the scan command"
+	errHealthProbe           = "installed module is not healthy"
+	errVersionCheck          = "installed module does not report its version"
+	errOperationTimeout      = "operation timed out"
+	errInstallLock           = "fail to acquire install lock"
 )
 
 // opw is an operation wrapper function.
-type opw func(dir string, u *storage.Updatable) bool
+type opw func(dir string, u *storage.Updatable, cancel chan struct{}) bool
 
 func (f *ScriptBasedSoftwareUpdatable) init(
 	scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig, edge *edgeConfiguration) (err error) {
-	// Create Ditto client.
+	// Create Ditto client. Operations are processed and loaded only once, not on each reconnect.
+	var started sync.Once
 	config := ditto.NewConfiguration().
 		WithDisconnectTimeout(defaultDisconnectTimeout).
 		WithConnectHandler(func(dittoClient *ditto.Client) {
 			logger.Infof("Connected to MQTT broker: %s", scriptSUPConfig.Broker)
 			f.su.Activate()
-			go f.process()
-			f.load()
+			started.Do(func() {
+				go f.process()
+				f.load()
+			})
 		})
 
-	f.dittoClient, err = ditto.NewClientMqtt(f.mqttClient, config)
+	client := &dittoMQTTClient{
+		Client:         f.mqttClient,
+		topics:         newTopics(scriptSUPConfig),
+		statusQoS:      byte(scriptSUPConfig.StatusQoS),
+		statusRetained: scriptSUPConfig.StatusRetained,
+		commandsQoS:    byte(scriptSUPConfig.CommandsQoS),
+		compressSize:   scriptSUPConfig.StatusCompressSize,
+	}
+	f.dittoClient, err = ditto.NewClientMqtt(client, config)
 	if err != nil {
 		return fmt.Errorf("failed to create Ditto client: %v", err)
 	}
@@ -61,20 +81,46 @@ func (f *ScriptBasedSoftwareUpdatable) init(
 		return fmt.Errorf("failed to load installed dependencies: %v", err)
 	}
 
+	// Only commands to the expected thing are accepted, which is the edge device by default.
+	thingID := edge.DeviceID
+	if scriptSUPConfig.ThingID != "" {
+		if scriptSUPConfig.ThingID != edge.DeviceID {
+			logger.Warnf("Configured thing %s differs from the edge device %s", scriptSUPConfig.ThingID, edge.DeviceID)
+		}
+		thingID = scriptSUPConfig.ThingID
+	} else if scriptSUPConfig.ThingNamespace != "" {
+		if id := model.NewNamespacedIDFrom(edge.DeviceID); id != nil {
+			thingID = model.NewNamespacedID(scriptSUPConfig.ThingNamespace, id.Name).String()
+		}
+	}
+
 	// Create Hawkbit SoftwareUpdatable configuration.
 	cfg := (&hawkbit.Configuration{}).
 		WithDittoClient(f.dittoClient).
-		WithThingID(model.NewNamespacedIDFrom(edge.DeviceID)).
+		WithThingID(model.NewNamespacedIDFrom(thingID)).
 		WithFeatureID(scriptSUPConfig.FeatureID).
 		WithSoftwareType(scriptSUPConfig.ModuleType).
 		WithInstallHandler(f.installHandler).
-		WithDownloadHandler(f.downloadHandler)
+		WithDownloadHandler(f.downloadHandler).
+		WithCancelHandler(f.cancelHandler).
+		WithCancelPropertyHandler(f.cancelPropertyHandler).
+		WithProceedHandler(f.proceedHandler)
 
 	// Create new Hawkbit SoftwareUpdatable.
-	if f.su, err = hawkbit.NewSoftwareUpdatable(cfg); err != nil {
+	su, err := hawkbit.NewSoftwareUpdatable(cfg)
+	if err != nil {
 		return err
 	}
-	f.su.SetInstalledDependencies(ids...)
+	su.SetInstalledDependencies(ids...)
+
+	// Keep the last operations of the previous SoftwareUpdatable, so they are announced on connect.
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.su != nil {
+		su.SetLastFailedOperation(f.su.LastFailedOperation())
+		su.SetLastOperation(f.su.LastOperation())
+	}
+	f.su = su
 	return nil
 }
 
@@ -85,14 +131,17 @@ func (f *ScriptBasedSoftwareUpdatable) load() {
 	// Load all previous operations and add them to the queue
 	updatables := f.store.LoadSoftwareUpdatables()
 	for dir, updatable := range updatables {
+		dir, updatable := dir, updatable
+		cancel := f.addCancel(updatable.CorrelationID)
 		f.queue <- func() bool {
+			defer f.removeCancel(updatable.CorrelationID, cancel)
 			// Add install operation to the queue.
 			if updatable.Operation == "install" {
-				return f.installModules(dir, updatable, f.su)
+				return f.installModules(dir, updatable, f.su, cancel)
 			}
 			// Add download operation to the queue.
 			if updatable.Operation == "download" {
-				return f.downloadModules(dir, updatable, f.su)
+				return f.downloadModules(dir, updatable, f.su, cancel)
 			}
 			return false
 		}
@@ -115,7 +164,7 @@ func (f *ScriptBasedSoftwareUpdatable) process() {
 		case <-done:
 			return // Cancel: application is closing!
 		case op := <-f.queue:
-			if op() {
+			if op() && isShuttingDown() {
 				return // Cancel: application is closing!
 			}
 		}
@@ -124,7 +173,7 @@ func (f *ScriptBasedSoftwareUpdatable) process() {
 
 // prepare find available directory for the operation and saved it.
 func (f *ScriptBasedSoftwareUpdatable) prepare(name string, cid string,
-	modules []*hawkbit.SoftwareModuleAction, w opw) {
+	modules []*hawkbit.SoftwareModuleAction, metadata map[string]string, w opw) {
 	// Lock current goroute until file operation is added to the queue.
 	f.lock.Lock()
 	defer f.lock.Unlock()
@@ -136,40 +185,84 @@ func (f *ScriptBasedSoftwareUpdatable) prepare(name string, cid string,
 	if len(modules) == 0 {
 		return
 	}
+	// Do not accept new operations on shutdown.
+	if isShuttingDown() {
+		f.rejectOnShutdown(cid, modules)
+		return
+	}
 
-	// Find available directory to store the operation.
-	toDir, err := storage.FindAvailableLocation(f.store.DownloadPath)
+	// Reject the operations with invalid timeout, supplied by the backend, and resolve the valid one to its
+	// deadline, so that it is kept, when the operation is resumed.
+	if _, err := f.operationTimeoutOf(metadata); err != nil {
+		logger.Errorf("Reject %s operation with id %s: %v", name, cid, err)
+		f.fail(cid, modules, err)
+		return
+	}
+	metadata = resolveOperationDeadline(metadata, time.Now())
+
+	// Reject the operations, which exceed the maximal total size or count of their artifacts.
+	if err := f.checkOperationLimits(modules); err != nil {
+		logger.Errorf("Reject %s operation with id %s: %v", name, cid, err)
+		f.fail(cid, modules, err)
+		return
+	}
+
+	// Create the operation working directory, isolating its files from the other operations.
+	toDir, err := f.store.CreateOperationLocation(f.store.DownloadPath, cid)
 	if err != nil {
-		logger.Debugf("Fail to find available directory: %v", err)
-		f.fail(cid, modules)
+		logger.Debugf("Fail to create operation directory: %v", err)
+		f.fail(cid, modules, err)
 		return
 	}
 	logger.Debugf("%s operation working directory: %s", name, toDir)
 
 	// Save the operation to its directory.
 	to := filepath.Join(toDir, storage.SoftwareUpdatableName)
-	updatable, err := storage.SaveSoftwareUpdatable(name, cid, to, modules)
+	updatable, err := f.store.SaveSoftwareUpdatable(name, cid, to, modules, metadata,
+		storage.ModuleOptions{Duplicates: f.duplicateArtifacts, MissingChecksum: f.server.MissingChecksum})
 	if err != nil {
 		logger.Debugf("Fail to save [%s] operation: %v", name, err)
-		f.fail(cid, modules)
+		f.fail(cid, modules, err)
 		return
 	}
 
-	// Add operation to the queue.
+	// Add operation to the queue, superseding the queued operations of the same modules within the coalesce window.
+	cancel := f.addCancel(cid)
+	received := f.receive(cid, modules)
 	f.queue <- func() bool {
-		return w(toDir, updatable)
+		defer f.removeCancel(cid, cancel)
+		if superseded, closing := f.coalesce(name, cid, modules, received, toDir, cancel); superseded || closing {
+			return closing
+		}
+		return w(toDir, updatable, cancel)
 	}
 }
 
-// fail all modules in the operation.
-func (f *ScriptBasedSoftwareUpdatable) fail(cid string, modules []*hawkbit.SoftwareModuleAction) {
+// fail all modules in the operation. The operations with duplicate artifact file names, invalid timeout or
+// exceeded limits are reported with the cause.
+func (f *ScriptBasedSoftwareUpdatable) fail(cid string, modules []*hawkbit.SoftwareModuleAction, err error) {
+	msg := "Fail to save operation data."
+	if errors.Is(err, storage.ErrDuplicateFileName) || errors.Is(err, errInvalidOperationTimeout) ||
+		errors.Is(err, errOperationLimitExceeded) {
+		msg = err.Error()
+	}
 	for _, module := range modules {
 		setLastOS(f.su, (&hawkbit.OperationStatus{}).
 			WithCorrelationID(cid).
 			WithSoftwareModule(module.SoftwareModule).
 			WithStatus(hawkbit.StatusFinishedError).
-			WithMessage("Fail to save operation data."))
+			WithStatusCode(toStatusCode(errRuntime, err)).
+			WithMessage(msg))
+	}
+}
+
+// operationLog returns the log entry of the operation, with the operation context as structured fields.
+func operationLog(operation string, cid string, module *storage.Module) *logger.Entry {
+	fields := logger.Fields{"correlationId": cid, "operation": operation}
+	if module != nil {
+		fields["module"] = module.Name + ":" + module.Version
 	}
+	return logger.With(fields)
 }
 
 // newOS returns newly created OperationStatus pointer.
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+var errOperationLimitExceeded = errors.New("operation limit exceeded")
+
+// checkOperationLimits verifies that the operation modules do not exceed the maximal artifact count and total
+// declared size of an operation, before anything is downloaded. The artifacts, whose exact size is not known in
+// advance, are accounted with their maximal size. A limit of 0 is unlimited.
+func (f *ScriptBasedSoftwareUpdatable) checkOperationLimits(modules []*hawkbit.SoftwareModuleAction) error {
+	count := 0
+	var total int64
+	for _, module := range modules {
+		for _, artifact := range module.Artifacts {
+			count++
+			size := int64(artifact.Size)
+			if int64(artifact.MaxSize) > size {
+				size = int64(artifact.MaxSize)
+			}
+			if f.maxTotalBytes > 0 && (size < 0 || total > f.maxTotalBytes-size) {
+				return fmt.Errorf("%w: total artifacts size exceeds the maximal %d bytes", errOperationLimitExceeded,
+					f.maxTotalBytes)
+			}
+			total += size
+		}
+	}
+	if f.maxArtifacts > 0 && count > f.maxArtifacts {
+		return fmt.Errorf("%w: %d artifacts exceed the maximal %d artifacts", errOperationLimitExceeded, count,
+			f.maxArtifacts)
+	}
+	return nil
+}
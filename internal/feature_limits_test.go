@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestCheckOperationLimits tests the limits of the total declared size and count of the operation artifacts.
+func TestCheckOperationLimits(t *testing.T) {
+	modules := func(sizes ...[]int) []*hawkbit.SoftwareModuleAction {
+		var result []*hawkbit.SoftwareModuleAction
+		for _, module := range sizes {
+			action := &hawkbit.SoftwareModuleAction{}
+			for _, size := range module {
+				action.Artifacts = append(action.Artifacts, &hawkbit.SoftwareArtifactAction{Size: size})
+			}
+			result = append(result, action)
+		}
+		return result
+	}
+	tests := map[string]struct {
+		modules      []*hawkbit.SoftwareModuleAction
+		maxBytes     int64
+		maxArtifacts int
+		exceeded     bool
+	}{
+		"unlimited":         {modules: modules([]int{1 << 30, 1 << 30}, []int{1 << 30})},
+		"within":            {modules: modules([]int{100, 200}, []int{300}), maxBytes: 600, maxArtifacts: 3},
+		"total-exceeded":    {modules: modules([]int{100, 200}, []int{301}), maxBytes: 600, exceeded: true},
+		"count-exceeded":    {modules: modules([]int{1, 1}, []int{1, 1}), maxArtifacts: 3, exceeded: true},
+		"overflow":          {modules: modules([]int{1 << 62}, []int{1 << 62}, []int{1 << 62}), maxBytes: 1 << 62, exceeded: true},
+		"no-artifacts":      {modules: modules([]int{}), maxBytes: 1, maxArtifacts: 1},
+		"max-size-exceeded": {modules: modules([]int{0}), maxBytes: 600, exceeded: true},
+	}
+	tests["max-size-exceeded"].modules[0].Artifacts[0].MaxSize = 601
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := &ScriptBasedSoftwareUpdatable{maxTotalBytes: test.maxBytes, maxArtifacts: test.maxArtifacts}
+			err := f.checkOperationLimits(test.modules)
+			if exceeded := errors.Is(err, errOperationLimitExceeded); exceeded != test.exceeded || (err != nil && !exceeded) {
+				t.Fatalf("unexpected operation limits error: %v", err)
+			}
+		})
+	}
+}
+
+// TestOperationLimits tests that the operations within the limits are installed and the operations, which exceed
+// them, are rejected before anything is downloaded.
+func TestOperationLimits(t *testing.T) {
+	feature, mc, fx := mockOperationFeature(t)
+	extra := "extra"
+	extraPath, extraHash := fx.AddFile(t, "extra.txt", extra)
+
+	tests := map[string]struct {
+		maxBytes     int64
+		maxArtifacts int
+		rejected     bool
+	}{
+		"within":      {maxBytes: int64(len(fx.InstallBody) + len(extra)), maxArtifacts: 2},
+		"total-bytes": {maxBytes: int64(len(fx.InstallBody) + len(extra) - 1), rejected: true},
+		"artifacts":   {maxArtifacts: 1, rejected: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			installs := fx.Installs()
+			feature.maxTotalBytes, feature.maxArtifacts = test.maxBytes, test.maxArtifacts
+			sua := installAction(fx, "test-limits-"+name)
+			sua.SoftwareModules[0].Artifacts = append(sua.SoftwareModules[0].Artifacts,
+				convertLocalArtifact(extraPath, "extra.txt", extraHash, len(extra)))
+			feature.installHandler(sua, feature.su)
+			lo := pullFinalOperationStatus(t, mc)
+
+			if !test.rejected {
+				if lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+					t.Fatalf("expected install within the limits to succeed: %v", lo)
+				}
+				if fx.Installs() != installs+1 {
+					t.Fatal("operation within the limits is not installed")
+				}
+				return
+			}
+			if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeOperationLimitExceeded {
+				t.Fatalf("expected install over the limits to be rejected: %v", lo)
+			}
+			if msg, _ := lo[messageParam].(string); !strings.Contains(msg, errOperationLimitExceeded.Error()) {
+				t.Fatalf("unexpected rejection status message: %s", msg)
+			}
+			if fx.Installs() != installs {
+				t.Fatal("rejected operation is installed")
+			}
+		})
+	}
+}
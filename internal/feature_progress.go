@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// downloadProgress reports the module download progress as lastOperation updates.
+// Updates are sent only when the percentage increases and at most once per interval,
+// except the final 100 percent, which is always reported. The messages include the average
+// download speed since the start and the estimated remaining time, if the total size is known.
+type downloadProgress struct {
+	cid      string
+	module   *storage.Module
+	su       *hawkbit.SoftwareUpdatable
+	interval time.Duration
+	start    time.Time
+
+	percent int
+	time    time.Time
+	written int64
+	total   int64
+}
+
+func newDownloadProgress(cid string, module *storage.Module, su *hawkbit.SoftwareUpdatable,
+	interval time.Duration) *downloadProgress {
+	return &downloadProgress{cid: cid, module: module, su: su, interval: interval, start: clock.Now()}
+}
+
+// update is the storage.Progress callback of the module download.
+func (p *downloadProgress) update(percent int, written int64, total int64) {
+	p.written = written
+	p.total = total
+	if percent <= p.percent {
+		return
+	}
+	now := clock.Now()
+	if percent < 100 && p.interval > 0 && now.Sub(p.time) < p.interval {
+		return
+	}
+	p.percent = percent
+	p.time = now
+	p.send()
+}
+
+// complete reports 100 percent progress, if not reported yet.
+func (p *downloadProgress) complete() {
+	if p.percent < 100 {
+		p.percent = 100
+		if p.total > 0 {
+			p.written = p.total
+		}
+		p.send()
+	}
+}
+
+func (p *downloadProgress) send() {
+	ops := newOS(p.cid, p.module, hawkbit.StatusDownloading).WithProgress(p.percent)
+	if message := p.message(clock.Now()); message != "" {
+		ops.WithMessage(message)
+	}
+	setLastOS(p.su, ops)
+}
+
+// message describes the downloaded bytes, the speed and the estimated remaining time at the given time.
+// The remaining time is omitted, if the total size is unknown or the speed cannot be measured yet.
+func (p *downloadProgress) message(now time.Time) string {
+	var message string
+	if p.total > 0 {
+		message = fmt.Sprintf("downloaded %d of %d bytes", p.written, p.total)
+	} else if p.written > 0 {
+		message = fmt.Sprintf("downloaded %d bytes", p.written)
+	} else {
+		return ""
+	}
+	elapsed := now.Sub(p.start)
+	if elapsed <= 0 || p.written <= 0 {
+		return message
+	}
+	speed := float64(p.written) / elapsed.Seconds()
+	message += ", " + formatSpeed(speed)
+	if remaining := p.total - p.written; p.total > 0 && remaining > 0 {
+		eta := time.Duration(float64(remaining) / speed * float64(time.Second))
+		message += ", ETA " + eta.Round(time.Second).String()
+	}
+	return message
+}
+
+// withProgressListeners returns the progress callback, notifying the configured progress listeners as well,
+// and a function to stop notifying them, once the download is finished. The listeners are notified
+// asynchronously, so that they cannot stall the download.
+func (f *ScriptBasedSoftwareUpdatable) withProgressListeners(progress storage.Progress) (storage.Progress, func()) {
+	if len(f.progressListeners) == 0 {
+		return progress, func() {}
+	}
+	listeners := storage.NewProgressFanOut(f.progressListeners...)
+	return func(percent int, written int64, total int64) {
+		progress(percent, written, total)
+		listeners.Update(percent, written, total)
+	}, listeners.Close
+}
+
+// formatSpeed formats the bytes per second with a binary prefix, e.g. 1.5 MiB/s.
+func formatSpeed(speed float64) string {
+	const unit = 1024
+	if speed < unit {
+		return fmt.Sprintf("%.0f B/s", speed)
+	}
+	prefixes := "KMGT"
+	i := 0
+	for speed /= unit; speed >= unit && i < len(prefixes)-1; i++ {
+		speed /= unit
+	}
+	return fmt.Sprintf("%.1f %ciB/s", speed, prefixes[i])
+}
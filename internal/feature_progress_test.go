@@ -0,0 +1,173 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestDownloadProgress tests that the download progress updates are monotonic and reach 100 percent.
+func TestDownloadProgress(t *testing.T) {
+	useFakeClock(t)
+	su, mc := mockSoftwareUpdatable(t, hawkbit.NewConfiguration(), &testConfig{clientConnected: true})
+	if err := su.Activate(); err != nil {
+		t.Fatalf("failed to activate software updatable: %v", err)
+	}
+	module := &storage.Module{Name: testModuleName, Version: testModuleVersion}
+
+	// 1. Report each progress change, skipping the decreasing ones.
+	progress := newDownloadProgress(testCid, module, su, 0)
+	go func() {
+		progress.update(10, 100, 1000)
+		progress.update(5, 50, 1000)
+		progress.update(50, 500, 1000)
+		progress.update(100, 1000, 1000)
+		progress.complete()
+	}()
+	assertProgress(t, mc, 10, "downloaded 100 of 1000 bytes")
+	assertProgress(t, mc, 50, "downloaded 500 of 1000 bytes")
+	assertProgress(t, mc, 100, "downloaded 1000 of 1000 bytes")
+
+	// 2. Throttle the progress changes, but always report the completion.
+	throttled := newDownloadProgress(testCid, module, su, time.Hour)
+	go func() {
+		throttled.update(10, 100, 1000)
+		throttled.update(50, 500, 1000)
+		throttled.update(90, 900, 1000)
+		throttled.complete()
+	}()
+	assertProgress(t, mc, 10, "downloaded 100 of 1000 bytes")
+	assertProgress(t, mc, 100, "downloaded 1000 of 1000 bytes")
+
+	// 3. No more updates are expected.
+	select {
+	case payload := <-mc.payload:
+		t.Fatalf("unexpected progress update: %v", payload)
+	case <-time.After(time.Second):
+	}
+}
+
+// TestDownloadProgressSpeed tests that the throttled progress updates report the download speed and the remaining time.
+func TestDownloadProgressSpeed(t *testing.T) {
+	fake := useFakeClock(t)
+	su, mc := mockSoftwareUpdatable(t, hawkbit.NewConfiguration(), &testConfig{clientConnected: true})
+	if err := su.Activate(); err != nil {
+		t.Fatalf("failed to activate software updatable: %v", err)
+	}
+	module := &storage.Module{Name: testModuleName, Version: testModuleVersion}
+
+	// 1. Report the average speed since the start and the remaining time of the known total.
+	progress := newDownloadProgress(testCid, module, su, 10*time.Second)
+	go func() {
+		<-fake.After(10 * time.Second)
+		progress.update(10, 102400, 1024000)
+		<-fake.After(5 * time.Second)
+		progress.update(20, 204800, 1024000)
+		<-fake.After(5 * time.Second)
+		progress.update(30, 307200, 1024000)
+		progress.complete()
+	}()
+	assertProgress(t, mc, 10, "downloaded 102400 of 1024000 bytes, 10.0 KiB/s, ETA 1m30s")
+	assertProgress(t, mc, 30, "downloaded 307200 of 1024000 bytes, 15.0 KiB/s, ETA 47s")
+	assertProgress(t, mc, 100, "downloaded 1024000 of 1024000 bytes, 50.0 KiB/s")
+
+	// 2. Omit the remaining time, if the total size is unknown.
+	unknown := newDownloadProgress(testCid, module, su, 0)
+	go func() {
+		unknown.update(0, 2048, 0)
+		<-fake.After(4 * time.Second)
+		unknown.complete()
+	}()
+	assertProgress(t, mc, 100, "downloaded 2048 bytes, 512 B/s")
+}
+
+// TestFormatSpeed tests the binary prefixes of the download speed.
+func TestFormatSpeed(t *testing.T) {
+	tests := map[float64]string{
+		0:                "0 B/s",
+		1023:             "1023 B/s",
+		1536:             "1.5 KiB/s",
+		5 * 1024 * 1024:  "5.0 MiB/s",
+		3 << 30:          "3.0 GiB/s",
+		2048 * (1 << 40): "2048.0 TiB/s",
+	}
+	for speed, expected := range tests {
+		if actual := formatSpeed(speed); actual != expected {
+			t.Errorf("unexpected speed format of %v: %v != %v", speed, actual, expected)
+		}
+	}
+}
+
+// TestProgressListeners tests that the configured progress listeners are notified with the download progress,
+// besides the operation status updates.
+func TestProgressListeners(t *testing.T) {
+	received := make(chan int, 10)
+	blocked := make(chan struct{})
+	defer close(blocked)
+	f := &ScriptBasedSoftwareUpdatable{progressListeners: []storage.Progress{
+		func(percent int, written int64, total int64) { received <- percent },
+		func(percent int, written int64, total int64) { <-blocked },
+	}}
+	var reported []int
+	notify, stop := f.withProgressListeners(func(percent int, written int64, total int64) {
+		reported = append(reported, percent)
+	})
+	notify(50, 500, 1000)
+	notify(100, 1000, 1000)
+	stop()
+
+	if !reflect.DeepEqual(reported, []int{50, 100}) {
+		t.Fatalf("unexpected reported progress: %v", reported)
+	}
+	for last := 0; last < 100; {
+		select {
+		case percent := <-received:
+			last = percent
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing progress of listener")
+		}
+	}
+}
+
+// useFakeClock replaces the clock of the package with a fake one, until the test is finished.
+func useFakeClock(t *testing.T) *storage.FakeClock {
+	fake := storage.NewFakeClock()
+	clock = fake
+	t.Cleanup(func() { clock = storage.SystemClock })
+	return fake
+}
+
+func assertProgress(t *testing.T, mc *mockedClient, progress int, message string) {
+	t.Helper()
+
+	lo := mc.pullLastOperationStatus()
+	if lo == nil {
+		t.Fatalf("missing progress update %v", progress)
+	}
+	if lo[statusParam] != string(hawkbit.StatusDownloading) {
+		t.Fatalf("unexpected progress update status: %v", lo[statusParam])
+	}
+	if lo[progressParam] != float64(progress) {
+		t.Fatalf("unexpected progress: %v != %v", lo[progressParam], progress)
+	}
+	if lo[messageParam] != message {
+		t.Fatalf("unexpected progress message: %v != %v", lo[messageParam], message)
+	}
+}
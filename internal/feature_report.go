@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+const (
+	// reportLogName is the name of the uploaded install log of an install operation.
+	reportLogName = "install.log"
+	// reportManifestName is the name of the uploaded result manifest of an install operation.
+	reportManifestName = "result.json"
+	// truncatedMark starts the install log, if its beginning is dropped to stay within the log size.
+	truncatedMark = "[truncated]\n"
+)
+
+// installReport collects the bounded install commands output and the final status of the modules of an
+// install operation, to be uploaded after the operation completes.
+type installReport struct {
+	log     *tailBuffer
+	modules []*hawkbit.OperationStatus
+}
+
+// reportManifest is the result manifest of an install operation.
+type reportManifest struct {
+	CorrelationID   string                     `json:"correlationId"`
+	SoftwareModules []*hawkbit.OperationStatus `json:"softwareModules"`
+	LogTruncated    bool                       `json:"logTruncated,omitempty"`
+}
+
+// newInstallReport returns a new install operation report, or nil if no report URL is configured.
+func (f *ScriptBasedSoftwareUpdatable) newInstallReport() *installReport {
+	if f.reportURL == "" {
+		return nil
+	}
+	return &installReport{log: &tailBuffer{limit: f.reportLogSize}}
+}
+
+// output returns the writer of the module install command output, or nil if no report is collected.
+func (r *installReport) output(module *storage.Module) io.Writer {
+	if r == nil {
+		return nil
+	}
+	fmt.Fprintf(r.log, "=== %s:%s ===\n", module.Name, module.Version)
+	return r.log
+}
+
+// add adds the final status of an installed module to the report.
+func (r *installReport) add(status *hawkbit.OperationStatus) {
+	if r != nil && status != nil {
+		r.modules = append(r.modules, status)
+	}
+}
+
+// uploadReport uploads the install log and, if enabled, the result manifest of the install operation as
+// install.log and result.json under the correlation identifier of the report URL. Failed uploads are only
+// logged, they do not fail the completed operation.
+func (f *ScriptBasedSoftwareUpdatable) uploadReport(cid string, report *installReport) {
+	if report == nil {
+		return
+	}
+	log := operationLog("install", cid, nil)
+	base := strings.TrimSuffix(f.reportURL, "/") + "/" + url.PathEscape(cid) + "/"
+	data, truncated := report.log.bytes()
+	if err := storage.Upload(base+reportLogName, f.reportMethod, "text/plain; charset=utf-8", data,
+		f.server, f.downloadRetryCount, f.downloadRetryInterval, done); err != nil {
+		log.Errorf("failed to upload install log: %v", err)
+	}
+	if !f.reportManifest {
+		return
+	}
+	manifest, err := json.Marshal(&reportManifest{CorrelationID: cid, SoftwareModules: report.modules, LogTruncated: truncated})
+	if err != nil {
+		log.Errorf("failed to create result manifest: %v", err)
+		return
+	}
+	if err := storage.Upload(base+reportManifestName, f.reportMethod, "application/json", manifest,
+		f.server, f.downloadRetryCount, f.downloadRetryInterval, done); err != nil {
+		log.Errorf("failed to upload result manifest: %v", err)
+	}
+}
+
+// tailBuffer keeps the last limit bytes, written to it.
+type tailBuffer struct {
+	lock      sync.Mutex
+	data      []byte
+	limit     int
+	truncated bool
+}
+
+// Write appends the data to the buffer, dropping its beginning, if it exceeds the limit.
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.limit; over > 0 {
+		b.data = append(b.data[:0], b.data[over:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// bytes returns the buffered data, starting with a truncated mark, if its beginning is dropped.
+func (b *tailBuffer) bytes() ([]byte, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.truncated {
+		return append([]byte(truncatedMark), b.data...), true
+	}
+	return append([]byte(nil), b.data...), false
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestInstallReport tests the upload of the bounded install log and the result manifest after an install operation.
+func TestInstallReport(t *testing.T) {
+	testInstallReport(t, false)
+}
+
+// TestInstallReportUploadFailure tests that failed report uploads do not fail the install operation.
+func TestInstallReportUploadFailure(t *testing.T) {
+	testInstallReport(t, true)
+}
+
+func testInstallReport(t *testing.T, fail bool) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	uploads := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		uploads <- r
+		bodies <- string(data)
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-report", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.reportURL = srv.URL + "/reports/"
+	feature.reportMethod = http.MethodPut
+	feature.reportManifest = true
+	feature.reportLogSize = 60
+	feature.downloadRetryCount = 1
+	feature.downloadRetryInterval = 0
+
+	install := "echo first output line, dropped from the bounded log\necho install error output >&2\necho last install output"
+	installPath, _ := createLocalArtifact(t, tmpDir, "install.sh", install)
+	feature.installCommand = &command{cmd: "/bin/sh", args: []string{getAbsolutePath(t, installPath)}}
+
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{}, "*")
+	feature.installHandler(sua, feature.su)
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("expected install operation to succeed: %v", lo)
+	}
+
+	// Check install log
+	r, body := nextUpload(t, uploads, bodies)
+	if r.Method != http.MethodPut || r.URL.Path != "/reports/"+sua.CorrelationID+"/"+reportLogName {
+		t.Fatalf("unexpected install log upload: %s %s", r.Method, r.URL.Path)
+	}
+	if !strings.HasPrefix(body, truncatedMark) || !strings.Contains(body, "install error output") ||
+		!strings.HasSuffix(body, "last install output\n") || strings.Contains(body, "first output") {
+		t.Fatalf("unexpected install log: %s", body)
+	}
+	if fail { // The failed upload is retried
+		nextUpload(t, uploads, bodies)
+	}
+
+	// Check result manifest
+	r, body = nextUpload(t, uploads, bodies)
+	if r.URL.Path != "/reports/"+sua.CorrelationID+"/"+reportManifestName || r.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected result manifest upload: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+	}
+	manifest := &reportManifest{}
+	if err := json.Unmarshal([]byte(body), manifest); err != nil {
+		t.Fatalf("invalid result manifest %s: %v", body, err)
+	}
+	if manifest.CorrelationID != sua.CorrelationID || !manifest.LogTruncated || len(manifest.SoftwareModules) != 1 ||
+		manifest.SoftwareModules[0].Status != hawkbit.StatusFinishedSuccess {
+		t.Fatalf("unexpected result manifest: %s", body)
+	}
+}
+
+func nextUpload(t *testing.T, uploads chan *http.Request, bodies chan string) (*http.Request, string) {
+	select {
+	case r := <-uploads:
+		return r, <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("report is not uploaded")
+		return nil, ""
+	}
+}
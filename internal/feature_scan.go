@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+var errScanTimeout = errors.New("artifact scan timed out")
+
+// scanArtifacts passes the downloaded and verified module artifacts through the configured scan command,
+// e.g. an antivirus or SBOM scanner, one by one. The artifact path is given as last command argument and
+// the artifact is rejected, if the command exits with non-zero code or does not finish within the scan timeout.
+func (f *ScriptBasedSoftwareUpdatable) scanArtifacts(dir string, module *storage.Module, cancel chan struct{}) error {
+	if f.scanCommand == nil || f.scanCommand.cmd == "" {
+		return nil
+	}
+	for _, sa := range module.Artifacts {
+		path := filepath.Join(dir, sa.FileName)
+		if sa.Local && !sa.Copy {
+			path = sa.Link
+		}
+		if err := f.scanArtifact(dir, path, cancel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanArtifact runs the scan command for the artifact with the given path. Closing the cancel channel or
+// closing the storage on shutdown terminates the command with storage.ErrCanceled.
+func (f *ScriptBasedSoftwareUpdatable) scanArtifact(dir string, path string, cancel chan struct{}) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	scan := &command{cmd: f.scanCommand.cmd, args: append(append([]string{}, f.scanCommand.args...), path),
+		allowed: f.scanCommand.allowed}
+
+	stop, release := f.stopOnShutdown(cancel)
+	defer release()
+	expired := make(chan struct{})
+	if f.scanTimeout > 0 {
+		timer := time.AfterFunc(f.scanTimeout, func() { close(expired) })
+		defer timer.Stop()
+	}
+	terminate := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+		case <-expired:
+		case <-finished:
+			return
+		}
+		close(terminate)
+	}()
+
+	if err = scan.run(dir, "scan", terminate, f.gracePeriod); err == storage.ErrCanceled && isCanceled(expired) {
+		return fmt.Errorf("%w after %v: %s", errScanTimeout, f.scanTimeout, path)
+	}
+	if err != nil && err != storage.ErrCanceled {
+		// Do not report the scan command exit code as install script failure.
+		return fmt.Errorf("scan of artifact %s failed: %v", path, err)
+	}
+	return err
+}
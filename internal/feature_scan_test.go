@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestScanArtifactsPass tests the installation of artifacts, accepted by the scan command.
+func TestScanArtifactsPass(t *testing.T) {
+	testScanArtifacts(t, "exit 0", time.Minute, hawkbit.StatusFinishedSuccess, "")
+}
+
+// TestScanArtifactsFail tests that the installation of artifacts, rejected by the scan command, is blocked.
+func TestScanArtifactsFail(t *testing.T) {
+	testScanArtifacts(t, "exit 1", time.Minute, hawkbit.StatusFinishedError, codeArtifactScan)
+}
+
+// TestScanArtifactsTimeout tests that the installation is blocked, if the scan command does not finish in time.
+func TestScanArtifactsTimeout(t *testing.T) {
+	testScanArtifacts(t, "sleep 10", 500*time.Millisecond, hawkbit.StatusFinishedError, codeArtifactScan)
+}
+
+func testScanArtifacts(t *testing.T, scan string, timeout time.Duration, expected hawkbit.Status, code string) {
+	feature, mc, fx := mockOperationFeature(t)
+
+	scanned := filepath.Join(fx.Dir, "scanned")
+	scanPath, _ := fx.AddFile(t, "scan.sh", "echo $1 >> "+scanned+"\n"+scan)
+	feature.scanCommand = &command{cmd: "/bin/sh", args: []string{scanPath}}
+	feature.scanTimeout = timeout
+
+	sua := installAction(fx, "test-scan")
+	feature.installHandler(sua, feature.su)
+	var lo map[string]interface{}
+	for {
+		if lo = mc.pullLastOperationStatus(); lo == nil {
+			t.Fatal("install operation not finished")
+		}
+		if lo[statusParam] == string(hawkbit.StatusFinishedSuccess) || lo[statusParam] == string(hawkbit.StatusFinishedError) {
+			break
+		}
+		if lo[statusParam] == string(hawkbit.StatusInstalling) && expected != hawkbit.StatusFinishedSuccess {
+			t.Fatalf("rejected artifact must not be installed: %v", lo)
+		}
+	}
+	if lo[statusParam] != string(expected) {
+		t.Fatalf("unexpected install operation status: %v != %v", lo[statusParam], expected)
+	}
+	if code != "" && lo["statusCode"] != code {
+		t.Fatalf("unexpected install operation status code: %v != %v", lo["statusCode"], code)
+	}
+
+	// The scan command is called with the downloaded artifact path.
+	checkFileExistsWithContent(t, scanned, getAbsolutePath(t, filepath.Join(feature.store.DownloadPath, "0-"+sua.CorrelationID, "0", "install.sh")))
+	if installed := fx.Installs() == 1; installed != (expected == hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("unexpected installation state of the scanned artifact: %v", installed)
+	}
+}
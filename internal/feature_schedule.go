@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// metadataNotBefore is the operation metadata key of its scheduled start time, given as RFC 3339 timestamp.
+const metadataNotBefore = "notBefore"
+
+// waitForSchedule waits until the scheduled start time of the operation, if it is in the future, reporting
+// the operation modules as waiting meanwhile. Canceled operations stop waiting and their modules are reported
+// as canceled on processing. Returns true, if the application is closing.
+func (f *ScriptBasedSoftwareUpdatable) waitForSchedule(operation string, updatable *storage.Updatable,
+	su *hawkbit.SoftwareUpdatable, cancel chan struct{}) bool {
+	value := updatable.Metadata[metadataNotBefore]
+	if value == "" {
+		return false
+	}
+	log := operationLog(operation, updatable.CorrelationID, nil)
+	start, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Warnf("invalid scheduled start time %s, start the operation immediately: %v", value, err)
+		return false
+	}
+	delay := time.Until(start)
+	if delay <= 0 {
+		return false
+	}
+
+	log.Infof("Operation scheduled to start at %s", value)
+	for _, module := range updatable.Modules {
+		setLastOS(su, newOS(updatable.CorrelationID, module, hawkbit.StatusDownloadingWaiting).
+			WithMessage(fmt.Sprintf("scheduled to start at %s", value)))
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-cancel:
+	case <-timer.C:
+	}
+	return false
+}
+
+// waitForJitter waits a random delay up to the configured download start jitter, so that many devices, receiving
+// the same operation, do not start downloading at the same time. Canceled operations stop waiting and their
+// modules are reported as canceled on processing. Returns true, if the application is closing.
+func (f *ScriptBasedSoftwareUpdatable) waitForJitter(operation string, cid string, cancel chan struct{}) bool {
+	if f.downloadStartJitter <= 0 {
+		return false
+	}
+	delay := time.Duration(rand.Int63n(int64(f.downloadStartJitter) + 1))
+	operationLog(operation, cid, nil).Debugf("Delay the operation start with %v", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-cancel:
+	case <-timer.C:
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestScheduledDownload tests that a download, scheduled in the future, waits for its start time.
+func TestScheduledDownload(t *testing.T) {
+	feature, mc, sua := prepareScheduledDownload(t)
+	defer feature.Disconnect(true)
+
+	start := time.Now().Add(2 * time.Second)
+	sua.Metadata = map[string]string{metadataNotBefore: start.Format(time.RFC3339Nano)}
+	feature.downloadHandler(sua, feature.su)
+
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusDownloadingWaiting) {
+		t.Fatalf("expected scheduled download to wait: %v", lo)
+	}
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusStarted) {
+		t.Fatalf("expected scheduled download to start: %v", lo)
+	}
+	if now := time.Now(); now.Before(start) {
+		t.Fatalf("download started %v before its scheduled time", start.Sub(now))
+	}
+	checkScheduledDownloadFinished(t, mc, hawkbit.StatusFinishedSuccess)
+}
+
+// TestScheduledDownloadPast tests that a download, scheduled in the past, starts immediately.
+func TestScheduledDownloadPast(t *testing.T) {
+	feature, mc, sua := prepareScheduledDownload(t)
+	defer feature.Disconnect(true)
+
+	sua.Metadata = map[string]string{metadataNotBefore: time.Now().Add(-time.Hour).Format(time.RFC3339)}
+	feature.downloadHandler(sua, feature.su)
+
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusStarted) {
+		t.Fatalf("expected download to start immediately: %v", lo)
+	}
+	checkScheduledDownloadFinished(t, mc, hawkbit.StatusFinishedSuccess)
+}
+
+// TestScheduledDownloadCancel tests canceling a download, while waiting for its scheduled start time.
+func TestScheduledDownloadCancel(t *testing.T) {
+	feature, mc, sua := prepareScheduledDownload(t)
+	defer feature.Disconnect(true)
+
+	sua.Metadata = map[string]string{metadataNotBefore: time.Now().Add(time.Hour).Format(time.RFC3339)}
+	feature.downloadHandler(sua, feature.su)
+
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusDownloadingWaiting) {
+		t.Fatalf("expected scheduled download to wait: %v", lo)
+	}
+	feature.cancelHandler(sua, feature.su)
+	checkScheduledDownloadFinished(t, mc, hawkbit.StatusFinishedCanceled)
+}
+
+// TestDownloadStartJitter tests that the download start is randomly delayed up to the configured jitter.
+func TestDownloadStartJitter(t *testing.T) {
+	feature, mc, sua := prepareScheduledDownload(t)
+	defer feature.Disconnect(true)
+
+	feature.downloadStartJitter = 100 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		if feature.waitForJitter("download", sua.CorrelationID, make(chan struct{})) {
+			t.Fatal("unexpected application close, while waiting for the start jitter")
+		}
+		if elapsed := time.Since(start); elapsed > feature.downloadStartJitter+50*time.Millisecond {
+			t.Fatalf("start delayed with %v, more than the configured jitter %v", elapsed, feature.downloadStartJitter)
+		}
+	}
+
+	feature.downloadStartJitter = time.Second
+	start := time.Now()
+	feature.downloadHandler(sua, feature.su)
+	if lo := mc.pullLastOperationStatus(); lo == nil || lo[statusParam] != string(hawkbit.StatusStarted) {
+		t.Fatalf("expected download to start: %v", lo)
+	}
+	if elapsed := time.Since(start); elapsed > feature.downloadStartJitter+500*time.Millisecond {
+		t.Fatalf("download started after %v, more than the configured jitter %v", elapsed, feature.downloadStartJitter)
+	}
+	checkScheduledDownloadFinished(t, mc, hawkbit.StatusFinishedSuccess)
+}
+
+// TestDownloadStartJitterCancel tests canceling a download, while waiting for its start jitter.
+func TestDownloadStartJitterCancel(t *testing.T) {
+	feature, mc, sua := prepareScheduledDownload(t)
+	defer feature.Disconnect(true)
+
+	feature.downloadStartJitter = time.Hour
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+	start := time.Now()
+	if feature.waitForJitter("download", sua.CorrelationID, cancel) {
+		t.Fatal("unexpected application close, while waiting for the start jitter")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("start jitter not stopped on cancel: %v", elapsed)
+	}
+
+	feature.downloadHandler(sua, feature.su)
+	time.Sleep(100 * time.Millisecond)
+	feature.cancelHandler(sua, feature.su)
+	checkScheduledDownloadFinished(t, mc, hawkbit.StatusFinishedCanceled)
+}
+
+func prepareScheduledDownload(t *testing.T) (*ScriptBasedSoftwareUpdatable, *mockedClient, *hawkbit.SoftwareUpdateAction) {
+	t.Helper()
+
+	dir := assertDirs(t, testDirFeature, false)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	tmpDir := assertDirs(t, "_tmp-schedule", true)
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	body := "scheduled"
+	path, hash := createLocalArtifact(t, tmpDir, "scheduled.txt", body)
+	return feature, mc, prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, path), "scheduled.txt", hash, len(body)),
+	}, "*")
+}
+
+func checkScheduledDownloadFinished(t *testing.T, mc *mockedClient, status hawkbit.Status) {
+	t.Helper()
+
+	for {
+		lo := mc.pullLastOperationStatus()
+		if lo == nil {
+			t.Fatalf("download not finished with status %s", status)
+		}
+		if lo[statusParam] == string(status) {
+			return
+		}
+		if lo[statusParam] == string(hawkbit.StatusFinishedError) || lo[statusParam] == string(hawkbit.StatusFinishedCanceled) {
+			t.Fatalf("download finished with unexpected status: %v", lo)
+		}
+	}
+}
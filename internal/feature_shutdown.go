@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const errShuttingDown = "operation rejected, the agent is shutting down"
+
+// drain waits the shutdown grace period for the running operation to reach a safe checkpoint: the module
+// download or installation to finish. When the grace period expires, the storage is closed, so that the
+// running download is stopped and resumed on the next start, and the running install script is canceled.
+func (f *ScriptBasedSoftwareUpdatable) drain() {
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(f.shutdownGracePeriod):
+		logger.Warnf("Shutdown grace period of %v expired, cancel the running operation", f.shutdownGracePeriod)
+	}
+}
+
+// isShuttingDown reports if the agent is shutting down and new operations must not be accepted.
+func isShuttingDown() bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// rejectOnShutdown rejects all modules of the operation, received while shutting down.
+func (f *ScriptBasedSoftwareUpdatable) rejectOnShutdown(cid string, modules []*hawkbit.SoftwareModuleAction) {
+	logger.Warnf("Reject operation with id %s: %s", cid, errShuttingDown)
+	for _, module := range modules {
+		setLastOS(f.su, hawkbit.NewOperationStatusUpdate(cid, hawkbit.StatusFinishedRejected, module.SoftwareModule).
+			WithStatusCode(codeRuntime).WithMessage(errShuttingDown))
+	}
+}
+
+// stopOnShutdown returns a channel, which is closed when the operation is canceled or the storage is closed
+// on shutdown. The returned release function must be called, when the channel is no longer used.
+func (f *ScriptBasedSoftwareUpdatable) stopOnShutdown(cancel chan struct{}) (chan struct{}, func()) {
+	stop := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+		case <-f.store.Done():
+		case <-released:
+			return
+		}
+		close(stop)
+	}()
+	return stop, func() { close(released) }
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// TestShutdownDuringDownload tests that the partially downloaded artifact and the operation state
+// are preserved, when the download does not finish within the shutdown grace period.
+func TestShutdownDuringDownload(t *testing.T) {
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+
+	// Serve the artifact slowly, so that the download is running on shutdown.
+	size := 100 * 1024
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		chunk := make([]byte, 1024)
+		for i := 0; i < size/len(chunk); i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	feature.shutdownGracePeriod = 500 * time.Millisecond
+
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{{
+		Filename:  "artifact.bin",
+		Download:  map[hawkbit.Protocol]*hawkbit.Links{hawkbit.HTTP: {URL: srv.URL + "/artifact.bin"}},
+		Checksums: map[hawkbit.Hash]string{hawkbit.SHA256: "unknown"},
+		Size:      size,
+	}}, "")
+
+	// Collect the reported statuses, so that the download is not blocked on reporting.
+	statuses := make(chan interface{}, 1000)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case payload := <-mc.payload:
+				if lo, ok := payload.(map[string]interface{}); ok {
+					statuses <- lo[statusParam]
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// 1. Start the download and wait for its partial artifact.
+	feature.downloadHandler(sua, feature.su)
+	partial := filepath.Join(dir, "download", "*", "0", "_temporary-artifact.bin")
+	waitForFile(t, partial)
+
+	// 2. Shutdown within the grace period, while the download is still running.
+	start := time.Now()
+	feature.Disconnect(true)
+	if elapsed := time.Since(start); elapsed < feature.shutdownGracePeriod || elapsed > 5*time.Second {
+		t.Fatalf("unexpected shutdown duration: %v", elapsed)
+	}
+
+	// 3. Check the partial artifact and the operation state are preserved to be resumed.
+	matches, _ := filepath.Glob(partial)
+	if len(matches) != 1 {
+		t.Fatalf("partial artifact not preserved: %v", matches)
+	}
+	if info, err := os.Stat(matches[0]); err != nil || info.Size() == 0 || info.Size() >= int64(size) {
+		t.Fatalf("unexpected partial artifact: %v, %v", info, err)
+	}
+	opDir := filepath.Dir(filepath.Dir(matches[0]))
+	if _, err := os.Stat(filepath.Join(opDir, storage.SoftwareUpdatableName)); err != nil {
+		t.Fatalf("operation not persisted: %v", err)
+	}
+	checkFileExistsWithContent(t, filepath.Join(opDir, "0", storage.InternalStatusName), string(hawkbit.StatusDownloading))
+
+	// 4. Check no final status is reported for the interrupted download.
+	for len(statuses) > 0 {
+		if status := <-statuses; status != string(hawkbit.StatusStarted) && status != string(hawkbit.StatusDownloading) {
+			t.Fatalf("unexpected status of interrupted download: %v", status)
+		}
+	}
+}
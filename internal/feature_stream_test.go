@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestStreamInstall tests streaming of a module artifact to the standard input of the install command.
+func TestStreamInstall(t *testing.T) {
+	testStreamInstall(t, false)
+}
+
+// TestStreamInstallChecksumMismatch tests that a streamed artifact with wrong checksum is not installed.
+func TestStreamInstallChecksumMismatch(t *testing.T) {
+	testStreamInstall(t, true)
+}
+
+func testStreamInstall(t *testing.T, mismatch bool) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	// Prepare
+	dir := t.TempDir()
+	tmpDir := t.TempDir()
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	body := "streamed image content"
+	installed := getAbsolutePath(t, filepath.Join(tmpDir, "installed"))
+	install := "[ \"$(cat)\" = \"" + body + "\" ] || exit 1\necho installed > " + installed
+	installPath, _ := createLocalArtifact(t, tmpDir, "install.sh", install)
+	feature.installCommand = &command{cmd: "/bin/sh", args: []string{getAbsolutePath(t, installPath)}}
+
+	imagePath, imageHash := createLocalArtifact(t, tmpDir, "image.bin", body)
+	if mismatch {
+		imageHash = "ab2ce340d36bbaafe17965a3a2c6ed5bab2ce340d36bbaafe17965a3a2c6ed5b"
+	}
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, imagePath), "image.bin", imageHash, len(body)),
+	}, "*")
+	sua.SoftwareModules[0].Metadata[metadataInstallMode] = installModeStream
+
+	feature.installHandler(sua, feature.su)
+	lo := pullFinalOperationStatus(t, mc)
+	if mismatch {
+		if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeDownloadChecksumMismatch {
+			t.Fatalf("expected streamed install to fail on checksum mismatch: %v", lo)
+		}
+		if _, err := os.Stat(installed); !os.IsNotExist(err) {
+			t.Fatalf("artifact with checksum mismatch is installed: %v", err)
+		}
+	} else {
+		if lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+			t.Fatalf("expected streamed install to succeed: %v", lo)
+		}
+		checkFileExistsWithContent(t, installed, "installed")
+	}
+
+	// The streamed artifact is not stored.
+	if matches, _ := filepath.Glob(filepath.Join(dir, "download", "*", "*", "image.bin")); len(matches) > 0 {
+		t.Fatalf("streamed artifact is stored: %v", matches)
+	}
+}
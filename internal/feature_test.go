@@ -23,6 +23,7 @@ import (
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 	"github.com/eclipse-kanto/software-update/internal/storage"
+	"github.com/eclipse-kanto/software-update/util/testutil"
 )
 
 const (
@@ -34,6 +35,7 @@ const (
 
 	noMessage       = "no message"
 	anyErrorMessage = "*"
+	anyMessage      = "?"
 
 	statusParam   = "status"
 	progressParam = "progress"
@@ -121,6 +123,57 @@ func TestScriptBasedInit(t *testing.T) {
 
 }
 
+// TestScriptBasedReconnect tests that the feature and its last operations are announced again on reconnect.
+func TestScriptBasedReconnect(t *testing.T) {
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	// Remove temporary directory at the end.
+	defer os.RemoveAll(dir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	if status := mc.pullFeatureStatus(); status == nil {
+		t.Fatal("feature not announced on connect")
+	}
+
+	module := &storage.Module{Name: "test", Version: "1.0.0"}
+	setLastOS(feature.su, newOS("failed-id", module, hawkbit.StatusFinishedError))
+	mc.pullLastOperationStatus()
+	setLastOS(feature.su, newOS("success-id", module, hawkbit.StatusFinishedSuccess))
+	mc.pullLastOperationStatus()
+
+	// 1. Reconnect after the MQTT connection is restored.
+	if err := feature.Reconnect(); err != nil {
+		t.Fatalf("failed to reconnect: %v", err)
+	}
+	assertLastOperations(t, mc.pullFeatureStatus(), "success-id", "failed-id")
+
+	// 2. Reconnect after the edge configuration is changed.
+	feature.Disconnect(false)
+	if err := connectFeature(t, mc, feature, NewDefaultConfig().FeatureID); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	assertLastOperations(t, mc.pullFeatureStatus(), "success-id", "failed-id")
+}
+
+func assertLastOperations(t *testing.T, status map[string]interface{}, lastID string, lastFailedID string) {
+	t.Helper()
+
+	if status == nil {
+		t.Fatal("feature not announced on reconnect")
+	}
+	for name, cid := range map[string]string{"lastOperation": lastID, "lastFailedOperation": lastFailedID} {
+		operation, _ := status[name].(map[string]interface{})
+		if operation == nil || operation["correlationId"] != cid {
+			t.Fatalf("unexpected %s announced on reconnect: %v", name, status[name])
+		}
+	}
+}
+
 // TestScriptBasedDownloadAndInstall tests ScriptBasedSoftwareUpdatable core functionality: init, install and download operations.
 func TestScriptBasedDownloadAndInstall(t *testing.T) {
 	testScriptBasedSoftwareUpdatableOperations(true, t)
@@ -138,12 +191,12 @@ func testScriptBasedSoftwareUpdatableOperations(noResume bool, t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Prepare/Close simple HTTP server used to host testing artifacts
-	w := storage.NewTestHTTPServer(testDefaultHost, "", 0, t)
+	w := testutil.NewHTTPServer(testDefaultHost, "", 0, t)
 	w.Host(true, false, "", "")
 	w.AddInstallScript()
 	defer w.Close()
 
-	wSecure := storage.NewTestHTTPServer(testDefaultHostSecure, "", 0, t)
+	wSecure := testutil.NewHTTPServer(testDefaultHostSecure, "", 0, t)
 	wSecure.Host(true, true, testCert, testKey)
 	wSecure.AddInstallScript()
 	defer wSecure.Close()
@@ -157,11 +210,11 @@ func testScriptBasedSoftwareUpdatableOperations(noResume bool, t *testing.T) {
 	defer feature.Disconnect(true)
 
 	if noResume {
-		testDownloadInstall(feature, mc, w.GenerateSoftwareArtifacts(false, "install"), true, "*", t)
-		feature.serverCert = testCert
-		testDownloadInstall(feature, mc, wSecure.GenerateSoftwareArtifacts(true, "install"), true, "*", t)
+		testDownloadInstall(feature, mc, w.GenerateSoftwareArtifacts("install"), true, "*", t)
+		feature.server.Cert = testCert
+		testDownloadInstall(feature, mc, wSecure.GenerateSoftwareArtifacts("install"), true, "*", t)
 	} else {
-		testDisconnect(feature, mc, w.GenerateSoftwareArtifacts(false, "install"), t)
+		testDisconnect(feature, mc, w.GenerateSoftwareArtifacts("install"), t)
 	}
 }
 
@@ -225,12 +278,12 @@ func TestScriptBasedDownloadAndInstallMixedResources(t *testing.T) {
 	bPath, bHash := createLocalArtifact(t, storageDir, b, bBody)
 
 	// Prepare/Close simple HTTP server used to host testing artifacts
-	w := storage.NewTestHTTPServer(testDefaultHost, "", 0, t)
+	w := testutil.NewHTTPServer(testDefaultHost, "", 0, t)
 	w.Host(true, false, "", "")
 	w.AddInstallScript()
 	defer w.Close()
 
-	installScript := w.GenerateSoftwareArtifacts(false, "install")
+	installScript := w.GenerateSoftwareArtifacts("install")
 	artifacts := []*hawkbit.SoftwareArtifactAction{
 		convertLocalArtifact(aPath, a, aHash, len(aBody)),
 		convertLocalArtifact(bPath, b, bHash, len(bBody)),
@@ -247,7 +300,7 @@ func TestScriptBasedDownloadAndInstallLocalResources(t *testing.T) {
 	assertDirs(t, tmpDir, true)
 	defer os.RemoveAll(tmpDir)
 
-	installScriptAlias, installScriptBody := storage.GetTestInstallScript()
+	installScriptAlias, installScriptBody := testutil.InstallScript()
 	installScriptPath, installScriptHash := createLocalArtifact(t, tmpDir, installScriptAlias, installScriptBody)
 	localResourceAlias, localResourceBody := "local.txt", "test"
 	localResourcePath, localResourceHash := createLocalArtifact(t, tmpDir, localResourceAlias, localResourceBody)
@@ -338,7 +391,7 @@ func testDownloadInstall(feature *ScriptBasedSoftwareUpdatable, mc *mockedClient
 	if expectedSuccess {
 		checkDownloadStatusEvents(extraDownloadingEventsCount, statuses, t)
 		if copyArtifacts == "" {
-			if !checkNoFilesCopied(t, filepath.Join(testDirFeature, "download", "0", "0"), true) {
+			if !checkNoFilesCopied(t, filepath.Join(testDirFeature, "download", "0-"+sua.CorrelationID, "0"), true) {
 				checkNoFilesCopied(t, filepath.Join(testDirFeature, "modules", "0"), false)
 			}
 		}
@@ -376,6 +429,9 @@ func checkDownloadFailedStatusEvents(actualStatuses []interface{}, t *testing.T)
 		createStatus(hawkbit.StatusFinishedError, nil, anyErrorMessage),
 	)
 	checkStatusEvents(expectedStatuses, actualStatuses, t)
+	if code := actualStatuses[len(actualStatuses)-1].(map[string]interface{})["statusCode"]; code == nil || code == "" {
+		t.Fatalf("no status code found in failed operation status: %v", actualStatuses[len(actualStatuses)-1])
+	}
 }
 
 func checkDownloadStatusEvents(extraDownloadingEventsCount int, actualStatuses []interface{}, t *testing.T) {
@@ -385,10 +441,10 @@ func checkDownloadStatusEvents(extraDownloadingEventsCount int, actualStatuses [
 		createStatus(hawkbit.StatusDownloading, nil, noMessage),
 	)
 	for i := 0; i < extraDownloadingEventsCount; i++ {
-		expectedStatuses = append(expectedStatuses, createStatus(hawkbit.StatusDownloading, partialDownload, noMessage))
+		expectedStatuses = append(expectedStatuses, createStatus(hawkbit.StatusDownloading, partialDownload, anyMessage))
 	}
 	expectedStatuses = append(expectedStatuses,
-		createStatus(hawkbit.StatusDownloading, completeDownload, noMessage),
+		createStatus(hawkbit.StatusDownloading, completeDownload, anyMessage),
 		createStatus(hawkbit.StatusDownloaded, completeDownload, noMessage),
 		createStatus(hawkbit.StatusFinishedSuccess, nil, noMessage),
 	)
@@ -402,10 +458,10 @@ func checkInstallStatusEvents(extraDownloadingEventsCount int, actualStatuses []
 		createStatus(hawkbit.StatusDownloading, nil, noMessage),
 	)
 	for i := 0; i < extraDownloadingEventsCount; i++ {
-		expectedStatuses = append(expectedStatuses, createStatus(hawkbit.StatusDownloading, partialDownload, noMessage))
+		expectedStatuses = append(expectedStatuses, createStatus(hawkbit.StatusDownloading, partialDownload, anyMessage))
 	}
 	expectedStatuses = append(expectedStatuses,
-		createStatus(hawkbit.StatusDownloading, completeDownload, noMessage),
+		createStatus(hawkbit.StatusDownloading, completeDownload, anyMessage),
 		createStatus(hawkbit.StatusDownloaded, completeDownload, noMessage),
 		createStatus(hawkbit.StatusInstalling, nil, noMessage),
 		createStatus(hawkbit.StatusInstalling, nil, "My final message!"),
@@ -443,10 +499,10 @@ func checkStatusParameter(expectedParamValue interface{}, actualStatus map[strin
 			if !checkProgressFunc(receivedValue.(float64)) {
 				t.Fatalf("received unacceptable lastOperation %s: %v", name, receivedValue)
 			}
-		} else if expectedParamValue != anyErrorMessage && expectedParamValue != receivedValue {
+		} else if expectedParamValue != anyErrorMessage && expectedParamValue != anyMessage && expectedParamValue != receivedValue {
 			t.Fatalf("received unexpected lastOperation %s: %v != %v", name, receivedValue, expectedParamValue)
 		}
-	} else if !noValue {
+	} else if !noValue && expectedParamValue != anyMessage {
 		t.Fatalf("no %s found in payload: %v", name, actualStatus)
 	}
 }
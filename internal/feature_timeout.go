@@ -0,0 +1,240 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// metadataTimeout is the operation metadata key of the overall operation timeout, supplied by the backend as
+// a duration, e.g. 45m, or as a number of seconds. It overrides the configured operation timeout.
+const metadataTimeout = "timeout"
+
+// metadataDeadline is the operation metadata key of the operation deadline, supplied by the backend as an RFC 3339
+// time. The operation is canceled on its deadline, or on its timeout, if it is reached earlier.
+const metadataDeadline = "deadline"
+
+// errInvalidOperationTimeout is returned, when the operation timeout or deadline, supplied by the backend, is not
+// valid or not within the allowed range.
+var errInvalidOperationTimeout = errors.New("invalid operation timeout")
+
+// operationTimer is the timeout of a running operation.
+type operationTimer struct {
+	cancel  chan struct{}
+	timeout time.Duration
+}
+
+// operationTimeoutError is the error of a module, which operation is canceled on the overall operation timeout.
+// It keeps the last status reported for the module, showing how far the operation got.
+type operationTimeoutError struct {
+	timeout time.Duration
+	last    *hawkbit.OperationStatus
+}
+
+func (e *operationTimeoutError) Error() string {
+	timeout := e.timeout
+	if timeout >= time.Second { // The timeouts, resolved from the deadlines, are reported in whole seconds.
+		timeout = timeout.Round(time.Second)
+	}
+	msg := fmt.Sprintf("%s after %v", errOperationTimeout, timeout)
+	if e.last == nil {
+		return msg + " - module not started"
+	}
+	msg = fmt.Sprintf("%s - last status: %s", msg, e.last.Status)
+	if e.last.Progress > 0 {
+		msg = fmt.Sprintf("%s, progress: %d%%", msg, e.last.Progress)
+	}
+	if e.last.Message != "" {
+		msg = fmt.Sprintf("%s, %s", msg, e.last.Message)
+	}
+	return msg
+}
+
+// operationTimeoutOf returns the overall timeout of the operation with the given metadata, either supplied by
+// the backend or the configured one, if not supplied. The supplied timeout must be positive and must not exceed
+// the configured maximum, if any, otherwise errInvalidOperationTimeout is returned.
+func (f *ScriptBasedSoftwareUpdatable) operationTimeoutOf(metadata map[string]string) (time.Duration, error) {
+	timeout := time.Duration(-1)
+	if value, ok := metadata[metadataTimeout]; ok {
+		var err error
+		if timeout, err = parseOperationTimeout(value); err != nil {
+			return 0, err
+		}
+	}
+	if value, ok := metadata[metadataDeadline]; ok {
+		deadline, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s %q is not an RFC 3339 time", errInvalidOperationTimeout, metadataDeadline, value)
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, fmt.Errorf("%w: %s %s has already passed", errInvalidOperationTimeout, metadataDeadline, value)
+		}
+		if timeout < 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if timeout < 0 {
+		return f.operationTimeout, nil
+	}
+	if f.maxOperationTimeout > 0 && timeout > f.maxOperationTimeout {
+		return 0, fmt.Errorf("%w: %v exceeds the maximal operation timeout %v", errInvalidOperationTimeout,
+			timeout, f.maxOperationTimeout)
+	}
+	return timeout, nil
+}
+
+// parseOperationTimeout parses the operation timeout, supplied by the backend, either as a duration or as a number
+// of seconds. The timeout must be positive.
+func parseOperationTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseInt(value, 10, 64)
+		if serr != nil {
+			return 0, fmt.Errorf("%w: %s %q is neither a duration nor a number of seconds", errInvalidOperationTimeout,
+				metadataTimeout, value)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%w: %s %v is not positive", errInvalidOperationTimeout, metadataTimeout, timeout)
+	}
+	return timeout, nil
+}
+
+// resolveOperationDeadline returns the operation metadata with the timeout, supplied by the backend, resolved to
+// the deadline from the given receipt time, or to the supplied deadline, if it is earlier. The timeout is relative
+// to the operation receipt, so that it is not restarted, when the saved operation is resumed. The metadata is
+// returned unchanged, if it has no valid timeout.
+func resolveOperationDeadline(metadata map[string]string, received time.Time) map[string]string {
+	value, ok := metadata[metadataTimeout]
+	if !ok {
+		return metadata
+	}
+	timeout, err := parseOperationTimeout(value)
+	if err != nil {
+		return metadata
+	}
+	deadline := received.Add(timeout)
+	if supplied, err := time.Parse(time.RFC3339, metadata[metadataDeadline]); err == nil && supplied.Before(deadline) {
+		deadline = supplied
+	}
+	resolved := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		resolved[key] = value
+	}
+	delete(resolved, metadataTimeout)
+	resolved[metadataDeadline] = deadline.Format(time.RFC3339Nano)
+	return resolved
+}
+
+// startOperationTimeout cancels the operation with the given correlation id and metadata, if it does not finish
+// within its overall operation timeout. The timeout is started, once the operation is started, i.e. after its
+// scheduled start, and its deadline is kept in the operation directory, so that the operation, resumed after
+// a restart, is canceled on the same deadline. The returned function stops the timeout, when the operation is
+// finished. The operations, which supplied timeout is no longer valid, e.g. with a deadline passed while they were
+// queued or interrupted, are canceled at once.
+func (f *ScriptBasedSoftwareUpdatable) startOperationTimeout(dir string, cid string, cancel chan struct{},
+	metadata map[string]string) func() {
+	deadline, timeout, err := f.operationDeadline(dir, metadata)
+	if err != nil {
+		logger.Warnf("Cancel operation with id %s on timeout: %v", cid, err)
+		deadline, timeout = time.Now(), time.Nanosecond
+	}
+	if timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		f.cancelLock.Lock()
+		defer f.cancelLock.Unlock()
+
+		if f.cancels[cid] != cancel { // Already canceled or finished
+			return
+		}
+		logger.Warnf("Cancel operation with id %s on timeout after %v", cid, timeout)
+		delete(f.cancels, cid)
+		close(cancel)
+		if f.timeouts == nil {
+			f.timeouts = map[string]operationTimer{}
+		}
+		f.timeouts[cid] = operationTimer{cancel: cancel, timeout: timeout}
+	})
+	return func() {
+		timer.Stop()
+
+		f.cancelLock.Lock()
+		defer f.cancelLock.Unlock()
+		if f.timeouts[cid].cancel == cancel {
+			delete(f.timeouts, cid)
+		}
+	}
+}
+
+// operationDeadline returns the deadline and the overall timeout of the operation in the given directory. The
+// deadline of the started operation is loaded from the directory. Otherwise, it is resolved from the operation
+// timeout and saved to the directory. Zero timeout is returned, if the operation is not limited.
+func (f *ScriptBasedSoftwareUpdatable) operationDeadline(dir string,
+	metadata map[string]string) (time.Time, time.Duration, error) {
+	name := filepath.Join(dir, storage.OperationDeadlineName)
+	if saved, err := storage.ReadLn(name); err == nil {
+		if fields := strings.Fields(saved); len(fields) == 2 {
+			deadline, derr := time.Parse(time.RFC3339Nano, fields[0])
+			timeout, terr := time.ParseDuration(fields[1])
+			if derr == nil && terr == nil {
+				return deadline, timeout, nil
+			}
+		}
+		logger.Warnf("Ignore invalid operation deadline %q in %s", saved, name)
+	}
+	timeout, err := f.operationTimeoutOf(metadata)
+	if err != nil || timeout <= 0 {
+		return time.Time{}, 0, err
+	}
+	deadline := time.Now().Add(timeout)
+	if err := storage.WriteLn(name, deadline.Format(time.RFC3339Nano)+" "+timeout.String()); err != nil {
+		logger.Warnf("failed to save operation deadline to %s: %v", name, err)
+	}
+	return deadline, timeout, nil
+}
+
+// isTimedOut returns true, if the operation with the given correlation id is canceled on timeout.
+func (f *ScriptBasedSoftwareUpdatable) isTimedOut(cid string) bool {
+	f.cancelLock.Lock()
+	defer f.cancelLock.Unlock()
+
+	_, ok := f.timeouts[cid]
+	return ok
+}
+
+// timeoutError returns the error of the module, which operation with the given correlation id is canceled on
+// timeout, with the last status reported for the module.
+func (f *ScriptBasedSoftwareUpdatable) timeoutError(cid string,
+	module *storage.Module, su *hawkbit.SoftwareUpdatable) *operationTimeoutError {
+	f.cancelLock.Lock()
+	err := &operationTimeoutError{timeout: f.timeouts[cid].timeout}
+	f.cancelLock.Unlock()
+	if last := su.LastOperation(); last != nil && last.SoftwareModule != nil &&
+		last.SoftwareModule.Name == module.Name && last.SoftwareModule.Version == module.Version {
+		err.last = last
+	}
+	return err
+}
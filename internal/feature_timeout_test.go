@@ -0,0 +1,266 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestOperationTimeoutInstall tests canceling of an install operation, which exceeds the overall operation timeout.
+func TestOperationTimeoutInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-timeout", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.gracePeriod = 500 * time.Millisecond
+	feature.operationTimeout = 2 * time.Second
+
+	rolledBack := getAbsolutePath(t, filepath.Join(tmpDir, "rolledback"))
+	install := "echo started > started\nwhile true; do sleep 0.1; done"
+	rollback := fmt.Sprintf("echo rolled back > %s", rolledBack)
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	rollbackPath, rollbackHash := createLocalArtifact(t, tmpDir, "rollback.sh", rollback)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+		convertLocalArtifact(getAbsolutePath(t, rollbackPath), "rollback.sh", rollbackHash, len(rollback)),
+	}, "*")
+
+	start := time.Now()
+	feature.installHandler(sua, feature.su)
+	lo := pullFinalOperationStatus(t, mc)
+	if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeOperationTimeout {
+		t.Fatalf("expected install to fail on timeout: %v", lo)
+	}
+	if elapsed := time.Since(start); elapsed < feature.operationTimeout {
+		t.Fatalf("install canceled before the operation timeout: %v", elapsed)
+	}
+	expected := fmt.Sprintf("%s after %v - last status: %s", errOperationTimeout, feature.operationTimeout, hawkbit.StatusInstalling)
+	if msg, _ := lo[messageParam].(string); !strings.HasPrefix(msg, expected) {
+		t.Fatalf("unexpected timeout status message: %s", msg)
+	}
+	checkFileExistsWithContent(t, rolledBack, "rolled back")
+	for i := 0; feature.isTimedOut(sua.CorrelationID); i++ {
+		if i == 10 {
+			t.Fatal("operation timeout not released after the operation is finished")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// TestOperationTimeoutScheduled tests that the overall operation timeout starts at the scheduled start of
+// the operation, not on its receipt.
+func TestOperationTimeoutScheduled(t *testing.T) {
+	feature, mc, fx := mockOperationFeature(t)
+	feature.operationTimeout = time.Second
+
+	sua := installAction(fx, "test-timeout-scheduled")
+	sua.Metadata = map[string]string{metadataNotBefore: time.Now().Add(2 * time.Second).Format(time.RFC3339Nano)}
+	feature.installHandler(sua, feature.su)
+	if lo := pullFinalOperationStatus(t, mc); lo[statusParam] != string(hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("expected scheduled install within the operation timeout to succeed: %v", lo)
+	}
+}
+
+// TestOperationDeadline tests that the deadline of the started operation is saved to its directory and
+// reused, when the operation is resumed.
+func TestOperationDeadline(t *testing.T) {
+	feature := &ScriptBasedSoftwareUpdatable{operationTimeout: time.Hour}
+	dir := t.TempDir()
+
+	start := time.Now()
+	deadline, timeout, err := feature.operationDeadline(dir, nil)
+	if err != nil || timeout != time.Hour || deadline.Before(start.Add(time.Hour)) || deadline.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("unexpected operation deadline %v and timeout %v: %v", deadline, timeout, err)
+	}
+
+	// The resumed operation keeps its deadline, even if the operation timeout is changed meanwhile.
+	feature.operationTimeout = time.Minute
+	resumed, resumedTimeout, err := feature.operationDeadline(dir, nil)
+	if err != nil || !resumed.Equal(deadline) || resumedTimeout != time.Hour {
+		t.Fatalf("resumed operation deadline %v and timeout %v, expected %v and %v: %v",
+			resumed, resumedTimeout, deadline, time.Hour, err)
+	}
+
+	// The operations without timeout are not limited.
+	feature.operationTimeout = 0
+	if _, timeout, err := feature.operationDeadline(t.TempDir(), nil); err != nil || timeout != 0 {
+		t.Fatalf("expected unlimited operation, got timeout %v: %v", timeout, err)
+	}
+}
+
+// TestOperationTimeoutOf tests the operation timeouts and deadlines, supplied by the backend.
+func TestOperationTimeoutOf(t *testing.T) {
+	feature := &ScriptBasedSoftwareUpdatable{operationTimeout: time.Hour, maxOperationTimeout: 2 * time.Hour}
+	soon := time.Now().Add(10 * time.Minute).Format(time.RFC3339)
+	tests := map[string]struct {
+		metadata map[string]string
+		expected time.Duration
+		invalid  bool
+	}{
+		"default":            {expected: time.Hour},
+		"duration":           {metadata: map[string]string{metadataTimeout: "45m"}, expected: 45 * time.Minute},
+		"seconds":            {metadata: map[string]string{metadataTimeout: "90"}, expected: 90 * time.Second},
+		"maximal":            {metadata: map[string]string{metadataTimeout: "2h"}, expected: 2 * time.Hour},
+		"deadline":           {metadata: map[string]string{metadataDeadline: soon}, expected: 10 * time.Minute},
+		"earlier-deadline":   {metadata: map[string]string{metadataTimeout: "1h", metadataDeadline: soon}, expected: 10 * time.Minute},
+		"earlier-timeout":    {metadata: map[string]string{metadataTimeout: "1m", metadataDeadline: soon}, expected: time.Minute},
+		"zero":               {metadata: map[string]string{metadataTimeout: "0"}, invalid: true},
+		"negative":           {metadata: map[string]string{metadataTimeout: "-5m"}, invalid: true},
+		"out-of-range":       {metadata: map[string]string{metadataTimeout: "3h"}, invalid: true},
+		"malformed":          {metadata: map[string]string{metadataTimeout: "soon"}, invalid: true},
+		"passed-deadline":    {metadata: map[string]string{metadataDeadline: "2020-01-01T00:00:00Z"}, invalid: true},
+		"malformed-deadline": {metadata: map[string]string{metadataDeadline: "tomorrow"}, invalid: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			timeout, err := feature.operationTimeoutOf(test.metadata)
+			if test.invalid {
+				if !errors.Is(err, errInvalidOperationTimeout) {
+					t.Fatalf("expected invalid operation timeout, got %v: %v", timeout, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The deadlines are relative to the current time.
+			if timeout > test.expected || timeout < test.expected-time.Minute {
+				t.Fatalf("expected timeout %v, got %v", test.expected, timeout)
+			}
+		})
+	}
+
+	// Unlimited, if no maximal operation timeout is configured.
+	feature.maxOperationTimeout = 0
+	if timeout, err := feature.operationTimeoutOf(map[string]string{metadataTimeout: "72h"}); err != nil || timeout != 72*time.Hour {
+		t.Fatalf("expected unlimited timeout, got %v: %v", timeout, err)
+	}
+}
+
+// TestResolveOperationDeadline tests that the operation timeout, supplied by the backend, is resolved to its
+// deadline from the operation receipt, so that the resumed operation is not given the whole timeout again.
+func TestResolveOperationDeadline(t *testing.T) {
+	received := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		metadata map[string]string
+		expected map[string]string
+	}{
+		"no-timeout": {metadata: map[string]string{"other": "value"}, expected: map[string]string{"other": "value"}},
+		"timeout": {metadata: map[string]string{metadataTimeout: "45m", "other": "value"},
+			expected: map[string]string{metadataDeadline: "2022-06-01T12:45:00Z", "other": "value"}},
+		"seconds": {metadata: map[string]string{metadataTimeout: "90"},
+			expected: map[string]string{metadataDeadline: "2022-06-01T12:01:30Z"}},
+		"earlier-deadline": {metadata: map[string]string{metadataTimeout: "1h", metadataDeadline: "2022-06-01T12:10:00Z"},
+			expected: map[string]string{metadataDeadline: "2022-06-01T12:10:00Z"}},
+		"later-deadline": {metadata: map[string]string{metadataTimeout: "1m", metadataDeadline: "2022-06-01T12:10:00Z"},
+			expected: map[string]string{metadataDeadline: "2022-06-01T12:01:00Z"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if resolved := resolveOperationDeadline(test.metadata, received); !reflect.DeepEqual(resolved, test.expected) {
+				t.Fatalf("expected resolved metadata %v, got %v", test.expected, resolved)
+			}
+		})
+	}
+
+	// The resolved deadline is kept on resume, instead of the whole timeout.
+	feature := &ScriptBasedSoftwareUpdatable{}
+	resolved := resolveOperationDeadline(map[string]string{metadataTimeout: "1h"}, time.Now().Add(-50*time.Minute))
+	if timeout, err := feature.operationTimeoutOf(resolved); err != nil || timeout > 10*time.Minute || timeout < 9*time.Minute {
+		t.Fatalf("expected the remaining timeout of the resumed operation, got %v: %v", timeout, err)
+	}
+}
+
+// TestOperationTimeoutBackend tests that the operation timeout, supplied by the backend, overrides the configured one
+// and that the operations with out-of-range timeout are rejected.
+func TestOperationTimeoutBackend(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-backend-timeout", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+	feature.gracePeriod = 500 * time.Millisecond
+	feature.operationTimeout = 0
+	feature.maxOperationTimeout = time.Minute
+
+	install := "while true; do sleep 0.1; done"
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	newAction := func(cid string, timeout string) *hawkbit.SoftwareUpdateAction {
+		sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+			convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+		}, "*")
+		sua.CorrelationID = cid
+		sua.Metadata = map[string]string{metadataTimeout: timeout}
+		return sua
+	}
+
+	t.Run("honored", func(t *testing.T) {
+		start := time.Now()
+		feature.installHandler(newAction("test-backend-timeout", "2s"), feature.su)
+		lo := pullFinalOperationStatus(t, mc)
+		if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeOperationTimeout {
+			t.Fatalf("expected install to fail on the backend timeout: %v", lo)
+		}
+		if elapsed := time.Since(start); elapsed < 2*time.Second {
+			t.Fatalf("install canceled before the backend timeout: %v", elapsed)
+		}
+		expected := fmt.Sprintf("%s after %v", errOperationTimeout, 2*time.Second)
+		if msg, _ := lo[messageParam].(string); !strings.HasPrefix(msg, expected) {
+			t.Fatalf("unexpected timeout status message: %s", msg)
+		}
+	})
+
+	t.Run("out-of-range", func(t *testing.T) {
+		feature.installHandler(newAction("test-backend-timeout-range", "2m"), feature.su)
+		lo := pullFinalOperationStatus(t, mc)
+		if lo[statusParam] != string(hawkbit.StatusFinishedError) || lo["statusCode"] != codeInvalidOperationTimeout {
+			t.Fatalf("expected install with out-of-range timeout to be rejected: %v", lo)
+		}
+		if msg, _ := lo[messageParam].(string); !strings.Contains(msg, "exceeds the maximal operation timeout") {
+			t.Fatalf("unexpected rejection status message: %s", msg)
+		}
+	})
+}
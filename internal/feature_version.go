@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+var errVersionMismatch = errors.New("installed version mismatch")
+
+// checkVersion runs the configured version command in the module install directory, after the module is installed,
+// and verifies that the last non-empty line of its output is the module version, e.g. to detect the install scripts,
+// which exit with zero code without installing anything. The version command is terminated, if not finished within
+// the version timeout. Closing the cancel channel or closing the storage on shutdown terminates it with
+// storage.ErrCanceled.
+func (f *ScriptBasedSoftwareUpdatable) checkVersion(log *logger.Entry, dir string, module *storage.Module,
+	cancel chan struct{}) error {
+	if f.versionCommand == nil || f.versionCommand.cmd == "" {
+		return nil
+	}
+	stop, release := f.stopOnShutdown(cancel)
+	defer release()
+
+	terminate := make(chan struct{})
+	timer := time.AfterFunc(f.versionTimeout, func() { close(terminate) })
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+			if timer.Stop() {
+				close(terminate)
+			}
+		case <-finished:
+		}
+	}()
+
+	var output bytes.Buffer
+	err := f.versionCommand.runWithInput(dir, "version", nil, &output, terminate, f.gracePeriod)
+	if !timer.Stop() && err == storage.ErrCanceled && !isCanceled(stop) {
+		return fmt.Errorf("%w: version command did not finish within %v", errVersionMismatch, f.versionTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	if version := lastLine(output.String()); version != module.Version {
+		return fmt.Errorf("%w: %q instead of %q", errVersionMismatch, version, module.Version)
+	}
+	log.Infof("installed version %s verified", module.Version)
+	return nil
+}
+
+// lastLine returns the last non-empty line of the given command output, without the surrounding white space.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestVersionCheckMatch tests that the installed module succeeds, if it reports the module version.
+func TestVersionCheckMatch(t *testing.T) {
+	testVersionCheck(t, "installing\n1.0.0\n", hawkbit.StatusFinishedSuccess)
+}
+
+// TestVersionCheckMismatch tests that the installed module is rolled back and fails, if it still reports
+// the previous version.
+func TestVersionCheckMismatch(t *testing.T) {
+	testVersionCheck(t, "0.9.0", hawkbit.StatusFinishedError)
+}
+
+// TestLastLine tests the parsing of the version command output.
+func TestLastLine(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"1.0.0":                  "1.0.0",
+		" 1.0.0 \r\n":            "1.0.0",
+		"warning\n1.0.0\n\n":     "1.0.0",
+		"1.0.0\nnot installed":   "not installed",
+		"warning\r\n  1.0.0\r\n": "1.0.0",
+	}
+	for output, expected := range tests {
+		if version := lastLine(output); version != expected {
+			t.Errorf("unexpected last line of %q: %q != %q", output, version, expected)
+		}
+	}
+}
+
+func testVersionCheck(t *testing.T, version string, expected hawkbit.Status) {
+	if runtime.GOOS == "windows" {
+		t.Skip("version script is not supported on windows")
+	}
+	// Prepare
+	dir := assertDirs(t, testDirFeature, false)
+	defer os.RemoveAll(dir)
+	tmpDir := assertDirs(t, "_tmp-version", true)
+	defer os.RemoveAll(tmpDir)
+
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: dir, mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	defer feature.Disconnect(true)
+
+	rolledBack := getAbsolutePath(t, filepath.Join(tmpDir, "rolled-back"))
+	installed := getAbsolutePath(t, filepath.Join(tmpDir, "version"))
+	versionPath, _ := createLocalArtifact(t, tmpDir, "version.sh", "cat "+installed)
+	feature.versionCommand = &command{cmd: "/bin/sh", args: []string{getAbsolutePath(t, versionPath)}}
+	feature.versionTimeout = time.Minute
+
+	install := "printf '" + version + "' > " + installed
+	installPath, installHash := createLocalArtifact(t, tmpDir, "install.sh", install)
+	rollback := "echo rolled back > " + rolledBack
+	rollbackPath, rollbackHash := createLocalArtifact(t, tmpDir, "rollback.sh", rollback)
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(getAbsolutePath(t, installPath), "install.sh", installHash, len(install)),
+		convertLocalArtifact(getAbsolutePath(t, rollbackPath), "rollback.sh", rollbackHash, len(rollback)),
+	}, "*")
+
+	feature.installHandler(sua, feature.su)
+	var lo map[string]interface{}
+	reported := false
+	for {
+		if lo = mc.pullLastOperationStatus(); lo == nil {
+			t.Fatal("install operation not finished")
+		}
+		if lo[statusParam] == string(hawkbit.StatusInstalled) {
+			reported = true
+		}
+		if lo[statusParam] == string(hawkbit.StatusFinishedSuccess) || lo[statusParam] == string(hawkbit.StatusFinishedError) {
+			break
+		}
+	}
+	if lo[statusParam] != string(expected) {
+		t.Fatalf("unexpected install operation status: %v != %v", lo[statusParam], expected)
+	}
+	if reported != (expected == hawkbit.StatusFinishedSuccess) {
+		t.Fatalf("module installed status is reported: %v, but its version is verified: %v", reported, expected == hawkbit.StatusFinishedSuccess)
+	}
+	if expected == hawkbit.StatusFinishedSuccess {
+		if _, err := os.Stat(rolledBack); !os.IsNotExist(err) {
+			t.Fatalf("installed version is rolled back: %v", err)
+		}
+		return
+	}
+	if lo["statusCode"] != codeVersionMismatch {
+		t.Fatalf("unexpected install operation status code: %v != %v", lo["statusCode"], codeVersionMismatch)
+	}
+	checkFileExistsWithContent(t, rolledBack, "rolled back")
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"errors"
+	"os"
+)
+
+var errInstallLockUnsupported = errors.New("install lock is not supported on windows")
+
+// tryLock is not supported on windows.
+func tryLock(file *os.File) (bool, error) {
+	return false, errInstallLockUnsupported
+}
+
+// unlock is not supported on windows.
+func unlock(file *os.File) error {
+	return errInstallLockUnsupported
+}
@@ -27,6 +27,10 @@ import (
 const (
 	flagConfigFile = "configFile"
 	flagInstall    = "install"
+	flagScan       = "scanCommand"
+	flagHealth     = "healthCommand"
+	flagContinue   = "continueCommand"
+	flagVersionCmd = "versionCommand"
 )
 
 var (
@@ -46,27 +50,121 @@ func InitFlags(flagSet *flag.FlagSet, cfg *BasicConfig) {
 	flagSet.IntVar(&cfg.LogFileSize, "logFileSize", cfg.LogFileSize, "Log file size in MB before it gets rotated")
 	flagSet.IntVar(&cfg.LogFileCount, "logFileCount", cfg.LogFileCount, "Log file max rotations count")
 	flagSet.IntVar(&cfg.LogFileMaxAge, "logFileMaxAge", cfg.LogFileMaxAge, "Log file rotations max age in days")
+	flagSet.BoolVar(&cfg.LogFileCompress, "logFileCompress", cfg.LogFileCompress, "Compress the rotated log files with gzip")
+	flagSet.StringVar(&cfg.LogFormat, "logFormat", cfg.LogFormat, "Log format: text or json. JSON entries contain timestamp, level, component, message and the operation context, e.g. correlationId, operation and module")
 
 	// init connection flags
 	flagSet.StringVar(&cfg.Broker, "broker", cfg.Broker, "Local MQTT broker address")
 	flagSet.StringVar(&cfg.Username, "username", cfg.Username, "Username that is a part of the credentials")
-	flagSet.StringVar(&cfg.Password, "password", cfg.Password, "Password that is a part of the credentials")
+	flagSet.StringVar(&cfg.Password, "password", cfg.Password, "Password that is a part of the credentials. Can be a secret reference: 'env:VARIABLE' or 'file:/path'")
 	flagSet.StringVar(&cfg.CACert, "caCert", cfg.CACert, "A PEM encoded CA certificates file for MQTT broker connection")
 	flagSet.StringVar(&cfg.Cert, "cert", cfg.Cert, "A PEM encoded certificate file to authenticate to the MQTT server/broker")
-	flagSet.StringVar(&cfg.Key, "key", cfg.Key, "A PEM encoded unencrypted private key file to authenticate to the MQTT server/broker")
-	flagSet.StringVar(&cfg.StorageLocation, "storageLocation", cfg.StorageLocation, "Location of the storage")
+	flagSet.StringVar(&cfg.Key, "key", cfg.Key, "A PEM encoded private key file to authenticate to the MQTT server/broker")
+	flagSet.StringVar(&cfg.KeyPassphrase, "keyPassphrase", cfg.KeyPassphrase, "Passphrase of the encrypted PKCS#8 private key. Can be a secret reference: 'env:VARIABLE' or 'file:/path'")
+	flagSet.StringVar(&cfg.TLSMinVersion, "tlsMinVersion", cfg.TLSMinVersion, "Minimal TLS version of the secure MQTT server/broker connection: 1.2 or 1.3")
+	flagSet.Var(newPathArgs(&cfg.TLSPinnedKeys), "tlsPinnedKeys", "Base64 encoded SHA-256 hashes of the trusted MQTT server/broker public keys (SPKI), separated by space")
+	flagSet.DurationVar((*time.Duration)(&cfg.ReconnectInterval), "reconnectInterval", (time.Duration)(cfg.ReconnectInterval), "Initial interval between MQTT reconnect attempts, doubled on each failed attempt")
+	flagSet.DurationVar((*time.Duration)(&cfg.ReconnectMaxInterval), "reconnectMaxInterval", (time.Duration)(cfg.ReconnectMaxInterval), "Maximal interval between MQTT reconnect attempts")
+	flagSet.IntVar(&cfg.ReconnectMaxAttempts, "reconnectMaxAttempts", cfg.ReconnectMaxAttempts, "Number of MQTT reconnect attempts, before exiting with error. Unlimited, if set to 0")
+	flagSet.IntVar(&cfg.StatusQoS, "statusQos", cfg.StatusQoS, "QoS level of the published status messages: 0, 1 or 2")
+	flagSet.BoolVar(&cfg.StatusRetained, "statusRetained", cfg.StatusRetained, "Publish the status messages as retained, so that late-joining subscribers receive the latest status")
+	flagSet.IntVar(&cfg.StatusCompressSize, "statusCompressSize", cfg.StatusCompressSize, "Size in bytes, above which the value of the status messages is gzip compressed and marked with 'content-encoding' header. Not compressed, if set to 0")
+	flagSet.IntVar(&cfg.CommandsQoS, "commandsQos", cfg.CommandsQoS, "QoS level of the commands subscription: 0, 1 or 2")
+	flagSet.StringVar(&cfg.TopicPrefix, "topicPrefix", cfg.TopicPrefix, "Tenant prefix of all MQTT topics, e.g. 'tenants/my-tenant'. No prefix, if not set")
+	flagSet.StringVar(&cfg.EventsTopic, "eventsTopic", cfg.EventsTopic, "Topic of the Ditto events, used to publish the feature status")
+	flagSet.StringVar(&cfg.CommandsTopic, "commandsTopic", cfg.CommandsTopic, "Root topic of the Ditto commands and their responses")
+	flagSet.StringVar(&cfg.StorageLocation, "storageLocation", cfg.StorageLocation, "Location of the storage, the state directory of the service manager (STATE_DIRECTORY) or the current directory by default")
+	flagSet.StringVar(&cfg.StorageMode, "storageMode", cfg.StorageMode, "Octal permission mode of the storage location, if created on start, e.g. 0750")
+	flagSet.StringVar(&cfg.StorageLayout, "storageLayout", cfg.StorageLayout, "Storage layout of the downloaded module artifacts: 'module' to store them in their module directories or 'blobs' to store them once under their SHA-256 digest, hard linked to the module directories")
+	flagSet.BoolVar(&cfg.MmapChecksum, "mmapChecksum", cfg.MmapChecksum, "Use memory-mapped reads to calculate the checksums of local artifacts, where supported")
+	flagSet.BoolVar(&cfg.VerifyArchives, "verifyArchives", cfg.VerifyArchives, "Verify that the downloaded zip and tar.gz artifacts are valid archives, after their checksum is validated. Invalid archives are downloaded again")
+	flagSet.StringVar(&cfg.TUFRoot, "tufRoot", cfg.TUFRoot, "Trusted root metadata file of a TUF repository. The downloaded artifacts are verified against its signed targets, after their checksum is validated, if set")
+	flagSet.StringVar(&cfg.TUFRepository, "tufRepository", cfg.TUFRepository, "Base URL or local directory of the timestamp, snapshot and targets metadata of the TUF repository, fetched with the download settings. Required with tufRoot")
+	flagSet.StringVar(&cfg.ThingID, "thingId", cfg.ThingID, "Identifier of the thing, which commands are accepted. Defaults to the edge device identifier")
+	flagSet.StringVar(&cfg.ThingNamespace, "thingNamespace", cfg.ThingNamespace, "Namespace of the thing, replacing the namespace of the edge device identifier. Cannot be combined with thingId")
 	flagSet.StringVar(&cfg.FeatureID, "featureId", cfg.FeatureID, "Feature identifier of SoftwareUpdatable")
 	flagSet.StringVar(&cfg.ModuleType, "moduleType", cfg.ModuleType, "Module type of SoftwareUpdatable")
 	flagSet.StringVar(&cfg.ArtifactType, "artifactType", cfg.ArtifactType, "Defines the module artifact type: archive or plain")
 	flagSet.StringVar(&cfg.ServerCert, "serverCert", cfg.ServerCert, "A PEM encoded certificate 'file' for secure artifact download")
+	flagSet.StringVar(&cfg.ServerName, "serverName", cfg.ServerName, "Expected host name of the artifact download server certificate, verified instead of the download link host, e.g. when downloading by IP address or through a load balancer")
+	flagSet.BoolVar(&cfg.InsecureSkipVerify, "insecureSkipVerify", cfg.InsecureSkipVerify, "INSECURE: disable the certificate verification of the artifact download servers. Only for testing with self-signed certificates, never use it in production")
+	flagSet.StringVar(&cfg.ServerToken, "serverToken", cfg.ServerToken, "Bearer token, sent in the authorization header of the artifact download requests. Can be a secret reference: 'env:VARIABLE' or 'file:/path'")
+	flagSet.BoolVar(&cfg.DisableHTTP2, "disableHttp2", cfg.DisableHTTP2, "Disable the HTTP/2 negotiation with the artifact download server, e.g. if it mishandles HTTP/2. HTTP/1.1 is used then")
+	flagSet.BoolVar(&cfg.DisableCompression, "disableCompression", cfg.DisableCompression, "Disable the transparent gzip compression of the artifact download responses, so that the artifacts are received exactly as served")
+	flagSet.StringVar(&cfg.ManifestKey, "manifestKey", cfg.ManifestKey, "A PEM encoded public key file (RSA, ECDSA or Ed25519) to verify the signature of the module manifests, listing the SHA-256 digests of the module artifacts. Modules with manifest fail, if not set")
+	flagSet.StringVar(&cfg.SFTPKnownHosts, "sftpKnownHosts", cfg.SFTPKnownHosts, "OpenSSH known_hosts file with the trusted host keys of the SFTP artifact servers. SFTP downloads fail, if not set")
+	flagSet.StringVar(&cfg.SFTPUsername, "sftpUsername", cfg.SFTPUsername, "Username to authenticate to the SFTP artifact servers, if not given by the artifact link")
+	flagSet.StringVar(&cfg.SFTPPassword, "sftpPassword", cfg.SFTPPassword, "Password to authenticate to the SFTP artifact servers. Can be a secret reference: 'env:VARIABLE' or 'file:/path'")
+	flagSet.StringVar(&cfg.SFTPKey, "sftpKey", cfg.SFTPKey, "A PEM encoded private key file to authenticate to the SFTP artifact servers")
+	flagSet.Var(newPathArgs(&cfg.DownloadAllowList), "downloadAllowList", "Allowed schemes and hosts of the artifact download links and their redirects in the form [scheme://]host[:port], separated by space. The host can be '*' or start with '*.' to allow all subdomains. All links are allowed, if not set")
+	flagSet.IntVar(&cfg.DownloadMaxRedirects, "downloadMaxRedirects", cfg.DownloadMaxRedirects, "Maximal number of redirects of the artifact download requests, which are stopped at the last one as with the standard HTTP client. Redirects are not followed, if set to 0")
+	flagSet.BoolVar(&cfg.DownloadSameHost, "downloadSameHostRedirects", cfg.DownloadSameHost, "Follow only the redirects of the artifact download requests to the same host and to the downloadRedirectAllowList entries")
+	flagSet.Var(newPathArgs(&cfg.DownloadRedirectList), "downloadRedirectAllowList", "Allowed schemes and hosts of the artifact download redirects in the form [scheme://]host[:port], separated by space. The host can be '*' or start with '*.' to allow all subdomains. All redirects are allowed, if not set and downloadSameHostRedirects is not enabled")
 	flagSet.IntVar(&cfg.DownloadRetryCount, "downloadRetryCount", cfg.DownloadRetryCount, "Number of retries, in case of a failed download. By default no retries are supported.")
+	flagSet.IntVar(&cfg.DownloadMaxRestarts, "downloadMaxRestarts", cfg.DownloadMaxRestarts, "Maximal number of restarts from the beginning of each artifact download, e.g. after corrupted downloads, before it fails. Distinct from the retries of the failed requests. Restarts are not limited, if set to 0")
+	flagSet.IntVar(&cfg.ChecksumRetries, "downloadChecksumRetries", cfg.ChecksumRetries, "Number of times an artifact is downloaded again from the beginning, after its checksum does not match, e.g. due to a corrupted edge cache. Checksum mismatches fail fast, if set to 0")
+	flagSet.Var(newPathArgs(&cfg.DownloadMirrors), "downloadMirrors", "Alternative artifact servers in the form scheme://host[:port], separated by space, which replace the scheme and host of the HTTP(S) artifact links in rotation on each checksum retry. The mirrored links must be allowed by downloadAllowList")
+	flagSet.Var(newPathArgs(&cfg.DownloadRewrites), "downloadRewrites", "Rewrites of the artifact download links in the form prefix=replacement, separated by space, e.g. to a device-local caching proxy. The prefix of the links is replaced by the first matching rewrite, before the downloadAllowList check and before each download request")
 	flagSet.DurationVar((*time.Duration)(&cfg.DownloadRetryInterval), "downloadRetryInterval", (time.Duration)(cfg.DownloadRetryInterval), "Interval between retries, in case of a failed download. Should be a sequence of decimal numbers, each with optional fraction and a unit suffix, such as '300ms', '1.5h', '10m30s', etc. Valid time units are 'ns', 'us' (or 'µs'), 'ms', 's', 'm', 'h'")
+	flagSet.IntVar(&cfg.DownloadBufferSize, "downloadBufferSize", cfg.DownloadBufferSize, "Size in bytes of the copy buffers, shared by the artifact downloads")
+	flagSet.IntVar(&cfg.DownloadBuffers, "downloadBuffers", cfg.DownloadBuffers, "Maximal number of copy buffers in use by the concurrent artifact downloads, bounding their total buffer memory. Unlimited, if set to 0")
+	flagSet.BoolVar(&cfg.DownloadPreempt, "downloadPreempt", cfg.DownloadPreempt, "Pause the running artifact downloads, while downloads with higher QoS class, set by the qosClass operation metadata, wait for one of the downloadBuffers copy buffers")
+	flagSet.BoolVar(&cfg.DownloadInPlace, "downloadInPlace", cfg.DownloadInPlace, "Download the artifacts directly to their files, resuming them in place, instead of renaming a temporary file on completion. Halves the peak storage usage, but the artifact files are not replaced atomically")
+	flagSet.BoolVar(&cfg.DownloadNoResume, "downloadNoResume", cfg.DownloadNoResume, "Restart the failed artifact downloads from the beginning instead of resuming them, e.g. for servers with chunked responses and without Range support. Such servers are also detected, when they send neither Content-Length nor Accept-Ranges")
+	flagSet.BoolVar(&cfg.DownloadTrustLocal, "downloadTrustLocal", cfg.DownloadTrustLocal, "Skip the checksum validation of the local artifacts, copied from a trusted file system with verified integrity, and verify only their size. Not recommended, a warning is logged for each trusted artifact")
+	flagSet.IntVar(&cfg.DownloadReadBuffer, "downloadReadBuffer", cfg.DownloadReadBuffer, "Size in bytes of the read buffer of the artifact download server connections, e.g. tuned to the link MTU. The default size of the HTTP transport is used, if set to 0")
+	flagSet.IntVar(&cfg.DownloadDSCP, "downloadDscp", cfg.DownloadDSCP, "Differentiated Services code point (0-63), marked on the artifact download connections, e.g. 8 (CS1) to yield to the management traffic. The connections are not marked, if set to 0")
+	flagSet.IntVar(&cfg.CircuitThreshold, "downloadCircuitThreshold", cfg.CircuitThreshold, "Number of consecutive failed requests to an artifact server host, after which its requests fail fast for the circuit cooldown period, instead of being retried. Disabled, if set to 0")
+	flagSet.DurationVar((*time.Duration)(&cfg.CircuitCooldown), "downloadCircuitCooldown", (time.Duration)(cfg.CircuitCooldown), "Time to fail fast the requests to an artifact server host with open circuit, before a single probe request checks its recovery")
+	flagSet.StringVar(&cfg.DownloadHashing, "downloadHashing", cfg.DownloadHashing, "Hashing mode of the downloaded artifacts: 'inline' after the download, better for single-core devices, or 'overlapped' in parallel with the disk writes, better for multi-core devices")
+	flagSet.BoolVar(&cfg.TrailerChecksum, "downloadTrailerChecksum", cfg.TrailerChecksum, "Cross-check the downloaded artifacts against the digests, sent by the artifact servers in the X-Goog-Hash, X-Amz-Checksum-* or Digest response trailers, failing on mismatch even if the artifact checksum matches")
+	flagSet.BoolVar(&cfg.DownloadPreallocate, "downloadPreallocate", cfg.DownloadPreallocate, "Reserve the storage of the downloaded artifacts up to their full size before they are written, failing fast on storage shortage and reducing the fragmentation of their files")
+	flagSet.StringVar(&cfg.MissingChecksum, "missingChecksum", cfg.MissingChecksum, "Policy for the artifacts without checksum: 'fail' to reject them or 'skip' to verify only their size with a warning, e.g. for legacy artifacts. Never use 'skip' in production, unless the risk is accepted")
+	flagSet.StringVar(&cfg.ArchiveSymlinks, "archiveSymlinks", cfg.ArchiveSymlinks, "Policy for the symbolic link entries of the module archives: 'reject' the archive or 'contain' them, extracting only the links, which resolve within the module directory")
+	flagSet.StringVar(&cfg.DuplicateArtifacts, "duplicateArtifacts", cfg.DuplicateArtifacts, "Policy for the artifacts of a module with the same file name: 'rename' the next ones with their artifact index or 'reject' the operation")
+	flagSet.DurationVar((*time.Duration)(&cfg.DownloadDNSWait), "downloadDnsWait", (time.Duration)(cfg.DownloadDNSWait), "Maximal time to wait for the artifact server host name to become resolvable, before starting a download, e.g. while the resolver is not ready on boot. Disabled, if set to 0")
+	flagSet.DurationVar((*time.Duration)(&cfg.DownloadStartJitter), "downloadStartJitter", (time.Duration)(cfg.DownloadStartJitter), "Maximal random delay before starting a download or install operation, spreading the artifact server load of many devices, receiving the same operation. Disabled, if set to 0")
+	flagSet.DurationVar((*time.Duration)(&cfg.CoalesceWindow), "coalesceWindow", (time.Duration)(cfg.CoalesceWindow), "Time to wait after receiving a download or install operation, before starting it, for newer operations of all of its modules, which supersede it. Canceled operations neither supersede nor are superseded. Disabled, if not set")
+
+	flagSet.DurationVar((*time.Duration)(&cfg.ProgressInterval), "progressInterval", (time.Duration)(cfg.ProgressInterval), "Minimal interval between download progress updates. Progress is reported on each change, if set to 0")
+	flagSet.DurationVar((*time.Duration)(&cfg.GracePeriod), "gracePeriod", (time.Duration)(cfg.GracePeriod), "Time to wait for a canceled install script to terminate, before killing it")
+	flagSet.DurationVar((*time.Duration)(&cfg.ShutdownGracePeriod), "shutdownGracePeriod", (time.Duration)(cfg.ShutdownGracePeriod), "Time to wait on shutdown for the running operation to finish, before canceling it. Canceled downloads are resumed on the next start")
+	flagSet.DurationVar((*time.Duration)(&cfg.OperationTimeout), "operationTimeout", (time.Duration)(cfg.OperationTimeout), "Overall time of a download or install operation, including the download and the installation of all its modules from its scheduled start, before canceling it and reporting how far it got. Unlimited, if set to 0")
+	flagSet.DurationVar((*time.Duration)(&cfg.MaxOperationTimeout), "maxOperationTimeout", (time.Duration)(cfg.MaxOperationTimeout), "Maximal overall time of an operation, supplied by the backend with the timeout or deadline operation metadata. The operations with longer timeout are rejected. Unlimited, if set to 0")
+	flagSet.Int64Var(&cfg.MaxTotalBytes, "maxTotalBytes", cfg.MaxTotalBytes, "Maximal total declared size in bytes of the artifacts of an operation. Larger operations are rejected before downloading anything. Unlimited, if set to 0")
+	flagSet.IntVar(&cfg.MaxArtifacts, "maxArtifacts", cfg.MaxArtifacts, "Maximal number of the artifacts of an operation. Operations with more artifacts are rejected before downloading anything. Unlimited, if set to 0")
 
 	flagSet.StringVar(&cfg.Mode, "mode", cfg.Mode, modeDescription)
+	flagSet.StringVar(&cfg.DiagnosticsAddress, "diagnosticsAddress", cfg.DiagnosticsAddress, "Address of the local diagnostics HTTP endpoint, e.g. 'localhost:8080'. Disabled, if not set")
+	flagSet.IntVar(&cfg.AuditLogEntries, "auditLogEntries", cfg.AuditLogEntries, "Maximal number of the completed operations, kept in the audit log in the storage location. Disabled, if not set")
 
 	flagSet.Var(&cfg.InstallCommand, flagInstall, "Defines the absolute path to install script")
+	flagSet.StringVar(&cfg.InstallLock, "installLock", cfg.InstallLock, "Path to the lock file, exclusively locked during the module installations and shared with the other installing processes on the device, e.g. the package managers. Downloads do not take the lock. Disabled, if not set")
+	flagSet.DurationVar((*time.Duration)(&cfg.InstallLockTimeout), "installLockTimeout", (time.Duration)(cfg.InstallLockTimeout), "Time to wait for the install lock, held by another process, before failing the module installation. Unlimited, if set to 0")
+	flagSet.Var(&cfg.ScanCommand, flagScan, "Defines the command to scan the downloaded and verified artifacts before installation, e.g. antivirus or SBOM scanner. The artifact path is given as last argument, non-zero exit code rejects the artifact")
+	flagSet.DurationVar((*time.Duration)(&cfg.ScanTimeout), "scanTimeout", (time.Duration)(cfg.ScanTimeout), "Time to wait for the scan command to finish, before rejecting the artifact. Unlimited, if set to 0")
+	flagSet.DurationVar((*time.Duration)(&cfg.ApprovalTimeout), "approvalTimeout", (time.Duration)(cfg.ApprovalTimeout), "Time to wait for the proceed message of the install operations, which require approval, before applying the approval action. Unlimited, if set to 0")
+	flagSet.StringVar(&cfg.ApprovalAction, "approvalAction", cfg.ApprovalAction, "Action on the approval timeout: 'cancel' the operation or 'proceed' with the installation")
+	flagSet.Var(&cfg.HealthCommand, flagHealth, "Defines the command to probe the health of the installed modules, run in the module install directory. Non-zero exit code is retried with exponential backoff and the module is rolled back, if the probe does not pass within the health timeout")
+	flagSet.DurationVar((*time.Duration)(&cfg.HealthTimeout), "healthTimeout", (time.Duration)(cfg.HealthTimeout), "Time for the health probe of the installed module to pass, before rolling the module back")
+	flagSet.DurationVar((*time.Duration)(&cfg.HealthInterval), "healthInterval", (time.Duration)(cfg.HealthInterval), "Initial interval between the health probe attempts, doubled on each failed attempt")
+	flagSet.Var(&cfg.VersionCommand, flagVersionCmd, "Defines the command to print the version of the installed modules, run in the module install directory after the install script. The module is rolled back, if the last line of its output is not the module version")
+	flagSet.DurationVar((*time.Duration)(&cfg.VersionTimeout), "versionTimeout", (time.Duration)(cfg.VersionTimeout), "Time for the version command of the installed module to print its version, before rolling the module back")
+	flagSet.Var(&cfg.ContinueCommand, flagContinue, "Defines the command, consulted periodically whether the running operations can continue, e.g. monitoring the battery level. Exit code 0 continues, 1 suspends and 2 aborts the operation, leaving it to be resumed on the next start")
+	flagSet.DurationVar((*time.Duration)(&cfg.ContinueInterval), "continueInterval", (time.Duration)(cfg.ContinueInterval), "Interval between the continue command runs, also limiting the time of each run")
+	flagSet.StringVar(&cfg.CleanupPolicy, "cleanupPolicy", cfg.CleanupPolicy, "Cleanup policy of the successfully installed module artifacts: 'keep' for a rollback or a reinstallation, 'delete-artifacts' or 'delete-on-next-success' to keep them until another version of the module is successfully installed")
+	flagSet.StringVar(&cfg.ReportURL, "reportUrl", cfg.ReportURL, "Base URL, where the install log and the result manifest of the completed install operations are uploaded as <correlationId>/install.log and <correlationId>/result.json, with the TLS, authorization and retry settings of the artifact downloads. Disabled, if not set")
+	flagSet.StringVar(&cfg.ReportMethod, "reportMethod", cfg.ReportMethod, "HTTP method of the install report uploads: PUT or POST")
+	flagSet.BoolVar(&cfg.ReportManifest, "reportManifest", cfg.ReportManifest, "Upload the JSON result manifest with the final status of the installed modules, besides the install log")
+	flagSet.IntVar(&cfg.ReportLogSize, "reportLogSize", cfg.ReportLogSize, "Maximal size in bytes of the uploaded install log, keeping the last output of the install commands")
+	flagSet.Var(&cfg.InstallCommands, "installCommands", "Defines the install command of a module artifact type in the form type=command [args]. Can be repeated for multiple types")
 	flagSet.Var(newPathArgs(&cfg.InstallDirs), "installDirs", "Local file system directories, where to search for module artifacts")
+	flagSet.Var(newPathArgs(&cfg.CommandAllowList), "commandAllowList", "Absolute paths of the allowed executables and directories of the install, scan, health, version, continue and rollback commands, separated by space. Shell scripts, run with /bin/sh, must be allowed as well, the shells are run only with an allowed script as their first argument. Can be given only on the command line, not in the configuration file. All commands are allowed, if not set")
 	flagSet.StringVar(&cfg.ConfigFile, flagConfigFile, cfg.ConfigFile, "Defines the configuration file")
+	flagSet.BoolVar(&cfg.SelfCheck, "selfCheck", cfg.SelfCheck, "Checks the configuration and the environment, reports all found problems and exits")
+	flagSet.StringVar(&cfg.VerifyManifest, "verifyManifest", cfg.VerifyManifest, "Verifies the files in verifyDir against the SHA-256 digests of the given manifest file, in the format of the module manifests, reports the status of each listed file and exits")
+	flagSet.StringVar(&cfg.VerifyDir, "verifyDir", cfg.VerifyDir, "Directory with the files, verified against verifyManifest. The storage location is verified, if not set")
+	flagSet.IntVar(&cfg.VerifyConcurrency, "verifyConcurrency", cfg.VerifyConcurrency, "Maximal number of files, verified against verifyManifest at the same time")
 }
 
 // ParseConfigFilePath returns the value for configuration file path if set.
@@ -104,14 +202,11 @@ func parseFlags(cfg *BasicConfig, version string) {
 
 	fVersion := flagSet.Bool("version", false, "Prints current version and exits")
 	args := os.Args[1:]
-	flag1 := "-" + flagInstall
-	flag2 := "--" + flagInstall
-	for _, arg := range args {
-		if strings.HasPrefix(arg, flag1+"=") || strings.HasPrefix(arg, flag2+"=") {
-			cfg.InstallCommand = command{}
-			break
-		}
-	}
+	resetCommandFlag(args, flagInstall, &cfg.InstallCommand)
+	resetCommandFlag(args, flagScan, &cfg.ScanCommand)
+	resetCommandFlag(args, flagHealth, &cfg.HealthCommand)
+	resetCommandFlag(args, flagVersionCmd, &cfg.VersionCommand)
+	resetCommandFlag(args, flagContinue, &cfg.ContinueCommand)
 	if err := flagSet.Parse(args); err != nil {
 		logger.Errorf("Cannot parse command flags: %v", err)
 	}
@@ -122,6 +217,19 @@ func parseFlags(cfg *BasicConfig, version string) {
 	}
 }
 
+// resetCommandFlag resets the command, loaded from the config file, if it is also provided with flag,
+// so that the flag arguments are not appended to it.
+func resetCommandFlag(args []string, flag string, cmd *command) {
+	flag1 := "-" + flag
+	flag2 := "--" + flag
+	for _, arg := range args {
+		if strings.HasPrefix(arg, flag1+"=") || strings.HasPrefix(arg, flag2+"=") {
+			*cmd = command{}
+			return
+		}
+	}
+}
+
 // LoadConfigFromFile reads the file contents and unmarshal them into the given config structure.
 func LoadConfigFromFile(filePath string, config interface{}) error {
 	if !isFile(filePath) {
@@ -328,6 +328,40 @@ func TestInvalidAccessModeFlag(t *testing.T) {
 	}
 }
 
+func TestInvalidQoSFlags(t *testing.T) {
+	for _, flag := range []string{flagStatusQoS, flagCommandsQoS} {
+		setFlags([]string{c(flag, "3")})
+		cfg, err := LoadConfig(testVersion)
+		if err != nil {
+			t.Errorf("not expecting error when initializing flags with invalid %s: %v", flag, err)
+		}
+		if err = cfg.Validate(); err == nil {
+			t.Fatalf("expecting error when validating configuration with invalid %s flag", flag)
+		}
+	}
+}
+
+// TestInvalidThingIDFlag tests that a thing identifier without namespace is rejected.
+func TestInvalidThingIDFlag(t *testing.T) {
+	setFlags([]string{c(flagThingID, "no-namespace")})
+	cfg, err := LoadConfig(testVersion)
+	if err != nil {
+		t.Errorf("not expecting error when initializing flags with invalid %s: %v", flagThingID, err)
+	}
+	if err = cfg.Validate(); err == nil {
+		t.Fatalf("expecting error when validating configuration with invalid %s flag", flagThingID)
+	}
+}
+
+// TestDefaultStorageLocation tests that the state directory of the service manager is the default storage location.
+func TestDefaultStorageLocation(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", "")
+	assertString(t, NewDefaultConfig().StorageLocation, defaultStorageLocation)
+
+	t.Setenv("STATE_DIRECTORY", "/var/lib/software-update"+string(os.PathListSeparator)+"/var/lib/other")
+	assertString(t, NewDefaultConfig().StorageLocation, "/var/lib/software-update")
+}
+
 // compareConfigResult function verifies the content of the expected and actual configuration struct
 func compareConfigResult(t *testing.T, expectedConfig *BasicConfig) {
 	cfg, err := LoadConfig(testVersion)
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// installCommands maps module artifact types to their install commands.
+type installCommands map[string]command
+
+// String is representation of the install commands as comma separated type=command pairs, sorted by type.
+func (c *installCommands) String() string {
+	if c == nil || len(*c) == 0 {
+		return ""
+	}
+	types := make([]string, 0, len(*c))
+	for artifactType := range *c {
+		types = append(types, artifactType)
+	}
+	sort.Strings(types)
+	pairs := make([]string, len(types))
+	for i, artifactType := range types {
+		cmd := (*c)[artifactType]
+		pairs[i] = artifactType + "=" + cmd.String()
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// Set adds install command from string in the form type=command [args], used for flag set.
+func (c *installCommands) Set(value string) error {
+	artifactType, cmdLine, ok := cut(value, "=")
+	fields := strings.Fields(cmdLine)
+	if !ok || strings.TrimSpace(artifactType) == "" || len(fields) == 0 {
+		return fmt.Errorf("invalid install command - %s, must be in the form type=command", value)
+	}
+	if *c == nil {
+		*c = installCommands{}
+	}
+	cmd := command{}
+	for _, field := range fields {
+		if err := cmd.Set(field); err != nil {
+			return err
+		}
+	}
+	(*c)[strings.TrimSpace(artifactType)] = cmd
+	return nil
+}
+
+// validate checks that each artifact type is mapped to an install command.
+func (c installCommands) validate() error {
+	for artifactType, cmd := range c {
+		if artifactType == "" || cmd.cmd == "" {
+			return fmt.Errorf("invalid install command for artifact type - (%s), must define a command", artifactType)
+		}
+	}
+	return nil
+}
+
+// cut slices s around the first instance of sep, as strings.Cut, which requires Go 1.18.
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
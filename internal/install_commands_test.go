@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestInstallCommandsIsSet(t *testing.T) {
+	f := flag.NewFlagSet("testing", flag.ContinueOnError)
+
+	var c installCommands
+	f.Var(&c, "C", "C")
+
+	args := []string{"-C=deb=/usr/bin/dpkg -i", "-C", "script=/bin/bash -e"}
+	if err := f.Parse(args); err != nil {
+		t.Errorf("Expected no error, but received %s", err)
+	}
+
+	expected := installCommands{
+		"deb":    command{cmd: "/usr/bin/dpkg", args: []string{"-i"}},
+		"script": command{cmd: "/bin/bash", args: []string{"-e"}},
+	}
+	if !reflect.DeepEqual(expected, c) {
+		t.Errorf("Expected %v, but received %v", expected, c)
+	}
+	if "deb=/usr/bin/dpkg -i, script=/bin/bash -e" != c.String() {
+		t.Errorf("Unexpected string value %s", c.String())
+	}
+}
+
+func TestInstallCommandsInvalid(t *testing.T) {
+	for _, arg := range []string{"-C=", "-C=deb", "-C==/usr/bin/dpkg", "-C=deb= "} {
+		f := flag.NewFlagSet("testing", flag.ContinueOnError)
+		f.SetOutput(io.Discard)
+
+		var c installCommands
+		f.Var(&c, "C", "C")
+		if err := f.Parse([]string{arg}); err == nil {
+			t.Errorf("Expected error for %s, but not received", arg)
+		}
+	}
+}
+
+func TestInstallCommandsJSON(t *testing.T) {
+	var c installCommands
+	if err := json.Unmarshal([]byte(`{"deb":["/usr/bin/dpkg","-i"],"script":["install.sh"]}`), &c); err != nil {
+		t.Fatalf("Expected no error, but received %s", err)
+	}
+	if err := c.validate(); err != nil {
+		t.Errorf("Expected no validation error, but received %s", err)
+	}
+	if cmd := c["deb"]; cmd.cmd != "/usr/bin/dpkg" || !reflect.DeepEqual(cmd.args, []string{"-i"}) {
+		t.Errorf("Unexpected deb install command %v", cmd)
+	}
+
+	if err := json.Unmarshal([]byte(`{"deb":[]}`), &c); err != nil {
+		t.Fatalf("Expected no error, but received %s", err)
+	}
+	if err := c.validate(); err == nil {
+		t.Error("Expected validation error for empty install command, but not received")
+	}
+}
@@ -13,12 +13,15 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 
@@ -28,11 +31,21 @@ import (
 
 // LogConfig represents a log configuration.
 type LogConfig struct {
-	LogFile       string `json:"logFile,omitempty"`
-	LogLevel      string `json:"logLevel,omitempty"`
-	LogFileSize   int    `json:"logFileSize,omitempty"`
-	LogFileCount  int    `json:"logFileCount,omitempty"`
-	LogFileMaxAge int    `json:"logFileMaxAge,omitempty"`
+	LogFile         string `json:"logFile,omitempty"`
+	LogLevel        string `json:"logLevel,omitempty"`
+	LogFileSize     int    `json:"logFileSize,omitempty"`
+	LogFileCount    int    `json:"logFileCount,omitempty"`
+	LogFileMaxAge   int    `json:"logFileMaxAge,omitempty"`
+	LogFileCompress bool   `json:"logFileCompress"`
+	LogFormat       string `json:"logFormat,omitempty"`
+}
+
+// Fields are the structured context of log entries, e.g. the operation correlation identifier and module.
+type Fields map[string]string
+
+// Entry writes log entries with structured context.
+type Entry struct {
+	fields Fields
 }
 
 // LogLevel represents a log level.
@@ -62,11 +75,15 @@ const (
 	suPrefix      = "[SU]"
 	hawkbitPrefix = "[Hawkbit]"
 	dittoPrefix   = "[Ditto]"
+
+	// FormatJSON is the log format, writing each entry as JSON object on a separate line.
+	FormatJSON = "json"
 )
 
 var (
-	logger *log.Logger
-	level  LogLevel
+	logger     *log.Logger
+	level      LogLevel
+	jsonFormat bool
 )
 
 // SetupLogger initialized the log besed on the provided log configuration.
@@ -80,7 +97,7 @@ func SetupLogger(logConfig *LogConfig) io.WriteCloser {
 				MaxBackups: logConfig.LogFileCount,
 				MaxAge:     logConfig.LogFileMaxAge,
 				LocalTime:  true,
-				Compress:   true,
+				Compress:   logConfig.LogFileCompress,
 			}
 		}
 	}
@@ -88,7 +105,8 @@ func SetupLogger(logConfig *LogConfig) io.WriteCloser {
 	log.SetOutput(loggerOut)
 	log.SetFlags(logFlags)
 
-	logger = log.New(loggerOut, fmt.Sprintf(prefix, suPrefix), logFlags)
+	jsonFormat = strings.EqualFold(logConfig.LogFormat, FormatJSON)
+	logger = newLogger(loggerOut, suPrefix)
 
 	// Parse log level
 	switch strings.ToUpper(logConfig.LogLevel) {
@@ -103,17 +121,17 @@ func SetupLogger(logConfig *LogConfig) io.WriteCloser {
 	default:
 		level = ERROR
 	}
-	lHawkbit := log.New(loggerOut, fmt.Sprintf(prefix, hawkbitPrefix), logFlags)
-	hawkbit.ERROR = &wrapper{logger: lHawkbit, level: ERROR, prefix: ePrefix}
-	hawkbit.WARN = &wrapper{logger: lHawkbit, level: WARN, prefix: wPrefix}
-	hawkbit.INFO = &wrapper{logger: lHawkbit, level: INFO, prefix: iPrefix}
-	hawkbit.DEBUG = &wrapper{logger: lHawkbit, level: DEBUG, prefix: dPrefix}
-
-	lDitto := log.New(loggerOut, fmt.Sprintf(prefix, dittoPrefix), logFlags)
-	ditto.ERROR = &wrapper{logger: lDitto, level: ERROR, prefix: ePrefix}
-	ditto.WARN = &wrapper{logger: lDitto, level: WARN, prefix: wPrefix}
-	ditto.INFO = &wrapper{logger: lDitto, level: INFO, prefix: iPrefix}
-	ditto.DEBUG = &wrapper{logger: lDitto, level: DEBUG, prefix: dPrefix}
+	lHawkbit := newLogger(loggerOut, hawkbitPrefix)
+	hawkbit.ERROR = &wrapper{logger: lHawkbit, component: hawkbitPrefix, level: ERROR, prefix: ePrefix}
+	hawkbit.WARN = &wrapper{logger: lHawkbit, component: hawkbitPrefix, level: WARN, prefix: wPrefix}
+	hawkbit.INFO = &wrapper{logger: lHawkbit, component: hawkbitPrefix, level: INFO, prefix: iPrefix}
+	hawkbit.DEBUG = &wrapper{logger: lHawkbit, component: hawkbitPrefix, level: DEBUG, prefix: dPrefix}
+
+	lDitto := newLogger(loggerOut, dittoPrefix)
+	ditto.ERROR = &wrapper{logger: lDitto, component: dittoPrefix, level: ERROR, prefix: ePrefix}
+	ditto.WARN = &wrapper{logger: lDitto, component: dittoPrefix, level: WARN, prefix: wPrefix}
+	ditto.INFO = &wrapper{logger: lDitto, component: dittoPrefix, level: INFO, prefix: iPrefix}
+	ditto.DEBUG = &wrapper{logger: lDitto, component: dittoPrefix, level: DEBUG, prefix: dPrefix}
 
 	return loggerOut
 }
@@ -121,7 +139,7 @@ func SetupLogger(logConfig *LogConfig) io.WriteCloser {
 // Error writes an error entry to the log.
 func Error(v interface{}) {
 	if level >= ERROR {
-		logger.Println(ePrefix, v)
+		write(logger, suPrefix, ePrefix, nil, fmt.Sprint(v))
 	}
 }
 
@@ -129,14 +147,14 @@ func Error(v interface{}) {
 // error entry to the log.
 func Errorf(format string, v ...interface{}) {
 	if level >= ERROR {
-		logger.Println(fmt.Errorf(fmt.Sprint(ePrefix, " ", format), v...))
+		write(logger, suPrefix, ePrefix, nil, fmt.Errorf(format, v...).Error())
 	}
 }
 
 // Warn writes a warning entry to the log.
 func Warn(v interface{}) {
 	if level >= WARN {
-		logger.Println(wPrefix, v)
+		write(logger, suPrefix, wPrefix, nil, fmt.Sprint(v))
 	}
 }
 
@@ -144,14 +162,14 @@ func Warn(v interface{}) {
 // warning entry to the log.
 func Warnf(format string, v ...interface{}) {
 	if level >= WARN {
-		logger.Printf(fmt.Sprint(wPrefix, " ", format), v...)
+		write(logger, suPrefix, wPrefix, nil, fmt.Sprintf(format, v...))
 	}
 }
 
 // Info writes an info entry to the log.
 func Info(v interface{}) {
 	if level >= INFO {
-		logger.Println(iPrefix, v)
+		write(logger, suPrefix, iPrefix, nil, fmt.Sprint(v))
 	}
 }
 
@@ -159,14 +177,14 @@ func Info(v interface{}) {
 // info entry to the log.
 func Infof(format string, v ...interface{}) {
 	if level >= INFO {
-		logger.Printf(fmt.Sprint(iPrefix, " ", format), v...)
+		write(logger, suPrefix, iPrefix, nil, fmt.Sprintf(format, v...))
 	}
 }
 
 // Debug writes an debug entry to the log.
 func Debug(v interface{}) {
 	if IsDebugEnabled() {
-		logger.Println(dPrefix, v)
+		write(logger, suPrefix, dPrefix, nil, fmt.Sprint(v))
 	}
 }
 
@@ -174,14 +192,14 @@ func Debug(v interface{}) {
 // debug entry to the log.
 func Debugf(format string, v ...interface{}) {
 	if IsDebugEnabled() {
-		logger.Printf(fmt.Sprint(dPrefix, " ", format), v...)
+		write(logger, suPrefix, dPrefix, nil, fmt.Sprintf(format, v...))
 	}
 }
 
 // Trace writes an trace entry to the log.
 func Trace(v ...interface{}) {
 	if IsTraceEnabled() {
-		logger.Println(tPrefix, fmt.Sprint(v...))
+		write(logger, suPrefix, tPrefix, nil, fmt.Sprint(v...))
 	}
 }
 
@@ -189,7 +207,52 @@ func Trace(v ...interface{}) {
 // trace entry to the log.
 func Tracef(format string, v ...interface{}) {
 	if IsTraceEnabled() {
-		logger.Printf(fmt.Sprint(tPrefix, " ", format), v...)
+		write(logger, suPrefix, tPrefix, nil, fmt.Sprintf(format, v...))
+	}
+}
+
+// With returns an entry, writing to the log with the given structured context.
+func With(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// Errorf formats according to a format specifier and write the string as an
+// error entry with the structured context to the log.
+func (e *Entry) Errorf(format string, v ...interface{}) {
+	if level >= ERROR {
+		write(logger, suPrefix, ePrefix, e.fields, fmt.Errorf(format, v...).Error())
+	}
+}
+
+// Warnf formats according to a format specifier and write the string as a
+// warning entry with the structured context to the log.
+func (e *Entry) Warnf(format string, v ...interface{}) {
+	if level >= WARN {
+		write(logger, suPrefix, wPrefix, e.fields, fmt.Sprintf(format, v...))
+	}
+}
+
+// Infof formats according to a format specifier and write the string as an
+// info entry with the structured context to the log.
+func (e *Entry) Infof(format string, v ...interface{}) {
+	if level >= INFO {
+		write(logger, suPrefix, iPrefix, e.fields, fmt.Sprintf(format, v...))
+	}
+}
+
+// Debugf formats according to a format specifier and write the string as a
+// debug entry with the structured context to the log.
+func (e *Entry) Debugf(format string, v ...interface{}) {
+	if IsDebugEnabled() {
+		write(logger, suPrefix, dPrefix, e.fields, fmt.Sprintf(format, v...))
+	}
+}
+
+// Tracef formats according to a format specifier and write the string as a
+// trace entry with the structured context to the log.
+func (e *Entry) Tracef(format string, v ...interface{}) {
+	if IsTraceEnabled() {
+		write(logger, suPrefix, tPrefix, e.fields, fmt.Sprintf(format, v...))
 	}
 }
 
@@ -204,21 +267,64 @@ func IsTraceEnabled() bool {
 }
 
 type wrapper struct {
-	logger *log.Logger
-	level  LogLevel
-	prefix string
+	logger    *log.Logger
+	component string
+	level     LogLevel
+	prefix    string
 }
 
 func (w *wrapper) Println(v ...interface{}) {
 	if level >= w.level {
-		w.logger.Println(w.prefix, fmt.Sprint(v...))
+		write(w.logger, w.component, w.prefix, nil, fmt.Sprint(v...))
 	}
 }
 
 func (w *wrapper) Printf(format string, v ...interface{}) {
 	if level >= w.level {
-		w.logger.Printf(fmt.Sprint(w.prefix, " ", format), v...)
+		write(w.logger, w.component, w.prefix, nil, fmt.Sprintf(format, v...))
+	}
+}
+
+// newLogger creates a logger of the given component. The JSON logger has no prefix and flags,
+// as the component and the timestamp are part of the JSON entry.
+func newLogger(out io.Writer, component string) *log.Logger {
+	if jsonFormat {
+		return log.New(out, "", 0)
+	}
+	return log.New(out, fmt.Sprintf(prefix, component), logFlags)
+}
+
+// write writes the entry of the component with the level prefix and the structured context, sorted by name,
+// to the given logger.
+func write(l *log.Logger, component string, levelPrefix string, fields Fields, msg string) {
+	if jsonFormat {
+		entry := make(map[string]string, len(fields)+4)
+		for name, value := range fields {
+			entry[name] = value
+		}
+		entry["timestamp"] = time.Now().Format(time.RFC3339Nano)
+		entry["level"] = strings.TrimSpace(levelPrefix)
+		entry["component"] = strings.Trim(component, "[]")
+		entry["message"] = strings.TrimRight(msg, "\n")
+		if data, err := json.Marshal(entry); err == nil {
+			l.Print(string(data))
+		}
+		return
+	}
+	if len(fields) == 0 {
+		l.Print(levelPrefix, " ", msg)
+		return
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	context := make([]string, len(names))
+	for i, name := range names {
+		context[i] = name + "=" + fields[name]
 	}
+	l.Print(levelPrefix, " [", strings.Join(context, " "), "] ", msg)
 }
 
 type nopWriterCloser struct {
@@ -16,11 +16,15 @@ package logger
 
 import (
 	"bufio"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 )
@@ -76,6 +80,113 @@ func TestNopWriter(t *testing.T) {
 	}
 }
 
+// TestJSONFormat tests logger functions with JSON log format.
+func TestJSONFormat(t *testing.T) {
+	// Prepare
+	dir := "_tmp-logger"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "json.log")
+	loggerOut := SetupLogger(&LogConfig{LogFile: log, LogLevel: "info", LogFormat: FormatJSON, LogFileSize: 2, LogFileCount: 5})
+	defer loggerOut.Close()
+
+	Infof("info log [%v]", "param1")
+	Debugf("debug log")
+	With(Fields{"correlationId": "test-cid", "operation": "install", "module": "test:1.0.0"}).Errorf("error log: %v", "param1")
+	hawkbit.WARN.Println("hawkbit log")
+
+	content, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("fail to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	expected := []map[string]string{
+		{"level": "INFO", "component": "SU", "message": "info log [param1]"},
+		{"level": "ERROR", "component": "SU", "message": "error log: param1",
+			"correlationId": "test-cid", "operation": "install", "module": "test:1.0.0"},
+		{"level": "WARN", "component": "Hawkbit", "message": "hawkbit log"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d log entries, got: %v", len(expected), lines)
+	}
+	for i, line := range lines {
+		entry := map[string]string{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid JSON log entry %s: %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, entry["timestamp"]); err != nil {
+			t.Errorf("invalid timestamp of log entry %s: %v", line, err)
+		}
+		delete(entry, "timestamp")
+		if !reflect.DeepEqual(expected[i], entry) {
+			t.Errorf("expected log entry %v, got: %v", expected[i], entry)
+		}
+	}
+}
+
+// TestTextFormatFields tests the structured context of text log entries.
+func TestTextFormatFields(t *testing.T) {
+	// Prepare
+	dir := "_tmp-logger"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "text.log")
+	loggerOut := SetupLogger(&LogConfig{LogFile: log, LogLevel: "INFO", LogFileSize: 2, LogFileCount: 5})
+	defer loggerOut.Close()
+
+	With(Fields{"operation": "download", "correlationId": "test-cid"}).Infof("info log")
+	if !search(log, t, suPrefix, iPrefix, "[correlationId=test-cid operation=download] info log") {
+		t.Error("info entry with structured context not found")
+	}
+}
+
+// TestLogRotation tests the log file rollover at the configured size and the pruning of the old log files.
+func TestLogRotation(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			dir := t.TempDir()
+			log := filepath.Join(dir, "rotate.log")
+			loggerOut := SetupLogger(&LogConfig{LogFile: log, LogLevel: "INFO", LogFileSize: 1, LogFileCount: 2, LogFileCompress: compress})
+			defer loggerOut.Close()
+
+			// Write about 3.5 MB, which rolls the log file over 3 times.
+			entry := strings.Repeat("x", 1024)
+			for i := 0; i < 3500; i++ {
+				Info(entry)
+			}
+
+			info, err := os.Stat(log)
+			if err != nil {
+				t.Fatalf("fail to stat log file: %v", err)
+			}
+			if info.Size() > 1024*1024 {
+				t.Errorf("log file not rolled over at the configured size: %d", info.Size())
+			}
+
+			// The old log files are pruned and compressed in background.
+			suffix := ".log"
+			if compress {
+				suffix = ".log.gz"
+			}
+			var backups []string
+			for i := 0; i < 50; i++ {
+				backups, _ = filepath.Glob(filepath.Join(dir, "rotate-*"))
+				if len(backups) == 2 && strings.HasSuffix(backups[0], suffix) && strings.HasSuffix(backups[1], suffix) {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			t.Fatalf("expected 2 old log files with suffix %s, got: %v", suffix, backups)
+		})
+	}
+}
+
 func validate(lvl string, hasError bool, hasWarn bool, hasInfo bool, hasDebug bool, hasTrace bool, t *testing.T) {
 	// Prepare
 	dir := "_tmp-logger"
@@ -0,0 +1,181 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// topicEvents is the Ditto client topic for the outbound feature status messages.
+	topicEvents = "e"
+	// topicCommandsRoot is the Ditto client root of the inbound commands and outbound responses topics.
+	topicCommandsRoot = "command"
+	// topicCommands is the Ditto client topic for the inbound commands.
+	topicCommands = topicCommandsRoot + "///req/#"
+
+	// headerContentEncoding is the Ditto header, marking the status messages with gzip compressed value.
+	headerContentEncoding = "content-encoding"
+	contentEncodingGzip   = "gzip"
+)
+
+// topics builds the MQTT topics from the hardcoded ones of the Ditto client and the edge connector,
+// applying the configured tenant prefix and Ditto events and commands topics.
+type topics struct {
+	prefix   string
+	events   string
+	commands string
+}
+
+func newTopics(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) *topics {
+	t := &topics{prefix: scriptSUPConfig.TopicPrefix, events: topicEvents, commands: topicCommandsRoot}
+	if scriptSUPConfig.EventsTopic != "" {
+		t.events = scriptSUPConfig.EventsTopic
+	}
+	if scriptSUPConfig.CommandsTopic != "" {
+		t.commands = scriptSUPConfig.CommandsTopic
+	}
+	return t
+}
+
+// name returns the configured MQTT topic of the hardcoded one.
+func (t *topics) name(topic string) string {
+	if topic == topicEvents {
+		topic = t.events
+	} else if strings.HasPrefix(topic, topicCommandsRoot+"/") {
+		topic = t.commands + strings.TrimPrefix(topic, topicCommandsRoot)
+	}
+	if t.prefix == "" {
+		return topic
+	}
+	return t.prefix + "/" + topic
+}
+
+// original returns the hardcoded topic of the configured MQTT one.
+func (t *topics) original(topic string) string {
+	if t.prefix != "" {
+		topic = strings.TrimPrefix(topic, t.prefix+"/")
+	}
+	if topic == t.events {
+		return topicEvents
+	}
+	if strings.HasPrefix(topic, t.commands+"/") {
+		return topicCommandsRoot + strings.TrimPrefix(topic, t.commands)
+	}
+	return topic
+}
+
+// dittoMQTTClient wraps the MQTT client of the Ditto client, which has hardcoded topics, QoS and retain flag,
+// to apply the configured ones: on the status messages publishing and on the commands subscription.
+// The status messages larger than the compress size, if set, are published with gzip compressed value.
+type dittoMQTTClient struct {
+	MQTT.Client
+	topics         *topics
+	statusQoS      byte
+	statusRetained bool
+	commandsQoS    byte
+	compressSize   int
+}
+
+// Publish the message, applying the configured topic, and QoS, retain flag and compression to the status messages.
+func (c *dittoMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	if topic == topicEvents {
+		qos, retained = c.statusQoS, c.statusRetained
+		if data, ok := payload.([]byte); ok && c.compressSize > 0 && len(data) > c.compressSize {
+			payload = compressValue(data)
+		}
+	}
+	return c.Client.Publish(c.topics.name(topic), qos, retained, payload)
+}
+
+// compressValue replaces the value of the Ditto message with its gzip compressed, base64 encoded form and marks it
+// with the gzip content-encoding header, so that the envelope remains routable. The message is returned unchanged,
+// if it is not a valid Ditto message with value, or the compression does not reduce its size.
+func compressValue(data []byte) []byte {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(data, &envelope); err != nil || len(envelope["value"]) == 0 {
+		return data
+	}
+	headers := map[string]interface{}{}
+	if raw, ok := envelope["headers"]; ok {
+		if err := json.Unmarshal(raw, &headers); err != nil || headers == nil {
+			return data
+		}
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(envelope["value"]); err != nil {
+		return data
+	}
+	if err := writer.Close(); err != nil {
+		return data
+	}
+	value, err := json.Marshal(base64.StdEncoding.EncodeToString(buffer.Bytes()))
+	if err != nil {
+		return data
+	}
+	headers[headerContentEncoding] = contentEncodingGzip
+	if envelope["headers"], err = json.Marshal(headers); err != nil {
+		return data
+	}
+	envelope["value"] = value
+	compressed, err := json.Marshal(envelope)
+	if err != nil || len(compressed) >= len(data) {
+		return data
+	}
+	logger.Debugf("Compressed status message from %d to %d bytes", len(data), len(compressed))
+	return compressed
+}
+
+// Subscribe for the configured topic, applying the configured QoS to the commands subscription.
+// The received messages are handled with their hardcoded topics, as expected by the Ditto client.
+func (c *dittoMQTTClient) Subscribe(topic string, qos byte, callback MQTT.MessageHandler) MQTT.Token {
+	if topic == topicCommands {
+		qos = c.commandsQoS
+	}
+	if callback == nil {
+		return c.Client.Subscribe(c.topics.name(topic), qos, nil)
+	}
+	return c.Client.Subscribe(c.topics.name(topic), qos, func(client MQTT.Client, message MQTT.Message) {
+		callback(client, &topicMessage{Message: message, topic: c.topics.original(message.Topic())})
+	})
+}
+
+// Unsubscribe from the configured topics.
+func (c *dittoMQTTClient) Unsubscribe(topics ...string) MQTT.Token {
+	names := make([]string, len(topics))
+	for i, topic := range topics {
+		names[i] = c.topics.name(topic)
+	}
+	return c.Client.Unsubscribe(names...)
+}
+
+// topicMessage replaces the topic of the received message.
+type topicMessage struct {
+	MQTT.Message
+	topic string
+}
+
+// Topic returns the replaced topic.
+func (m *topicMessage) Topic() string {
+	return m.topic
+}
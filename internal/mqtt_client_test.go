@@ -0,0 +1,328 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse/ditto-clients-golang"
+	"github.com/eclipse/ditto-clients-golang/model"
+	"github.com/eclipse/ditto-clients-golang/protocol"
+	"github.com/eclipse/ditto-clients-golang/protocol/things"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TestQoSClient tests that the configured QoS and retain flag are applied to the Ditto client messages.
+func TestQoSClient(t *testing.T) {
+	rc := &recordingClient{mockedClient: mockMqttClient(&testConfig{clientConnected: true})}
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	client := &dittoMQTTClient{Client: rc, topics: newTopics(&cfg), statusQoS: 2, statusRetained: true, commandsQoS: 0}
+
+	// 1. Subscribe for the commands with the configured QoS.
+	dc, err := ditto.NewClientMqtt(client, ditto.NewConfiguration())
+	if err != nil {
+		t.Fatalf("failed to create ditto client: %v", err)
+	}
+	if err := dc.Connect(); err != nil {
+		t.Fatalf("failed to connect ditto client: %v", err)
+	}
+	if rc.topic != topicCommands || rc.qos != 0 {
+		t.Fatalf("unexpected commands subscription: %s[%d]", rc.topic, rc.qos)
+	}
+
+	// 2. Publish the status messages with the configured QoS and retain flag.
+	su, err := hawkbit.NewSoftwareUpdatable(hawkbit.NewConfiguration().WithDittoClient(dc).
+		WithThingID(model.NewNamespacedID(testTopicNamespace, testTopicEntryID)).WithSoftwareType(testType))
+	if err != nil {
+		t.Fatalf("failed to create software updatable: %v", err)
+	}
+	if err := su.Activate(); err != nil {
+		t.Fatalf("failed to activate software updatable: %v", err)
+	}
+	if rc.topic != topicEvents || rc.qos != 2 || !rc.retained {
+		t.Fatalf("unexpected status message publish: %s[%d, %v]", rc.topic, rc.qos, rc.retained)
+	}
+
+	// 3. Do not change other topics.
+	client.Publish("other", 1, false, nil)
+	if rc.topic != "other" || rc.qos != 1 || rc.retained {
+		t.Fatalf("unexpected message publish: %s[%d, %v]", rc.topic, rc.qos, rc.retained)
+	}
+	client.Subscribe("other", 1, nil)
+	if rc.topic != "other" || rc.qos != 1 {
+		t.Fatalf("unexpected subscription: %s[%d]", rc.topic, rc.qos)
+	}
+}
+
+// TestStatusCompression tests that only the status messages larger than the compress size are compressed.
+func TestStatusCompression(t *testing.T) {
+	rc := &recordingClient{mockedClient: mockMqttClient(&testConfig{clientConnected: true})}
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	client := &dittoMQTTClient{Client: rc, topics: newTopics(&cfg), statusQoS: 1, compressSize: 2048}
+	dc, err := ditto.NewClientMqtt(client, ditto.NewConfiguration())
+	if err != nil {
+		t.Fatalf("failed to create ditto client: %v", err)
+	}
+	if err := dc.Connect(); err != nil {
+		t.Fatalf("failed to connect ditto client: %v", err)
+	}
+	su, err := hawkbit.NewSoftwareUpdatable(hawkbit.NewConfiguration().WithDittoClient(dc).
+		WithThingID(model.NewNamespacedID(testTopicNamespace, testTopicEntryID)).WithSoftwareType(testType))
+	if err != nil {
+		t.Fatalf("failed to create software updatable: %v", err)
+	}
+	if err := su.Activate(); err != nil {
+		t.Fatalf("failed to activate software updatable: %v", err)
+	}
+
+	// 1. Compress the value of the large status message and mark it with the content-encoding header.
+	tail := strings.Repeat("install.sh: extracting files...\n", 200)
+	su.SetLastOperation((&hawkbit.OperationStatus{CorrelationID: testCid, Status: hawkbit.StatusFinishedError}).
+		WithMessage(tail))
+	if size := len(rc.payload.([]byte)); size >= len(tail) {
+		t.Fatalf("status message is not compressed: %d bytes", size)
+	}
+	envelope := assertStatusMessage(t, rc, true)
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(envelope.Value.([]byte), &value); err != nil {
+		t.Fatalf("failed to parse decompressed value: %v", err)
+	}
+	if value[messageParam] != tail {
+		t.Fatalf("unexpected decompressed message: %v", value[messageParam])
+	}
+
+	// 2. Do not compress the small status message.
+	su.SetLastOperation((&hawkbit.OperationStatus{CorrelationID: testCid, Status: hawkbit.StatusFinishedSuccess}).
+		WithMessage("installed"))
+	envelope = assertStatusMessage(t, rc, false)
+	if lo := envelope.Value.(map[string]interface{}); lo[messageParam] != "installed" {
+		t.Fatalf("unexpected status message value: %v", lo)
+	}
+}
+
+// assertStatusMessage asserts the compression of the last published status message and returns it,
+// with the decompressed value bytes, if compressed, or the parsed value otherwise.
+func assertStatusMessage(t *testing.T, rc *recordingClient, compressed bool) *protocol.Envelope {
+	t.Helper()
+
+	if rc.topic != topicEvents {
+		t.Fatalf("unexpected status message topic: %s", rc.topic)
+	}
+	envelope := &protocol.Envelope{}
+	if err := json.Unmarshal(rc.payload.([]byte), envelope); err != nil {
+		t.Fatalf("failed to parse status message: %v", err)
+	}
+	encoding := envelope.Headers.Generic(headerContentEncoding)
+	if !compressed {
+		if encoding != nil {
+			t.Fatalf("unexpected content-encoding header: %v", encoding)
+		}
+		return envelope
+	}
+	if encoding != contentEncodingGzip {
+		t.Fatalf("unexpected content-encoding header: %v", encoding)
+	}
+	data, err := base64.StdEncoding.DecodeString(envelope.Value.(string))
+	if err != nil {
+		t.Fatalf("failed to decode compressed value: %v", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decompress value: %v", err)
+	}
+	if envelope.Value, err = io.ReadAll(reader); err != nil {
+		t.Fatalf("failed to decompress value: %v", err)
+	}
+	return envelope
+}
+
+// TestTopics tests that the Ditto client and edge topics are built from the configured namespace.
+func TestTopics(t *testing.T) {
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	tests := map[string]struct {
+		prefix   string
+		events   string
+		commands string
+		expected map[string]string
+	}{
+		"default": {
+			prefix: defaultTopicPrefix, events: defaultEventsTopic, commands: defaultCommandsTopic,
+			expected: map[string]string{
+				topicEvents:                    "e",
+				topicCommands:                  "command///req/#",
+				"command///res/request-id/204": "command///res/request-id/204",
+				topic:                          "edge/thing/response",
+				topicConnection:                "edge/software-update/connection",
+			},
+		},
+		"custom": {
+			prefix: "tenants/my-tenant", events: "events", commands: "commands",
+			expected: map[string]string{
+				topicEvents:                    "tenants/my-tenant/events",
+				topicCommands:                  "tenants/my-tenant/commands///req/#",
+				"command///res/request-id/204": "tenants/my-tenant/commands///res/request-id/204",
+				topic:                          "tenants/my-tenant/edge/thing/response",
+				topicConnection:                "tenants/my-tenant/edge/software-update/connection",
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg.TopicPrefix, cfg.EventsTopic, cfg.CommandsTopic = test.prefix, test.events, test.commands
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("unexpected configuration error: %v", err)
+			}
+			topics := newTopics(&cfg)
+			for original, expected := range test.expected {
+				if actual := topics.name(original); actual != expected {
+					t.Errorf("unexpected topic of %s: %s != %s", original, actual, expected)
+				}
+				if actual := topics.original(expected); actual != original {
+					t.Errorf("unexpected original topic of %s: %s != %s", expected, actual, original)
+				}
+			}
+		})
+	}
+}
+
+// TestTopicsCommands tests that the commands are received and responded on the configured topics.
+func TestTopicsCommands(t *testing.T) {
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	cfg.TopicPrefix, cfg.CommandsTopic = "tenants/my-tenant", "commands"
+	rc := &recordingClient{mockedClient: mockMqttClient(&testConfig{clientConnected: true})}
+	client := &dittoMQTTClient{Client: rc, topics: newTopics(&cfg), commandsQoS: 1}
+
+	// 1. Subscribe for the commands on the configured topic.
+	dc, err := ditto.NewClientMqtt(client, ditto.NewConfiguration())
+	if err != nil {
+		t.Fatalf("failed to create ditto client: %v", err)
+	}
+	received := make(chan string, 1)
+	dc.Subscribe(func(requestID string, msg *protocol.Envelope) {
+		received <- requestID
+	})
+	if err := dc.Connect(); err != nil {
+		t.Fatalf("failed to connect ditto client: %v", err)
+	}
+	if rc.topic != "tenants/my-tenant/commands///req/#" {
+		t.Fatalf("unexpected commands subscription: %s", rc.topic)
+	}
+
+	// 2. Receive the command with its request identifier.
+	msg := things.NewMessage(model.NewNamespacedID(testTopicNamespace, testTopicEntryID)).Feature("SoftwareUpdatable").
+		Inbox("install").Envelope(protocol.WithCorrelationID("correlation-id"))
+	payload, _ := json.Marshal(msg)
+	rc.callback(rc, &testMessage{topic: "tenants/my-tenant/commands///req/request-id/install", payload: payload})
+	select {
+	case requestID := <-received:
+		if requestID != "request-id" {
+			t.Fatalf("unexpected command request identifier: %s", requestID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command not received")
+	}
+
+	// 3. Respond on the configured topic.
+	if err := dc.Reply("request-id", msg); err != nil {
+		t.Fatalf("failed to reply: %v", err)
+	}
+	if rc.topic != "tenants/my-tenant/commands///res/request-id/0" {
+		t.Fatalf("unexpected command response topic: %s", rc.topic)
+	}
+}
+
+// TestInvalidTopics tests that invalid topic configurations are rejected.
+func TestInvalidTopics(t *testing.T) {
+	for name, update := range map[string]func(cfg *ScriptBasedSoftwareUpdatableConfig){
+		"prefixWildcard":   func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.TopicPrefix = "tenants/+" },
+		"prefixSlash":      func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.TopicPrefix = "tenants/" },
+		"emptyEvents":      func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.EventsTopic = "" },
+		"multiLevelEvents": func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.EventsTopic = "events/status" },
+		"wildcardCommands": func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.CommandsTopic = "#" },
+		"invalidNamespace": func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.ThingNamespace = "my namespace" },
+		"namespaceWithThing": func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+			cfg.ThingNamespace, cfg.ThingID = "my.namespace", "my.namespace:thing"
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+			update(&cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expecting error when validating invalid topic configuration")
+			}
+		})
+	}
+}
+
+// recordingClient records the last published message or subscription QoS and retain flag.
+type recordingClient struct {
+	*mockedClient
+	topic    string
+	qos      byte
+	retained bool
+	payload  interface{}
+	callback mqtt.MessageHandler
+}
+
+func (client *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	client.topic, client.qos, client.retained, client.payload = topic, qos, retained, payload
+	return &mockedToken{}
+}
+
+func (client *recordingClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	client.topic, client.qos, client.retained, client.callback = topic, qos, false, callback
+	return &mockedToken{}
+}
+
+// testMessage is a received MQTT message.
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *testMessage) Duplicate() bool {
+	return false
+}
+
+func (m *testMessage) Qos() byte {
+	return 1
+}
+
+func (m *testMessage) Retained() bool {
+	return false
+}
+
+func (m *testMessage) Topic() string {
+	return m.topic
+}
+
+func (m *testMessage) MessageID() uint16 {
+	return 0
+}
+
+func (m *testMessage) Payload() []byte {
+	return m.payload
+}
+
+func (m *testMessage) Ack() {
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretMask       = "******"
+)
+
+// secret is a credential of the configuration, which can be given as a secret reference.
+type secret struct {
+	name  string
+	value *string
+}
+
+// secrets returns the credentials of the configuration.
+func (scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) secrets() []secret {
+	return []secret{
+		{name: "password", value: &scriptSUPConfig.Password},
+		{name: "key passphrase", value: &scriptSUPConfig.KeyPassphrase},
+		{name: "server token", value: &scriptSUPConfig.ServerToken},
+		{name: "SFTP password", value: &scriptSUPConfig.SFTPPassword},
+	}
+}
+
+// resolveSecrets replaces the secret references of the credentials with the referenced secrets.
+func (scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) resolveSecrets() error {
+	for _, s := range scriptSUPConfig.secrets() {
+		value, err := resolveSecret(s.name, *s.value)
+		if err != nil {
+			return err
+		}
+		*s.value = value
+	}
+	return nil
+}
+
+// maskSecrets returns a copy of the configuration with masked credentials, safe to be logged.
+func maskSecrets(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) ScriptBasedSoftwareUpdatableConfig {
+	masked := *scriptSUPConfig
+	for _, s := range masked.secrets() {
+		if *s.value != "" {
+			*s.value = secretMask
+		}
+	}
+	return masked
+}
+
+// resolveSecret returns the secret, referenced as "env:VARIABLE" environment variable or "file:/path" file
+// with trailing line breaks trimmed. Values without reference prefix are returned as they are.
+func resolveSecret(name string, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		variable := strings.TrimPrefix(value, secretEnvPrefix)
+		secret, ok := os.LookupEnv(variable)
+		if !ok {
+			return "", fmt.Errorf("%s environment variable %s is not set", name, variable)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretFilePrefix):
+		file := strings.TrimPrefix(value, secretFilePrefix)
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("%s file cannot be read: %v", name, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecretEnv = "SOFTWARE_UPDATE_TEST_SECRET"
+
+// TestResolveSecrets tests resolving of the credentials from environment variables and files.
+func TestResolveSecrets(t *testing.T) {
+	os.Setenv(testSecretEnv, "env-secret")
+	defer os.Unsetenv(testSecretEnv)
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret file: %v", err)
+	}
+
+	cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+	cfg.Password = secretEnvPrefix + testSecretEnv
+	cfg.KeyPassphrase = secretFilePrefix + secretFile
+	cfg.ServerToken = "plain-secret"
+	if err := cfg.resolveSecrets(); err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	assertString(t, cfg.Password, "env-secret")
+	assertString(t, cfg.KeyPassphrase, "file-secret")
+	assertString(t, cfg.ServerToken, "plain-secret")
+
+	masked := fmt.Sprintf("%+v", maskSecrets(&cfg))
+	for _, secret := range []string{"env-secret", "file-secret", "plain-secret"} {
+		if strings.Contains(masked, secret) {
+			t.Errorf("secret %s not masked: %s", secret, masked)
+		}
+	}
+}
+
+// TestResolveMissingSecrets tests that missing secrets fail the startup.
+func TestResolveMissingSecrets(t *testing.T) {
+	os.Unsetenv(testSecretEnv)
+	missingFile := filepath.Join(t.TempDir(), "missing")
+
+	tests := map[string]struct {
+		configure func(cfg *ScriptBasedSoftwareUpdatableConfig)
+		expected  string
+	}{
+		"missingEnv": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.Password = secretEnvPrefix + testSecretEnv },
+			expected:  "password environment variable " + testSecretEnv + " is not set",
+		},
+		"missingFile": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.ServerToken = secretFilePrefix + missingFile },
+			expected:  "server token file cannot be read",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+			cfg.StorageLocation = t.TempDir()
+			test.configure(&cfg)
+
+			edge, err := InitScriptBasedSU(&cfg)
+			if err == nil {
+				edge.Close()
+				t.Fatal("expected startup to fail")
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected error containing %q, got: %v", test.expected, err)
+			}
+		})
+	}
+}
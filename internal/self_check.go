@@ -0,0 +1,178 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/storage"
+	"github.com/eclipse-kanto/software-update/util/tls"
+)
+
+const (
+	// selfCheckMinFreeSpace is the minimal free space in bytes, required in the storage location.
+	selfCheckMinFreeSpace = 1 << 20
+	// selfCheckDialTimeout is the timeout to reach the MQTT broker.
+	selfCheckDialTimeout = 5 * time.Second
+)
+
+// Check verifies that the configuration can be applied in the current environment: the secret references
+// can be resolved, the storage location is writable and has free space, the certificate and key files can
+// be loaded, the install commands are executable and the MQTT broker is reachable. All found problems are
+// returned at once.
+func (scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) Check() []error {
+	var errs []error
+	if err := scriptSUPConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	resolved := *scriptSUPConfig
+	for _, s := range resolved.secrets() {
+		value, err := resolveSecret(s.name, *s.value)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*s.value = value
+	}
+	if err := checkStorage(scriptSUPConfig.StorageLocation, storageMode(scriptSUPConfig.StorageMode)); err != nil {
+		errs = append(errs, err)
+	} else if err := storage.CheckLocationMode(scriptSUPConfig.StorageLocation); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, checkBroker(&resolved)...)
+	if scriptSUPConfig.ServerCert != "" {
+		if _, err := tls.NewConfig(&tls.Config{CACert: scriptSUPConfig.ServerCert}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid artifacts download server certificate: %v", err))
+		}
+	}
+	if _, err := loadManifestKey(scriptSUPConfig.ManifestKey); err != nil {
+		errs = append(errs, err)
+	}
+	if scriptSUPConfig.InsecureSkipVerify {
+		errs = append(errs, fmt.Errorf("insecure artifacts download: the server certificate verification is disabled"))
+	}
+	if scriptSUPConfig.SFTPKnownHosts != "" || scriptSUPConfig.SFTPKey != "" {
+		if err := (storage.SFTPConfig{KnownHosts: scriptSUPConfig.SFTPKnownHosts, Key: scriptSUPConfig.SFTPKey}).Check(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid SFTP artifacts download configuration: %v", err))
+		}
+	}
+	allowed := commandAllowList(scriptSUPConfig.CommandAllowList)
+	if err := checkCommand("install command", &scriptSUPConfig.InstallCommand, allowed); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkCommand("scan command", &scriptSUPConfig.ScanCommand, allowed); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkCommand("health command", &scriptSUPConfig.HealthCommand, allowed); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkCommand("version command", &scriptSUPConfig.VersionCommand, allowed); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkCommand("continue command", &scriptSUPConfig.ContinueCommand, allowed); err != nil {
+		errs = append(errs, err)
+	}
+	types := make([]string, 0, len(scriptSUPConfig.InstallCommands))
+	for artifactType := range scriptSUPConfig.InstallCommands {
+		types = append(types, artifactType)
+	}
+	sort.Strings(types)
+	for _, artifactType := range types {
+		cmd := scriptSUPConfig.InstallCommands[artifactType]
+		if err := checkCommand(fmt.Sprintf("install command for artifact type %s", artifactType), &cmd, allowed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// checkStorage verifies that the storage location is writable and has free space.
+func checkStorage(location string, mode os.FileMode) error {
+	if err := storage.InitLocation(location, mode); err != nil {
+		return err
+	}
+	free, err := storage.OSFileSystem{}.Statfs(location)
+	if err != nil {
+		return fmt.Errorf("fail to determine free space of storage location %s: %v", location, err)
+	}
+	if free < selfCheckMinFreeSpace {
+		return fmt.Errorf("storage location %s has insufficient free space: %d bytes", location, free)
+	}
+	return nil
+}
+
+// checkBroker verifies that the MQTT broker TLS configuration can be loaded and the broker is reachable.
+func checkBroker(scriptSUPConfig *ScriptBasedSoftwareUpdatableConfig) []error {
+	var errs []error
+	u, err := url.Parse(scriptSUPConfig.Broker)
+	if err != nil {
+		return []error{fmt.Errorf("invalid MQTT broker address %s: %v", scriptSUPConfig.Broker, err)}
+	}
+	secure := isConnectionSecure(u.Scheme)
+	if secure || scriptSUPConfig.CACert != "" || scriptSUPConfig.Cert != "" || scriptSUPConfig.Key != "" {
+		if _, err := tls.NewConfig(&tls.Config{
+			CACert:        scriptSUPConfig.CACert,
+			Cert:          scriptSUPConfig.Cert,
+			Key:           scriptSUPConfig.Key,
+			KeyPassphrase: scriptSUPConfig.KeyPassphrase,
+			MinVersion:    scriptSUPConfig.TLSMinVersion,
+			PinnedKeys:    scriptSUPConfig.TLSPinnedKeys,
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid MQTT broker TLS configuration: %v", err))
+		}
+	}
+	address := u.Host
+	if u.Port() == "" {
+		if secure {
+			address = net.JoinHostPort(u.Hostname(), "8883")
+		} else {
+			address = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	}
+	conn, err := net.DialTimeout("tcp", address, selfCheckDialTimeout)
+	if err != nil {
+		return append(errs, fmt.Errorf("MQTT broker %s is not reachable: %v", scriptSUPConfig.Broker, err))
+	}
+	conn.Close()
+	return errs
+}
+
+// checkCommand verifies that the command exists, is executable and is allowed. Relative scripts are run in
+// the module directory and cannot be verified in advance.
+func checkCommand(name string, cmd *command, allowed commandAllowList) error {
+	if cmd.cmd == "" {
+		return nil
+	}
+	if _, err := exec.LookPath(cmd.cmd); err != nil {
+		return fmt.Errorf("%s %s is not executable: %v", name, cmd.cmd, err)
+	}
+	if len(cmd.args) > 0 && isShell(cmd.cmd) && filepath.IsAbs(cmd.args[0]) {
+		if info, err := os.Stat(cmd.args[0]); err != nil {
+			return fmt.Errorf("%s script %s is not available: %v", name, cmd.args[0], err)
+		} else if info.IsDir() {
+			return fmt.Errorf("%s script %s is a directory", name, cmd.args[0])
+		}
+		if err := allowed.check(cmd.cmd, cmd.args, ""); err != nil {
+			return fmt.Errorf("%s rejected - %v", name, err)
+		}
+	} else if _, err := allowed.checkExecutable(cmd.cmd, ""); err != nil {
+		return fmt.Errorf("%s rejected - %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestSelfCheck tests the configuration self-check with valid configuration and individual misconfigurations.
+func TestSelfCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install commands are shell commands")
+	}
+	dir := assertDirs(t, "_tmp-self-check", true)
+	defer os.RemoveAll(dir)
+	dir = getAbsolutePath(t, dir)
+
+	certs := newTestCertificates(t, dir)
+	notPEM := filepath.Join(dir, "not-pem.crt")
+	notExecutable := filepath.Join(dir, "install")
+	storageFile := filepath.Join(dir, "storage-file")
+	for _, file := range []string{notPEM, notExecutable, storageFile} {
+		if err := os.WriteFile(file, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+
+	worldWritable := filepath.Join(dir, "world-writable")
+	if err := os.Mkdir(worldWritable, 0755); err != nil || os.Chmod(worldWritable, 0777) != nil {
+		t.Fatalf("failed to create %s: %v", worldWritable, err)
+	}
+
+	broker, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to start test broker: %v", err)
+	}
+	defer broker.Close()
+	closed, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to reserve test port: %v", err)
+	}
+	closed.Close()
+
+	tests := map[string]struct {
+		configure func(cfg *ScriptBasedSoftwareUpdatableConfig)
+		expected  []string
+	}{
+		"valid": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.Broker = "ssl://" + broker.Addr().String()
+				cfg.CACert = filepath.Dir(certs.caCert)
+				cfg.Cert = certs.clientCert
+				cfg.Key = certs.clientKey
+				cfg.ServerCert = certs.caCert
+				cfg.InstallCommand.setCommand("/bin/sh")
+				cfg.InstallCommands = installCommands{"deb": command{cmd: "/bin/sh", args: []string{"-c", "true"}}}
+			},
+		},
+		"invalidConfig": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.StatusQoS = 3 },
+			expected:  []string{"invalid status QoS value"},
+		},
+		"invalidStorageMode": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.StorageMode = "0789" },
+			expected:  []string{"invalid storage mode value - (0789)"},
+		},
+		"commandNotAllowed": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.InstallCommand.setCommand("/bin/sh")
+				cfg.CommandAllowList = []string{"/usr/local/bin"}
+			},
+			expected: []string{"install command rejected - command is not allowed: /bin/sh"},
+		},
+		"storageNotDirectory": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.StorageLocation = storageFile },
+			expected:  []string{"storage location " + storageFile + " cannot be created"},
+		},
+		"storageWorldWritable": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.StorageLocation = worldWritable },
+			expected:  []string{"storage location " + worldWritable + " is world-writable"},
+		},
+		"brokerNotReachable": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.Broker = "tcp://" + closed.Addr().String() },
+			expected:  []string{"is not reachable"},
+		},
+		"invalidCACert": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.Broker = "ssl://" + broker.Addr().String()
+				cfg.CACert = notPEM
+			},
+			expected: []string{"invalid MQTT broker TLS configuration"},
+		},
+		"missingKey": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.Cert = certs.clientCert
+				cfg.Key = filepath.Join(dir, "missing.key")
+			},
+			expected: []string{"invalid MQTT broker TLS configuration: failed to load X509 key pair"},
+		},
+		"invalidServerCert": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.ServerCert = notPEM },
+			expected:  []string{"invalid artifacts download server certificate"},
+		},
+		"invalidManifestKey": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.ManifestKey = notPEM },
+			expected:  []string{"invalid module manifest key"},
+		},
+		"insecureSkipVerify": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.InsecureSkipVerify = true },
+			expected:  []string{"insecure artifacts download"},
+		},
+		"installNotExecutable": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) { cfg.InstallCommand.setCommand(notExecutable) },
+			expected:  []string{"install command " + notExecutable + " is not executable"},
+		},
+		"installScriptMissing": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.InstallCommand.setCommand(filepath.Join(dir, "missing.sh"))
+			},
+			expected: []string{"install command script " + filepath.Join(dir, "missing.sh") + " is not available"},
+		},
+		"mappedInstallMissing": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.InstallCommands = installCommands{"deb": command{cmd: filepath.Join(dir, "missing")}}
+			},
+			expected: []string{"install command for artifact type deb"},
+		},
+		"allProblems": {
+			configure: func(cfg *ScriptBasedSoftwareUpdatableConfig) {
+				cfg.StorageLocation = storageFile
+				cfg.Broker = "ssl://" + closed.Addr().String()
+				cfg.CACert = notPEM
+				cfg.InstallCommand.setCommand(notExecutable)
+			},
+			expected: []string{"cannot be created", "invalid MQTT broker TLS configuration", "is not reachable", "is not executable"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := NewDefaultConfig().ScriptBasedSoftwareUpdatableConfig
+			cfg.Broker = "tcp://" + broker.Addr().String()
+			cfg.StorageLocation = filepath.Join(dir, "storage")
+			test.configure(&cfg)
+
+			errs := cfg.Check()
+			if len(errs) != len(test.expected) {
+				t.Fatalf("expected %d problems, got: %v", len(test.expected), errs)
+			}
+			for i, expected := range test.expected {
+				if !strings.Contains(errs[i].Error(), expected) {
+					t.Errorf("expected problem containing %q, got: %v", expected, errs[i])
+				}
+			}
+		})
+	}
+}
@@ -15,10 +15,12 @@ package feature
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 	"github.com/eclipse-kanto/software-update/internal/logger"
@@ -27,20 +29,47 @@ import (
 
 const nStatus = "status"
 
+// progressPrefix is the prefix of the progress lines, printed by the install scripts to their output,
+// i.e. "PROGRESS: <0-100> [message]".
+const progressPrefix = "PROGRESS:"
+
+// maxProgressLine is the maximal length of the progress lines. Longer output lines are not parsed.
+const maxProgressLine = 4096
+
 type monitor struct {
 	cid    string
 	module *hawkbit.SoftwareModuleID
 	status hawkbit.Status
 	su     *hawkbit.SoftwareUpdatable
 
+	lock          sync.Mutex
 	oldProgress   int
 	oldMessage    string
 	oldStatusCode string
 }
 
 func (o *monitor) update(name string) {
-	p, m, c, done := load(name)
-	if done && (p > o.oldProgress || m != o.oldMessage || c != o.oldStatusCode) {
+	if p, m, c, done := load(name); done {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+		o.send(p, m, c)
+	}
+}
+
+// progress reports the progress, printed by the install script. The last message and status code are kept,
+// if the progress line has no message.
+func (o *monitor) progress(p int, m string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if m == "" {
+		m = o.oldMessage
+	}
+	o.send(p, m, o.oldStatusCode)
+}
+
+// send sends the last operation status, if changed. It must be called with the monitor lock held.
+func (o *monitor) send(p int, m string, c string) {
+	if p > o.oldProgress || m != o.oldMessage || c != o.oldStatusCode {
 		o.oldProgress = p
 		o.oldMessage = m
 		o.oldStatusCode = c
@@ -52,6 +81,12 @@ func (o *monitor) update(name string) {
 	}
 }
 
+// output returns the writer of the install script output, which reports its progress lines and forwards
+// the output to the given writer, if any.
+func (o *monitor) output(next io.Writer) io.Writer {
+	return &progressWriter{next: next, monitor: o}
+}
+
 func (o *monitor) waitFor(dir string) (chan bool, error) {
 	o.oldProgress = -1
 	file := filepath.Join(dir, nStatus)
@@ -167,3 +202,62 @@ func trim(data []byte) []byte {
 	}
 	return data
 }
+
+// progressWriter parses the progress lines of the install script output and reports them to its monitor.
+// The output is forwarded unchanged to the next writer, if any.
+type progressWriter struct {
+	next    io.Writer
+	monitor *monitor
+	line    []byte
+	long    bool
+}
+
+func (w *progressWriter) Write(data []byte) (int, error) {
+	for rest := data; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			w.append(rest)
+			break
+		}
+		w.append(rest[:i])
+		if !w.long {
+			if p, m, ok := parseProgressLine(string(trim(w.line))); ok {
+				w.monitor.progress(p, m)
+			}
+		}
+		w.line, w.long = w.line[:0], false
+		rest = rest[i+1:]
+	}
+	if w.next != nil {
+		return w.next.Write(data)
+	}
+	return len(data), nil
+}
+
+// append appends the data to the current line, skipping the lines longer than a progress line.
+func (w *progressWriter) append(data []byte) {
+	if w.long || len(w.line)+len(data) > maxProgressLine {
+		w.line, w.long = w.line[:0], true
+		return
+	}
+	w.line = append(w.line, data...)
+}
+
+// parseProgressLine returns the progress and the optional message of a progress line. Other and malformed
+// progress lines, e.g. with progress out of the range [0, 100], are ignored.
+func parseProgressLine(ln string) (p int, m string, ok bool) {
+	ln = strings.TrimSpace(ln)
+	if !strings.HasPrefix(ln, progressPrefix) {
+		return 0, "", false
+	}
+	ss := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(ln, progressPrefix)), " ", 2)
+	p, err := strconv.Atoi(ss[0])
+	if err != nil || p < 0 || p > 100 {
+		logger.Debugf("ignoring malformed progress line [%s], progress must be a number with range [0, 100]", ln)
+		return 0, "", false
+	}
+	if len(ss) == 2 {
+		m = strings.TrimSpace(ss[1])
+	}
+	return p, m, true
+}
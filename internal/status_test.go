@@ -15,9 +15,11 @@
 package feature
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -221,3 +223,61 @@ func assertEqualsMonitor(ep int, em string, esc string, ap int,
 			ep, em, esc, ap, am, asc)
 	}
 }
+
+// TestParseProgressLine tests the parsing of the valid and malformed progress lines of the install scripts.
+func TestParseProgressLine(t *testing.T) {
+	tests := map[string]struct {
+		line     string
+		progress int
+		message  string
+		ok       bool
+	}{
+		"progress":       {line: "PROGRESS: 40", progress: 40, ok: true},
+		"message":        {line: "PROGRESS: 75 Configuring services ", progress: 75, message: "Configuring services", ok: true},
+		"no-space":       {line: "PROGRESS:5", progress: 5, ok: true},
+		"leading-spaces": {line: "  PROGRESS: 100\r", progress: 100, ok: true},
+		"out-of-range":   {line: "PROGRESS: 101"},
+		"negative":       {line: "PROGRESS: -1"},
+		"not-a-number":   {line: "PROGRESS: half"},
+		"empty":          {line: "PROGRESS:"},
+		"lower-case":     {line: "progress: 40"},
+		"other-output":   {line: "Unpacking 40 files"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			progress, message, ok := parseProgressLine(test.line)
+			if ok != test.ok || progress != test.progress || message != test.message {
+				t.Fatalf("expected %v, %d, %q, got %v, %d, %q", test.ok, test.progress, test.message, ok, progress, message)
+			}
+		})
+	}
+}
+
+// TestProgressWriter tests that the progress lines, split between writes, are reported and the output is forwarded.
+func TestProgressWriter(t *testing.T) {
+	su, _ := mockSoftwareUpdatable(t, hawkbit.NewConfiguration(), &testConfig{clientConnected: true})
+	monitor := &monitor{
+		status:      hawkbit.StatusInstalling,
+		su:          su,
+		cid:         testCid,
+		module:      &hawkbit.SoftwareModuleID{Name: testModuleName, Version: testModuleVersion},
+		oldProgress: -1,
+	}
+	var output bytes.Buffer
+	w := monitor.output(&output)
+	// The progress line is split between writes, the out-of-range and the too long lines are ignored.
+	writes := []string{"step 1\nPROGRESS: 2", "0 Unpacking\nPROGRESS: 500\n", strings.Repeat("x", maxProgressLine), "PROGRESS: 90\n"}
+	for _, data := range writes {
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatalf("failed to write output: %v", err)
+		}
+	}
+	assertEqualsMonitor(20, "Unpacking", "", monitor.oldProgress, monitor.oldMessage, monitor.oldStatusCode, t)
+	if output.String() != strings.Join(writes, "") {
+		t.Fatalf("install output not forwarded unchanged: %q", output.String())
+	}
+
+	// The last message is kept for the progress lines without message.
+	w.Write([]byte("PROGRESS: 60\n"))
+	assertEqualsMonitor(60, "Unpacking", "", monitor.oldProgress, monitor.oldMessage, monitor.oldStatusCode, t)
+}
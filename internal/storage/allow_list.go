@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// allowEntry is a parsed download allow list entry.
+type allowEntry struct {
+	scheme string
+	host   string
+	port   string
+}
+
+// ValidateAllowList verifies the download allow list entries. Each entry is in the form [scheme://]host[:port],
+// where the host can be "*" for any host or start with "*." for all subdomains of a domain. Any scheme and port
+// are allowed, if not given.
+func ValidateAllowList(entries []string) error {
+	for _, entry := range entries {
+		if _, err := parseAllowEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseAllowEntry(entry string) (*allowEntry, error) {
+	parsed := &allowEntry{host: strings.ToLower(entry)}
+	if i := strings.Index(parsed.host, "://"); i >= 0 {
+		parsed.scheme, parsed.host = parsed.host[:i], parsed.host[i+3:]
+	}
+	if host, port, err := net.SplitHostPort(parsed.host); err == nil {
+		parsed.host, parsed.port = host, port
+	}
+	wildcard := strings.TrimPrefix(parsed.host, "*.")
+	if parsed.host == "" || wildcard == "" || strings.ContainsAny(parsed.host, "/?#@") ||
+		(parsed.host != "*" && strings.Contains(wildcard, "*")) {
+		return nil, fmt.Errorf("invalid download allow list entry - %s, must be in the form [scheme://]host[:port]", entry)
+	}
+	return parsed, nil
+}
+
+// matches reports whether the given link scheme, host name and port are allowed by the entry.
+func (e *allowEntry) matches(scheme, host, port string) bool {
+	if e.scheme != "" && e.scheme != scheme {
+		return false
+	}
+	if e.port != "" && e.port != port {
+		return false
+	}
+	if e.host == "*" {
+		return true
+	}
+	if strings.HasPrefix(e.host, "*.") {
+		return strings.HasSuffix(host, e.host[1:])
+	}
+	return e.host == host
+}
+
+// checkLink verifies that the scheme and the host of the download link are allowed. All links are allowed,
+// if the allow list is empty.
+func checkLink(link string, allowList []string) error {
+	if len(allowList) == 0 {
+		return nil
+	}
+	u, err := url.Parse(link)
+	if err == nil && u.Hostname() != "" {
+		scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+		if port == "" {
+			port = defaultPort(scheme)
+		}
+		for _, entry := range allowList {
+			if parsed, err := parseAllowEntry(entry); err == nil && parsed.matches(scheme, host, port) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrLinkNotAllowed, RedactLink(link))
+}
+
+// checkLinks verifies that all module artifacts, downloaded from a server, are allowed by the download allow list
+// of the server configuration. The links are checked, as rewritten by its RewriteLink hook.
+func checkLinks(module *Module, server ServerConfig) error {
+	for _, sa := range module.Artifacts {
+		if sa.Local {
+			continue
+		}
+		link := rewrite(sa, server).Link
+		if err := checkLink(link, server.AllowList); err != nil {
+			logger.Errorf("link of artifact [%s] is not in the download allow list, rejecting the module: %s", sa.FileName, RedactLink(link))
+			return err
+		}
+	}
+	return nil
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http":
+		return "80"
+	case "https", schemeOCI:
+		return "443"
+	case schemeSFTP:
+		return sftpDefaultPort
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestCheckLink tests the allowed and disallowed schemes and hosts of the download links.
+func TestCheckLink(t *testing.T) {
+	allowList := []string{"https://updates.example.com", "*.cdn.example.com", "http://mirror.local:8080", "ftp://*"}
+	tests := []struct {
+		link    string
+		allowed bool
+	}{
+		{link: "https://updates.example.com/app.bin", allowed: true},
+		{link: "https://UPDATES.example.com:443/app.bin", allowed: true},
+		{link: "http://updates.example.com/app.bin", allowed: false},
+		{link: "https://updates.example.com:8443/app.bin", allowed: true},
+		{link: "https://eu.cdn.example.com/app.bin", allowed: true},
+		{link: "http://a.eu.cdn.example.com:8080/app.bin", allowed: true},
+		{link: "https://cdn.example.com/app.bin", allowed: false},
+		{link: "https://evilcdn.example.com/app.bin", allowed: false},
+		{link: "https://cdn.example.com.evil.org/app.bin", allowed: false},
+		{link: "http://mirror.local:8080/app.bin", allowed: true},
+		{link: "http://mirror.local/app.bin", allowed: false},
+		{link: "ftp://any.host/app.bin", allowed: true},
+		{link: "http://169.254.169.254/latest/meta-data", allowed: false},
+		{link: "file:///etc/passwd", allowed: false},
+		{link: "not a link", allowed: false},
+	}
+	for _, test := range tests {
+		err := checkLink(test.link, allowList)
+		if test.allowed && err != nil {
+			t.Errorf("link %s is not allowed: %v", test.link, err)
+		}
+		if !test.allowed && !errors.Is(err, ErrLinkNotAllowed) {
+			t.Errorf("link %s is allowed: %v", test.link, err)
+		}
+	}
+	if err := checkLink("http://169.254.169.254/latest/meta-data", nil); err != nil {
+		t.Errorf("link is not allowed by empty allow list: %v", err)
+	}
+}
+
+// TestValidateAllowList tests the validation of the download allow list entries.
+func TestValidateAllowList(t *testing.T) {
+	if err := ValidateAllowList([]string{"https://host", "host:8080", "*.example.com", "*", "http://[::1]:80"}); err != nil {
+		t.Fatalf("valid allow list is rejected: %v", err)
+	}
+	for _, entry := range []string{"", "https://", "*.", "a.*.example.com", "host/path", "user@host"} {
+		if err := ValidateAllowList([]string{entry}); err == nil {
+			t.Errorf("invalid allow list entry is accepted: %s", entry)
+		}
+	}
+}
+
+// TestDownloadModuleAllowList tests that the modules with disallowed links or redirects are rejected
+// without being downloaded or retried.
+func TestDownloadModuleAllowList(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	body := "allowed content"
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://blocked.example.com/image.bin", http.StatusFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	hash := sha256.Sum256([]byte(body))
+	module := func(link string) *Module {
+		return &Module{Name: "name", Version: "1", Artifacts: []*Artifact{{FileName: "image.bin", Size: len(body),
+			Link: link, HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])}}}
+	}
+	server := ServerConfig{AllowList: []string{srv.URL}}
+
+	// 1. Download module with allowed link.
+	if err := store.DownloadModule(filepath.Join(dir, "1"), module(srv.URL+"/image.bin"), nil, server, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download allowed module: %v", err)
+	}
+	check(filepath.Join(dir, "1", "image.bin"), len(body), t)
+
+	// 2. Download module with disallowed link.
+	requests = 0
+	err = store.DownloadModule(filepath.Join(dir, "2"), module("http://blocked.example.com/image.bin"), nil, server, 3, 0, nil, nil)
+	if !errors.Is(err, ErrLinkNotAllowed) {
+		t.Fatalf("expected disallowed link error: %v", err)
+	}
+
+	// 3. Download module, redirected to disallowed link.
+	err = store.DownloadModule(filepath.Join(dir, "3"), module(srv.URL+"/redirect"), nil, server, 3, 0, nil, nil)
+	if !errors.Is(err, ErrLinkNotAllowed) {
+		t.Fatalf("expected disallowed redirect error: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("disallowed links are requested or retried: %d requests", requests)
+	}
+}
+
+// TestDownloadModuleRewriteLink tests that the artifact links are rewritten before the download allow list check
+// and before each retried request, while the module links are left unchanged.
+func TestDownloadModuleRewriteLink(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	body := "content from caching proxy"
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/origin/image.bin" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	hash := sha256.Sum256([]byte(body))
+	origin := "https://cdn.example.com/image.bin"
+	module := &Module{Name: "name", Version: "1", Artifacts: []*Artifact{{FileName: "image.bin", Size: len(body),
+		Link: origin, HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])}}}
+
+	var rewritten []string
+	server := ServerConfig{AllowList: []string{srv.URL}, RewriteLink: func(link string) string {
+		rewritten = append(rewritten, link)
+		return strings.Replace(link, "https://cdn.example.com", srv.URL+"/origin", 1)
+	}}
+	if err := store.DownloadModule(filepath.Join(dir, "1"), module, nil, server, 1, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module with rewritten link: %v", err)
+	}
+	check(filepath.Join(dir, "1", "image.bin"), len(body), t)
+	if requests != 2 {
+		t.Fatalf("rewritten link is not retried: %d requests", requests)
+	}
+	// At least once for the allow list check and once for each attempt.
+	if len(rewritten) < 3 {
+		t.Fatalf("link is not rewritten for each attempt: %q", rewritten)
+	}
+	for _, link := range rewritten {
+		if link != origin {
+			t.Fatalf("unexpected link to rewrite: %s", link)
+		}
+	}
+	if module.Artifacts[0].Link != origin {
+		t.Fatalf("module link is changed: %s", module.Artifacts[0].Link)
+	}
+
+	// Not rewritten links are rejected by the download allow list.
+	server.RewriteLink = nil
+	if err := store.DownloadModule(filepath.Join(dir, "2"), module, nil, server, 1, 0, nil, nil); !errors.Is(err, ErrLinkNotAllowed) {
+		t.Fatalf("expected disallowed link error: %v", err)
+	}
+
+	// Links rewritten to disallowed hosts on retry are rejected, without being requested.
+	atomic.StoreInt32(&requests, 0)
+	server.RewriteLink = func(link string) string {
+		if atomic.LoadInt32(&requests) > 0 {
+			return "https://other.example.com/image.bin"
+		}
+		return strings.Replace(link, "https://cdn.example.com", srv.URL+"/origin", 1)
+	}
+	if err := store.DownloadModule(filepath.Join(dir, "3"), module, nil, server, 1, 0, nil, nil); !errors.Is(err, ErrLinkNotAllowed) {
+		t.Fatalf("expected disallowed rewritten link error: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("unexpected requests of disallowed rewritten link: %d", requests)
+	}
+}
@@ -16,20 +16,64 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/eclipse-kanto/software-update/internal/logger"
 )
 
+// MetadataArchiveManifest is the module metadata with the name of the archive entry, listing the SHA-256 digests
+// of all other archive files in the sha256sum format. See MetadataManifest. The extracted files are verified against
+// it and the archive extraction fails, if any of them does not match or is not listed.
+const MetadataArchiveManifest = "archive-manifest"
+
+const (
+	// SymlinksReject fails the extraction of the archives with symbolic link entries.
+	SymlinksReject = "reject"
+	// SymlinksContain extracts the symbolic link entries of the archives, which resolve within the extraction
+	// directory, and fails the extraction for the others.
+	SymlinksContain = "contain"
+)
+
+// ErrSymlink represents symbolic link, which is not allowed or escapes the storage directory error.
+var ErrSymlink = errors.New("symbolic link is not allowed")
+
+// maxSymlinkTarget is the maximal length of the symbolic link target, stored as zip entry content.
+const maxSymlinkTarget = 4096
+
 type reader func() (io.Reader, error)
 
-// ExtractArchive all artifacts to file system and remove the archive.
-func ExtractArchive(dir string) error {
+// extracted records the files and the directories, created by the archive extraction, to verify and clean them up.
+// It also holds the resolved extraction directory and the policy of the symbolic link entries.
+type extracted struct {
+	files    []string
+	dirs     []string
+	root     string
+	symlinks string
+}
+
+// ValidateSymlinks checks that the policy of the symbolic link archive entries is SymlinksReject or SymlinksContain.
+func ValidateSymlinks(symlinks string) error {
+	if symlinks != SymlinksReject && symlinks != SymlinksContain {
+		return fmt.Errorf("invalid archive symlinks policy - %s, must be %s or %s", symlinks, SymlinksReject, SymlinksContain)
+	}
+	return nil
+}
+
+// ExtractArchive all artifacts to file system and remove the archive. The extracted files are verified against
+// the archive entry with the given manifest name, if not empty, and removed, if the extraction fails.
+// The symbolic link entries are handled by the symlinks policy, rejected if not set, and the extracted files
+// are never written through symbolic links, which escape the directory.
+func ExtractArchive(dir string, manifest string, symlinks string) error {
 	logger.Debugf("Extract archive(s) in directory: %s", dir)
 	files, err := os.ReadDir(dir)
 	if err != nil {
@@ -38,7 +82,7 @@ func ExtractArchive(dir string) error {
 
 	for _, file := range files {
 		if file.Type().IsRegular() {
-			if err := extractAndRemove(dir, file.Name()); err != nil {
+			if err := extractAndRemove(dir, file.Name(), manifest, symlinks); err != nil {
 				return err
 			}
 		}
@@ -46,25 +90,227 @@ func ExtractArchive(dir string) error {
 	return nil
 }
 
-func extractAndRemove(dir string, name string) error {
+func extractAndRemove(dir string, name string, manifest string, symlinks string) error {
+	var extract func(dir string, name string, entries *extracted) error
 	if strings.HasSuffix(name, ".zip") {
-		if err := unzip(dir, name); err != nil {
+		extract = unzip
+	} else if strings.HasSuffix(name, ".tar.gz") {
+		extract = untar
+	} else {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if symlinks == "" {
+		symlinks = SymlinksReject
+	}
+	entries := &extracted{root: root, symlinks: symlinks}
+	err = extract(dir, name, entries)
+	if err == nil && manifest != "" {
+		err = entries.verify(dir, name, manifest)
+	}
+	if err != nil {
+		entries.remove()
+		return err
+	}
+	logger.Debugf("Remove archive: %s", name)
+	return os.Remove(filepath.Join(dir, name))
+}
+
+// verify verifies the extracted files against the SHA-256 digests of the manifest entry of the archive.
+func (e *extracted) verify(dir string, archive string, manifest string) error {
+	manifest = path.Clean(manifest)
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(manifest)))
+	if err != nil {
+		return fmt.Errorf("%w: manifest %s is not an entry of archive %s", ErrManifestInvalid, manifest, archive)
+	}
+	listed, err := parseManifest(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrManifestInvalid, err)
+	}
+	digests := map[string]string{}
+	for name, digest := range listed {
+		digests[path.Clean(name)] = digest
+	}
+	delete(digests, manifest)
+	verified := map[string]bool{manifest: true}
+	for _, file := range e.files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if verified[name] { // the manifest or a file, extracted more than once
+			continue
+		}
+		if info, err := os.Lstat(file); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			continue // a contained symbolic link, its target is verified as an entry itself
+		}
+		digest, ok := digests[name]
+		if !ok {
+			return fmt.Errorf("%w: entry %s of archive %s is not listed in manifest %s", ErrManifestInvalid, name, archive, manifest)
+		}
+		actual, err := fileDigest(file)
+		if err != nil {
 			return err
 		}
-		logger.Debugf("Remove archive: %s", name)
-		return os.Remove(filepath.Join(dir, name))
+		if actual != digest {
+			return fmt.Errorf("%w: entry %s of archive %s, expected %s, but was %s", ErrChecksumMismatch, name, archive, digest, actual)
+		}
+		delete(digests, name)
+		verified[name] = true
+	}
+	if len(digests) > 0 {
+		var missing []string
+		for name := range digests {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("%w: entries %s of manifest %s are missing in archive %s", ErrManifestInvalid,
+			strings.Join(missing, ", "), manifest, archive)
+	}
+	logger.Infof("%d entries of archive [%s] are verified with manifest [%s]", len(verified)-1, archive, manifest)
+	return nil
+}
+
+// remove removes the extracted files and directories, e.g. if the extraction fails.
+func (e *extracted) remove() {
+	for _, name := range e.files {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("failed to remove extracted file [%s]: %v", name, err)
+		}
+	}
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		if err := os.RemoveAll(e.dirs[i]); err != nil {
+			logger.Errorf("failed to remove extracted directory [%s]: %v", e.dirs[i], err)
+		}
+	}
+}
+
+// mkdirAll creates the directory with its missing parents, recording the top-most created one.
+func (e *extracted) mkdirAll(dir string, perm os.FileMode) error {
+	missing := ""
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = d
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	if missing != "" {
+		e.dirs = append(e.dirs, missing)
+	}
+	return nil
+}
+
+// checkPath verifies that the existing components of the destination path below the extraction directory are not
+// symbolic links, unless contained by the symlinks policy and resolving within the extraction directory.
+func (e *extracted) checkPath(dir string, dest string) error {
+	rel, err := filepath.Rel(dir, dest)
+	if err != nil {
+		return err
 	}
-	if strings.HasSuffix(name, ".tar.gz") {
-		if err := untar(dir, name); err != nil {
+	current := filepath.Clean(dir)
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
 			return err
 		}
-		logger.Debugf("Remove archive: %s", name)
-		return os.Remove(filepath.Join(dir, name))
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if e.symlinks != SymlinksContain {
+			return fmt.Errorf("%w: %s is written through symbolic link %s", ErrSymlink, dest, current)
+		}
+		if err := e.contained(current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symlink creates the symbolic link entry, if contained by the symlinks policy and its target resolves within
+// the extraction directory.
+func (e *extracted) symlink(dir string, name string, target string, dest string) error {
+	if e.symlinks != SymlinksContain {
+		return fmt.Errorf("%w: archive entry %s is a symbolic link to %s", ErrSymlink, name, target)
+	}
+	resolved := filepath.Join(filepath.Dir(dest), filepath.FromSlash(target))
+	if filepath.IsAbs(filepath.FromSlash(target)) || !strings.HasPrefix(resolved, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("%w: archive entry %s links to %s outside of the directory", ErrSymlink, name, target)
+	}
+	if err := e.mkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	logger.Tracef("Create symbolic link: %s -> %s", name, target)
+	if err := os.Symlink(target, dest); err != nil {
+		return err
+	}
+	e.files = append(e.files, dest)
+	// The target can resolve outside through the other links, e.g. a link to the directory itself.
+	return e.contained(dest)
+}
+
+// contained verifies that the existing symbolic link resolves within the extraction directory. Dangling links
+// are contained by their target path, verified when they are created.
+func (e *extracted) contained(link string) error {
+	resolved, err := filepath.EvalSymlinks(link)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resolved, e.root+string(os.PathSeparator)) && resolved != e.root {
+		return fmt.Errorf("%w: %s resolves to %s outside of the directory", ErrSymlink, link, resolved)
 	}
 	return nil
 }
 
-func unzip(dir string, name string) error {
+// fileDigest returns the hex encoded SHA-256 digest of the file.
+func fileDigest(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyArchive is an ArtifactVerifier, which verifies that the zip and tar.gz artifacts are valid archives,
+// before they are extracted. The other artifacts are not verified.
+func VerifyArchive(artifact *Artifact, data io.ReaderAt, size int64) error {
+	if strings.HasSuffix(artifact.FileName, ".zip") {
+		if _, err := zip.NewReader(data, size); err != nil {
+			return fmt.Errorf("invalid zip archive %s: %v", artifact.FileName, err)
+		}
+	}
+	if strings.HasSuffix(artifact.FileName, ".tar.gz") {
+		gz, err := gzip.NewReader(io.NewSectionReader(data, 0, size))
+		if err != nil {
+			return fmt.Errorf("invalid tar.gz archive %s: %v", artifact.FileName, err)
+		}
+		defer gz.Close()
+		if _, err := tar.NewReader(gz).Next(); err != nil && err != io.EOF {
+			return fmt.Errorf("invalid tar.gz archive %s: %v", artifact.FileName, err)
+		}
+	}
+	return nil
+}
+
+func unzip(dir string, name string, entries *extracted) error {
 	logger.Debugf("Unzip archive [%s] in directory: %s", name, dir)
 	file, err := zip.OpenReader(filepath.Join(dir, name))
 	if err != nil {
@@ -73,16 +319,28 @@ func unzip(dir string, name string) error {
 	defer file.Close()
 
 	for _, f := range file.File {
-		if err := processEntry(dir, f.Name, f.FileInfo(), func() (io.Reader, error) {
+		f := f
+		open := func() (io.Reader, error) {
 			return f.Open()
-		}); err != nil {
+		}
+		// The target of a zip symbolic link is its content.
+		target := func() (string, error) {
+			in, err := f.Open()
+			if err != nil {
+				return "", err
+			}
+			defer in.Close()
+			data, err := io.ReadAll(io.LimitReader(in, maxSymlinkTarget))
+			return string(data), err
+		}
+		if err := processEntry(dir, f.Name, f.FileInfo(), entries, open, target); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func untar(dir string, name string) error {
+func untar(dir string, name string, entries *extracted) error {
 	logger.Debugf("Untar archive [%s] in directory: %s", name, dir)
 	r, err := os.Open(filepath.Join(dir, name))
 	if err != nil {
@@ -106,23 +364,38 @@ func untar(dir string, name string) error {
 			return err
 		}
 
-		if err := processEntry(dir, header.Name, header.FileInfo(), func() (io.Reader, error) {
+		if err := processEntry(dir, header.Name, header.FileInfo(), entries, func() (io.Reader, error) {
 			return tr, nil
+		}, func() (string, error) {
+			return header.Linkname, nil
 		}); err != nil {
 			return err
 		}
 	}
 }
 
-func processEntry(dir string, name string, f fs.FileInfo, in reader) error {
+func processEntry(dir string, name string, f fs.FileInfo, entries *extracted, in reader,
+	target func() (string, error)) error {
 	dest := filepath.Join(dir, name)
 	if !strings.HasPrefix(dest, filepath.Clean(dir)+string(os.PathSeparator)) {
 		return fmt.Errorf("illegal file path: %s", name)
 	}
+	// Never create or write through symbolic links, escaping the directory.
+	if err := entries.checkPath(dir, dest); err != nil {
+		return err
+	}
+
+	if f.Mode()&os.ModeSymlink != 0 {
+		link, err := target()
+		if err != nil {
+			return err
+		}
+		return entries.symlink(dir, name, link, dest)
+	}
 
 	if f.Mode().IsDir() {
 		logger.Tracef("Create directory: %s", name)
-		return os.MkdirAll(dest, f.Mode())
+		return entries.mkdirAll(dest, f.Mode())
 	}
 
 	logger.Tracef("Extract: %s", name)
@@ -130,17 +403,17 @@ func processEntry(dir string, name string, f fs.FileInfo, in reader) error {
 	if err != nil {
 		return err
 	}
-	return saveToFile(input, dest, f.Mode())
+	return saveToFile(input, dest, f.Mode(), entries)
 }
 
-func saveToFile(in io.Reader, dest string, perm os.FileMode) error {
+func saveToFile(in io.Reader, dest string, perm os.FileMode, entries *extracted) error {
 	// Close the input in case of io.ReadCloser (Zip).
 	if closer, ok := in.(io.ReadCloser); ok {
 		defer closer.Close()
 	}
 
 	// Make parent directories if missing.
-	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+	if err := entries.mkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return err
 	}
 
@@ -150,6 +423,7 @@ func saveToFile(in io.Reader, dest string, perm os.FileMode) error {
 		return err
 	}
 	defer out.Close()
+	entries.files = append(entries.files, dest)
 
 	// Copy archive file to the system file.
 	_, err = io.Copy(out, in)
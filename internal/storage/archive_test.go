@@ -18,9 +18,14 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -51,7 +56,7 @@ func TestExtractArchive(t *testing.T) {
 	createTar(filepath.Join(dir, aTar), eTar, t)
 
 	// 1. Try to extract all archives.
-	if err := ExtractArchive(dir); err != nil {
+	if err := ExtractArchive(dir, "", ""); err != nil {
 		t.Errorf("fail to extract all archives: %v", err)
 	}
 	isExtracted(dir, eZip, t)
@@ -61,12 +66,12 @@ func TestExtractArchive(t *testing.T) {
 	if err := WriteLn(fZip, "corrupted"); err != nil {
 		t.Fatalf("fail to write file: %v", err)
 	}
-	if err := ExtractArchive(dir); err == nil {
+	if err := ExtractArchive(dir, "", ""); err == nil {
 		t.Errorf("fail to validate with corrupted archive")
 	}
 
 	// 3. Try to extract archives from file (not directory).
-	if err := ExtractArchive(fZip); err == nil {
+	if err := ExtractArchive(fZip, "", ""); err == nil {
 		t.Errorf("fail to validate with file as target directory")
 	}
 }
@@ -86,7 +91,7 @@ func TestUnzip(t *testing.T) {
 	z := "test.zip"
 	entries := []ae{{"f.txt", "f1"}, {filepath.Join("d", "f.txt"), "s2"}, {"e/", ""}}
 	createZip(filepath.Join(dir, z), entries, t)
-	if err := unzip(dir, z); err != nil {
+	if err := unzip(dir, z, &extracted{}); err != nil {
 		t.Errorf("failed to extract zip archive: %v", err)
 	}
 	isExtracted(dir, entries, t)
@@ -94,12 +99,12 @@ func TestUnzip(t *testing.T) {
 	// 2. Try to extract zip archive with illegal file path.
 	z = "illegal.zip"
 	createZip(filepath.Join(dir, z), []ae{{"../i.txt", "file with illegal path"}}, t)
-	if err := unzip(dir, z); err == nil {
+	if err := unzip(dir, z, &extracted{}); err == nil {
 		t.Error("illegal paths should not be permitted")
 	}
 
 	// 3. Try to extract missing zip archive.
-	if err := unzip(dir, "missing.zip"); err == nil {
+	if err := unzip(dir, "missing.zip", &extracted{}); err == nil {
 		t.Error("missing zip should not be permitted")
 	}
 
@@ -109,7 +114,7 @@ func TestUnzip(t *testing.T) {
 	}
 	z = "fail.zip"
 	createZip(filepath.Join(dir, z), []ae{{"dir", "a"}}, t)
-	if err := unzip(dir, z); err == nil {
+	if err := unzip(dir, z, &extracted{}); err == nil {
 		t.Error("extracting file to directory should not be permitted")
 	}
 }
@@ -129,7 +134,7 @@ func TestUntar(t *testing.T) {
 	z := "test.tar.gz"
 	entries := []ae{{"f.txt", "f1"}, {filepath.Join("d", "f.txt"), "s2"}, {"e/", ""}}
 	createTar(filepath.Join(dir, z), entries, t)
-	if err := untar(dir, z); err != nil {
+	if err := untar(dir, z, &extracted{}); err != nil {
 		t.Errorf("failed to extract tar.gz archive: %v", err)
 	}
 	isExtracted(dir, entries, t)
@@ -137,12 +142,12 @@ func TestUntar(t *testing.T) {
 	// 2. Try to extract tar.gz archive with illegal file path.
 	z = "illegal.tar.gz"
 	createTar(filepath.Join(dir, z), []ae{{"../i.txt", "file with illegal path"}}, t)
-	if err := untar(dir, z); err == nil {
+	if err := untar(dir, z, &extracted{}); err == nil {
 		t.Error("illegal paths should not be permitted")
 	}
 
 	// 3. Try to extract missing tar.gz archive.
-	if err := untar(dir, "missing.tar.gz"); err == nil {
+	if err := untar(dir, "missing.tar.gz", &extracted{}); err == nil {
 		t.Error("missing tar.gz should not be permitted")
 	}
 }
@@ -162,7 +167,7 @@ func TestExtractAndRemove(t *testing.T) {
 	z := "test.zip"
 	entries := []ae{{"fz.txt", "fz"}}
 	createZip(filepath.Join(dir, z), entries, t)
-	if err := extractAndRemove(dir, z); err != nil {
+	if err := extractAndRemove(dir, z, "", ""); err != nil {
 		t.Errorf("failed to extract zip archive: %v", err)
 	}
 	isExtracted(dir, entries, t)
@@ -174,7 +179,7 @@ func TestExtractAndRemove(t *testing.T) {
 	z = "test.tar.gz"
 	entries = []ae{{"fgz.txt", "fgz"}}
 	createTar(filepath.Join(dir, z), entries, t)
-	if err := extractAndRemove(dir, z); err != nil {
+	if err := extractAndRemove(dir, z, "", ""); err != nil {
 		t.Errorf("failed to extract tar.gz archive: %v", err)
 	}
 	isExtracted(dir, entries, t)
@@ -183,23 +188,119 @@ func TestExtractAndRemove(t *testing.T) {
 	}
 
 	// 3. Try to extract file with unknown extension.
-	if err := extractAndRemove(dir, "unknown"); err != nil {
+	if err := extractAndRemove(dir, "unknown", "", ""); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	// 4. Try to extract missing zip archive.
-	if err := extractAndRemove(dir, "missing.zip"); err == nil {
+	if err := extractAndRemove(dir, "missing.zip", "", ""); err == nil {
 		t.Error("missing tar.gz should not be permitted")
 	}
 
 	// 5. Try to extract missing tar.gz archive.
-	if err := extractAndRemove(dir, "missing.tar.gz"); err == nil {
+	if err := extractAndRemove(dir, "missing.tar.gz", "", ""); err == nil {
 		t.Error("missing tar.gz should not be permitted")
 	}
 }
 
+// TestExtractArchiveManifest tests the verification of the extracted files against the archive manifest entry and
+// the removal of the extracted files, if any of them does not match.
+func TestExtractArchiveManifest(t *testing.T) {
+	manifest := func(entries ...ae) string {
+		var lines []string
+		for _, entry := range entries {
+			hash := sha256.Sum256([]byte(entry.Body))
+			lines = append(lines, hex.EncodeToString(hash[:])+"  "+filepath.ToSlash(entry.Name))
+		}
+		return strings.Join(lines, "\n")
+	}
+	f1, f2 := ae{"f.txt", "f1"}, ae{filepath.Join("d", "f.txt"), "f2"}
+
+	tests := map[string]struct {
+		entries []ae
+		err     error
+	}{
+		"valid":      {entries: []ae{f1, f2, {"SHA256SUMS", manifest(f1, f2)}}},
+		"tampered":   {entries: []ae{f1, {f2.Name, "tampered"}, {"SHA256SUMS", manifest(f1, f2)}}, err: ErrChecksumMismatch},
+		"notListed":  {entries: []ae{f1, f2, {"SHA256SUMS", manifest(f1)}}, err: ErrManifestInvalid},
+		"missing":    {entries: []ae{f1, {"SHA256SUMS", manifest(f1, f2)}}, err: ErrManifestInvalid},
+		"noManifest": {entries: []ae{f1, f2}, err: ErrManifestInvalid},
+	}
+	for name, test := range tests {
+		for _, archive := range []string{"test.zip", "test.tar.gz"} {
+			t.Run(name+"/"+archive, func(t *testing.T) {
+				dir := t.TempDir()
+				if archive == "test.zip" {
+					createZip(filepath.Join(dir, archive), test.entries, t)
+				} else {
+					createTar(filepath.Join(dir, archive), test.entries, t)
+				}
+				err := ExtractArchive(dir, "SHA256SUMS", "")
+				if test.err == nil {
+					if err != nil {
+						t.Fatalf("fail to extract archive with manifest: %v", err)
+					}
+					isExtracted(dir, test.entries[:len(test.entries)-1], t)
+					return
+				}
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v, got: %v", test.err, err)
+				}
+				// Only the archive is left.
+				files, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("fail to read directory: %v", err)
+				}
+				if len(files) != 1 || files[0].Name() != archive {
+					t.Fatalf("extracted files are not removed: %v", files)
+				}
+			})
+		}
+	}
+}
+
+// TestVerifyArchive tests the verification of the zip and tar.gz artifacts.
+func TestVerifyArchive(t *testing.T) {
+	dir := t.TempDir()
+	createZip(filepath.Join(dir, "test.zip"), []ae{{"zf.txt", "zf1"}}, t)
+	createTar(filepath.Join(dir, "test.tar.gz"), []ae{{"tf.txt", "tf1"}}, t)
+	if err := WriteLn(filepath.Join(dir, "test.txt"), "plain"); err != nil {
+		t.Fatalf("fail to write file: %v", err)
+	}
+
+	// 1. Verify valid archives and plain artifact.
+	for _, name := range []string{"test.zip", "test.tar.gz", "test.txt"} {
+		if err := verifyArchiveFile(dir, name); err != nil {
+			t.Errorf("fail to verify valid artifact %s: %v", name, err)
+		}
+	}
+
+	// 2. Verify corrupted archives.
+	for _, name := range []string{"test.zip", "test.tar.gz"} {
+		if err := WriteLn(filepath.Join(dir, name), "corrupted"); err != nil {
+			t.Fatalf("fail to write file: %v", err)
+		}
+		if err := verifyArchiveFile(dir, name); err == nil {
+			t.Errorf("corrupted archive %s verified", name)
+		}
+	}
+}
+
 // ----- utils ----- ----- ----- ----- ----- ----- ----- ----- -----
 
+func verifyArchiveFile(dir string, name string) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return VerifyArchive(&Artifact{FileName: name}, f, info.Size())
+}
+
 func isExtracted(dir string, files []ae, t *testing.T) {
 	for _, file := range files {
 		name := filepath.Join(dir, file.Name)
@@ -216,6 +317,122 @@ func isExtracted(dir string, files []ae, t *testing.T) {
 	}
 }
 
+// TestExtractArchiveSymlinks tests that the symbolic link archive entries are rejected or contained within the
+// directory and that the archive entries are never written through symbolic links, escaping it.
+func TestExtractArchiveSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	tests := map[string]struct {
+		entries  []ae
+		links    []ae
+		symlinks string
+		escape   bool
+		err      error
+	}{
+		"reject":         {entries: []ae{{"f.txt", "f"}}, links: []ae{{"l.txt", "f.txt"}}, err: ErrSymlink},
+		"contain":        {entries: []ae{{"f.txt", "f"}}, links: []ae{{"l.txt", "f.txt"}}, symlinks: SymlinksContain},
+		"contain-nested": {entries: []ae{{"d/f.txt", "f"}}, links: []ae{{"d/l.txt", "../d/f.txt"}}, symlinks: SymlinksContain},
+		"contain-dir":    {entries: []ae{{"sub/", ""}}, links: []ae{{"d", "sub"}, {"d/f.txt", ""}}, symlinks: SymlinksContain},
+		"escape":         {links: []ae{{"l.txt", "../outside.txt"}}, symlinks: SymlinksContain, err: ErrSymlink},
+		"absolute":       {links: []ae{{"l.txt", "/etc/passwd"}}, symlinks: SymlinksContain, err: ErrSymlink},
+		"existing":       {entries: []ae{{"evil/f.txt", "f"}}, escape: true, err: ErrSymlink},
+		"existing-contain": {entries: []ae{{"evil/f.txt", "f"}}, escape: true, symlinks: SymlinksContain,
+			err: ErrSymlink},
+	}
+	for _, format := range []string{"zip", "tar.gz"} {
+		for name, test := range tests {
+			t.Run(format+"-"+name, func(t *testing.T) {
+				outside := t.TempDir()
+				dir := t.TempDir()
+				if test.escape {
+					if err := os.Symlink(outside, filepath.Join(dir, "evil")); err != nil {
+						t.Fatal(err)
+					}
+				}
+				archive := filepath.Join(dir, "test."+format)
+				createSymlinkArchive(archive, test.entries, test.links, t)
+
+				err := ExtractArchive(dir, "", test.symlinks)
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v, got %v", test.err, err)
+				}
+				if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+					t.Fatalf("file %s is written outside of the directory", entries[0].Name())
+				}
+				if test.err != nil {
+					if _, err := os.Lstat(filepath.Join(dir, "l.txt")); !os.IsNotExist(err) {
+						t.Fatalf("symbolic link of the failed extraction is not removed: %v", err)
+					}
+					return
+				}
+				for _, link := range test.links {
+					if link.Body == "" {
+						continue
+					}
+					if target, err := os.Readlink(filepath.Join(dir, link.Name)); err != nil || target != link.Body {
+						t.Fatalf("unexpected symbolic link %s -> %s: %v", link.Name, target, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// createSymlinkArchive creates a zip or tar.gz archive with the file entries, followed by the symbolic link
+// entries with their target as body. The link entries without target are written as regular files.
+func createSymlinkArchive(name string, files []ae, links []ae, t *testing.T) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(name, ".zip") {
+		w := zip.NewWriter(f)
+		defer w.Close()
+		for i, file := range append(append([]ae{}, files...), links...) {
+			fh := &zip.FileHeader{Name: file.Name}
+			switch {
+			case strings.HasSuffix(file.Name, "/"):
+				fh.SetMode(fs.ModeDir | 0755)
+			case i >= len(files) && file.Body != "":
+				fh.SetMode(fs.ModeSymlink | 0777)
+			default:
+				fh.SetMode(0644)
+			}
+			entry, err := w.CreateHeader(fh)
+			if err == nil {
+				_, err = entry.Write([]byte(file.Body))
+			}
+			if err != nil {
+				t.Fatalf("failed to write zip archive entry: %v", err)
+			}
+		}
+		return
+	}
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+	for i, file := range append(append([]ae{}, files...), links...) {
+		fh := &tar.Header{Name: file.Name, Mode: 0644, Size: int64(len(file.Body))}
+		switch {
+		case strings.HasSuffix(file.Name, "/"):
+			fh.Typeflag, fh.Mode = tar.TypeDir, 0755
+		case i >= len(files) && file.Body != "":
+			fh.Typeflag, fh.Linkname, fh.Size = tar.TypeSymlink, file.Body, 0
+		}
+		if err := tw.WriteHeader(fh); err != nil {
+			t.Fatalf("failed to write tar archive entry: %v", err)
+		}
+		if fh.Size > 0 {
+			if _, err := tw.Write([]byte(file.Body)); err != nil {
+				t.Fatalf("failed to write tar archive entry: %v", err)
+			}
+		}
+	}
+}
+
 func createZip(name string, files []ae, t *testing.T) {
 	f, err := os.Create(name)
 	if err != nil {
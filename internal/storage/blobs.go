@@ -0,0 +1,181 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// Storage layouts of the downloaded module artifacts.
+const (
+	// LayoutModule stores the artifacts as regular files in the directories of their modules.
+	LayoutModule = "module"
+	// LayoutBlobs stores the verified artifacts once under their SHA-256 digest in the blob store and links the named
+	// files of the modules to them, so that the artifacts, shared by several modules, are downloaded and stored once.
+	// The blobs and their linked module files are read-only, so that they are not modified in place, e.g. by the
+	// install scripts, and are verified again, before they are reused.
+	LayoutBlobs = "blobs"
+)
+
+// ValidateLayout validates the storage layout of the downloaded module artifacts.
+func ValidateLayout(layout string) error {
+	switch layout {
+	case LayoutModule, LayoutBlobs:
+		return nil
+	default:
+		return fmt.Errorf("invalid storage layout %s, expected %s or %s", layout, LayoutModule, LayoutBlobs)
+	}
+}
+
+// blobName returns the blob store name of the artifact, i.e. sha256-<hex digest>, or empty string, if it cannot be
+// shared. Only the artifacts, verified with a SHA-256 checksum, are shared, so that a weaker checksum collision
+// cannot replace the artifact of another module.
+func blobName(artifact *Artifact) string {
+	if artifact.trusted {
+		return ""
+	}
+	for _, h := range append([]*Hash{{Type: artifact.HashType, Value: artifact.HashValue}}, artifact.Hashes...) {
+		if !strings.EqualFold(h.Type, string(hawkbit.SHA256)) {
+			continue
+		}
+		if digest, err := decodeHash(h.Type, artifact.HashEncoding, h.Value); err == nil {
+			return "sha256-" + hex.EncodeToString(digest)
+		}
+	}
+	return ""
+}
+
+// blob returns the blob store file of the module artifact, if stored with the blob layout, or empty string otherwise.
+// The decrypted artifacts do not match their checksum and are not shared.
+func (st *Storage) blob(artifact *Artifact, server ServerConfig, pp postProcess) string {
+	if st.Layout != LayoutBlobs || pp != nil {
+		return ""
+	}
+	if _, ok := st.fs.(linkingFileSystem); !ok {
+		return ""
+	}
+	if name := blobName(trust(artifact, server)); name != "" {
+		return filepath.Join(st.BlobsPath, name)
+	}
+	return ""
+}
+
+// linkBlob links the module file to its blob, if already stored, so that the blob is validated instead of downloaded.
+// The blob, found corrupted by the validation, is replaced by the downloaded artifact. The available and the
+// partially downloaded module files are kept.
+func (st *Storage) linkBlob(blob string, to string) {
+	if blob == "" {
+		return
+	}
+	if _, err := st.fs.Stat(to); !os.IsNotExist(err) {
+		return
+	}
+	if _, err := st.fs.Stat(blob); err != nil {
+		return
+	}
+	if err := st.fs.(linkingFileSystem).Link(blob, to); err != nil {
+		logger.Debugf("failed to link blob %s to %s: %v", blob, to, err)
+		return
+	}
+	logger.Infof("Reuse stored blob %s for %s", blob, to)
+}
+
+// storeBlob stores the verified module file in the blob store, replacing the blob, if it is not the same file, e.g.
+// after the blob is found corrupted and the artifact is downloaded again. The stored blob and so the module file
+// are made read-only. The module file is kept as it is, if it cannot be linked, e.g. on another file system.
+func (st *Storage) storeBlob(blob string, to string) {
+	if blob == "" {
+		return
+	}
+	st.blobLock.Lock()
+	defer st.blobLock.Unlock()
+	info, err := st.fs.Stat(to)
+	if err != nil {
+		return
+	}
+	if blobInfo, err := st.fs.Stat(blob); err == nil && os.SameFile(info, blobInfo) {
+		return
+	}
+	if err := st.fs.MkdirAll(st.BlobsPath); err != nil {
+		logger.Errorf("failed to create blob store directory: %v", err)
+		return
+	}
+	tmp := filepath.Join(st.BlobsPath, prefix+filepath.Base(blob))
+	if _, err := st.fs.Stat(tmp); err == nil {
+		st.fs.Remove(tmp)
+	}
+	lfs := st.fs.(linkingFileSystem)
+	if err := lfs.Link(to, tmp); err != nil {
+		logger.Warnf("failed to store %s as blob, keeping it in its module directory: %v", to, err)
+		return
+	}
+	if err := lfs.Chmod(tmp, 0444); err != nil {
+		logger.Warnf("failed to store %s as blob, keeping it in its module directory: %v", to, err)
+		st.fs.Remove(tmp)
+		return
+	}
+	if err := st.fs.Rename(tmp, blob); err != nil {
+		logger.Warnf("failed to store %s as blob, keeping it in its module directory: %v", to, err)
+		st.fs.Remove(tmp)
+		return
+	}
+	logger.Debugf("Stored %s as blob %s", to, blob)
+}
+
+// PruneBlobs removes the blobs, which are no longer linked by any module file, e.g. after their modules are
+// removed, and returns their count. The blobs are reference-counted by the hard links of the file system.
+// The blobs, stored meanwhile, are not pruned until they are stored.
+func (st *Storage) PruneBlobs() int {
+	lfs, ok := st.fs.(linkingFileSystem)
+	if !ok {
+		return 0
+	}
+	st.blobLock.Lock()
+	defer st.blobLock.Unlock()
+	entries, err := st.fs.ReadDir(st.BlobsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("failed to read blob store: %v", err)
+		}
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := filepath.Join(st.BlobsPath, entry.Name())
+		links, err := lfs.Links(name)
+		if err != nil {
+			logger.Debugf("failed to get the links of blob %s: %v", name, err)
+			continue
+		}
+		if links > 1 && !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		if err := st.fs.Remove(name); err != nil {
+			logger.Errorf("failed to remove unused blob %s: %v", name, err)
+			continue
+		}
+		logger.Debugf("Removed unused blob %s", name)
+		removed++
+	}
+	return removed
+}
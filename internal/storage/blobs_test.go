@@ -0,0 +1,164 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// TestDownloadModuleBlobs tests that two modules, sharing an artifact, store it once in the blob store and that
+// the blob is removed only after both modules are removed.
+func TestDownloadModuleBlobs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	store.Layout = LayoutBlobs
+
+	body := "shared artifact content"
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	sha := sha256.Sum256([]byte(body))
+	md := md5.Sum([]byte(body))
+	module := func(name string, fileName string) *Module {
+		return &Module{Name: name, Version: "1", Artifacts: []*Artifact{
+			{FileName: fileName, Size: len(body), Link: srv.URL + "/" + fileName, HashType: "SHA256",
+				HashValue: hex.EncodeToString(sha[:])},
+			{FileName: "weak.bin", Size: len(body), Link: srv.URL + "/weak.bin", HashType: "MD5",
+				HashValue: hex.EncodeToString(md[:])},
+		}}
+	}
+	blob := filepath.Join(store.BlobsPath, "sha256-"+hex.EncodeToString(sha[:]))
+
+	// 1. Download two modules, sharing an artifact with different file names.
+	first := filepath.Join(store.DownloadPath, "0", "0")
+	if err := store.DownloadModule(first, module("first", "first.bin"), nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download first module: %v", err)
+	}
+	second := filepath.Join(store.DownloadPath, "1", "0")
+	if err := store.DownloadModule(second, module("second", "second.bin"), nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download second module: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected the shared artifact to be downloaded once, got %d requests", requests)
+	}
+	for _, name := range []string{filepath.Join(first, "first.bin"), filepath.Join(second, "second.bin")} {
+		check(name, len(body), t)
+		assertSameFile(t, name, blob, true)
+	}
+	// The shared blob is read-only, so that the module files are not modified in place.
+	if info, err := os.Stat(blob); err != nil || info.Mode().Perm()&0222 != 0 {
+		t.Fatalf("expected read-only blob %s: %v, %v", blob, info, err)
+	}
+	// The artifacts without SHA-256 checksum are not shared.
+	assertSameFile(t, filepath.Join(first, "weak.bin"), filepath.Join(second, "weak.bin"), false)
+	assertLinks(t, blob, 3)
+
+	// 2. Remove the first module, the blob is kept for the second one.
+	if err := os.RemoveAll(filepath.Dir(first)); err != nil {
+		t.Fatalf("fail to remove first module: %v", err)
+	}
+	if removed := store.PruneBlobs(); removed != 0 {
+		t.Fatalf("expected no blob to be removed, got %d", removed)
+	}
+	check(filepath.Join(second, "second.bin"), len(body), t)
+	assertLinks(t, blob, 2)
+
+	// 3. Archive and remove the second module, the blob is removed.
+	if err := store.ArchiveModule(second); err != nil {
+		t.Fatalf("fail to archive second module: %v", err)
+	}
+	assertLinks(t, blob, 2)
+	if err := os.RemoveAll(store.ModulesPath); err != nil {
+		t.Fatalf("fail to remove archived module: %v", err)
+	}
+	if removed := store.PruneBlobs(); removed != 1 {
+		t.Fatalf("expected the unused blob to be removed, got %d", removed)
+	}
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Fatalf("unused blob is not removed: %v", err)
+	}
+}
+
+// TestDownloadModuleCorruptedBlob tests that a corrupted blob is not reused, but replaced by the downloaded artifact.
+func TestDownloadModuleCorruptedBlob(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(dir)
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	store.Layout = LayoutBlobs
+
+	body := "blob content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	sha := sha256.Sum256([]byte(body))
+	blob := filepath.Join(store.BlobsPath, "sha256-"+hex.EncodeToString(sha[:]))
+	save(blob, "corrupted content", t)
+
+	to := filepath.Join(store.DownloadPath, "0", "0")
+	module := &Module{Name: "name", Version: "1", Artifacts: []*Artifact{{FileName: "app.bin", Size: len(body),
+		Link: srv.URL + "/app.bin", HashType: "SHA256", HashValue: hex.EncodeToString(sha[:])}}}
+	if err := store.DownloadModule(to, module, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module: %v", err)
+	}
+	if data, err := os.ReadFile(blob); err != nil || string(data) != body {
+		t.Fatalf("corrupted blob is not replaced: %s, %v", data, err)
+	}
+	assertSameFile(t, filepath.Join(to, "app.bin"), blob, true)
+}
+
+func assertSameFile(t *testing.T, name string, other string, same bool) {
+	t.Helper()
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("fail to stat %s: %v", name, err)
+	}
+	otherInfo, err := os.Stat(other)
+	if err != nil {
+		t.Fatalf("fail to stat %s: %v", other, err)
+	}
+	if os.SameFile(info, otherInfo) != same {
+		t.Fatalf("expected %s and %s to be the same file: %v", name, other, same)
+	}
+}
+
+func assertLinks(t *testing.T, name string, expected uint64) {
+	t.Helper()
+	links, err := OSFileSystem{}.Links(name)
+	if err != nil {
+		t.Fatalf("fail to get the links of %s: %v", name, err)
+	}
+	if links != expected {
+		t.Fatalf("expected %d links of %s, got %d", expected, name, links)
+	}
+}
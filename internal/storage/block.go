@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// blockMismatchError reports a downloaded artifact block, not matching its hash.
+type blockMismatchError struct {
+	index  int
+	offset int64
+	err    error
+}
+
+func (e *blockMismatchError) Error() string {
+	return fmt.Sprintf("block %d at offset %d: %v", e.index, e.offset, e.err)
+}
+
+func (e *blockMismatchError) Unwrap() error {
+	return e.err
+}
+
+// blockWriter verifies the artifact blocks, while they are written to the download file. Without artifact
+// blocks, the data is only written.
+type blockWriter struct {
+	dst      io.Writer
+	artifact *Artifact
+	hash     hash.Hash
+	// offset is the artifact position of the next written byte.
+	offset int64
+}
+
+// newBlockWriter returns a writer to the named download file, starting at the given offset. The already
+// downloaded bytes of the block at the offset are read from the file, to verify the whole block.
+func newBlockWriter(fs FileSystem, name string, dst io.Writer, offset int64, artifact *Artifact) (*blockWriter, error) {
+	w := &blockWriter{dst: dst, artifact: artifact, offset: offset}
+	if artifact.Blocks == nil {
+		return w, nil
+	}
+	var err error
+	if w.hash, err = newHash(artifact.Blocks.HashType); err != nil {
+		return nil, err
+	}
+	start := offset - offset%int64(artifact.Blocks.Size)
+	if start == offset {
+		return w, nil
+	}
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.CopyN(io.Discard, file, start); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(w.hash, file, offset-start); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes the data and verifies each block, completed by it.
+func (w *blockWriter) Write(p []byte) (int, error) {
+	n, err := w.dst.Write(p)
+	if w.hash == nil {
+		return n, err
+	}
+	size := int64(w.artifact.Blocks.Size)
+	for written := p[:n]; len(written) > 0; {
+		chunk := written
+		if rest := size - w.offset%size; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		w.hash.Write(chunk)
+		w.offset += int64(len(chunk))
+		written = written[len(chunk):]
+		if w.offset%size == 0 {
+			if vErr := w.verify(); vErr != nil {
+				return n, vErr
+			}
+		}
+	}
+	return n, err
+}
+
+// finish verifies the last block, shorter than the block size, when the whole artifact is written.
+func (w *blockWriter) finish() error {
+	if w.hash == nil || w.offset%int64(w.artifact.Blocks.Size) == 0 {
+		return nil
+	}
+	return w.verify()
+}
+
+// verify verifies the last written block against its hash.
+func (w *blockWriter) verify() error {
+	blocks := w.artifact.Blocks
+	index := int((w.offset - 1) / int64(blocks.Size))
+	sum := w.hash.Sum(nil)
+	w.hash.Reset()
+	if index >= len(blocks.Hashes) {
+		return fmt.Errorf("%w: no hash of block %d", ErrFileSizeExceeded, index)
+	}
+	expected, err := decodeHash(blocks.HashType, w.artifact.HashEncoding, blocks.Hashes[index])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, expected) {
+		return &blockMismatchError{index: index, offset: int64(index) * int64(blocks.Size),
+			err: fmt.Errorf("%w: %s %s != %s", ErrChecksumMismatch, blocks.HashType, hex.EncodeToString(sum), blocks.Hashes[index])}
+	}
+	logger.Tracef("block %d of artifact %s verified", index, w.artifact.FileName)
+	return nil
+}
+
+// discardCorruptedBlock truncates the download file before the corrupted block, reported by the error, so that
+// the download is resumed from it, keeping the verified blocks. It returns the offset to resume the download from,
+// which is the beginning of the artifact, if the storage backend cannot truncate its files.
+func discardCorruptedBlock(fs FileSystem, name string, offset int64, err error) int64 {
+	var mismatch *blockMismatchError
+	if !errors.As(err, &mismatch) {
+		return offset
+	}
+	tfs, ok := fs.(truncatingFileSystem)
+	if !ok {
+		return 0
+	}
+	if tErr := tfs.Truncate(name, mismatch.offset); tErr != nil {
+		logger.Errorf("fail to discard corrupted block %d of %s: %v", mismatch.index, name, tErr)
+		return 0
+	}
+	logger.Warnf("corrupted block %d of %s discarded, download it again", mismatch.index, name)
+	return mismatch.offset
+}
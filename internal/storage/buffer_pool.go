@@ -0,0 +1,176 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultBufferSize is the default size in bytes of the download copy buffers.
+const DefaultBufferSize = 32 * 1024
+
+const (
+	// QoSCritical is the quality of service class of the critical downloads, e.g. security updates, which get
+	// the free buffers ahead of all other waiting downloads.
+	QoSCritical = "critical"
+	// QoSNormal is the default quality of service class of the downloads.
+	QoSNormal = "normal"
+	// QoSBackground is the quality of service class of the background downloads, which get the free buffers
+	// after all other waiting downloads.
+	QoSBackground = "background"
+)
+
+// ValidateQoSClass checks that the quality of service class is QoSCritical, QoSNormal or QoSBackground.
+func ValidateQoSClass(class string) error {
+	_, err := qosRank(class)
+	return err
+}
+
+// qosRank returns the admission rank of the quality of service class, higher first. Not set is QoSNormal.
+func qosRank(class string) (int, error) {
+	switch class {
+	case QoSBackground:
+		return 0, nil
+	case QoSNormal, "":
+		return 1, nil
+	case QoSCritical:
+		return 2, nil
+	default:
+		return 1, fmt.Errorf("invalid QoS class - %s, must be one of %s, %s or %s", class, QoSCritical, QoSNormal, QoSBackground)
+	}
+}
+
+// defaultBuffers is the buffer pool of the downloads without configured one.
+var defaultBuffers = NewBufferPool(DefaultBufferSize, 0)
+
+// BufferPool is a pool of download copy buffers, shared by the concurrent downloads. If the buffer count
+// is positive, the downloads wait for a free buffer, bounding the total buffer memory to count * size bytes.
+// The waiting downloads get the free buffers in the order of their quality of service class and then in the
+// order of their artifact priority, higher first.
+type BufferPool struct {
+	pool  sync.Pool
+	count int
+
+	lock    sync.Mutex
+	used    int
+	waiters []*bufferWaiter
+}
+
+// bufferWaiter is a download, waiting for a free buffer.
+type bufferWaiter struct {
+	rank     int
+	priority int
+	ready    chan struct{}
+}
+
+// before reports whether the waiter is admitted before a download, arriving later with the given rank and priority.
+func (w *bufferWaiter) before(rank int, priority int) bool {
+	return w.rank > rank || (w.rank == rank && w.priority >= priority)
+}
+
+// NewBufferPool returns a new pool of buffers with the given size, DefaultBufferSize if not positive.
+// At most count buffers are in use at the same time, unlimited if not positive.
+func NewBufferPool(size int, count int) *BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	p := &BufferPool{}
+	p.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if count > 0 {
+		p.count = count
+	}
+	return p
+}
+
+// get returns a buffer from the pool, waiting while all buffers are in use. The waiting downloads with higher
+// quality of service class get a buffer first, then the ones with higher priority and the ones with the same class
+// and priority in their arrival order. ErrCancel is returned, if the done channel is closed while waiting.
+func (p *BufferPool) get(class string, priority int, done chan struct{}) (*[]byte, error) {
+	if p.count > 0 {
+		rank, _ := qosRank(class)
+		if err := p.acquire(rank, priority, done); err != nil {
+			return nil, err
+		}
+	}
+	return p.pool.Get().(*[]byte), nil
+}
+
+// put returns the buffer to the pool.
+func (p *BufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+	if p.count > 0 {
+		p.release()
+	}
+}
+
+// preempted reports whether a download with higher quality of service class than the given one waits for a buffer.
+func (p *BufferPool) preempted(class string) bool {
+	if p.count == 0 {
+		return false
+	}
+	rank, _ := qosRank(class)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.waiters) > 0 && p.waiters[0].rank > rank
+}
+
+func (p *BufferPool) acquire(rank int, priority int, done chan struct{}) error {
+	p.lock.Lock()
+	if p.used < p.count && len(p.waiters) == 0 {
+		p.used++
+		p.lock.Unlock()
+		return nil
+	}
+	w := &bufferWaiter{rank: rank, priority: priority, ready: make(chan struct{})}
+	i := sort.Search(len(p.waiters), func(i int) bool { return !p.waiters[i].before(rank, priority) })
+	p.waiters = append(p.waiters, nil)
+	copy(p.waiters[i+1:], p.waiters[i:])
+	p.waiters[i] = w
+	p.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-done:
+	}
+	p.lock.Lock()
+	for i, waiter := range p.waiters {
+		if waiter == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.lock.Unlock()
+			return ErrCancel
+		}
+	}
+	p.lock.Unlock()
+	// The buffer is already handed over, pass it to the next waiting download.
+	p.release()
+	return ErrCancel
+}
+
+// release hands the freed buffer over to the first waiting download, if any.
+func (p *BufferPool) release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		close(w.ready)
+		return
+	}
+	p.used--
+}
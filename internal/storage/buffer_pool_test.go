@@ -0,0 +1,268 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBufferPoolConcurrentDownloads tests concurrent downloads, sharing a bounded buffer pool.
+// Run with -race to verify that the buffers are not shared between the downloads at the same time.
+func TestBufferPoolConcurrentDownloads(t *testing.T) {
+	const downloads = 8
+	bodies := make([][]byte, downloads)
+	for i := range bodies {
+		bodies[i] = bytes.Repeat([]byte(fmt.Sprintf("artifact-%d;", i)), 10000)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var i int
+		fmt.Sscanf(r.URL.Path, "/%d", &i)
+		w.Write(bodies[i])
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	buffers := NewBufferPool(1024, 2)
+	var wg sync.WaitGroup
+	errs := make(chan error, downloads)
+	for i := 0; i < downloads; i++ {
+		sum := sha256.Sum256(bodies[i])
+		art := &Artifact{
+			FileName: fmt.Sprintf("%d.txt", i), Size: len(bodies[i]), Link: fmt.Sprintf("%s/%d", srv.URL, i),
+			HashType: "SHA256", HashValue: hex.EncodeToString(sum[:]),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := filepath.Join(dir, art.FileName)
+			if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Buffers: buffers}, 0, 0, nil, make(chan struct{})); err != nil {
+				errs <- fmt.Errorf("failed to download artifact %s: %v", art.FileName, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if used := buffers.inUse(); used != 0 {
+		t.Fatalf("%d buffers not returned to the pool", used)
+	}
+}
+
+// TestBufferPoolBound tests that the downloads wait for a free buffer and can be canceled while waiting.
+func TestBufferPoolBound(t *testing.T) {
+	buffers := NewBufferPool(16, 1)
+	buf, err := buffers.get("", 0, make(chan struct{}))
+	if err != nil || len(*buf) != 16 {
+		t.Fatalf("unexpected buffer: %v", err)
+	}
+
+	// 1. Wait for the buffer in use.
+	var copied int32
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := copyWithProgress(io.Discard, bytes.NewReader([]byte("data")), 0, 0, nil, ServerConfig{Buffers: buffers}, make(chan struct{}))
+		atomic.StoreInt32(&copied, 1)
+		copyDone <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&copied) != 0 {
+		t.Fatal("copied without a free buffer")
+	}
+	buffers.put(buf)
+	if err := <-copyDone; err != nil {
+		t.Fatalf("failed to copy with the returned buffer: %v", err)
+	}
+
+	// 2. Cancel while waiting for a buffer.
+	buf, _ = buffers.get("", 0, make(chan struct{}))
+	defer buffers.put(buf)
+	done := make(chan struct{})
+	close(done)
+	if _, err := copyWithProgress(io.Discard, bytes.NewReader([]byte("data")), 0, 0, nil, ServerConfig{Buffers: buffers}, done); err != ErrCancel {
+		t.Fatalf("expected copy to be canceled: %v", err)
+	}
+}
+
+// TestBufferPoolPriority tests that the waiting downloads get a free buffer in the order of their priority.
+func TestBufferPoolPriority(t *testing.T) {
+	buffers := NewBufferPool(16, 1)
+	buf, _ := buffers.get("", 0, make(chan struct{}))
+
+	// 1. Queue the downloads with different priorities, while the only buffer is in use.
+	order := make(chan int, 4)
+	canceled := make(chan struct{})
+	for i, priority := range []int{1, 3, 2, 5} {
+		done := make(chan struct{})
+		if priority == 5 {
+			done = canceled
+		}
+		go func(priority int, done chan struct{}) {
+			buf, err := buffers.get("", priority, done)
+			if err != nil {
+				return
+			}
+			order <- priority
+			buffers.put(buf)
+		}(priority, done)
+		for waiting(buffers) != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 2. Cancel the download with the highest priority, while waiting.
+	close(canceled)
+	for waiting(buffers) != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 3. Return the buffer, the waiting downloads get it one by one.
+	buffers.put(buf)
+	for _, expected := range []int{3, 2, 1} {
+		if priority := <-order; priority != expected {
+			t.Fatalf("unexpected buffer admission: priority %d, expected %d", priority, expected)
+		}
+	}
+	for buffers.inUse() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestBufferPoolQoS tests that the waiting downloads with higher QoS class are admitted ahead of the queued
+// downloads with lower class, regardless of their artifact priority.
+func TestBufferPoolQoS(t *testing.T) {
+	buffers := NewBufferPool(16, 1)
+	buf, _ := buffers.get(QoSNormal, 0, make(chan struct{}))
+
+	// 1. Queue a background download with high priority, then a critical and a normal one.
+	order := make(chan string, 3)
+	for i, waiter := range []struct {
+		class    string
+		priority int
+	}{{QoSBackground, 10}, {QoSCritical, 0}, {QoSNormal, 0}} {
+		go func(class string, priority int) {
+			buf, err := buffers.get(class, priority, make(chan struct{}))
+			if err != nil {
+				return
+			}
+			order <- class
+			buffers.put(buf)
+		}(waiter.class, waiter.priority)
+		for waiting(buffers) != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 2. Return the buffer, the critical download is admitted first.
+	buffers.put(buf)
+	for _, expected := range []string{QoSCritical, QoSNormal, QoSBackground} {
+		if class := <-order; class != expected {
+			t.Fatalf("unexpected buffer admission: class %s, expected %s", class, expected)
+		}
+	}
+	for buffers.inUse() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestBufferPoolPreempt tests that a running background download is paused, while a critical download waits
+// for a buffer, if preemption is enabled.
+func TestBufferPoolPreempt(t *testing.T) {
+	buffers := NewBufferPool(16, 1)
+
+	// 1. Start a background download, blocked until the critical download is finished.
+	reader, writer := io.Pipe()
+	background := make(chan error, 1)
+	go func() {
+		_, err := copyWithProgress(io.Discard, reader, 0, 0, nil,
+			ServerConfig{Buffers: buffers, QoS: QoSBackground, Preempt: true}, make(chan struct{}))
+		background <- err
+	}()
+	for buffers.inUse() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 2. The critical download gets the buffer of the paused background download.
+	critical := make(chan error, 1)
+	go func() {
+		_, err := copyWithProgress(io.Discard, bytes.NewReader([]byte("data")), 0, 0, nil,
+			ServerConfig{Buffers: buffers, QoS: QoSCritical}, make(chan struct{}))
+		critical <- err
+	}()
+	for waiting(buffers) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	writer.Write([]byte("data"))
+	select {
+	case err := <-critical:
+		if err != nil {
+			t.Fatalf("failed to copy the critical download: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("critical download is not admitted ahead of the running background download")
+	}
+
+	// 3. The background download continues with the returned buffer.
+	writer.Close()
+	if err := <-background; err != nil {
+		t.Fatalf("failed to copy the background download: %v", err)
+	}
+	if used := buffers.inUse(); used != 0 {
+		t.Fatalf("%d buffers not returned to the pool", used)
+	}
+}
+
+func BenchmarkCopyWithProgress(b *testing.B) {
+	data := bytes.Repeat([]byte{1}, 1<<20)
+	for _, buffers := range []*BufferPool{NewBufferPool(DefaultBufferSize, 0), NewBufferPool(DefaultBufferSize, 4)} {
+		b.Run(fmt.Sprintf("buffers-%d", buffers.count), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := copyWithProgress(io.Discard, bytes.NewReader(data), 0, 0, nil, ServerConfig{Buffers: buffers}, make(chan struct{})); err != nil {
+						b.Fatalf("failed to copy: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// inUse returns the number of buffers in use.
+func (p *BufferPool) inUse() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.used
+}
+
+// waiting returns the number of downloads, waiting for a buffer.
+func waiting(p *BufferPool) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.waiters)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// CircuitBreaker short-circuits the download requests to the artifact server hosts, which failed a number of
+// consecutive requests, e.g. a CDN host during an outage. The requests to such host fail fast with ErrCircuitOpen
+// for a cooldown period, instead of retrying each artifact with its full retry budget. After the cooldown,
+// a single probe request is let through: the circuit is closed again, if it succeeds, or opened for another
+// cooldown period otherwise. The circuit breaker is shared by the downloads of all operations.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	lock  sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the state of the requests to a single host.
+type circuit struct {
+	// failures is the number of consecutive failed requests.
+	failures int
+	// openUntil is the end of the cooldown period of the open circuit.
+	openUntil time.Time
+	// probing is set, while the probe request of the half-open circuit is running.
+	probing bool
+}
+
+// NewCircuitBreaker returns a new circuit breaker, which opens the circuit of a host after the given number of
+// consecutive failed requests for the cooldown period. It is disabled, if the threshold is not positive.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, hosts: map[string]*circuit{}}
+}
+
+// allow returns ErrCircuitOpen, if the request to the host of the link is short-circuited. The first request
+// after the cooldown period is allowed as a probe, while the others fail until the probe is recorded.
+func (b *CircuitBreaker) allow(link string) error {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+	host := circuitHost(link)
+	if host == "" {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c := b.hosts[host]
+	if c == nil || c.failures < b.threshold {
+		return nil
+	}
+	if c.probing || clock.Now().Before(c.openUntil) {
+		return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	}
+	logger.Infof("cooldown of artifact server host %s elapsed, probe its recovery", host)
+	c.probing = true
+	return nil
+}
+
+// record records the result of the request to the host of the link. Only the request failures, which can be
+// retried, are counted as host failures.
+func (b *CircuitBreaker) record(link string, err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	host := circuitHost(link)
+	if host == "" {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c := b.hosts[host]
+	if err == nil {
+		if c != nil && c.failures >= b.threshold {
+			logger.Infof("artifact server host %s recovered, close its circuit", host)
+		}
+		delete(b.hosts, host)
+		return
+	}
+	if !isRetryable(err) {
+		if c != nil {
+			c.probing = false
+		}
+		return
+	}
+	if c == nil {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	c.failures++
+	c.probing = false
+	if c.failures >= b.threshold {
+		c.openUntil = clock.Now().Add(b.cooldown)
+		logger.Warnf("artifact server host %s failed %d consecutive requests, short-circuit its requests for %v",
+			host, c.failures, b.cooldown)
+	}
+}
+
+// isOpen reports whether the circuit of the host of the link is open, e.g. to stop retrying the failed request.
+func (b *CircuitBreaker) isOpen(link string) bool {
+	if b == nil || b.threshold <= 0 {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c := b.hosts[circuitHost(link)]
+	return c != nil && c.failures >= b.threshold && clock.Now().Before(c.openUntil)
+}
+
+// circuitHost returns the host of the download link, or an empty string for local artifacts.
+func circuitHost(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
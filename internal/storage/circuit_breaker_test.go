@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker tests that the requests to a down host trip its circuit breaker, failing the downloads fast
+// during the cooldown period, and that the circuit is closed again, when the host recovers.
+func TestCircuitBreaker(t *testing.T) {
+	dir := t.TempDir()
+	fake := useFakeClock(t)
+
+	body := "circuit breaker content"
+	var down int32 = 1
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(body))
+	server := ServerConfig{Breaker: NewCircuitBreaker(2, time.Minute)}
+	download := func(name string) error {
+		art := &Artifact{FileName: name, Size: len(body), Link: srv.URL + "/" + name,
+			HashType: "SHA256", HashValue: hex.EncodeToString(sum[:])}
+		return downloadArtifact(OSFileSystem{}, filepath.Join(dir, name), art, nil, server, 5, time.Second, nil, make(chan struct{}))
+	}
+
+	// 1. The down host trips the breaker after the threshold, instead of the whole retry budget.
+	if err := download("first.txt"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected short-circuited download, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 requests before the circuit is open, got: %d", n)
+	}
+	if waits := fake.Waits(); !reflect.DeepEqual(waits, []time.Duration{time.Second}) {
+		t.Fatalf("unexpected retry intervals: %v", waits)
+	}
+
+	// 2. The other artifacts of the host fail fast during the cooldown period.
+	if err := download("second.txt"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected short-circuited download, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected no requests during the cooldown period, got: %d", n)
+	}
+
+	// 3. The failed probe after the cooldown opens the circuit again.
+	<-fake.After(time.Minute)
+	if err := download("third.txt"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected short-circuited download after failed probe, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected a single probe request, got: %d", n)
+	}
+
+	// 4. The successful probe after the cooldown closes the circuit of the recovered host.
+	atomic.StoreInt32(&down, 0)
+	<-fake.After(time.Minute)
+	for _, name := range []string{"fourth.txt", "fifth.txt"} {
+		if err := download(name); err != nil {
+			t.Fatalf("failed to download %s from the recovered host: %v", name, err)
+		}
+		check(filepath.Join(dir, name), len(body), t)
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Fatalf("expected 5 requests, got: %d", n)
+	}
+}
+
+// TestCircuitBreakerHosts tests that the circuits of the hosts are independent and the local artifacts,
+// the not retryable failures and the disabled circuit breaker do not short-circuit the requests.
+func TestCircuitBreakerHosts(t *testing.T) {
+	useFakeClock(t)
+	failure := fmt.Errorf("%w: 503", ErrBadStatus)
+
+	b := NewCircuitBreaker(1, time.Minute)
+	b.record("http://down:8080/a.txt", failure)
+	if err := b.allow("http://down:8080/b.txt"); !errors.Is(err, ErrCircuitOpen) || !strings.Contains(err.Error(), "down:8080") {
+		t.Fatalf("expected short-circuited request to the down host, got: %v", err)
+	}
+	if err := b.allow("http://up:8080/a.txt"); err != nil {
+		t.Fatalf("unexpected short-circuited request to other host: %v", err)
+	}
+	b.record("http://other:8080/a.txt", ErrLinkNotAllowed)
+	if err := b.allow("http://other:8080/a.txt"); err != nil {
+		t.Fatalf("unexpected short-circuited request after not retryable failure: %v", err)
+	}
+	b.record("local.txt", failure)
+	if err := b.allow("local.txt"); err != nil {
+		t.Fatalf("unexpected short-circuited local artifact: %v", err)
+	}
+
+	disabled := NewCircuitBreaker(0, time.Minute)
+	disabled.record("http://down:8080/a.txt", failure)
+	if err := disabled.allow("http://down:8080/a.txt"); err != nil {
+		t.Fatalf("unexpected short-circuited request with disabled circuit breaker: %v", err)
+	}
+	var none *CircuitBreaker
+	none.record("http://down:8080/a.txt", failure)
+	if err := none.allow("http://down:8080/a.txt"); err != nil {
+		t.Fatalf("unexpected short-circuited request without circuit breaker: %v", err)
+	}
+}
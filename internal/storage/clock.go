@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import "time"
+
+// Clock provides the current time and waits the retry and backoff intervals.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the real clock of the system.
+var SystemClock Clock = systemClock{}
+
+// clock is the clock of the retry and wait intervals, replaced in tests to advance the time deterministically.
+var clock = SystemClock
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// sleep pauses the current goroutine for the duration on the clock.
+func sleep(d time.Duration) {
+	if d > 0 {
+		<-clock.After(d)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock is a clock for tests, which advances its time instantly by the waited durations and records them,
+// so that the retry and backoff intervals are asserted without waiting for them.
+type FakeClock struct {
+	lock  sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+// NewFakeClock returns a new fake clock, starting at the Unix epoch.
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Unix(0, 0)}
+}
+
+// Now returns the current time of the fake clock.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// After records the duration, advances the fake clock with it and returns the new time immediately.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Waits returns the durations, waited on the fake clock so far.
+func (c *FakeClock) Waits() []time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]time.Duration{}, c.waits...)
+}
+
+// useFakeClock replaces the clock of the package with a fake one, until the test is finished.
+func useFakeClock(t *testing.T) *FakeClock {
+	fake := NewFakeClock()
+	clock = fake
+	t.Cleanup(func() { clock = SystemClock })
+	return fake
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// DownloadModuleContext downloads the module artifacts to local storage, as DownloadModule, but is canceled with
+// the context instead of a cancel channel. The download stops, once the context is done, and the context error
+// is returned, e.g. context.Canceled or context.DeadlineExceeded.
+func (st *Storage) DownloadModuleContext(ctx context.Context, toDir string, module *Module, progress Progress,
+	server ServerConfig, retryCount int, retryInterval time.Duration, validation Validation) error {
+	cancel, release := cancelOn(ctx)
+	defer release()
+	return contextErr(ctx, st.DownloadModule(toDir, module, progress, server, retryCount, retryInterval, validation, cancel))
+}
+
+// DownloadDataContext downloads the artifact into memory, as DownloadData, but is canceled with the context instead
+// of a cancel channel. The context error is returned, once the context is done.
+func (st *Storage) DownloadDataContext(ctx context.Context, artifact *Artifact, limit int64, server ServerConfig,
+	retryCount int, retryInterval time.Duration) ([]byte, error) {
+	cancel, release := cancelOn(ctx)
+	defer release()
+	data, err := st.DownloadData(artifact, limit, server, retryCount, retryInterval, cancel)
+	return data, contextErr(ctx, err)
+}
+
+// StreamModuleContext streams the only artifact of the module to the writer, as StreamModule, but is canceled with
+// the context instead of a cancel channel. The context error is returned, once the context is done.
+func (st *Storage) StreamModuleContext(ctx context.Context, module *Module, to io.Writer, progress Progress,
+	server ServerConfig, retryCount int, retryInterval time.Duration) error {
+	cancel, release := cancelOn(ctx)
+	defer release()
+	return contextErr(ctx, st.StreamModule(module, to, progress, server, retryCount, retryInterval, cancel))
+}
+
+// cancelOn returns the cancel channel, closed once the context is done. The returned function releases
+// the channel, when the operation is finished.
+func cancelOn(ctx context.Context) (chan struct{}, func()) {
+	cancel := make(chan struct{})
+	if ctx.Done() == nil { // never canceled, e.g. the background context
+		return cancel, func() { /* Nothing to release. */ }
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(cancel)
+		case <-finished:
+		}
+	}()
+	return cancel, func() { close(finished) }
+}
+
+// contextErr returns the context error instead of ErrCanceled, if the operation is canceled by the context.
+func contextErr(ctx context.Context, err error) error {
+	if errors.Is(err, ErrCanceled) && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDownloadContext tests that the downloads, canceled with a context or exceeding its deadline, stop and return
+// the context error.
+func TestDownloadContext(t *testing.T) {
+	chunk := strings.Repeat("x", 1024)
+	chunks := 500
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty.bin" {
+			return
+		}
+		// Send the artifact slowly, so that the download is canceled in the middle.
+		for i := 0; i < chunks; i++ {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	hash := sha256.Sum256([]byte(strings.Repeat(chunk, chunks)))
+	art := &Artifact{FileName: "slow.bin", Size: len(chunk) * chunks, Link: srv.URL + "/slow.bin", HashType: "SHA256",
+		HashValue: hex.EncodeToString(hash[:])}
+	module := &Module{Name: "slow", Version: "1", Artifacts: []*Artifact{art}}
+
+	// 1. Cancel the module download, once started.
+	ctx, cancel := context.WithCancel(context.Background())
+	var once sync.Once
+	progress := func(percent int, written int64, total int64) {
+		if written > 0 {
+			once.Do(cancel)
+		}
+	}
+	err = store.DownloadModuleContext(ctx, filepath.Join(dir, "1"), module, progress, ServerConfig{}, 0, 0, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled module download: %v", err)
+	}
+
+	// 2. Exceed the deadline of the module download.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = store.DownloadModuleContext(ctx, filepath.Join(dir, "2"), module, nil, ServerConfig{}, 0, 0, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected module download deadline exceeded: %v", err)
+	}
+
+	// 3. Exceed the deadline of the download into memory.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = store.DownloadDataContext(ctx, art, int64(art.Size), ServerConfig{}, 0, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected data download deadline exceeded: %v", err)
+	}
+
+	// 4. Cancel the stream before it is started.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = store.StreamModuleContext(ctx, module, io.Discard, nil, ServerConfig{}, 0, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled stream: %v", err)
+	}
+
+	// 5. Download the small artifact with a context, which is not canceled.
+	art.Size, art.Link = 0, srv.URL+"/empty.bin"
+	empty := sha256.Sum256(nil)
+	art.HashValue = hex.EncodeToString(empty[:])
+	if err = store.DownloadModuleContext(context.Background(), filepath.Join(dir, "5"), module, nil, ServerConfig{}, 0, 0, nil); err != nil {
+		t.Fatalf("failed to download module with context: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// defaultContinueInterval is the interval between the continue policy checks, if not configured.
+const defaultContinueInterval = time.Second
+
+// Decision is the decision of the continue policy for the running operation.
+type Decision int
+
+const (
+	// DecisionContinue lets the operation continue.
+	DecisionContinue Decision = iota
+	// DecisionSuspend suspends the operation, until the policy lets it continue or aborts it.
+	DecisionSuspend
+	// DecisionAbort aborts the operation with ErrAborted, leaving its partial downloads to be resumed later.
+	DecisionAbort
+)
+
+// String returns the name of the decision.
+func (d Decision) String() string {
+	switch d {
+	case DecisionSuspend:
+		return "suspend"
+	case DecisionAbort:
+		return "abort"
+	default:
+		return "continue"
+	}
+}
+
+// ContinuePolicy decides whether the running downloads and installations can continue, based on system conditions,
+// monitored by the caller, e.g. the battery level or the device temperature.
+type ContinuePolicy func() Decision
+
+// Wait consults the policy and waits, while the operation is suspended, checking the policy again on each interval.
+// It returns ErrAborted, if the operation is aborted, or ErrCancel, if the done channel is closed while suspended.
+// A nil policy always continues.
+func (p ContinuePolicy) Wait(interval time.Duration, done <-chan struct{}) error {
+	if p == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = defaultContinueInterval
+	}
+	suspended := false
+	for {
+		switch p() {
+		case DecisionAbort:
+			logger.Warnf("operation is aborted by the continue policy")
+			return ErrAborted
+		case DecisionSuspend:
+			if !suspended {
+				logger.Warnf("operation is suspended by the continue policy")
+				suspended = true
+			}
+			select {
+			case <-done:
+				return ErrCancel
+			case <-clock.After(interval):
+			}
+		default:
+			if suspended {
+				logger.Infof("suspended operation is continued")
+			}
+			return nil
+		}
+	}
+}
+
+// continueGate consults the continue policy of the running download at most once per interval.
+type continueGate struct {
+	policy   ContinuePolicy
+	interval time.Duration
+	next     time.Time
+}
+
+func newContinueGate(server ServerConfig) *continueGate {
+	interval := server.ContinueInterval
+	if interval <= 0 {
+		interval = defaultContinueInterval
+	}
+	return &continueGate{policy: server.Continue, interval: interval}
+}
+
+// wait consults the policy, if the interval since the previous check has elapsed. See ContinuePolicy.Wait.
+func (g *continueGate) wait(done <-chan struct{}) error {
+	if g.policy == nil || clock.Now().Before(g.next) {
+		return nil
+	}
+	err := g.policy.Wait(g.interval, done)
+	g.next = clock.Now().Add(g.interval)
+	return err
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sequencePolicy returns a continue policy with the given decisions, repeating the last one, and its call counter.
+func sequencePolicy(decisions ...Decision) (ContinuePolicy, *int) {
+	calls := 0
+	return func() Decision {
+		calls++
+		if calls > len(decisions) {
+			return decisions[len(decisions)-1]
+		}
+		return decisions[calls-1]
+	}, &calls
+}
+
+// TestContinuePolicyWait tests the suspend, continue and abort decisions of the continue policy.
+func TestContinuePolicyWait(t *testing.T) {
+	tests := map[string]struct {
+		decisions []Decision
+		cancel    bool
+		expected  error
+		calls     int
+	}{
+		"continue":        {decisions: []Decision{DecisionContinue}, calls: 1},
+		"suspendContinue": {decisions: []Decision{DecisionSuspend, DecisionSuspend, DecisionContinue}, calls: 3},
+		"suspendAbort":    {decisions: []Decision{DecisionSuspend, DecisionAbort}, expected: ErrAborted, calls: 2},
+		"abort":           {decisions: []Decision{DecisionAbort}, expected: ErrAborted, calls: 1},
+		"cancel":          {decisions: []Decision{DecisionSuspend}, cancel: true, expected: ErrCancel, calls: 1},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy, calls := sequencePolicy(test.decisions...)
+			done := make(chan struct{})
+			if test.cancel {
+				close(done)
+			}
+			if err := policy.Wait(time.Millisecond, done); err != test.expected {
+				t.Fatalf("expected %v, got: %v", test.expected, err)
+			}
+			if *calls != test.calls {
+				t.Fatalf("expected %d policy calls, got %d", test.calls, *calls)
+			}
+		})
+	}
+
+	// A missing policy always continues.
+	if err := ContinuePolicy(nil).Wait(0, make(chan struct{})); err != nil {
+		t.Fatalf("missing policy must continue: %v", err)
+	}
+}
+
+// TestDownloadContinuePolicy tests that the downloads are suspended and aborted by the continue policy and
+// the aborted downloads are resumed from their partial file.
+func TestDownloadContinuePolicy(t *testing.T) {
+	body := strings.Repeat("continue policy content ", 512)
+	sum := md5.Sum([]byte(body))
+
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	newArtifact := func() *Artifact {
+		return &Artifact{
+			FileName: "test-continue.txt", Size: len(body), Link: srv.URL + "/test-continue.txt",
+			HashType:  "MD5",
+			HashValue: hex.EncodeToString(sum[:]),
+		}
+	}
+	newServer := func(policy ContinuePolicy) ServerConfig {
+		return ServerConfig{Buffers: NewBufferPool(1024, 0), Continue: policy, ContinueInterval: time.Nanosecond}
+	}
+
+	t.Run("suspend", func(t *testing.T) {
+		ranges = nil
+		file := filepath.Join(t.TempDir(), "test-continue.txt")
+		policy, calls := sequencePolicy(DecisionContinue, DecisionSuspend, DecisionSuspend, DecisionContinue)
+		if err := downloadArtifact(OSFileSystem{}, file, newArtifact(), nil, newServer(policy), 0, 0, nil, make(chan struct{})); err != nil {
+			t.Fatalf("failed to download artifact: %v", err)
+		}
+		if data, err := os.ReadFile(file); err != nil || string(data) != body {
+			t.Fatalf("unexpected downloaded content: %v", err)
+		}
+		if *calls < 4 {
+			t.Fatalf("expected the suspended download to consult the policy, got %d calls", *calls)
+		}
+		if len(ranges) != 1 {
+			t.Fatalf("expected a single request for the suspended download, got %q", ranges)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		ranges = nil
+		dir := t.TempDir()
+		file := filepath.Join(dir, "test-continue.txt")
+		tmp := filepath.Join(dir, prefix+"test-continue.txt")
+		policy, _ := sequencePolicy(DecisionContinue, DecisionContinue, DecisionAbort)
+		if err := downloadArtifact(OSFileSystem{}, file, newArtifact(), nil, newServer(policy), 3, 0, nil, make(chan struct{})); err != ErrAborted {
+			t.Fatalf("expected aborted download, got: %v", err)
+		}
+		stat, err := os.Stat(tmp)
+		if err != nil {
+			t.Fatalf("partial file of the aborted download is not kept: %v", err)
+		}
+		if stat.Size() == 0 || stat.Size() >= int64(len(body)) {
+			t.Fatalf("unexpected partial file size: %d", stat.Size())
+		}
+		if len(ranges) != 1 {
+			t.Fatalf("aborted download must not be retried, got requests %q", ranges)
+		}
+
+		// Resume the aborted download.
+		policy, _ = sequencePolicy(DecisionContinue)
+		if err := downloadArtifact(OSFileSystem{}, file, newArtifact(), nil, newServer(policy), 0, 0, nil, make(chan struct{})); err != nil {
+			t.Fatalf("failed to resume aborted download: %v", err)
+		}
+		if data, err := os.ReadFile(file); err != nil || string(data) != body {
+			t.Fatalf("unexpected downloaded content: %v", err)
+		}
+		if expected := "bytes=" + strconv.FormatInt(stat.Size(), 10) + "-"; len(ranges) != 2 || ranges[1] != expected {
+			t.Fatalf("expected resume with range %s, got requests %q", expected, ranges)
+		}
+	})
+}
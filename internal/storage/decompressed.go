@@ -0,0 +1,102 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// decompressedHash validates the gzip decompressed content of the written data against the decompressed artifact
+// hashes on a separate goroutine, so that the compressed artifact is kept as downloaded and is still verified
+// against the hashes of its content in the same pass.
+type decompressedHash struct {
+	pipe   *io.PipeWriter
+	result chan error
+}
+
+// newDecompressedHash starts the validation of the decompressed content of the data, written to the returned hash,
+// or returns nil, if the artifact has no decompressed hashes.
+func newDecompressedHash(artifact *Artifact) (*decompressedHash, error) {
+	if len(artifact.Decompressed) == 0 {
+		return nil, nil
+	}
+	expected := make([][]byte, len(artifact.Decompressed))
+	actual := make([]hash.Hash, len(artifact.Decompressed))
+	writers := make([]io.Writer, len(artifact.Decompressed))
+	for i, h := range artifact.Decompressed {
+		var err error
+		if expected[i], err = decodeHash(h.Type, artifact.HashEncoding, h.Value); err != nil {
+			return nil, err
+		}
+		if actual[i], err = newHash(h.Type); err != nil {
+			return nil, err
+		}
+		writers[i] = actual[i]
+	}
+
+	data, pipe := io.Pipe()
+	h := &decompressedHash{pipe: pipe, result: make(chan error, 1)}
+	go func() {
+		err := decompress(io.MultiWriter(writers...), data)
+		if err == nil {
+			for i, dh := range artifact.Decompressed {
+				if sum := actual[i].Sum(nil); !bytes.Equal(sum, expected[i]) {
+					err = fmt.Errorf("%w: decompressed %s %s != %s", ErrChecksumMismatch, dh.Type,
+						hex.EncodeToString(sum), dh.Value)
+					break
+				}
+			}
+		}
+		data.CloseWithError(err)
+		h.result <- err
+	}()
+	return h, nil
+}
+
+// decompress writes the gzip decompressed content of the data to w. Malformed gzip data fails with ErrChecksumMismatch.
+func decompress(w io.Writer, data io.Reader) error {
+	gz, err := gzip.NewReader(data)
+	if err == nil {
+		_, err = io.Copy(w, gz)
+	}
+	if err == io.ErrClosedPipe || err == errHashingStopped {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: invalid gzip content: %v", ErrChecksumMismatch, err)
+	}
+	return nil
+}
+
+// Write passes the compressed data to the decompressing goroutine.
+func (h *decompressedHash) Write(p []byte) (int, error) {
+	return h.pipe.Write(p)
+}
+
+// finish returns the validation result of the decompressed content of all written data.
+func (h *decompressedHash) finish() error {
+	h.pipe.Close()
+	return <-h.result
+}
+
+// stop stops the decompressing goroutine, if not finished yet.
+func (h *decompressedHash) stop() {
+	if h != nil {
+		h.pipe.CloseWithError(errHashingStopped)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// TestDownloadDecompressedChecksum tests that the decompressed content of a gzip artifact is verified in the same
+// pass as the artifact itself, while the downloaded artifact is kept compressed.
+func TestDownloadDecompressedChecksum(t *testing.T) {
+	content := strings.Repeat("decompressed content test\n", 1000)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(content))
+	gz.Close()
+	body := compressed.Bytes()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "plain.txt") {
+			w.Write([]byte(content))
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	contentSum := sha256.Sum256([]byte(content))
+	tests := map[string]struct {
+		link         string
+		data         []byte
+		decompressed string
+		hashing      string
+		expected     error
+	}{
+		"default":    {link: "/artifact.gz", data: body, decompressed: hex.EncodeToString(contentSum[:])},
+		"overlapped": {link: "/artifact.gz", data: body, decompressed: hex.EncodeToString(contentSum[:]), hashing: HashingOverlapped},
+		"mismatch":   {link: "/artifact.gz", data: body, decompressed: hex.EncodeToString(make([]byte, sha256.Size)), expected: ErrChecksumMismatch},
+		"not-gzip":   {link: "/plain.txt", data: []byte(content), decompressed: hex.EncodeToString(contentSum[:]), expected: ErrChecksumMismatch},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sum := md5.Sum(test.data)
+			sa := &hawkbit.SoftwareArtifactAction{
+				Filename:              "artifact.gz",
+				Size:                  len(test.data),
+				Checksums:             map[hawkbit.Hash]string{hawkbit.MD5: hex.EncodeToString(sum[:])},
+				DecompressedChecksums: map[hawkbit.Hash]string{hawkbit.SHA256: test.decompressed},
+				Download:              map[hawkbit.Protocol]*hawkbit.Links{hawkbit.HTTP: {URL: srv.URL + test.link}},
+			}
+			art, err := toArtifact(sa, false, false)
+			if err != nil {
+				t.Fatalf("failed to convert artifact: %v", err)
+			}
+			name := filepath.Join(t.TempDir(), art.FileName)
+			err = downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Hashing: test.hashing}, 0, 0, nil, make(chan struct{}))
+			if test.expected != nil {
+				if !errors.Is(err, test.expected) || !(strings.Contains(err.Error(), "decompressed") || strings.Contains(err.Error(), "gzip")) {
+					t.Fatalf("expected decompressed checksum mismatch: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("failed to read downloaded artifact: %v", err)
+			}
+			if !bytes.Equal(data, body) {
+				t.Fatal("downloaded artifact is not kept compressed")
+			}
+			// The stored artifact is verified again with its decompressed content, e.g. before its installation.
+			if err := validate(OSFileSystem{}, name, art, nil); err != nil {
+				t.Fatalf("failed to validate downloaded artifact: %v", err)
+			}
+		})
+	}
+}
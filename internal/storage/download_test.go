@@ -15,16 +15,37 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+	"github.com/eclipse-kanto/software-update/util/testutil"
 )
 
 const (
@@ -167,14 +188,14 @@ func testDownloadToFile(arts []*Artifact, certFile string, t *testing.T) {
 			defer os.RemoveAll(dir)
 
 			// Start http(s) server
-			srv := NewTestHTTPServer(":43234", art.FileName, int64(art.Size), t)
+			srv := testutil.NewHTTPServer(":43234", art.FileName, int64(art.Size), t)
 			srv.Host(false, isSecure(art.Link, t), validCert, validKey)
 			defer srv.Close()
 			name := filepath.Join(dir, art.FileName)
 
 			// 1. Resume download of corrupted temporary file.
 			WriteLn(filepath.Join(dir, prefix+art.FileName), "wrong start")
-			if err := downloadArtifact(name, art, nil, certFile, 0, 0, nil, make(chan struct{})); err == nil {
+			if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Cert: certFile}, 0, 0, nil, make(chan struct{})); err == nil {
 				t.Fatal("download of corrupted temporary file must fail")
 			}
 
@@ -183,7 +204,7 @@ func testDownloadToFile(arts []*Artifact, certFile string, t *testing.T) {
 			callback := func(bytes int64) {
 				close(done)
 			}
-			if err := downloadArtifact(name, art, callback, certFile, 0, 0, nil, done); err != ErrCancel {
+			if err := downloadArtifact(OSFileSystem{}, name, art, callback, ServerConfig{Cert: certFile}, 0, 0, nil, done); err != ErrCancel {
 				t.Fatalf("failed to cancel download operation: %v", err)
 			}
 			if _, err := os.Stat(filepath.Join(dir, prefix+art.FileName)); os.IsNotExist(err) {
@@ -192,13 +213,13 @@ func testDownloadToFile(arts []*Artifact, certFile string, t *testing.T) {
 
 			// 3. Resume previous download operation.
 			callback = func(bytes int64) { /* Do nothing. */ }
-			if err := downloadArtifact(name, art, callback, certFile, 0, 0, nil, make(chan struct{})); err != nil {
+			if err := downloadArtifact(OSFileSystem{}, name, art, callback, ServerConfig{Cert: certFile}, 0, 0, nil, make(chan struct{})); err != nil {
 				t.Fatalf("failed to download artifact: %v", err)
 			}
 			check(name, art.Size, t)
 
 			// 4. Download available file.
-			if err := downloadArtifact(name, art, callback, certFile, 0, 0, nil, make(chan struct{})); err != nil {
+			if err := downloadArtifact(OSFileSystem{}, name, art, callback, ServerConfig{Cert: certFile}, 0, 0, nil, make(chan struct{})); err != nil {
 				t.Fatalf("failed to download artifact: %v", err)
 			}
 			check(name, art.Size, t)
@@ -211,14 +232,14 @@ func testDownloadToFile(arts []*Artifact, certFile string, t *testing.T) {
 			// 5. Try to resume with file bigger than expected.
 			WriteLn(filepath.Join(dir, prefix+art.FileName), "1111111111111")
 			art.Size -= 10
-			if err := downloadArtifact(name, art, nil, certFile, 0, 0, nil, make(chan struct{})); err == nil {
+			if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Cert: certFile}, 0, 0, nil, make(chan struct{})); err == nil {
 				t.Fatal("validate resume with file bigger than expected")
 			}
 
 			// 6. Try to resume from missing link.
 			WriteLn(filepath.Join(dir, prefix+art.FileName), "1111111111111")
 			art.Link = "http://localhost:43234/test-missing.txt"
-			if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
+			if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err == nil {
 				t.Fatal("failed to validate with missing link")
 			}
 
@@ -235,7 +256,7 @@ func TestDownloadToFileLocalLink(t *testing.T) {
 		t.Fatal("failed to create temp file", err)
 	}
 	defer os.Remove(name)
-	write(file, size, false)
+	testutil.WriteContent(file, size)
 	file.Close()
 	testDownloadToFile([]*Artifact{
 		{ // A Local Artifact with MD5 checksum.
@@ -257,6 +278,60 @@ func TestDownloadToFileLocalLink(t *testing.T) {
 			Local:     true,
 		},
 	}, "", t)
+
+	// Large local artifact with memory-mapped and buffered reads.
+	dir := t.TempDir()
+	large := filepath.Join(dir, "local-large.bin")
+	largeSize := int64(32 << 20)
+	hashValue := writeLargeFile(large, largeSize, t)
+	for _, fs := range []OSFileSystem{{}, {MmapChecksum: true}} {
+		art := &Artifact{
+			FileName: large, Size: int(largeSize), Link: large,
+			HashType:  "SHA256",
+			HashValue: hashValue,
+			Local:     true,
+		}
+		name := filepath.Join(dir, fmt.Sprintf("mmap-%v", fs.MmapChecksum))
+		if err := downloadArtifact(fs, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err != nil {
+			t.Fatalf("failed to download large local artifact [mmap: %v]: %v", fs.MmapChecksum, err)
+		}
+		check(name, art.Size, t)
+
+		art.HashValue = "4eefb9a7a40a8b314b586a00f307157043c0bbe4f59fa39cba88773680758bc3"
+		if err := validate(fs, name, art, nil); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected checksum mismatch of large local artifact [mmap: %v]: %v", fs.MmapChecksum, err)
+		}
+	}
+}
+
+// BenchmarkValidateLocalArtifact compares the checksum calculation of local artifacts with buffered and memory-mapped reads.
+func BenchmarkValidateLocalArtifact(b *testing.B) {
+	name := filepath.Join(b.TempDir(), "local-large.bin")
+	size := int64(64 << 20)
+	art := &Artifact{FileName: name, Size: int(size), Link: name, HashType: "SHA256", Local: true}
+	art.HashValue = writeLargeFile(name, size, b)
+	for _, fs := range []OSFileSystem{{}, {MmapChecksum: true}} {
+		b.Run(fmt.Sprintf("mmap-%v", fs.MmapChecksum), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if err := validate(fs, name, art, nil); err != nil {
+					b.Fatalf("failed to validate local artifact: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// writeLargeFile writes a file with the given size and returns its SHA256 checksum.
+func writeLargeFile(name string, size int64, tb testing.TB) string {
+	file, err := os.Create(name)
+	if err != nil {
+		tb.Fatalf("failed to create file %s: %v", name, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	testutil.WriteContent(io.MultiWriter(file, hash), size)
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 // TestDownloadToFileError tests downloadToFile function for some edge cases.
@@ -277,40 +352,45 @@ func TestDownloadToFileError(t *testing.T) {
 	}
 
 	// Start http(s) server
-	srv := NewTestHTTPServer(":43234", art.FileName, int64(art.Size), t)
+	srv := testutil.NewHTTPServer(":43234", art.FileName, int64(art.Size), t)
 	srv.Host(true, isSecure(art.Link, t), untrustedCert, untrustedKey)
 	defer srv.Close()
 	name := filepath.Join(dir, art.FileName)
 
 	// 1. Resume is not supported.
 	WriteLn(filepath.Join(dir, prefix+art.FileName), "1111")
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err != nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err != nil {
 		t.Fatalf("failed to download file artifact: %v", err)
 	}
 	check(name, art.Size, t)
 
-	// 2. Try with missing checksum.
+	// 2. Try with missing checksum, accepted only by the missing checksum policy.
 	art.HashValue = ""
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
-		t.Fatal("validated with missing checksum")
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("validated with missing checksum: %v", err)
+	}
+	server := ServerConfig{MissingChecksum: MissingChecksumSkip}
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, server, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download artifact with missing checksum: %v", err)
 	}
+	check(name, art.Size, t)
 
 	// 3. Try with missing link.
 	art.Link = "http://localhost:43234/test-missing.txt"
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
-		t.Fatal("failed to validate with missing link")
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); !errors.Is(err, ErrBadStatus) {
+		t.Fatalf("failed to validate with missing link: %v", err)
 	}
 
 	// 4. Try with wrong checksum type.
 	art.Link = "http://localhost:43234/test-simple.txt"
 	art.HashType = ""
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatal("validate with wrong checksum type")
 	}
 
 	// 5. Try with wrong checksum format.
 	art.HashValue = ";;"
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatal("validate with wrong checksum format")
 	}
 
@@ -318,10 +398,1145 @@ func TestDownloadToFileError(t *testing.T) {
 	art.HashType = "MD5"
 	art.HashValue = "ab2ce340d36bbaafe17965a3a2c6ed5b"
 	art.Size -= 10
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
-		t.Fatal("validate with file bigger than expected")
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); !errors.Is(err, ErrFileSizeExceeded) {
+		t.Fatalf("validate with file bigger than expected: %v", err)
+	}
+
+}
+
+// TestDownloadAuthToken tests that the authorization token is sent to the artifacts server.
+func TestDownloadAuthToken(t *testing.T) {
+	dir := t.TempDir()
+	body := "authorized content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	art := &Artifact{
+		FileName: "test-auth.txt", Size: len(body), Link: srv.URL + "/test-auth.txt",
+		HashType:  "MD5",
+		HashValue: "4e54acb9ed2abbed670f23cef3b80e57",
 	}
+	name := filepath.Join(dir, art.FileName)
 
+	// 1. Try without authorization token.
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); !errors.Is(err, ErrBadStatus) {
+		t.Fatalf("expected unauthorized download to fail: %v", err)
+	}
+
+	// 2. Download with authorization token.
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{AuthToken: "test-token"}, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download file artifact: %v", err)
+	}
+	check(name, art.Size, t)
+}
+
+// TestDownloadLinkCredentials tests that the credentials of the artifact links are sent as basic authentication,
+// also by the retried and the resumed requests, and never logged or reported.
+func TestDownloadLinkCredentials(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "test.log")
+	defer logger.SetupLogger(&logger.LogConfig{})
+	out := logger.SetupLogger(&logger.LogConfig{LogFile: log, LogLevel: "TRACE", LogFileSize: 2, LogFileCount: 1})
+	defer out.Close()
+
+	body := strings.Repeat("content with credentials ", 1024)
+	var requests, resumed int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&resumed, 1)
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// Abort the first download in the middle, to be resumed.
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write([]byte(body[:len(body)/2]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	hash := sha256.Sum256([]byte(body))
+	host := strings.TrimPrefix(srv.URL, "http://")
+	art := &Artifact{
+		FileName: "app.bin", Size: len(body), Link: "http://user:s3cr3t@" + host + "/app.bin",
+		HashType: "SHA256", HashValue: hex.EncodeToString(hash[:]),
+	}
+	name := filepath.Join(dir, art.FileName)
+
+	// 1. Download with the link credentials instead of the authorization token, resuming the aborted download.
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{AuthToken: "test-token"}, 1, 0, nil,
+		make(chan struct{})); err != nil {
+		t.Fatalf("failed to download artifact with link credentials: %v", err)
+	}
+	check(name, art.Size, t)
+	if atomic.LoadInt32(&resumed) != 1 {
+		t.Fatalf("aborted download is not resumed: %d requests", atomic.LoadInt32(&requests))
+	}
+
+	// 2. Fail with wrong link credentials.
+	art.Link = "http://user:wr0ng@" + host + "/app.bin"
+	err := downloadArtifact(OSFileSystem{}, filepath.Join(dir, "wrong.bin"), art, nil, ServerConfig{}, 1, 0, nil,
+		make(chan struct{}))
+	if !errors.Is(err, ErrBadStatus) || strings.Contains(err.Error(), "wr0ng") {
+		t.Fatalf("expected download with wrong credentials to fail without them: %v", err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	if !strings.Contains(string(data), "http://xxxxx@"+host+"/app.bin") {
+		t.Fatalf("redacted artifact link is not logged: %s", data)
+	}
+	for _, secret := range []string{"s3cr3t", "wr0ng"} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("credentials %s are logged: %s", secret, data)
+		}
+	}
+}
+
+// TestDownloadHTTP2 tests the HTTP/2 negotiation with an HTTP/2 capable server and its disabling.
+func TestDownloadHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	cert := filepath.Join(dir, "server.pem")
+	if err := os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatalf("failed to write server certificate: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		server   ServerConfig
+		expected string
+		conns    int32
+	}{
+		{name: "HTTP2", server: ServerConfig{Cert: cert}, expected: "HTTP/2.0", conns: 1},
+		{name: "HTTP2Disabled", server: ServerConfig{Cert: cert, DisableHTTP2: true}, expected: "HTTP/1.1", conns: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&conns, 0)
+			get := func(delay time.Duration) error {
+				resp, err := requestDownload(srv.URL+"/test.txt", 0, test.server)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				time.Sleep(delay) // Keep the connection busy.
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return err
+				}
+				if resp.Proto != test.expected || string(body) != test.expected {
+					return fmt.Errorf("expected protocol %s, but got %s, served with %s", test.expected, resp.Proto, body)
+				}
+				return nil
+			}
+			// Establish the connection to the server.
+			if err := get(0); err != nil {
+				t.Fatal(err)
+			}
+			// Send concurrent requests to the same server.
+			var wg sync.WaitGroup
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := get(100 * time.Millisecond); err != nil {
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+			if c := atomic.LoadInt32(&conns); c != test.conns {
+				t.Fatalf("expected %d connection(s) to the server, but got %d", test.conns, c)
+			}
+		})
+	}
+}
+
+// TestDownloadCompression tests the transparent gzip compression of the download responses and its disabling.
+func TestDownloadCompression(t *testing.T) {
+	body := "uncompressed artifact content"
+	var encodings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	sum := md5.Sum([]byte(body))
+	tests := []struct {
+		name     string
+		server   ServerConfig
+		expected string
+	}{
+		{name: "CompressionEnabled", server: ServerConfig{}, expected: "gzip"},
+		{name: "CompressionDisabled", server: ServerConfig{DisableCompression: true, ReadBufferSize: 1500}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encodings = nil
+			art := &Artifact{
+				FileName: "test-compression.txt", Size: len(body), Link: srv.URL + "/test-compression.txt",
+				HashType: "MD5", HashValue: hex.EncodeToString(sum[:]),
+			}
+			file := filepath.Join(t.TempDir(), art.FileName)
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, test.server, 0, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			if len(encodings) != 1 || encodings[0] != test.expected {
+				t.Fatalf("expected accepted encoding %q, got %q", test.expected, encodings)
+			}
+
+			client, err := httpClient(&url.URL{Scheme: "http", Host: srv.Listener.Addr().String()}, test.server)
+			if err != nil {
+				t.Fatalf("failed to get HTTP client: %v", err)
+			}
+			transport := client.Transport.(*http.Transport)
+			if transport.DisableCompression != test.server.DisableCompression || transport.ReadBufferSize != test.server.ReadBufferSize {
+				t.Fatalf("unexpected transport configuration: compression disabled %v, read buffer size %d",
+					transport.DisableCompression, transport.ReadBufferSize)
+			}
+		})
+	}
+}
+
+// TestDownloadServerName tests the verification of the server certificate against the expected host name,
+// when downloading by IP address.
+func TestDownloadServerName(t *testing.T) {
+	body := "verified content"
+	cert, err := tls.LoadX509KeyPair(validCert, validKey)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	var sni string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sni = r.TLS.ServerName
+		w.Write([]byte(body))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := map[string]struct {
+		serverName string
+		valid      bool
+	}{
+		"matching":    {serverName: "localhost", valid: true},
+		"mismatching": {serverName: "updates.example.com"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sni = ""
+			art := &Artifact{
+				FileName: "test-server-name.txt", Size: len(body), Link: srv.URL + "/test-server-name.txt",
+				HashType:  "MD5",
+				HashValue: fmt.Sprintf("%x", md5.Sum([]byte(body))),
+			}
+			file := filepath.Join(t.TempDir(), art.FileName)
+			err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{Cert: validCert, ServerName: test.serverName},
+				0, 0, nil, make(chan struct{}))
+			if !test.valid {
+				var certErr x509.HostnameError
+				if !errors.As(err, &certErr) {
+					t.Fatalf("expected host name verification error, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			check(file, len(body), t)
+			if sni != test.serverName {
+				t.Fatalf("unexpected server name indication: %s", sni)
+			}
+		})
+	}
+}
+
+// TestDownloadInsecureSkipVerify tests that artifacts from servers with untrusted certificates are downloaded
+// only with disabled certificate verification.
+func TestDownloadInsecureSkipVerify(t *testing.T) {
+	body := "untrusted content"
+	cert, err := tls.LoadX509KeyPair(untrustedCert, untrustedKey)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	art := &Artifact{
+		FileName: "test-insecure.txt", Size: len(body), Link: srv.URL + "/test-insecure.txt",
+		HashType:  "MD5",
+		HashValue: fmt.Sprintf("%x", md5.Sum([]byte(body))),
+	}
+	file := filepath.Join(t.TempDir(), art.FileName)
+
+	// 1. Untrusted certificate is rejected by default.
+	err = downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{}))
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if !errors.As(err, &authorityErr) && !errors.As(err, &hostnameErr) {
+		t.Fatalf("expected certificate verification error, got: %v", err)
+	}
+
+	// 2. Untrusted certificate is accepted with disabled verification.
+	if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{InsecureSkipVerify: true}, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download artifact with disabled certificate verification: %v", err)
+	}
+	check(file, len(body), t)
+}
+
+// TestDownloadVerify tests the structural verification of the downloaded artifacts after their checksum.
+func TestDownloadVerify(t *testing.T) {
+	dir := t.TempDir()
+	body := "MAGIC artifact content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(body))
+	art := &Artifact{
+		FileName: "test-verify.bin", Size: len(body), Link: srv.URL + "/test-verify.bin",
+		HashType: "SHA256", HashValue: hex.EncodeToString(sum[:]),
+	}
+	var calls int32
+	verifier := func(magic string) ArtifactVerifier {
+		return func(artifact *Artifact, data io.ReaderAt, size int64) error {
+			atomic.AddInt32(&calls, 1)
+			header := make([]byte, len(magic))
+			if _, err := data.ReadAt(header, 0); err != nil {
+				return err
+			}
+			if size != int64(len(body)) || string(header) != magic {
+				return fmt.Errorf("unexpected header %s", header)
+			}
+			return nil
+		}
+	}
+
+	// 1. Download with passing verifier.
+	name := filepath.Join(dir, art.FileName)
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Verify: verifier("MAGIC")}, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download verified artifact: %v", err)
+	}
+	check(name, art.Size, t)
+	if _, err := downloadData(art, 1024, ServerConfig{Verify: verifier("MAGIC")}, 0, 0, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download verified artifact to memory: %v", err)
+	}
+
+	// 2. Download with failing verifier is retried.
+	os.Remove(name)
+	atomic.StoreInt32(&calls, 0)
+	err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Verify: verifier("OTHER")}, 2, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrArtifactInvalid) {
+		t.Fatalf("expected rejected artifact: %v", err)
+	}
+	if c := atomic.LoadInt32(&calls); c != 3 {
+		t.Fatalf("expected verification of each download attempt, but got %d", c)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("rejected artifact is not removed: %v", err)
+	}
+	if _, err := downloadData(art, 1024, ServerConfig{Verify: verifier("OTHER")}, 0, 0, make(chan struct{})); !errors.Is(err, ErrArtifactInvalid) {
+		t.Fatalf("expected rejected artifact in memory: %v", err)
+	}
+}
+
+// TestDownloadSizeRange tests the artifact size verification against a size range and the exact size.
+func TestDownloadSizeRange(t *testing.T) {
+	dir := t.TempDir()
+	body := "authorized content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		size     int
+		minSize  int
+		maxSize  int
+		expected error
+	}{
+		"inRange":        {minSize: len(body) - 5, maxSize: len(body) + 5},
+		"inRangeNoMax":   {minSize: len(body)},
+		"inRangeNoMin":   {maxSize: len(body)},
+		"belowRange":     {minSize: len(body) + 1, maxSize: len(body) + 5, expected: ErrFileSizeMismatch},
+		"aboveRange":     {minSize: 1, maxSize: len(body) - 1, expected: ErrFileSizeExceeded},
+		"rangeOverSize":  {size: 1, minSize: 1, maxSize: len(body)},
+		"exactSize":      {size: len(body)},
+		"belowExactSize": {size: len(body) + 1, expected: ErrFileSizeMismatch},
+		"aboveExactSize": {size: len(body) - 1, expected: ErrFileSizeExceeded},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			art := &Artifact{
+				FileName: "test-size.txt", Link: srv.URL + "/test-size.txt",
+				Size: test.size, MinSize: test.minSize, MaxSize: test.maxSize,
+				HashType:  "MD5",
+				HashValue: "4e54acb9ed2abbed670f23cef3b80e57",
+			}
+			file := filepath.Join(dir, name)
+			err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{}))
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("failed to download file artifact: %v", err)
+				}
+				check(file, len(body), t)
+				return
+			}
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got: %v", test.expected, err)
+			}
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Fatalf("file artifact with unexpected size is not removed: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadETag tests the verification of the artifact server entity tag.
+func TestDownloadETag(t *testing.T) {
+	dir := t.TempDir()
+	body := "authorized content"
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `W/"test-etag"`)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		etag     string
+		expected error
+	}{
+		"noETag":       {},
+		"matching":     {etag: `"test-etag"`},
+		"matchingWeak": {etag: `W/"test-etag"`},
+		"mismatching":  {etag: `"poisoned-etag"`, expected: ErrETagMismatch},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests = 0
+			art := &Artifact{
+				FileName: "test-etag.txt", Size: len(body), Link: srv.URL + "/test-etag.txt", ETag: test.etag,
+				HashType:  "MD5",
+				HashValue: "4e54acb9ed2abbed670f23cef3b80e57",
+			}
+			file := filepath.Join(dir, name)
+			err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{}, 3, 0, nil, make(chan struct{}))
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("failed to download file artifact: %v", err)
+				}
+				check(file, len(body), t)
+				return
+			}
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got: %v", test.expected, err)
+			}
+			if requests != 1 {
+				t.Fatalf("expected no retries on entity tag mismatch, got %d requests", requests)
+			}
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Fatalf("file artifact with mismatching entity tag is created: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadBlocks tests that corrupted artifact blocks are downloaded again from their beginning.
+func TestDownloadBlocks(t *testing.T) {
+	const blockSize = 1024
+	data := make([]byte, 4*blockSize+blockSize/2)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	var hashes []string
+	for start := 0; start < len(data); start += blockSize {
+		end := start + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha256.Sum256(data[start:end])
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+	sum := sha256.Sum256(data)
+
+	var ranges []string
+	var corrupted int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		served := append([]byte{}, data...)
+		if len(ranges) == 1 && corrupted >= 0 {
+			served[corrupted] ^= 0xff
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(served))
+	}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		corrupted int
+		partial   int
+		noBlocks  bool
+		expected  []string
+	}{
+		"corruptedBlock":     {corrupted: 2*blockSize + 10, expected: []string{"", "bytes=2048-"}},
+		"corruptedLastBlock": {corrupted: len(data) - 1, expected: []string{"", "bytes=4096-"}},
+		"corruptedNoBlocks":  {corrupted: 2*blockSize + 10, noBlocks: true, expected: []string{"", ""}},
+		"corruptedPartial":   {corrupted: -1, partial: blockSize + blockSize/2, expected: []string{"bytes=1536-", "bytes=1024-"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ranges = nil
+			corrupted = test.corrupted
+			art := &Artifact{
+				FileName: "test-blocks.bin", Size: len(data), Link: srv.URL + "/test-blocks.bin",
+				HashType: "SHA256", HashValue: hex.EncodeToString(sum[:]),
+				Blocks: &Blocks{Size: blockSize, HashType: "SHA256", Hashes: hashes},
+			}
+			if test.noBlocks {
+				art.Blocks = nil
+			}
+			file := filepath.Join(t.TempDir(), art.FileName)
+			if test.partial > 0 {
+				// Partial file with corrupted already downloaded bytes of the last block.
+				partial := append([]byte{}, data[:test.partial]...)
+				partial[blockSize+10] ^= 0xff
+				if err := os.WriteFile(filepath.Join(filepath.Dir(file), prefix+art.FileName), partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{}, 1, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			if actual, err := os.ReadFile(file); err != nil || !bytes.Equal(actual, data) {
+				t.Fatalf("unexpected downloaded artifact: %v", err)
+			}
+			if !reflect.DeepEqual(ranges, test.expected) {
+				t.Fatalf("unexpected requested ranges: %q != %q", ranges, test.expected)
+			}
+		})
+	}
+}
+
+// TestResumePartialContentWithoutRange tests resume with servers returning partial content without Content-Range header.
+func TestResumePartialContentWithoutRange(t *testing.T) {
+	body := "authorized content"
+	offset := 5
+
+	tests := map[string]struct {
+		contentRange  string
+		contentLength bool
+		skip          int
+		ranges        []string
+	}{
+		"matchingLength":    {contentLength: true, ranges: []string{"bytes=5-"}},
+		"unknownLength":     {ranges: []string{"bytes=5-", ""}},
+		"mismatchingLength": {contentLength: true, skip: 1, ranges: []string{"bytes=5-", ""}},
+		"matchingRange":     {contentRange: "bytes 5-17/18", contentLength: true, ranges: []string{"bytes=5-"}},
+		"mismatchingRange":  {contentRange: "bytes 0-17/18", contentLength: true, ranges: []string{"bytes=5-", ""}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				if r.Header.Get("Range") == "" {
+					w.Write([]byte(body))
+					return
+				}
+				content := body[offset+test.skip:]
+				if test.contentRange != "" {
+					w.Header().Set("Content-Range", test.contentRange)
+				}
+				if test.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				}
+				w.WriteHeader(http.StatusPartialContent)
+				if !test.contentLength {
+					w.(http.Flusher).Flush()
+				}
+				w.Write([]byte(content))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			art := &Artifact{
+				FileName: "test-partial.txt", Size: len(body), Link: srv.URL + "/test-partial.txt",
+				HashType:  "MD5",
+				HashValue: "4e54acb9ed2abbed670f23cef3b80e57",
+			}
+			file := filepath.Join(dir, art.FileName)
+			if err := os.WriteFile(filepath.Join(dir, prefix+art.FileName), []byte(body[:offset]), 0644); err != nil {
+				t.Fatalf("failed to write partial download: %v", err)
+			}
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to resume download: %v", err)
+			}
+			check(file, art.Size, t)
+			if !reflect.DeepEqual(ranges, test.ranges) {
+				t.Fatalf("unexpected range requests: %q != %q", ranges, test.ranges)
+			}
+		})
+	}
+}
+
+// TestDownloadRestartEvents tests that the restart listener is notified with the reason, whenever a partial download
+// is abandoned and the artifact is downloaded from the beginning.
+func TestDownloadRestartEvents(t *testing.T) {
+	body := "restarted download content"
+	offset := 10
+	sum := md5.Sum([]byte(body))
+
+	tests := map[string]struct {
+		info     func(link string) *partialInfo
+		partial  string
+		expected []RestartReason
+		ranges   []string
+	}{
+		"resume": {
+			info:   func(link string) *partialInfo { return &partialInfo{Link: link, Size: int64(len(body)), ETag: `"v2"`} },
+			ranges: []string{"bytes=10-"},
+		},
+		"sizeMismatch": {
+			info:     func(link string) *partialInfo { return &partialInfo{Link: link, Size: int64(len(body)) + 1} },
+			expected: []RestartReason{RestartSizeMismatch},
+			ranges:   []string{""},
+		},
+		"sizeExceeded": {
+			partial:  body + "overflow",
+			expected: []RestartReason{RestartSizeMismatch},
+			ranges:   []string{""},
+		},
+		"linkMismatch": {
+			info: func(link string) *partialInfo {
+				return &partialInfo{Link: link + "?previous", Size: int64(len(body))}
+			},
+			expected: []RestartReason{RestartLinkMismatch},
+			ranges:   []string{""},
+		},
+		"etagChanged": {
+			info:     func(link string) *partialInfo { return &partialInfo{Link: link, Size: int64(len(body)), ETag: `"v1"`} },
+			expected: []RestartReason{RestartETagChanged},
+			ranges:   []string{"bytes=10-", ""},
+		},
+		"corrupted": {
+			partial:  strings.Repeat("x", len(body)),
+			expected: []RestartReason{RestartCorrupted},
+			ranges:   []string{""},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				w.Header().Set("ETag", `"v2"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			art := &Artifact{
+				FileName: "test-restart.txt", Size: len(body), Link: srv.URL + "/test-restart.txt",
+				HashType:  "MD5",
+				HashValue: hex.EncodeToString(sum[:]),
+			}
+			file := filepath.Join(dir, art.FileName)
+			tmp := filepath.Join(dir, prefix+art.FileName)
+			partial := test.partial
+			if partial == "" {
+				partial = body[:offset]
+			}
+			if err := os.WriteFile(tmp, []byte(partial), 0644); err != nil {
+				t.Fatalf("failed to write partial download: %v", err)
+			}
+			if test.info != nil {
+				data, _ := json.Marshal(test.info(art.Link))
+				if err := os.WriteFile(tmp+partialInfoSuffix, data, 0644); err != nil {
+					t.Fatalf("failed to write partial download information: %v", err)
+				}
+			}
+
+			var reasons []RestartReason
+			server := ServerConfig{OnRestart: func(artifact *Artifact, reason RestartReason) {
+				if artifact != art {
+					t.Errorf("unexpected restarted artifact: %v", artifact)
+				}
+				reasons = append(reasons, reason)
+			}}
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 1, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			if data, err := os.ReadFile(file); err != nil || string(data) != body {
+				t.Fatalf("unexpected downloaded content: %s, %v", data, err)
+			}
+			if !reflect.DeepEqual(reasons, test.expected) {
+				t.Fatalf("unexpected restart reasons: %q != %q", reasons, test.expected)
+			}
+			if !reflect.DeepEqual(ranges, test.ranges) {
+				t.Fatalf("unexpected range requests: %q != %q", ranges, test.ranges)
+			}
+			if _, err := os.Stat(tmp + partialInfoSuffix); !os.IsNotExist(err) {
+				t.Fatalf("partial download information is not removed: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadMaxRestarts tests that the download from a server, which keeps corrupting the artifact, fails with
+// ErrTooManyRestarts after the maximal restarts from the beginning, even if more retries are left.
+func TestDownloadMaxRestarts(t *testing.T) {
+	body := "content corrupted by the server"
+	sum := md5.Sum([]byte(body))
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(strings.ToUpper(body)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	art := &Artifact{
+		FileName: "test-restarts.txt", Size: len(body), Link: srv.URL + "/test-restarts.txt",
+		HashType:  "MD5",
+		HashValue: hex.EncodeToString(sum[:]),
+	}
+	file := filepath.Join(dir, art.FileName)
+
+	// 1. Fail after the maximal restarts.
+	err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{MaxRestarts: 2}, 10, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrTooManyRestarts) {
+		t.Fatalf("expected too many restarts error: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected the initial download and 2 restarts, got %d requests", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, prefix+art.FileName)); !os.IsNotExist(err) {
+		t.Fatalf("failed download file is not removed: %v", err)
+	}
+
+	// 2. Fail with the checksum error after all retries, if not limited.
+	atomic.StoreInt32(&requests, 0)
+	err = downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{}, 4, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch error: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Fatalf("expected the initial download and 4 retries, got %d requests", n)
+	}
+}
+
+// TestDownloadMaxRestartsRetries tests that the retries of the downloads, failed before receiving any data,
+// are not counted as restarts from the beginning.
+func TestDownloadMaxRestartsRetries(t *testing.T) {
+	body := "content sent on the third request"
+	sum := md5.Sum([]byte(body))
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	art := &Artifact{
+		FileName: "test-retries.txt", Size: len(body), Link: srv.URL + "/test-retries.txt",
+		HashType:  "MD5",
+		HashValue: hex.EncodeToString(sum[:]),
+	}
+	file := filepath.Join(t.TempDir(), art.FileName)
+	if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{MaxRestarts: 1}, 5, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download artifact after retries: %v", err)
+	}
+	check(file, len(body), t)
+}
+
+// TestDownloadRangeNotSatisfiable tests that a partial download, rejected with 416 by the artifact server,
+// is promoted, when already complete and valid, and downloaded from the beginning otherwise.
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	body := "complete partial content"
+	sum := md5.Sum([]byte(body))
+
+	tests := map[string]struct {
+		partial  string
+		expected []RestartReason
+		ranges   []string
+	}{
+		"complete": {
+			partial: body,
+			ranges:  []string{"bytes=24-"},
+		},
+		"corrupted": {
+			partial:  strings.Repeat("x", len(body)),
+			expected: []RestartReason{RestartCorrupted},
+			ranges:   []string{"bytes=24-", ""},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			// The exact size is not known, so the complete partial file is not validated before the request.
+			art := &Artifact{
+				FileName: "test-range.txt", MinSize: 1, MaxSize: 2 * len(body), Link: srv.URL + "/test-range.txt",
+				HashType:  "MD5",
+				HashValue: hex.EncodeToString(sum[:]),
+			}
+			file := filepath.Join(dir, art.FileName)
+			tmp := filepath.Join(dir, prefix+art.FileName)
+			if err := os.WriteFile(tmp, []byte(test.partial), 0644); err != nil {
+				t.Fatalf("failed to write partial download: %v", err)
+			}
+
+			var reasons []RestartReason
+			server := ServerConfig{OnRestart: func(artifact *Artifact, reason RestartReason) {
+				reasons = append(reasons, reason)
+			}}
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 1, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			if data, err := os.ReadFile(file); err != nil || string(data) != body {
+				t.Fatalf("unexpected downloaded content: %s, %v", data, err)
+			}
+			if !reflect.DeepEqual(reasons, test.expected) {
+				t.Fatalf("unexpected restart reasons: %q != %q", reasons, test.expected)
+			}
+			if !reflect.DeepEqual(ranges, test.ranges) {
+				t.Fatalf("unexpected range requests: %q != %q", ranges, test.ranges)
+			}
+			if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+				t.Fatalf("partial download is not promoted: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadTrustLocal tests that the checksum validation is skipped only for the local artifacts, trusted by
+// the server configuration, and that their size is still verified.
+func TestDownloadTrustLocal(t *testing.T) {
+	dir := t.TempDir()
+	body := "trusted local content"
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte(body), 0644); err != nil {
+		t.Fatalf("failed to write local artifact: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	wrongHash := strings.Repeat("0", 64)
+
+	tests := map[string]struct {
+		artifact Artifact
+		trust    bool
+		expected error
+	}{
+		"notTrusted":    {artifact: Artifact{Size: len(body), Link: source, Local: true}, expected: ErrChecksumMismatch},
+		"trusted":       {artifact: Artifact{Size: len(body), Link: source, Local: true}, trust: true},
+		"trustedSize":   {artifact: Artifact{Size: len(body) + 1, Link: source, Local: true}, trust: true, expected: ErrFileSizeMismatch},
+		"trustedRemote": {artifact: Artifact{Size: len(body), Link: srv.URL + "/remote.txt"}, trust: true, expected: ErrChecksumMismatch},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			art := test.artifact
+			art.FileName, art.HashType, art.HashValue = name+".txt", "SHA256", wrongHash
+			to := filepath.Join(dir, art.FileName)
+			err := downloadArtifact(OSFileSystem{}, to, &art, nil, ServerConfig{TrustLocal: test.trust}, 0, 0, nil, make(chan struct{}))
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("failed to download trusted local artifact: %v", err)
+				}
+				if data, err := os.ReadFile(to); err != nil || string(data) != body {
+					t.Fatalf("unexpected trusted local artifact content: %s, %v", data, err)
+				}
+				// The available trusted artifact is not downloaded again.
+				if err := downloadArtifact(OSFileSystem{}, to, &art, nil, ServerConfig{TrustLocal: true}, 0, 0, nil, make(chan struct{})); err != nil {
+					t.Fatalf("available trusted local artifact is not valid: %v", err)
+				}
+				if err := validate(OSFileSystem{}, to, &art, nil); !errors.Is(err, ErrChecksumMismatch) {
+					t.Fatalf("expected checksum mismatch of not trusted artifact, got: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got: %v", test.expected, err)
+			}
+			if _, err := os.Stat(to); !os.IsNotExist(err) {
+				t.Fatalf("invalid artifact is not removed: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadMissingChecksum tests that the artifacts without checksum are rejected by default and verified only
+// by their size, if accepted by the missing checksum policy.
+func TestDownloadMissingChecksum(t *testing.T) {
+	dir := t.TempDir()
+	body := "legacy content without checksum"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		policy   string
+		size     int
+		expected error
+	}{
+		"default":  {size: len(body), expected: ErrChecksumMismatch},
+		"fail":     {policy: MissingChecksumFail, size: len(body), expected: ErrChecksumMismatch},
+		"skip":     {policy: MissingChecksumSkip, size: len(body)},
+		"skipSize": {policy: MissingChecksumSkip, size: len(body) + 1, expected: ErrFileSizeMismatch},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			art := &Artifact{FileName: name + ".bin", Size: test.size, Link: srv.URL + "/legacy.bin"}
+			to := filepath.Join(dir, art.FileName)
+			server := ServerConfig{MissingChecksum: test.policy}
+			err := downloadArtifact(OSFileSystem{}, to, art, nil, server, 0, 0, nil, make(chan struct{}))
+			if test.expected != nil {
+				if !errors.Is(err, test.expected) {
+					t.Fatalf("expected %v, got: %v", test.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to download artifact without checksum: %v", err)
+			}
+			if data, err := os.ReadFile(to); err != nil || string(data) != body {
+				t.Fatalf("unexpected artifact content: %s, %v", data, err)
+			}
+			// The checksum of the artifacts with one is still verified.
+			art.HashType, art.HashValue = "SHA256", strings.Repeat("0", 64)
+			if err := validate(OSFileSystem{}, to, trust(art, server), nil); !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("expected checksum mismatch of artifact with checksum, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloadNoResume tests that the failed downloads from servers without content length and range support,
+// or with disabled resume, are restarted from the beginning without range requests.
+func TestDownloadNoResume(t *testing.T) {
+	body := strings.Repeat("not resumable content ", 1024)
+	sum := md5.Sum([]byte(body))
+
+	tests := map[string]struct {
+		contentLength bool
+		server        ServerConfig
+	}{
+		"detected":   {},
+		"configured": {contentLength: true, server: ServerConfig{NoResume: true}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				if test.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+					w.Header().Set("Accept-Ranges", "bytes")
+				}
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				if len(ranges) == 1 {
+					// Fail the first download in the middle of the transfer.
+					w.Write([]byte(body[:len(body)/2]))
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				w.Write([]byte(body))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			art := &Artifact{
+				FileName: "test-no-resume.txt", Size: len(body), Link: srv.URL + "/test-no-resume.txt",
+				HashType:  "MD5",
+				HashValue: hex.EncodeToString(sum[:]),
+			}
+			var reasons []RestartReason
+			server := test.server
+			server.OnRestart = func(artifact *Artifact, reason RestartReason) {
+				reasons = append(reasons, reason)
+			}
+			file := filepath.Join(dir, art.FileName)
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 1, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			if data, err := os.ReadFile(file); err != nil || string(data) != body {
+				t.Fatalf("unexpected downloaded content: %d bytes, %v", len(data), err)
+			}
+			if expected := []RestartReason{RestartNotSupported}; !reflect.DeepEqual(reasons, expected) {
+				t.Fatalf("unexpected restart reasons: %q != %q", reasons, expected)
+			}
+			if expected := []string{"", ""}; !reflect.DeepEqual(ranges, expected) {
+				t.Fatalf("unexpected range requests: %q != %q", ranges, expected)
+			}
+		})
+	}
+}
+
+// TestPartialInfo tests that the partial download information is kept on cancel, to be checked on resume.
+func TestPartialInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(make([]byte, 65536))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	art := &Artifact{FileName: "test-partial.txt", Size: 65536, Link: srv.URL + "/test-partial.txt"}
+
+	done := make(chan struct{})
+	callback := func(bytes int64) {
+		close(done)
+	}
+	file := filepath.Join(dir, art.FileName)
+	if err := downloadArtifact(OSFileSystem{}, file, art, callback, ServerConfig{}, 0, 0, nil, done); err != ErrCancel {
+		t.Fatalf("failed to cancel download operation: %v", err)
+	}
+	info := readPartialInfo(OSFileSystem{}, filepath.Join(dir, prefix+art.FileName))
+	if info == nil || info.Link != art.Link || info.Size != int64(art.Size) || info.ETag != `"v1"` {
+		t.Fatalf("unexpected partial download information: %+v", info)
+	}
+}
+
+// TestDownloadInPlace tests that the in-place download is resumed from its interrupted artifact file and
+// the artifact file is not available, until completed and verified.
+func TestDownloadInPlace(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+	sum := sha256.Sum256(data)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	art := &Artifact{
+		FileName: "test-in-place.bin", Size: len(data), Link: srv.URL + "/test-in-place.bin",
+		HashType: "SHA256", HashValue: hex.EncodeToString(sum[:]),
+	}
+	file := filepath.Join(dir, art.FileName)
+	tmp := filepath.Join(dir, prefix+art.FileName)
+	server := ServerConfig{InPlace: true}
+	// Temporary file, left by a previous download with rename.
+	if err := os.WriteFile(tmp, data[:1024], 0644); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+
+	// 1. Interrupt the download, keeping the partial artifact file with its partial download information.
+	done := make(chan struct{})
+	callback := func(bytes int64) {
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+	}
+	if err := downloadArtifact(OSFileSystem{}, file, art, callback, server, 0, 0, nil, done); err != ErrCancel {
+		t.Fatalf("failed to interrupt download: %v", err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil || stat.Size() >= int64(len(data)) {
+		t.Fatalf("unexpected interrupted artifact file: %v, %v", stat, err)
+	}
+	if !hasPartialInfo(OSFileSystem{}, file) {
+		t.Fatal("missing partial download information of the interrupted artifact file")
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("temporary file is not removed: %v", err)
+	}
+
+	// 2. Resume the download from the interrupted artifact file and verify it.
+	ranges = nil
+	if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to resume download: %v", err)
+	}
+	if expected := []string{fmt.Sprintf("bytes=%d-", stat.Size())}; !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("unexpected range requests: %q != %q", ranges, expected)
+	}
+	if actual, err := os.ReadFile(file); err != nil || !bytes.Equal(actual, data) {
+		t.Fatalf("unexpected downloaded artifact: %v", err)
+	}
+	if hasPartialInfo(OSFileSystem{}, file) {
+		t.Fatal("partial download information of the completed artifact file is not removed")
+	}
+
+	// 3. Keep the completed artifact file.
+	ranges = nil
+	if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download available artifact: %v", err)
+	}
+	if len(ranges) > 0 {
+		t.Fatalf("unexpected requests for available artifact: %q", ranges)
+	}
+	check(file, art.Size, t)
+
+	// 4. Remove the corrupted artifact file, after the retries are exhausted.
+	art.HashValue = strings.Repeat("0", len(art.HashValue))
+	os.Remove(file)
+	if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 0, 0, nil, make(chan struct{})); err == nil {
+		t.Fatal("expected error when downloading corrupted artifact")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("corrupted artifact file is not removed: %v", err)
+	}
+	if hasPartialInfo(OSFileSystem{}, file) {
+		t.Fatal("partial download information of the corrupted artifact file is not removed")
+	}
 }
 
 // TestRobustDownloadRetryBadStatus tests file download with retry strategy, when a bad response status is returned
@@ -339,31 +1554,216 @@ func TestRobustDownloadRetryBadStatus(t *testing.T) {
 		HashValue: "ab2ce340d36bbaafe17965a3a2c6ed5b",
 	}
 	// Start Web server
-	srv := NewTestHTTPServer(":43234", art.FileName, int64(art.Size), t)
-	setIncorrectBehavior(3, false, false)
+	srv := testutil.NewHTTPServer(":43234", art.FileName, int64(art.Size), t)
+	srv.SetBehavior(testutil.Behavior{BadStatusCount: 3})
 	srv.Host(false, false, "", "")
 	defer srv.Close()
 
 	name := filepath.Join(dir, art.FileName)
 
-	if err := downloadArtifact(name, art, nil, "", 1, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 1, 0, nil, make(chan struct{})); err == nil {
 		t.Fatal("error is expected when downloading artifact, due to bad response status")
 	}
 
-	if err := downloadArtifact(name, art, nil, "", 5, time.Second, nil, make(chan struct{})); err != nil {
+	fake := useFakeClock(t)
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 5, time.Second, nil, make(chan struct{})); err != nil {
 		t.Fatal("expected to handle download error, by using retry download strategy")
 	}
 	check(name, art.Size, t)
+	if waits := fake.Waits(); !reflect.DeepEqual(waits, []time.Duration{time.Second}) {
+		t.Fatalf("unexpected retry intervals: %v", waits)
+	}
 
 	if err := os.Remove(name); err != nil {
 		t.Fatalf("failed to delete test file %s", name)
 	}
-	setIncorrectBehavior(2, false, false)
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
+	srv.SetBehavior(testutil.Behavior{BadStatusCount: 2})
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatal("error is expected when downloading artifact, due to bad response status")
 	}
 }
 
+// TestWaitForDNS tests waiting for the artifact server host name to become resolvable.
+func TestWaitForDNS(t *testing.T) {
+	defer func(lookup func(context.Context, string) ([]string, error), interval time.Duration) {
+		lookupHost = lookup
+		dnsWaitInterval = interval
+	}(lookupHost, dnsWaitInterval)
+	dnsWaitInterval = 10 * time.Millisecond
+
+	notReady := &net.DNSError{Err: "server misbehaving", Name: "artifacts.example.com", IsTemporary: true}
+	notFound := &net.DNSError{Err: "no such host", Name: "artifacts.example.com", IsNotFound: true}
+	link := "https://artifacts.example.com/test.txt"
+
+	// 1. Resolver fails a couple of times, then succeeds
+	var attempts int
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host != "artifacts.example.com" {
+			t.Fatalf("unexpected host name resolved: %s", host)
+		}
+		if attempts++; attempts < 3 {
+			return nil, notReady
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	if err := waitForDNS(link, time.Second, make(chan struct{})); err != nil {
+		t.Fatalf("failed to wait for DNS: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("unexpected host name resolution attempts: %d != 3", attempts)
+	}
+
+	// 2. Host name does not exist
+	attempts = 0
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		attempts++
+		return nil, notFound
+	}
+	if err := waitForDNS(link, time.Second, make(chan struct{})); !errors.Is(err, notFound) {
+		t.Fatalf("expected not found host name error: %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("not found host name must not be resolved again: %d attempts", attempts)
+	}
+
+	// 3. Resolver is not ready until the timeout expires, the download retry strategy takes over
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, notReady
+	}
+	if err := waitForDNS(link, 50*time.Millisecond, make(chan struct{})); err != nil {
+		t.Fatalf("expected to continue with the download after the timeout: %v", err)
+	}
+
+	// 4. Waiting is canceled
+	done := make(chan struct{})
+	close(done)
+	if err := waitForDNS(link, time.Second, done); err != ErrCancel {
+		t.Fatalf("expected cancel error: %v", err)
+	}
+
+	// 5. Waiting is disabled or not needed
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		t.Fatalf("unexpected host name resolution: %s", host)
+		return nil, nil
+	}
+	for _, test := range []struct {
+		link    string
+		timeout time.Duration
+	}{
+		{link: link, timeout: 0},
+		{link: "http://127.0.0.1:43234/test.txt", timeout: time.Second},
+		{link: "/tmp/test.txt", timeout: time.Second},
+	} {
+		if err := waitForDNS(test.link, test.timeout, make(chan struct{})); err != nil {
+			t.Fatalf("unexpected error waiting for DNS of %s: %v", test.link, err)
+		}
+	}
+}
+
+// TestIsRetryable tests the classification of the artifact server request errors.
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: fmt.Errorf("%w: 503", ErrBadStatus), retryable: true},
+		{err: fmt.Errorf("%w: a != b", ErrETagMismatch), retryable: false},
+		{err: &url.Error{Op: "Get", URL: "http://host/test.txt", Err: fmt.Errorf("%w: http://other/test.txt", ErrLinkNotAllowed)}, retryable: false},
+		{err: &url.Error{Op: "Get", URL: "http://host/test.txt", Err: fmt.Errorf("%w: http://other/test.txt", ErrRedirectNotAllowed)}, retryable: false},
+		{err: &url.Error{Op: "Get", URL: "http://host/test.txt", Err: &net.OpError{Op: "dial", Net: "tcp",
+			Err: &net.DNSError{Err: "server misbehaving", Name: "host", IsTemporary: true}}}, retryable: true},
+		{err: &url.Error{Op: "Get", URL: "http://host/test.txt", Err: &net.OpError{Op: "dial", Net: "tcp",
+			Err: &net.DNSError{Err: "connection refused", Name: "host"}}}, retryable: true},
+		{err: &url.Error{Op: "Get", URL: "http://host/test.txt", Err: &net.OpError{Op: "dial", Net: "tcp",
+			Err: &net.DNSError{Err: "no such host", Name: "host", IsNotFound: true}}}, retryable: false},
+	}
+	for _, test := range tests {
+		if retryable := isRetryable(test.err); retryable != test.retryable {
+			t.Errorf("unexpected retryable classification of %v: %v != %v", test.err, retryable, test.retryable)
+		}
+	}
+}
+
+// TestValidateHashEncoding tests the validation against hex and base64 encoded hash values of the same digest.
+func TestValidateHashEncoding(t *testing.T) {
+	data := "hash encoding test"
+	sum := sha256.Sum256([]byte(data))
+	md5Sum := md5.Sum([]byte(data))
+	tests := []struct {
+		name     string
+		hashType string
+		encoding string
+		value    string
+		valid    bool
+		mismatch bool
+	}{
+		{name: "Hex", hashType: "SHA256", value: hex.EncodeToString(sum[:]), valid: true},
+		{name: "HexUpperCase", hashType: "SHA256", value: strings.ToUpper(hex.EncodeToString(sum[:])), valid: true},
+		{name: "Base64", hashType: "SHA256", value: base64.StdEncoding.EncodeToString(sum[:]), valid: true},
+		{name: "Base64Raw", hashType: "SHA256", value: base64.RawStdEncoding.EncodeToString(sum[:]), valid: true},
+		{name: "Base64MD5", hashType: "MD5", value: base64.StdEncoding.EncodeToString(md5Sum[:]), valid: true},
+		{name: "ExplicitHex", hashType: "SHA256", encoding: HashEncodingHex, value: hex.EncodeToString(sum[:]), valid: true},
+		{name: "ExplicitBase64", hashType: "SHA256", encoding: HashEncodingBase64, value: base64.StdEncoding.EncodeToString(sum[:]), valid: true},
+		{name: "Base64Mismatch", hashType: "SHA256", value: base64.StdEncoding.EncodeToString(md5Sum[:]), mismatch: true},
+		{name: "HexMismatch", hashType: "MD5", value: hex.EncodeToString(sum[:16]), mismatch: true},
+		{name: "InvalidBase64", hashType: "SHA256", value: "not-a-base64-checksum!"},
+		{name: "InvalidHex", hashType: "SHA256", encoding: HashEncodingHex, value: base64.StdEncoding.EncodeToString(sum[:])},
+		{name: "UnknownEncoding", hashType: "SHA256", encoding: "base32", value: hex.EncodeToString(sum[:])},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			art := &Artifact{HashType: test.hashType, HashEncoding: test.encoding, HashValue: test.value}
+			err := validateData(strings.NewReader(data), art)
+			if test.valid {
+				if err != nil {
+					t.Fatalf("failed to validate with %s hash value: %v", test.name, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validated with %s hash value", test.name)
+			}
+			if errors.Is(err, ErrChecksumMismatch) != test.mismatch {
+				t.Fatalf("unexpected validation error with %s hash value: %v", test.name, err)
+			}
+		})
+	}
+}
+
+// TestValidateMultipleHashes tests the validation against all artifact hashes.
+func TestValidateMultipleHashes(t *testing.T) {
+	data := "multiple hashes test"
+	sum := sha256.Sum256([]byte(data))
+	md5Sum := md5.Sum([]byte(data))
+
+	// 1. Validate with two matching hashes.
+	art := &Artifact{HashType: "SHA256", HashValue: hex.EncodeToString(sum[:]),
+		Hashes: []*Hash{{Type: "MD5", Value: hex.EncodeToString(md5Sum[:])}}}
+	if err := validateData(strings.NewReader(data), art); err != nil {
+		t.Fatalf("failed to validate with matching hashes: %v", err)
+	}
+
+	// 2. Validate with mismatching additional hash.
+	art.Hashes[0].Value = "ab2ce340d36bbaafe17965a3a2c6ed5b"
+	if err := validateData(strings.NewReader(data), art); !errors.Is(err, ErrChecksumMismatch) || !strings.Contains(err.Error(), "MD5") {
+		t.Fatalf("expected MD5 checksum mismatch: %v", err)
+	}
+
+	// 3. Validate with mismatching primary hash.
+	art.HashValue = hex.EncodeToString(make([]byte, sha256.Size))
+	art.Hashes[0].Value = hex.EncodeToString(md5Sum[:])
+	if err := validateData(strings.NewReader(data), art); !errors.Is(err, ErrChecksumMismatch) || !strings.Contains(err.Error(), "SHA256") {
+		t.Fatalf("expected SHA256 checksum mismatch: %v", err)
+	}
+
+	// 4. Validate with unknown additional hash type.
+	art.HashValue = hex.EncodeToString(sum[:])
+	art.Hashes[0].Type = "SHA512"
+	if err := validateData(strings.NewReader(data), art); err == nil {
+		t.Fatal("validated with unknown hash type")
+	}
+}
+
 func TestRobustDownloadRetryCopyError(t *testing.T) {
 	testCopyError(false, false, t)
 	testCopyError(false, true, t)
@@ -372,52 +1772,34 @@ func TestRobustDownloadRetryCopyError(t *testing.T) {
 }
 
 func testCopyError(withInsufficientRetryCount bool, withCorruptedFile bool, t *testing.T) {
-	dir := "_tmp-download"
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		t.Fatalf("failed to create a temporary directory: %v", err)
-	}
-	// Remove temporary directory at the end
-	defer os.RemoveAll(dir)
+	dir := t.TempDir()
+	fake := useFakeClock(t)
 
 	art := &Artifact{
 		FileName: "test.txt", Size: 65536, Link: "http://localhost:43234/test.txt",
 		HashType:  "MD5",
 		HashValue: "ab2ce340d36bbaafe17965a3a2c6ed5b",
 	}
-	var serverClosing sync.WaitGroup
-	var serverClosed sync.WaitGroup
-	// Start Web server
-	serverClosing.Add(1)
-	serverClosed.Add(1)
-	defer serverClosed.Wait()
-	defer serverClosing.Done()
-	go func() {
-		for i := 0; i < 5; i++ {
-			srv := NewTestHTTPServer(":43234", art.FileName, int64(art.Size), t)
-			if withCorruptedFile {
-				setIncorrectBehavior(0, false, true)
-			} else {
-				setIncorrectBehavior(0, true, false)
-			}
-			srv.Host(false, false, "", "")
-			time.Sleep(2 * time.Second)
-			srv.Close()
+	// Start Web server, failing the first 5 requests with incomplete or corrupted content
+	var requests int32
+	ts := testutil.NewHTTPServer("", art.FileName, int64(art.Size), t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 5 {
+			ts.SetBehavior(testutil.Behavior{Truncate: !withCorruptedFile, Corrupt: withCorruptedFile})
+		} else {
+			ts.SetBehavior(testutil.Behavior{})
 		}
-		srv := NewTestHTTPServer(":43234", art.FileName, int64(art.Size), t)
-		setIncorrectBehavior(0, false, false)
-		srv.Host(false, false, "", "")
-		setIncorrectBehavior(0, false, false)
-		serverClosing.Wait()
-		srv.Close()
-		serverClosed.Done()
-	}()
+		ts.Handler(false).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	art.Link = srv.URL + "/" + art.FileName
 
 	name := filepath.Join(dir, art.FileName)
 	retryCount := 10
 	if withInsufficientRetryCount {
 		retryCount = 2
 	}
-	err := downloadArtifact(name, art, nil, "", retryCount, 2*time.Second, nil, make(chan struct{}))
+	err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, retryCount, 2*time.Second, nil, make(chan struct{}))
 	if withInsufficientRetryCount {
 		if err == nil {
 			t.Fatal("error is expected when downloading artifact, due to copy error")
@@ -428,6 +1810,17 @@ func testCopyError(withInsufficientRetryCount bool, withCorruptedFile bool, t *t
 		}
 		check(name, art.Size, t)
 	}
+
+	// Each failed attempt is retried after the retry interval.
+	waits := fake.Waits()
+	if len(waits) == 0 {
+		t.Fatal("expected the failed attempts to be retried after the retry interval")
+	}
+	for _, wait := range waits {
+		if wait != 2*time.Second {
+			t.Fatalf("unexpected retry intervals: %v", waits)
+		}
+	}
 }
 
 // TestDownloadToFileSecureError tests HTTPS file download function for bad/expired TLS certificates.
@@ -448,35 +1841,35 @@ func TestDownloadToFileSecureError(t *testing.T) {
 	}
 
 	// Start https servers
-	srvSecureInvalid := NewTestHTTPServer(":43234", art.FileName, int64(art.Size), t)
+	srvSecureInvalid := testutil.NewHTTPServer(":43234", art.FileName, int64(art.Size), t)
 	srvSecureInvalid.Host(true, true, expiredCert, expiredKey)
 	defer srvSecureInvalid.Close()
-	srvSecureUntrusted := NewTestHTTPServer(":43235", art.FileName, int64(art.Size), t)
+	srvSecureUntrusted := testutil.NewHTTPServer(":43235", art.FileName, int64(art.Size), t)
 	srvSecureUntrusted.Host(true, true, untrustedCert, untrustedKey)
 	defer srvSecureUntrusted.Close()
-	srvSecureValid := NewTestHTTPServer(":43236", art.FileName, int64(art.Size), t)
+	srvSecureValid := testutil.NewHTTPServer(":43236", art.FileName, int64(art.Size), t)
 	srvSecureValid.Host(true, true, validCert, validKey)
 	defer srvSecureValid.Close()
 	name := filepath.Join(dir, art.FileName)
 
 	// 1. Server uses expired certificate
 	art.Link = "https://localhost:43234/test.txt"
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatalf("download must fail(client uses no certificate, server uses expired): %v", err)
 	}
-	if err := downloadArtifact(name, art, nil, expiredCert, 0, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Cert: expiredCert}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatalf("download must fail(client and server use expired certificate): %v", err)
 	}
 
 	// 2. Server uses untrusted certificate
 	art.Link = "https://localhost:43235/test.txt"
-	if err := downloadArtifact(name, art, nil, "", 0, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatalf("download must fail(client uses no certificate, server uses untrusted): %v", err)
 	}
 
 	// 3. Server uses valid certificate
 	art.Link = "https://localhost:43236/test.txt"
-	if err := downloadArtifact(name, art, nil, untrustedCert, 0, 0, nil, make(chan struct{})); err == nil {
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Cert: untrustedCert}, 0, 0, nil, make(chan struct{})); err == nil {
 		t.Fatalf("download must fail(client uses untrusted certificate, server uses valid): %v", err)
 	}
 }
@@ -487,3 +1880,193 @@ func check(name string, expected int, t *testing.T) {
 		t.Fatalf("corrupted download artifact: %v != %v", stat.Size(), expected)
 	}
 }
+
+// isSecure checks whether the link uses HTTPS.
+func isSecure(link string, t *testing.T) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("cannot parse url %v", link)
+	}
+	return u.Scheme == "https"
+}
+
+// TestDownloadZeroByte tests that the empty artifacts are downloaded and validated against the checksums of
+// the empty content, and their non-empty partial files are not resumed.
+func TestDownloadZeroByte(t *testing.T) {
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("Accept-Ranges", "bytes")
+	}))
+	defer srv.Close()
+
+	emptyMD5 := "d41d8cd98f00b204e9800998ecf8427e"
+	emptySHA256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := map[string]struct {
+		md5      string
+		sha256   string
+		partial  string
+		reasons  []RestartReason
+		expected error
+	}{
+		"valid":           {md5: emptyMD5, sha256: emptySHA256},
+		"wrongMD5":        {md5: "4e54acb9ed2abbed670f23cef3b80e57", sha256: emptySHA256, expected: ErrChecksumMismatch},
+		"wrongSHA256":     {md5: emptyMD5, sha256: strings.Repeat("0", 64), expected: ErrChecksumMismatch},
+		"spuriousPartial": {md5: emptyMD5, sha256: emptySHA256, partial: "stale", reasons: []RestartReason{RestartSizeMismatch}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			art := &Artifact{
+				FileName: "empty.txt", Link: srv.URL + "/empty.txt",
+				HashType: "MD5", HashValue: test.md5, Hashes: []*Hash{{Type: "SHA256", Value: test.sha256}},
+			}
+			file := filepath.Join(dir, art.FileName)
+			if test.partial != "" {
+				if err := os.WriteFile(filepath.Join(dir, prefix+art.FileName), []byte(test.partial), 0644); err != nil {
+					t.Fatalf("failed to write partial file: %v", err)
+				}
+			}
+			var reasons []RestartReason
+			server := ServerConfig{OnRestart: func(artifact *Artifact, reason RestartReason) {
+				reasons = append(reasons, reason)
+			}}
+			ranges = nil
+			err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 0, 0, nil, make(chan struct{}))
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("failed to download zero-byte artifact: %v", err)
+				}
+				check(file, 0, t)
+			} else if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got: %v", test.expected, err)
+			}
+			if !reflect.DeepEqual(reasons, test.reasons) {
+				t.Fatalf("unexpected restart reasons: %q != %q", reasons, test.reasons)
+			}
+			if expected := []string{""}; !reflect.DeepEqual(ranges, expected) {
+				t.Fatalf("unexpected range requests: %q != %q", ranges, expected)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read download directory: %v", err)
+			}
+			for _, entry := range entries {
+				if entry.Name() != art.FileName || test.expected != nil {
+					t.Fatalf("unexpected file left in download directory: %s", entry.Name())
+				}
+			}
+		})
+	}
+
+	t.Run("module", func(t *testing.T) {
+		store, err := NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("fail to initialize local storage: %v", err)
+		}
+		defer store.Close()
+		m := &Module{Name: "empty", Version: "1", Artifacts: []*Artifact{{FileName: "empty.txt",
+			Link: srv.URL + "/empty.txt", HashType: "SHA256", HashValue: emptySHA256}}}
+		var progress []int
+		err = store.DownloadModule(filepath.Join(store.DownloadPath, "0", "0"), m, func(percent int, written int64, size int64) {
+			progress = append(progress, percent)
+		}, ServerConfig{}, 0, 0, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to download zero-byte module: %v", err)
+		}
+		if expected := []int{100}; !reflect.DeepEqual(progress, expected) {
+			t.Fatalf("unexpected module progress: %v != %v", progress, expected)
+		}
+	})
+}
+
+// TestDownloadHashing tests that the overlapped hashing validates the downloaded artifacts the same way as
+// the inline hashing.
+func TestDownloadHashing(t *testing.T) {
+	var data bytes.Buffer
+	testutil.WriteContent(&data, 1<<20+123)
+	content := data.Bytes()
+	sha := sha256.Sum256(content)
+	sum := md5.Sum(content)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		md5      string
+		partial  int
+		verify   ArtifactVerifier
+		expected error
+	}{
+		"valid":     {md5: hex.EncodeToString(sum[:])},
+		"resumed":   {md5: hex.EncodeToString(sum[:]), partial: 1000},
+		"corrupted": {md5: strings.Repeat("0", 32), expected: ErrChecksumMismatch},
+		"rejected": {md5: hex.EncodeToString(sum[:]), expected: ErrArtifactInvalid,
+			verify: func(artifact *Artifact, data io.ReaderAt, size int64) error {
+				return fmt.Errorf("rejected %d bytes", size)
+			}},
+	}
+	for _, mode := range []string{HashingInline, HashingOverlapped} {
+		for name, test := range tests {
+			t.Run(mode+"-"+name, func(t *testing.T) {
+				dir := t.TempDir()
+				art := &Artifact{
+					FileName: "hashed.bin", Size: len(content), Link: srv.URL + "/hashed.bin",
+					HashType: "SHA256", HashValue: hex.EncodeToString(sha[:]),
+					Hashes: []*Hash{{Type: "MD5", Value: test.md5}},
+				}
+				if test.partial > 0 {
+					if err := os.WriteFile(filepath.Join(dir, prefix+art.FileName), content[:test.partial], 0644); err != nil {
+						t.Fatalf("failed to write partial file: %v", err)
+					}
+				}
+				file := filepath.Join(dir, art.FileName)
+				err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{Hashing: mode, Verify: test.verify},
+					0, 0, nil, make(chan struct{}))
+				if test.expected == nil {
+					if err != nil {
+						t.Fatalf("failed to download artifact: %v", err)
+					}
+					if downloaded, err := os.ReadFile(file); err != nil || !bytes.Equal(downloaded, content) {
+						t.Fatalf("unexpected downloaded content: %d bytes, %v", len(downloaded), err)
+					}
+					return
+				}
+				if !errors.Is(err, test.expected) {
+					t.Fatalf("expected %v, got: %v", test.expected, err)
+				}
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Fatalf("invalid artifact is not removed: %v", err)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkDownloadHashing compares the download of an artifact with inline and overlapped hashing.
+func BenchmarkDownloadHashing(b *testing.B) {
+	var data bytes.Buffer
+	hash := sha256.New()
+	testutil.WriteContent(io.MultiWriter(&data, hash), 64<<20)
+	content := data.Bytes()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	art := &Artifact{FileName: "hashed.bin", Size: len(content), Link: srv.URL + "/hashed.bin",
+		HashType: "SHA256", HashValue: hex.EncodeToString(hash.Sum(nil))}
+	for _, mode := range []string{HashingInline, HashingOverlapped} {
+		b.Run(mode, func(b *testing.B) {
+			file := filepath.Join(b.TempDir(), art.FileName)
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{Hashing: mode}, 0, 0, nil, make(chan struct{})); err != nil {
+					b.Fatalf("failed to download artifact: %v", err)
+				}
+				os.Remove(file)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const (
+	// MaxDSCP is the maximal Differentiated Services code point.
+	MaxDSCP = 63
+	// dialTimeout and dialKeepAlive are the connect timeout and the keep-alive period of the marked download
+	// connections, the same as the ones of the default HTTP transport.
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+var errDSCPNotSupported = errors.New("DSCP marking is not supported on this platform")
+
+// markSocket sets the type of service or the traffic class of the socket, depending on its network.
+var markSocket = setTOS
+
+// ValidateDSCP verifies that the Differentiated Services code point is in the range from 0 to MaxDSCP.
+func ValidateDSCP(dscp int) error {
+	if dscp < 0 || dscp > MaxDSCP {
+		return fmt.Errorf("invalid DSCP value - %d, must be between 0 and %d", dscp, MaxDSCP)
+	}
+	return nil
+}
+
+// dscpDialer returns the dialer of the download connections, which marks their IP packets with the
+// Differentiated Services code point. The marking is best effort: the connections, which cannot be marked,
+// e.g. on unsupported platforms, are still used, logging a warning.
+func dscpDialer(dscp int) *net.Dialer {
+	return &net.Dialer{Timeout: dialTimeout, KeepAlive: dialKeepAlive,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cErr := c.Control(func(fd uintptr) {
+				// The DSCP is the upper 6 bits of the type of service and the traffic class fields.
+				err = markSocket(fd, network, dscp<<2)
+			}); cErr != nil {
+				err = cErr
+			}
+			if err != nil {
+				logger.Warnf("failed to mark download connection to %s with DSCP %d: %v", address, dscp, err)
+			}
+			return nil
+		}}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestValidateDSCP tests the valid and invalid Differentiated Services code points.
+func TestValidateDSCP(t *testing.T) {
+	for dscp, valid := range map[int]bool{-1: false, 0: true, 8: true, MaxDSCP: true, MaxDSCP + 1: false} {
+		if err := ValidateDSCP(dscp); (err == nil) != valid {
+			t.Errorf("unexpected validation result of DSCP %d: %v", dscp, err)
+		}
+	}
+}
+
+// TestDownloadDSCP tests that the download connections are marked with the configured DSCP and are not marked,
+// if it is not set.
+func TestDownloadDSCP(t *testing.T) {
+	body := "marked download content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	var lock sync.Mutex
+	var marked []int
+	defer func() { markSocket = setTOS }()
+	markSocket = func(fd uintptr, network string, tos int) error {
+		err := setTOS(fd, network, tos)
+		if err != nil && err != errDSCPNotSupported {
+			t.Errorf("failed to set the type of service of %s socket: %v", network, err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		marked = append(marked, tos)
+		return err
+	}
+
+	sum := md5.Sum([]byte(body))
+	dir := t.TempDir()
+	for i, dscp := range []int{0, 8} {
+		art := &Artifact{FileName: "test-dscp.txt", Size: len(body), Link: srv.URL + "/test-dscp.txt", HashType: "MD5",
+			HashValue: hex.EncodeToString(sum[:])}
+		file := filepath.Join(dir, strconv.Itoa(i)+"-"+art.FileName)
+		if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{DSCP: dscp}, 0, 0, nil, make(chan struct{})); err != nil {
+			t.Fatalf("failed to download artifact with DSCP %d: %v", dscp, err)
+		}
+		check(file, art.Size, t)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	// Only the connection of the marked download, with the DSCP in the upper 6 bits of the type of service.
+	if len(marked) != 1 || marked[0] != 8<<2 {
+		t.Fatalf("unexpected marked connections: %v", marked)
+	}
+}
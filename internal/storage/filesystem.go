@@ -0,0 +1,248 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errMmapNotSupported = errors.New("memory-mapped reads are not supported")
+
+var errPreallocateNotSupported = errors.New("preallocation is not supported")
+
+var errLinksNotSupported = errors.New("hard link count is not supported")
+
+// File represents a file of the storage backend.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+}
+
+// FileSystem represents the storage backend, used to download the artifacts. The errors for missing
+// files must satisfy os.IsNotExist.
+type FileSystem interface {
+	// Create creates or truncates the named file for writing.
+	Create(name string) (File, error)
+	// Append opens the named file for writing at its end, creating it if it does not exist.
+	Append(name string) (File, error)
+	// Open opens the named file for reading.
+	Open(name string) (File, error)
+	// Rename renames the file, replacing the existing one.
+	Rename(oldName string, newName string) error
+	// Stat returns the file info of the named file.
+	Stat(name string) (os.FileInfo, error)
+	// Remove removes the named file.
+	Remove(name string) error
+	// MkdirAll creates the directory and its missing parents.
+	MkdirAll(name string) error
+	// ReadDir returns the entries of the named directory, sorted by their names.
+	ReadDir(name string) ([]os.DirEntry, error)
+	// RemoveAll removes the named file or directory with its contents.
+	RemoveAll(name string) error
+	// Statfs returns the free space in bytes, available in the given directory.
+	Statfs(dir string) (uint64, error)
+}
+
+// mappedFileSystem represents a storage backend, which supports memory-mapped reads of its files.
+type mappedFileSystem interface {
+	// Mmap maps the named file into memory for reading. The returned function unmaps the file.
+	Mmap(name string) ([]byte, func() error, error)
+}
+
+// truncatingFileSystem represents a storage backend, which supports truncating its files.
+type truncatingFileSystem interface {
+	// Truncate changes the size of the named file, discarding its data after the given size.
+	Truncate(name string, size int64) error
+}
+
+// preallocatingFileSystem represents a storage backend, which supports reserving the storage of its files.
+type preallocatingFileSystem interface {
+	// Preallocate reserves the storage of the open file up to the given size, without changing its size.
+	Preallocate(file File, size int64) error
+}
+
+// linkingFileSystem represents a storage backend, which supports hard links of its files.
+type linkingFileSystem interface {
+	// Link creates the new name as a hard link to the old file.
+	Link(oldName string, newName string) error
+	// Links returns the number of hard links to the named file.
+	Links(name string) (uint64, error)
+	// Chmod changes the mode of the named file, e.g. to make the linked file read-only.
+	Chmod(name string, mode os.FileMode) error
+}
+
+// OSFileSystem is the storage backend of the operating system file system.
+type OSFileSystem struct {
+	// Root is the storage directory. The directories of the written files within it must not be symbolic links,
+	// so that the files are not written outside of it. Not verified, if not set.
+	Root string
+	// MmapChecksum enables memory-mapped reads to calculate the checksums of local artifacts, where supported.
+	MmapChecksum bool
+}
+
+// Create creates or truncates the named file for writing.
+func (fs OSFileSystem) Create(name string) (File, error) {
+	return fs.openFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// Append opens the named file for writing at its end, creating it if it does not exist.
+func (fs OSFileSystem) Append(name string) (File, error) {
+	return fs.openFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+}
+
+// Open opens the named file for reading.
+func (fs OSFileSystem) Open(name string) (File, error) {
+	return fs.openFile(name, os.O_RDONLY)
+}
+
+// Rename renames the file, replacing the existing one.
+func (fs OSFileSystem) Rename(oldName string, newName string) error {
+	if err := fs.checkDirs(filepath.Dir(newName)); err != nil {
+		return err
+	}
+	return os.Rename(oldName, newName)
+}
+
+// Stat returns the file info of the named file.
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Remove removes the named file.
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// MkdirAll creates the directory and its missing parents.
+func (fs OSFileSystem) MkdirAll(name string) error {
+	if err := fs.checkDirs(name); err != nil {
+		return err
+	}
+	return os.MkdirAll(name, 0755)
+}
+
+// ReadDir returns the entries of the named directory, sorted by their names.
+func (OSFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// RemoveAll removes the named file or directory with its contents.
+func (OSFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+// Statfs returns the free space in bytes, available to unprivileged users in the given directory.
+func (OSFileSystem) Statfs(dir string) (uint64, error) {
+	return freeSpace(dir)
+}
+
+// Truncate changes the size of the named file, discarding its data after the given size.
+func (fs OSFileSystem) Truncate(name string, size int64) error {
+	if err := fs.checkDirs(filepath.Dir(name)); err != nil {
+		return err
+	}
+	file, err := openNoFollow(name, os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Truncate(size)
+}
+
+// Link creates the new name as a hard link to the old file.
+func (fs OSFileSystem) Link(oldName string, newName string) error {
+	if err := fs.checkDirs(filepath.Dir(newName)); err != nil {
+		return err
+	}
+	return os.Link(oldName, newName)
+}
+
+// Links returns the number of hard links to the named file.
+func (OSFileSystem) Links(name string) (uint64, error) {
+	return links(name)
+}
+
+// Chmod changes the mode of the named file.
+func (OSFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Preallocate reserves the storage of the open file up to the given size, without changing its size, where supported.
+func (OSFileSystem) Preallocate(file File, size int64) error {
+	if f, ok := file.(*os.File); ok {
+		return fallocate(f, size)
+	}
+	return errPreallocateNotSupported
+}
+
+// Mmap maps the named file into memory for reading, if enabled and supported by the platform.
+func (fs OSFileSystem) Mmap(name string) ([]byte, func() error, error) {
+	if !fs.MmapChecksum {
+		return nil, nil, errMmapNotSupported
+	}
+	return mmap(name)
+}
+
+// checkDirs refuses to write within the directory, if it or any of its parents within the storage directory is a
+// symbolic link, which can point outside of the storage directory. The directories outside of the storage directory
+// are not verified.
+func (fs OSFileSystem) checkDirs(dir string) error {
+	if fs.Root == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(fs.Root, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return nil
+	}
+	current := filepath.Clean(fs.Root)
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is written through symbolic link %s", ErrSymlink, dir, current)
+		}
+	}
+	return nil
+}
+
+// openFile avoids returning a nil *os.File as non-nil File on error. Files opened for writing must not be
+// symbolic links and must not be written through symbolic links within the storage directory.
+func (fs OSFileSystem) openFile(name string, flag int) (File, error) {
+	var file *os.File
+	var err error
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err = fs.checkDirs(filepath.Dir(name)); err != nil {
+			return nil, err
+		}
+		file, err = openNoFollow(name, flag)
+	} else {
+		file, err = os.OpenFile(name, flag, 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
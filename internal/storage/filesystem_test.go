@@ -0,0 +1,436 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFileSystem is an in-memory storage backend.
+type memFileSystem struct {
+	lock  sync.Mutex
+	files map[string]*bytes.Buffer
+	dirs  map[string]bool
+}
+
+type memFile struct {
+	*bytes.Reader
+	name string
+	data *bytes.Buffer
+	fs   *memFileSystem
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func newMemFileSystem() *memFileSystem {
+	return &memFileSystem{files: map[string]*bytes.Buffer{}, dirs: map[string]bool{}}
+}
+
+func (fs *memFileSystem) Create(name string) (File, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.files[name] = &bytes.Buffer{}
+	return &memFile{name: name, data: fs.files[name], fs: fs}, nil
+}
+
+func (fs *memFileSystem) Append(name string) (File, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.files[name] == nil {
+		fs.files[name] = &bytes.Buffer{}
+	}
+	return &memFile{name: name, data: fs.files[name], fs: fs}, nil
+}
+
+func (fs *memFileSystem) Open(name string) (File, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &memFile{Reader: bytes.NewReader(data.Bytes()), name: name}, nil
+}
+
+func (fs *memFileSystem) Rename(oldName string, newName string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	data, ok := fs.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	fs.files[newName] = data
+	delete(fs.files, oldName)
+	return nil
+}
+
+func (fs *memFileSystem) Stat(name string) (os.FileInfo, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.dirs[name] {
+		return &memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return &memFileInfo{name: filepath.Base(name), size: int64(data.Len())}, nil
+}
+
+func (fs *memFileSystem) Remove(name string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFileSystem) MkdirAll(name string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.dirs[name] = true
+	return nil
+}
+
+func (fs *memFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	entries := map[string]os.DirEntry{}
+	for dir := range fs.dirs {
+		if rel, err := filepath.Rel(name, dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			child := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+			entries[child] = &memFileInfo{name: child, dir: true}
+		}
+	}
+	for file, data := range fs.files {
+		rel, err := filepath.Rel(name, file)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		parts := strings.SplitN(rel, string(filepath.Separator), 2)
+		entries[parts[0]] = &memFileInfo{name: parts[0], size: int64(data.Len()), dir: len(parts) > 1}
+	}
+	names := make([]string, 0, len(entries))
+	for entry := range entries {
+		names = append(names, entry)
+	}
+	sort.Strings(names)
+	list := make([]os.DirEntry, len(names))
+	for i, entry := range names {
+		list[i] = entries[entry]
+	}
+	return list, nil
+}
+
+func (fs *memFileSystem) RemoveAll(name string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	for file := range fs.files {
+		if rel, err := filepath.Rel(name, file); err == nil && !strings.HasPrefix(rel, "..") {
+			delete(fs.files, file)
+		}
+	}
+	for dir := range fs.dirs {
+		if rel, err := filepath.Rel(name, dir); err == nil && !strings.HasPrefix(rel, "..") {
+			delete(fs.dirs, dir)
+		}
+	}
+	return nil
+}
+
+func (fs *memFileSystem) Statfs(dir string) (uint64, error) {
+	return 1 << 30, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+	return f.data.Write(p)
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.Reader == nil {
+		return 0, os.ErrPermission
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (fi *memFileInfo) Name() string { return fi.name }
+func (fi *memFileInfo) Size() int64  { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0755
+}
+func (fi *memFileInfo) Type() os.FileMode          { return fi.Mode().Type() }
+func (fi *memFileInfo) Info() (os.FileInfo, error) { return fi, nil }
+func (fi *memFileInfo) ModTime() time.Time         { return time.Time{} }
+func (fi *memFileInfo) IsDir() bool                { return fi.dir }
+func (fi *memFileInfo) Sys() interface{}           { return nil }
+
+// TestDownloadMemoryFileSystem tests module download and resume with in-memory storage backend.
+func TestDownloadMemoryFileSystem(t *testing.T) {
+	body := "authorized content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	location := filepath.Join(t.TempDir(), "memory")
+	fs := newMemFileSystem()
+	store, err := NewStorageWithFileSystem(location, fs)
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	art := &Artifact{
+		FileName: "test.txt", Size: len(body), Link: srv.URL + "/test.txt",
+		HashType:  "MD5",
+		HashValue: "4e54acb9ed2abbed670f23cef3b80e57",
+	}
+	m := &Module{Name: "name", Version: "1", Artifacts: []*Artifact{art}}
+
+	// 1. Download and rename the artifact.
+	path := filepath.Join(store.DownloadPath, "0", "0")
+	if err := store.DownloadModule(path, m, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module: %v", err)
+	}
+	assertMemFile(t, fs, filepath.Join(path, art.FileName), body)
+	if _, err := fs.Stat(filepath.Join(path, prefix+art.FileName)); !os.IsNotExist(err) {
+		t.Fatalf("temporary download file is not renamed: %v", err)
+	}
+
+	// 2. Resume the partial download and rename the artifact.
+	path = filepath.Join(store.DownloadPath, "0", "1")
+	tmp, _ := fs.Create(filepath.Join(path, prefix+art.FileName))
+	tmp.Write([]byte(body[:5]))
+	if err := store.DownloadModule(path, m, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to resume module download: %v", err)
+	}
+	assertMemFile(t, fs, filepath.Join(path, art.FileName), body)
+
+	if _, err := os.Stat(location); !os.IsNotExist(err) {
+		t.Fatalf("unexpected storage location on the file system: %v", err)
+	}
+}
+
+// TestArchiveModuleMemoryFileSystem tests the archiving and the cleanup of the installed modules with in-memory
+// storage backend.
+func TestArchiveModuleMemoryFileSystem(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "memory")
+	fs := newMemFileSystem()
+	store, err := NewStorageWithFileSystem(location, fs)
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	install := func(version string, policy string) {
+		t.Helper()
+		dir, err := store.CreateOperationLocation(store.DownloadPath, "cid-"+version)
+		if err != nil {
+			t.Fatalf("fail to create operation location: %v", err)
+		}
+		dir = filepath.Join(dir, "0")
+		if err := writeLn(fs, filepath.Join(dir, "artifact.bin"), "artifact-"+version); err != nil {
+			t.Fatalf("fail to write artifact: %v", err)
+		}
+		if err := store.CleanupInstalledModule(dir, &Module{Name: "app", Version: version}, policy); err != nil {
+			t.Fatalf("fail to cleanup installed module %s: %v", version, err)
+		}
+	}
+
+	// 1. Keep the installed module in the modules directory.
+	install("1", CleanupKeep)
+	archived := filepath.Join(store.ModulesPath, "0")
+	assertMemFile(t, fs, filepath.Join(archived, "artifact.bin"), "artifact-1")
+	assertMemFile(t, fs, filepath.Join(archived, InstalledStatusName), "app\n1")
+
+	// 2. Archive the module to the next location.
+	if err := store.ArchiveModule(filepath.Join(store.DownloadPath, "0-cid-1")); err != nil {
+		t.Fatalf("fail to archive module: %v", err)
+	}
+	if _, err := fs.Stat(filepath.Join(store.ModulesPath, "1")); err != nil {
+		t.Fatalf("module is not archived to the next location: %v", err)
+	}
+
+	// 3. Replace the previously installed version of the module.
+	install("2", CleanupDeleteOnNextSuccess)
+	entries, err := fs.ReadDir(store.ModulesPath)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("unexpected archived modules: %v, %v", entries, err)
+	}
+	if _, err := fs.Stat(filepath.Join(archived, "artifact.bin")); !os.IsNotExist(err) {
+		t.Fatalf("previously installed module is not removed: %v", err)
+	}
+	assertMemFile(t, fs, filepath.Join(store.ModulesPath, "2", "artifact.bin"), "artifact-2")
+
+	if _, err := os.Stat(location); !os.IsNotExist(err) {
+		t.Fatalf("unexpected storage location on the file system: %v", err)
+	}
+}
+
+// shortFileSystem is an in-memory storage backend, which loses the last byte of the renamed files.
+type shortFileSystem struct {
+	*memFileSystem
+}
+
+func (fs shortFileSystem) Rename(oldName string, newName string) error {
+	if err := fs.memFileSystem.Rename(oldName, newName); err != nil {
+		return err
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	data := fs.files[newName]
+	data.Truncate(data.Len() - 1)
+	return nil
+}
+
+// TestDownloadShortStoredFile tests that the download fails with ErrStoredSizeMismatch and the final artifact file
+// is removed, if it is shorter than the downloaded artifact after its rename.
+func TestDownloadShortStoredFile(t *testing.T) {
+	body := "content lost on rename"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	fs := shortFileSystem{newMemFileSystem()}
+	art := &Artifact{
+		FileName: "test.txt", Size: len(body), Link: srv.URL + "/test.txt",
+		HashType:  "MD5",
+		HashValue: fmt.Sprintf("%x", md5.Sum([]byte(body))),
+	}
+	name := filepath.Join("memory", art.FileName)
+	err := downloadArtifact(fs, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrStoredSizeMismatch) {
+		t.Fatalf("expected stored size mismatch error: %v", err)
+	}
+	for _, file := range []string{name, filepath.Join("memory", prefix+art.FileName)} {
+		if _, err := fs.Stat(file); !os.IsNotExist(err) {
+			t.Fatalf("file %s of the failed download is not removed: %v", file, err)
+		}
+	}
+}
+
+// TestDownloadSymlink tests that the download is not written through a symbolic link in place of its partial file.
+func TestDownloadSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	body := "content not written through a symbolic link"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	art := &Artifact{
+		FileName: "test.txt", Size: len(body), Link: srv.URL + "/test.txt",
+		HashType:  "MD5",
+		HashValue: fmt.Sprintf("%x", md5.Sum([]byte(body))),
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, prefix+art.FileName)); err != nil {
+		t.Fatal(err)
+	}
+	err := downloadArtifact(OSFileSystem{}, filepath.Join(dir, art.FileName), art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrSymlink) {
+		t.Fatalf("expected symbolic link error: %v", err)
+	}
+	if data, err := os.ReadFile(outside); err != nil || string(data) != "outside" {
+		t.Fatalf("file is written through the symbolic link: %s, %v", data, err)
+	}
+}
+
+// TestDownloadSymlinkParent tests that the download is not written through a symbolic link in place of a directory
+// within the storage directory.
+func TestDownloadSymlinkParent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges")
+	}
+	body := "content not written through a symbolic link"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	outside := t.TempDir()
+	root := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "download")); err != nil {
+		t.Fatal(err)
+	}
+	fs := OSFileSystem{Root: root}
+	art := &Artifact{
+		FileName: "test.txt", Size: len(body), Link: srv.URL + "/test.txt",
+		HashType:  "MD5",
+		HashValue: fmt.Sprintf("%x", md5.Sum([]byte(body))),
+	}
+	dir := filepath.Join(root, "download", "0")
+	if err := fs.MkdirAll(dir); !errors.Is(err, ErrSymlink) {
+		t.Fatalf("expected symbolic link error of the directory: %v", err)
+	}
+	err := downloadArtifact(fs, filepath.Join(root, "download", art.FileName), art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrSymlink) {
+		t.Fatalf("expected symbolic link error: %v", err)
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Fatalf("files are written through the symbolic link: %v, %v", entries, err)
+	}
+}
+
+func assertMemFile(t *testing.T, fs *memFileSystem, name string, expected string) {
+	file, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("missing downloaded file %s: %v", name, err)
+	}
+	var data bytes.Buffer
+	data.ReadFrom(file)
+	if data.String() != expected {
+		t.Fatalf("unexpected content of file %s: %s != %s", name, data.String(), expected)
+	}
+}
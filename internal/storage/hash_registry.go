@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// HashFunc returns a new hash instance of a checksum algorithm.
+type HashFunc func() hash.Hash
+
+// builtinHashes are the checksum algorithms of the SoftwareUpdatable feature, in their priority order.
+var builtinHashes = []hawkbit.Hash{hawkbit.SHA256, hawkbit.SHA1, hawkbit.MD5}
+
+var (
+	hashesLock sync.RWMutex
+	hashes     = map[string]HashFunc{
+		string(hawkbit.SHA256): sha256.New,
+		string(hawkbit.SHA1):   sha1.New,
+		string(hawkbit.MD5):    md5.New,
+	}
+)
+
+// RegisterHash registers the checksum algorithm of the given hash type, e.g. a CRC variant or a keyed hash,
+// required by a legacy artifact server, so that the artifacts with such checksums are verified with it. The hash
+// types are case-insensitive and must be registered before the artifacts are downloaded. The built-in SHA256,
+// SHA1 and MD5 algorithms cannot be replaced.
+func RegisterHash(hashType string, newHash HashFunc) error {
+	name := strings.ToUpper(strings.TrimSpace(hashType))
+	if name == "" || newHash == nil {
+		return errors.New("hash type and function are required")
+	}
+	for _, builtin := range builtinHashes {
+		if name == string(builtin) {
+			return fmt.Errorf("built-in hash type %s cannot be replaced", name)
+		}
+	}
+	hashesLock.Lock()
+	defer hashesLock.Unlock()
+	hashes[name] = newHash
+	return nil
+}
+
+// newHash returns a new hash instance of the given hash type.
+func newHash(hashType string) (hash.Hash, error) {
+	hashesLock.RLock()
+	newHash, ok := hashes[strings.ToUpper(hashType)]
+	hashesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown hash type: %s", hashType)
+	}
+	return newHash(), nil
+}
+
+// registeredChecksums returns the checksums of the registered custom hash types, sorted by their hash type.
+func registeredChecksums(checksums map[hawkbit.Hash]string) []*Hash {
+	hashesLock.RLock()
+	defer hashesLock.RUnlock()
+	var registered []*Hash
+	for hashType, value := range checksums {
+		name := strings.ToUpper(string(hashType))
+		if _, ok := hashes[name]; !ok || value == "" {
+			continue
+		}
+		custom := true
+		for _, builtin := range builtinHashes {
+			custom = custom && name != string(builtin)
+		}
+		if custom {
+			registered = append(registered, &Hash{Type: string(hashType), Value: value})
+		}
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Type < registered[j].Type })
+	return registered
+}
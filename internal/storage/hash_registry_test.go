@@ -0,0 +1,132 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+const customHashType = "CRC32C"
+
+func newCRC32C() hash.Hash {
+	return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+}
+
+// unregisterHash removes the registered custom hash type at the end of the test, as e.g. the trailer
+// digests of the unregistered hash types are expected to be ignored.
+func unregisterHash(hashType string, t *testing.T) {
+	t.Cleanup(func() {
+		hashesLock.Lock()
+		defer hashesLock.Unlock()
+		delete(hashes, hashType)
+	})
+}
+
+func crc32cOf(data string) string {
+	h := newCRC32C()
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// TestRegisterHash tests the registration of the checksum algorithms.
+func TestRegisterHash(t *testing.T) {
+	unregisterHash(customHashType, t)
+	tests := map[string]struct {
+		hashType string
+		newHash  HashFunc
+		ok       bool
+	}{
+		"custom":    {hashType: customHashType, newHash: newCRC32C, ok: true},
+		"lowercase": {hashType: "crc32c", newHash: newCRC32C, ok: true},
+		"empty":     {hashType: " ", newHash: newCRC32C},
+		"nil":       {hashType: customHashType},
+		"builtin":   {hashType: "sha256", newHash: newCRC32C},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := RegisterHash(test.hashType, test.newHash); (err == nil) != test.ok {
+				t.Fatalf("unexpected registration error: %v", err)
+			}
+		})
+	}
+	if _, err := newHash("Crc32c"); err != nil {
+		t.Fatalf("registered hash type not found: %v", err)
+	}
+	if _, err := newHash("CRC64"); err == nil {
+		t.Fatal("found unregistered hash type")
+	}
+}
+
+// TestDownloadCustomHash tests the verification of a download with a registered custom hash type.
+func TestDownloadCustomHash(t *testing.T) {
+	unregisterHash(customHashType, t)
+	if err := RegisterHash(customHashType, newCRC32C); err != nil {
+		t.Fatalf("failed to register hash type: %v", err)
+	}
+	body := "custom hash test"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sa := &hawkbit.SoftwareArtifactAction{
+		Filename:  "custom.txt",
+		Size:      len(body),
+		Checksums: map[hawkbit.Hash]string{customHashType: crc32cOf(body), "UNREGISTERED": "value"},
+		Download:  map[hawkbit.Protocol]*hawkbit.Links{hawkbit.HTTP: {URL: srv.URL + "/custom.txt"}},
+	}
+
+	// 1. Verify with the registered hash type only.
+	art, err := toArtifact(sa, false, false)
+	if err != nil {
+		t.Fatalf("failed to convert artifact: %v", err)
+	}
+	if art.HashType != customHashType || art.HashValue != crc32cOf(body) || len(art.Hashes) != 0 {
+		t.Fatalf("unexpected artifact checksums: %s %s %v", art.HashType, art.HashValue, art.Hashes)
+	}
+	name := filepath.Join(dir, sa.Filename)
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download artifact: %v", err)
+	}
+
+	// 2. Verify with the registered hash type in addition to a built-in one.
+	md5Sum := md5.Sum([]byte(body))
+	sa.Checksums[hawkbit.MD5] = hex.EncodeToString(md5Sum[:])
+	sa.Checksums[customHashType] = crc32cOf("tampered")
+	if art, err = toArtifact(sa, false, false); err != nil {
+		t.Fatalf("failed to convert artifact: %v", err)
+	}
+	expected := []*Hash{{Type: customHashType, Value: crc32cOf("tampered")}}
+	if art.HashType != string(hawkbit.MD5) || !reflect.DeepEqual(expected, art.Hashes) {
+		t.Fatalf("unexpected artifact checksums: %s %v", art.HashType, art.Hashes)
+	}
+	os.Remove(name)
+	if err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{}, 0, 0, nil, make(chan struct{})); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch: %v", err)
+	}
+}
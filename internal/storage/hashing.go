@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// Hashing modes of the downloaded artifacts.
+const (
+	// HashingInline calculates the artifact checksums after the download, reading the downloaded file again.
+	// It is better for single-core devices, where the hashing cannot run in parallel with the download.
+	HashingInline = "inline"
+	// HashingOverlapped calculates the artifact checksums on a separate goroutine, in parallel with the disk writes
+	// of the downloaded data, so that the downloaded file is not read again. It is better for multi-core devices.
+	// Resumed downloads are always hashed inline.
+	HashingOverlapped = "overlapped"
+)
+
+// errHashingStopped stops the overlapped hashing of a failed download.
+var errHashingStopped = errors.New("hashing is stopped")
+
+// overlappedHash validates the written data against the artifact hashes on a separate goroutine. Each write
+// returns, once the hashing goroutine has received its data, so the data is hashed while the next data is
+// downloaded and written.
+type overlappedHash struct {
+	pipe   *io.PipeWriter
+	result chan error
+}
+
+// newOverlappedHash starts the validation of the data, written to the returned hash, against the artifact hashes.
+func newOverlappedHash(artifact *Artifact) *overlappedHash {
+	data, pipe := io.Pipe()
+	h := &overlappedHash{pipe: pipe, result: make(chan error, 1)}
+	go func() {
+		err := validateData(data, artifact)
+		data.CloseWithError(err)
+		h.result <- err
+	}()
+	return h
+}
+
+// Write passes the data to the hashing goroutine.
+func (h *overlappedHash) Write(p []byte) (int, error) {
+	return h.pipe.Write(p)
+}
+
+// finish returns the validation result of all written data.
+func (h *overlappedHash) finish() error {
+	h.pipe.Close()
+	return <-h.result
+}
+
+// stop stops the hashing goroutine, if not finished yet.
+func (h *overlappedHash) stop() {
+	if h != nil {
+		h.pipe.CloseWithError(errHashingStopped)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// DefaultLocationMode is the default permission mode of the created storage location.
+const DefaultLocationMode os.FileMode = 0755
+
+// InitLocation creates the storage location and its missing parents with the given permission mode, if it does not
+// exist, and verifies that it is a writable directory with a probe file. World-writable storage locations are only
+// reported with a warning.
+func InitLocation(location string, mode os.FileMode) error {
+	_, err := os.Stat(location)
+	created := os.IsNotExist(err)
+	if err = os.MkdirAll(location, mode); err == nil && created {
+		// Apply the exact mode, not restricted by the process umask.
+		err = os.Chmod(location, mode)
+	}
+	if err != nil {
+		return fmt.Errorf("storage location %s cannot be created: %v", location, err)
+	}
+	if created {
+		logger.Infof("Storage location %s is created with mode %v", location, mode)
+	}
+	if err := probeLocation(location); err != nil {
+		return fmt.Errorf("storage location %s is not writable: %v", location, err)
+	}
+	if err := CheckLocationMode(location); err != nil {
+		logger.Warnf("%v", err)
+	}
+	return nil
+}
+
+// CheckLocationMode returns an error, if the storage location is writable by all users, who could replace the
+// downloaded artifacts and the installed modules.
+func CheckLocationMode(location string) error {
+	info, err := os.Stat(location)
+	if err != nil {
+		return fmt.Errorf("fail to determine mode of storage location %s: %v", location, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("storage location %s is world-writable: %v", location, info.Mode().Perm())
+	}
+	return nil
+}
+
+// probeLocation writes and removes a probe file in the storage location.
+func probeLocation(location string) error {
+	file, err := os.CreateTemp(location, ".probe-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write([]byte("probe")); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInitLocation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission modes are not supported on windows")
+	}
+	dir := t.TempDir()
+
+	// 1. Create the missing location with the given mode.
+	location := filepath.Join(dir, "missing", "storage")
+	if err := InitLocation(location, 0750); err != nil {
+		t.Fatalf("failed to create missing storage location: %v", err)
+	}
+	if info, err := os.Stat(location); err != nil || !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Fatalf("unexpected created storage location: %v, %v", info, err)
+	}
+	if entries, err := os.ReadDir(location); err != nil || len(entries) != 0 {
+		t.Fatalf("probe file is not removed: %v, %v", entries, err)
+	}
+
+	// 2. Keep the mode of the existing location.
+	if err := InitLocation(location, 0700); err != nil {
+		t.Fatalf("failed to initialize existing storage location: %v", err)
+	}
+	if info, err := os.Stat(location); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("mode of existing storage location is changed: %v, %v", info, err)
+	}
+
+	// 3. Fail on a file location.
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("test"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+	if err := InitLocation(file, 0755); err == nil || !strings.Contains(err.Error(), "cannot be created") {
+		t.Fatalf("expected file storage location to be rejected: %v", err)
+	}
+
+	// 4. Fail on a not writable location.
+	if os.Geteuid() == 0 {
+		t.Skip("not writable directories are writable by root")
+	}
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatalf("failed to create %s: %v", readOnly, err)
+	}
+	if err := InitLocation(readOnly, 0755); err == nil || !strings.Contains(err.Error(), "is not writable") {
+		t.Fatalf("expected not writable storage location to be rejected: %v", err)
+	}
+}
+
+func TestCheckLocationMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission modes are not supported on windows")
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("failed to change mode of %s: %v", dir, err)
+	}
+	if err := CheckLocationMode(dir); err != nil {
+		t.Fatalf("unexpected warning for storage location: %v", err)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("failed to change mode of %s: %v", dir, err)
+	}
+	if err := CheckLocationMode(dir); err == nil || !strings.Contains(err.Error(), "world-writable") {
+		t.Fatalf("expected warning for world-writable storage location: %v", err)
+	}
+	if err := InitLocation(dir, 0755); err != nil {
+		t.Fatalf("world-writable storage location must only be warned: %v", err)
+	}
+	if err := CheckLocationMode(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error for missing storage location")
+	}
+}
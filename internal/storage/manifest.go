@@ -0,0 +1,181 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const (
+	// MetadataManifest is the module metadata with the file name of the module manifest artifact, listing the
+	// SHA-256 digests of all other module artifacts in the sha256sum format: a "<hex digest>  <file name>" line
+	// per artifact.
+	MetadataManifest = "manifest"
+	// MetadataManifestSignature is the module metadata with the file name of the detached signature artifact
+	// of the module manifest. The signature is given raw or base64 encoded.
+	MetadataManifestSignature = "manifest-signature"
+)
+
+// ErrManifestInvalid represents module manifest, which is missing, not signed with the trusted key or not valid error.
+var ErrManifestInvalid = errors.New("module manifest is not valid")
+
+// ParsePublicKey parses the PEM encoded PKIX public key, used to verify the module manifest signatures.
+// RSA (PKCS #1 v1.5 signatures with SHA-256), ECDSA (ASN.1 signatures with SHA-256) and Ed25519 keys are supported.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// verifySignature verifies the signature of the data with the public key.
+func verifySignature(key crypto.PublicKey, data []byte, signature []byte) bool {
+	digest := sha256.Sum256(data)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, signature)
+	default:
+		return false
+	}
+}
+
+// parseManifest returns the hex encoded SHA-256 digests of the manifest by artifact file name.
+func parseManifest(data []byte) (map[string]string, error) {
+	digests := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid manifest line %d, expected digest and file name", line)
+		}
+		digest, name := strings.ToLower(fields[0]), strings.TrimPrefix(fields[1], "*")
+		if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 digest of %s on manifest line %d", name, line)
+		}
+		if _, ok := digests[name]; ok {
+			return nil, fmt.Errorf("duplicate manifest entry of %s on line %d", name, line)
+		}
+		digests[name] = digest
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// downloadManifest downloads the module manifest and its signature to the module directory and verifies the
+// signature with the manifest key. The other module artifacts are returned with the manifest digests instead
+// of their own checksums, to be verified against the manifest, and all of them must be listed in it.
+func (st *Storage) downloadManifest(toDir string, module *Module, progress progressBytes, server ServerConfig,
+	retryCount int, retryInterval time.Duration, pp postProcess, done chan struct{}) ([]*Artifact, error) {
+	manifest, signature := findArtifact(module, module.Metadata[MetadataManifest]),
+		findArtifact(module, module.Metadata[MetadataManifestSignature])
+	if manifest == nil || signature == nil {
+		return nil, fmt.Errorf("%w: manifest %q or its signature %q is not a module artifact", ErrManifestInvalid,
+			module.Metadata[MetadataManifest], module.Metadata[MetadataManifestSignature])
+	}
+	if server.ManifestKey == nil {
+		return nil, fmt.Errorf("%w: no manifest key is configured to verify its signature", ErrManifestInvalid)
+	}
+	for _, sa := range []*Artifact{manifest, signature} {
+		signed := *sa
+		signed.signed = true
+		if err := downloadArtifact(st.fs, filepath.Join(toDir, sa.FileName), &signed, progress, server, retryCount, retryInterval, pp, done); err != nil {
+			return nil, err
+		}
+	}
+	data, err := st.readFile(filepath.Join(toDir, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	sig, err := st.readFile(filepath.Join(toDir, signature.FileName))
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(server.ManifestKey, data, sig) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil || !verifySignature(server.ManifestKey, data, decoded) {
+			// Download the manifest again, e.g. if replaced on the server.
+			st.fs.Remove(filepath.Join(toDir, manifest.FileName))
+			st.fs.Remove(filepath.Join(toDir, signature.FileName))
+			return nil, fmt.Errorf("%w: signature of %s does not match", ErrManifestInvalid, manifest.FileName)
+		}
+	}
+	digests, err := parseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifestInvalid, err)
+	}
+	logger.Infof("module manifest [%s] is verified with %d entries", manifest.FileName, len(digests))
+
+	var artifacts []*Artifact
+	for _, sa := range module.Artifacts {
+		if sa == manifest || sa == signature {
+			continue
+		}
+		digest, ok := digests[sa.FileName]
+		if !ok {
+			return nil, fmt.Errorf("%w: artifact %s is not listed in manifest %s", ErrManifestInvalid, sa.FileName, manifest.FileName)
+		}
+		manifested := *sa
+		manifested.HashType, manifested.HashValue, manifested.HashEncoding = string(hawkbit.SHA256), digest, HashEncodingHex
+		manifested.Hashes, manifested.Decompressed = nil, nil
+		artifacts = append(artifacts, &manifested)
+	}
+	return artifacts, nil
+}
+
+// findArtifact returns the module artifact with the given file name or nil, if not found.
+func findArtifact(module *Module, fileName string) *Artifact {
+	for _, sa := range module.Artifacts {
+		if fileName != "" && sa.FileName == fileName {
+			return sa
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDownloadModuleManifest tests that the module artifacts are verified against the signed module manifest.
+func TestDownloadModuleManifest(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	artifacts := map[string]string{"image.bin": "image content", "data.bin": "data content"}
+	manifest := "# module manifest\n"
+	for _, name := range []string{"image.bin", "data.bin"} {
+		sum := sha256.Sum256([]byte(artifacts[name]))
+		manifest += hex.EncodeToString(sum[:]) + "  " + name + "\n"
+	}
+	signature := ed25519.Sign(private, []byte(manifest))
+
+	tests := map[string]struct {
+		served    map[string]string
+		unlisted  bool
+		noKey     bool
+		expected  error
+		tampered  string
+		signature string
+	}{
+		"valid":             {},
+		"base64Signature":   {signature: base64.StdEncoding.EncodeToString(signature)},
+		"tamperedArtifact":  {tampered: "data.bin", expected: ErrChecksumMismatch},
+		"tamperedManifest":  {served: map[string]string{"manifest.sha256": strings.Replace(manifest, "0", "1", 1)}, expected: ErrManifestInvalid},
+		"tamperedSignature": {signature: strings.Repeat("x", ed25519.SignatureSize), expected: ErrManifestInvalid},
+		"unlistedArtifact":  {unlisted: true, expected: ErrManifestInvalid},
+		"noManifestKey":     {noKey: true, expected: ErrManifestInvalid},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			served := map[string]string{"manifest.sha256": manifest, "manifest.sig": string(signature)}
+			for file, content := range artifacts {
+				served[file] = content
+			}
+			if test.tampered != "" {
+				served[test.tampered] = strings.ToUpper(served[test.tampered])
+			}
+			if test.signature != "" {
+				served["manifest.sig"] = test.signature
+			}
+			for file, content := range test.served {
+				served[file] = content
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(served[strings.TrimPrefix(r.URL.Path, "/")]))
+			}))
+			defer srv.Close()
+
+			store, err := NewStorage(t.TempDir())
+			if err != nil {
+				t.Fatalf("fail to initialize local storage: %v", err)
+			}
+			defer store.Close()
+
+			// The artifacts have no checksums of their own, but only the manifest entries.
+			m := &Module{Name: "manifest", Version: "1", Metadata: map[string]string{
+				MetadataManifest: "manifest.sha256", MetadataManifestSignature: "manifest.sig"}}
+			names := []string{"manifest.sha256", "manifest.sig", "image.bin", "data.bin"}
+			if test.unlisted {
+				served["unlisted.bin"] = "unlisted content"
+				names = append(names, "unlisted.bin")
+			}
+			for _, file := range names {
+				m.Artifacts = append(m.Artifacts, &Artifact{FileName: file, Size: len(served[file]), Link: srv.URL + "/" + file})
+			}
+			server := ServerConfig{ManifestKey: public}
+			if test.noKey {
+				server.ManifestKey = nil
+			}
+
+			dir := filepath.Join(store.DownloadPath, "0", "0")
+			err = store.DownloadModule(dir, m, nil, server, 0, 0, nil, nil)
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("fail to download module: %v", err)
+				}
+				for file, content := range artifacts {
+					if data, err := os.ReadFile(filepath.Join(dir, file)); err != nil || string(data) != content {
+						t.Fatalf("unexpected downloaded artifact %s: %s, %v", file, data, err)
+					}
+				}
+				return
+			}
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got: %v", test.expected, err)
+			}
+			if test.tampered != "" {
+				var artifactsErr *ArtifactsError
+				if !errors.As(err, &artifactsErr) || fmt.Sprint(artifactsErr.FileNames()) != "["+test.tampered+"]" {
+					t.Fatalf("unexpected failed artifacts: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, test.tampered)); !os.IsNotExist(err) {
+					t.Fatalf("tampered artifact is not removed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestParsePublicKey tests the manifest signature verification with the supported public key types.
+func TestParsePublicKey(t *testing.T) {
+	data := []byte("manifest content")
+	digest := sha256.Sum256(data)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	rsaSignature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	ecdsaSignature, _ := ecdsa.SignASN1(rand.Reader, ecdsaKey, digest[:])
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		key       crypto.PublicKey
+		signature []byte
+	}{
+		"rsa":     {key: &rsaKey.PublicKey, signature: rsaSignature},
+		"ecdsa":   {key: &ecdsaKey.PublicKey, signature: ecdsaSignature},
+		"ed25519": {key: edPublic, signature: ed25519.Sign(edPrivate, data)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			der, err := x509.MarshalPKIXPublicKey(test.key)
+			if err != nil {
+				t.Fatalf("failed to marshal public key: %v", err)
+			}
+			key, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+			if err != nil {
+				t.Fatalf("failed to parse public key: %v", err)
+			}
+			if !verifySignature(key, data, test.signature) {
+				t.Fatal("valid signature is not verified")
+			}
+			if verifySignature(key, []byte("tampered content"), test.signature) {
+				t.Fatal("signature of tampered data is verified")
+			}
+		})
+	}
+	if _, err := ParsePublicKey([]byte("not a key")); err == nil {
+		t.Fatal("expected error when parsing invalid public key")
+	}
+}
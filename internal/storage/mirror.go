@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// ValidateMirrors checks that all mirrors are in the form scheme://host[:port], with HTTP or HTTPS scheme.
+func ValidateMirrors(mirrors []string) error {
+	for _, mirror := range mirrors {
+		if _, err := parseMirror(mirror); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseMirror(mirror string) (*url.URL, error) {
+	u, err := url.Parse(mirror)
+	if err != nil || !isHTTP(u) || u.Host == "" || u.User != nil || strings.Trim(u.Path, "/") != "" ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid download mirror - %s, must be in the form scheme://host[:port] with http or https scheme", mirror)
+	}
+	return u, nil
+}
+
+func isHTTP(u *url.URL) bool {
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// downloadWithChecksumRetries calls the download function for the artifact and calls it again with a fresh
+// artifact download, up to the checksum retries of the server configuration, while it fails with checksum mismatch.
+// The failed download file is already discarded by then. Each retry rotates the HTTP(S) artifact links over the
+// configured mirrors, starting again from the original link after the last mirror.
+func downloadWithChecksumRetries(artifact *Artifact, server ServerConfig, retryInterval time.Duration,
+	download func(artifact *Artifact, server ServerConfig) error) error {
+	err := download(artifact, server)
+	for retry := 1; retry <= server.ChecksumRetries && errors.Is(err, ErrChecksumMismatch); retry++ {
+		mirrored, mirrorServer, mErr := mirror(artifact, server, retry)
+		if mErr != nil {
+			return mErr
+		}
+		logger.Warnf("checksum of artifact %s does not match, download it again from %s (%d of %d): %v",
+			artifact.FileName, RedactLink(mirrored.Link), retry, server.ChecksumRetries, err)
+		if server.OnRestart != nil {
+			server.OnRestart(artifact, RestartChecksumMismatch)
+		}
+		server.timings.retried()
+		sleep(retryInterval)
+		err = download(mirrored, mirrorServer)
+	}
+	return err
+}
+
+// mirror returns a copy of the artifact with the scheme and host of its link replaced by the mirror for the given
+// retry, i.e. the original link and the mirrors in rotation, and the server configuration to download it with.
+// Only the HTTP(S) links of remote artifacts are mirrored and the mirrored links must be allowed by the download
+// allow list. The credentials of the original link and the bearer token of the server are not sent to the mirrors.
+func mirror(artifact *Artifact, server ServerConfig, retry int) (*Artifact, ServerConfig, error) {
+	index := retry % (len(server.Mirrors) + 1)
+	if index == 0 || artifact.Local {
+		return artifact, server, nil
+	}
+	u, err := url.Parse(artifact.Link)
+	if err != nil || !isHTTP(u) {
+		return artifact, server, nil
+	}
+	m, err := parseMirror(server.Mirrors[index-1])
+	if err != nil {
+		return nil, server, err
+	}
+	u.Scheme, u.Host, u.User = m.Scheme, m.Host, nil
+	mirrored := *artifact
+	mirrored.Link = u.String()
+	if err := checkLink(rewrite(&mirrored, server).Link, server.AllowList); err != nil {
+		logger.Errorf("mirror link of artifact [%s] is not in the download allow list: %s", artifact.FileName, RedactLink(mirrored.Link))
+		return nil, server, err
+	}
+	server.AuthToken = ""
+	return &mirrored, server, nil
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// openNoFollow opens the named file, if it is not a symbolic link. The file is verified before it is opened,
+// as the platform cannot refuse to follow it on open.
+func openNoFollow(name string, flag int) (*os.File, error) {
+	if err := checkNotSymlink(name); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(name, flag, 0755)
+}
+
+// checkNotSymlink refuses to write through the named file, if it is an existing symbolic link, which can point
+// outside of the storage directory.
+func checkNotSymlink(name string) error {
+	if info, err := os.Lstat(name); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s is not written through a symbolic link", ErrSymlink, name)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const (
+	schemeOCI = "oci"
+	// ociDefaultTag is the tag of the OCI references without tag or digest.
+	ociDefaultTag = "latest"
+	// ociManifestLimit is the maximal size in bytes of the OCI manifests.
+	ociManifestLimit = 4 * 1024 * 1024
+	// ociDigestPrefix is the prefix of the supported OCI content digests.
+	ociDigestPrefix = "sha256:"
+)
+
+// ociManifestTypes are the accepted media types of the OCI image manifests.
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociReference is a reference to an OCI artifact in the form oci://registry/repository[:tag|@digest].
+type ociReference struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+// ociManifest is an OCI image manifest, listing the artifact layers.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociDescriptor describes the content of a layer.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// isOCI reports whether the artifact link is an OCI artifact reference.
+func isOCI(link string) bool {
+	return strings.HasPrefix(strings.ToLower(link), schemeOCI+"://")
+}
+
+// parseOCIReference parses the OCI artifact reference. The latest tag is referenced, if neither tag nor
+// digest is given.
+func parseOCIReference(link string) (*ociReference, error) {
+	path := link[len(schemeOCI+"://"):]
+	i := strings.Index(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return nil, fmt.Errorf("invalid OCI reference - %s, must be in the form oci://registry/repository[:tag|@digest]", RedactLink(link))
+	}
+	ref := &ociReference{registry: path[:i], repository: path[i+1:], reference: ociDefaultTag}
+	if at := strings.Index(ref.repository, "@"); at >= 0 {
+		ref.repository, ref.reference = ref.repository[:at], ref.repository[at+1:]
+		if !strings.HasPrefix(ref.reference, ociDigestPrefix) {
+			return nil, fmt.Errorf("unsupported digest of OCI reference - %s, only sha256 is supported", RedactLink(link))
+		}
+	} else if colon := strings.LastIndex(ref.repository, ":"); colon > strings.LastIndex(ref.repository, "/") {
+		ref.repository, ref.reference = ref.repository[:colon], ref.repository[colon+1:]
+	}
+	if ref.repository == "" || ref.reference == "" {
+		return nil, fmt.Errorf("invalid OCI reference - %s, must be in the form oci://registry/repository[:tag|@digest]", RedactLink(link))
+	}
+	return ref, nil
+}
+
+// getOCIInput resolves the OCI artifact reference and returns its layers, assembled in the manifest order.
+// Each layer is verified against its content digest. Resume is not supported, the artifact is pulled again.
+func getOCIInput(link string, server ServerConfig) (io.ReadCloser, bool, error) {
+	ref, err := parseOCIReference(link)
+	if err != nil {
+		return nil, false, err
+	}
+	registry := &ociRegistry{ref: ref, server: server}
+	manifest, err := registry.manifest()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, false, fmt.Errorf("OCI artifact %s has no layers", RedactLink(link))
+	}
+	for _, layer := range manifest.Layers {
+		if !strings.HasPrefix(layer.Digest, ociDigestPrefix) {
+			return nil, false, fmt.Errorf("unsupported digest of OCI artifact %s layer - %s", RedactLink(link), layer.Digest)
+		}
+	}
+	logger.Infof("resolved OCI artifact %s to %d layer(s)", RedactLink(link), len(manifest.Layers))
+	return &ociLayers{registry: registry, layers: manifest.Layers}, false, nil
+}
+
+// ociRegistry sends the requests of an OCI artifact to its registry, obtaining a bearer token from the
+// registry authorization service, if challenged.
+type ociRegistry struct {
+	ref    *ociReference
+	server ServerConfig
+	token  string
+}
+
+// manifest fetches the manifest of the artifact reference and verifies its digest, if referenced by digest.
+func (r *ociRegistry) manifest() (*ociManifest, error) {
+	response, err := r.get("manifests/"+r.ref.reference, strings.Join(ociManifestTypes, ", "))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(response.Body, ociManifestLimit))
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(r.ref.reference, ociDigestPrefix) {
+		if digest := sha256.Sum256(data); ociDigestPrefix+hex.EncodeToString(digest[:]) != r.ref.reference {
+			return nil, fmt.Errorf("%w: manifest of OCI artifact %s", ErrChecksumMismatch, r.ref.reference)
+		}
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of OCI artifact %s/%s: %v", r.ref.registry, r.ref.repository, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = response.Header.Get("Content-Type")
+	}
+	for _, mediaType := range ociManifestTypes {
+		if manifest.MediaType == mediaType {
+			return manifest, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported manifest media type of OCI artifact %s/%s - %s",
+		r.ref.registry, r.ref.repository, manifest.MediaType)
+}
+
+// get sends a request to the registry API of the artifact repository. The configured authorization token
+// is sent to the registry, or exchanged for a registry token, if the registry challenges it.
+func (r *ociRegistry) get(path string, accept string) (*http.Response, error) {
+	link := "https://" + r.ref.registry + "/v2/" + r.ref.repository + "/" + path
+	response, err := r.send(link, accept)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized && r.token == "" {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		if r.token, err = r.authorize(challenge); err != nil {
+			return nil, err
+		}
+		if response, err = r.send(link, accept); err != nil {
+			return nil, err
+		}
+	}
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		response.Body.Close()
+		return nil, fmt.Errorf("%w: %v", ErrBadStatus, response.StatusCode)
+	}
+	return response, nil
+}
+
+func (r *ociRegistry) send(link string, accept string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error doing http(s) request to %s", RedactLink(link))
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	token := r.token
+	if token == "" {
+		token = r.server.AuthToken
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	client, err := httpClient(request.URL, r.server)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(request)
+}
+
+// authorize obtains a registry token for the bearer authorization challenge, authenticated with the
+// configured authorization token, if set.
+func (r *ociRegistry) authorize(challenge string) (string, error) {
+	params := parseChallenge(challenge)
+	if params == nil || params["realm"] == "" {
+		return "", fmt.Errorf("%w: %v, unsupported authorization challenge - %s", ErrBadStatus, http.StatusUnauthorized, challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization realm of OCI registry %s - %s", r.ref.registry, params["realm"])
+	}
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	realm.RawQuery = query.Encode()
+	if err := checkLink(realm.String(), r.server.AllowList); err != nil {
+		return "", err
+	}
+	response, err := (&ociRegistry{ref: r.ref, server: r.server}).send(realm.String(), "")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return "", fmt.Errorf("%w: %v, OCI registry authorization failed", ErrBadStatus, response.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, ociManifestLimit)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid authorization response of OCI registry %s: %v", r.ref.registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return token.Token, nil
+}
+
+// parseChallenge returns the parameters of a bearer authorization challenge or nil, if not a bearer challenge.
+func parseChallenge(challenge string) map[string]string {
+	const scheme = "bearer "
+	if len(challenge) < len(scheme) || !strings.EqualFold(challenge[:len(scheme)], scheme) {
+		return nil
+	}
+	params := map[string]string{}
+	rest := challenge[len(scheme):]
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			return params
+		}
+		name, value := strings.ToLower(strings.TrimSpace(rest[:eq])), rest[eq+1:]
+		end := len(value)
+		if strings.HasPrefix(value, "\"") { // Quoted values can contain commas, e.g. the scope actions
+			value = value[1:]
+			if end = strings.Index(value, "\""); end < 0 {
+				end = len(value)
+			}
+			rest = strings.TrimPrefix(value[end:], "\"")
+		} else {
+			if i := strings.Index(value, ","); i >= 0 {
+				end = i
+			}
+			rest = value[end:]
+		}
+		params[name] = value[:end]
+	}
+}
+
+// ociLayers reads the layers of an OCI artifact one after another, verifying each against its digest.
+type ociLayers struct {
+	registry *ociRegistry
+	layers   []ociDescriptor
+	current  io.ReadCloser
+	hash     hash.Hash
+	read     int64
+}
+
+// Read reads the current layer, pulling the next one, when the current is completely read and verified.
+func (l *ociLayers) Read(p []byte) (int, error) {
+	for {
+		if l.current == nil {
+			if len(l.layers) == 0 {
+				return 0, io.EOF
+			}
+			response, err := l.registry.get("blobs/"+l.layers[0].Digest, "")
+			if err != nil {
+				return 0, err
+			}
+			l.current, l.hash, l.read = response.Body, sha256.New(), 0
+		}
+		n, err := l.current.Read(p)
+		l.hash.Write(p[:n])
+		l.read += int64(n)
+		if err == io.EOF {
+			err = l.next()
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// next verifies the completely read current layer and moves to the next layer.
+func (l *ociLayers) next() error {
+	layer := l.layers[0]
+	l.current.Close()
+	l.current = nil
+	l.layers = l.layers[1:]
+	if digest := ociDigestPrefix + hex.EncodeToString(l.hash.Sum(nil)); digest != layer.Digest {
+		return fmt.Errorf("%w: OCI layer %s != %s", ErrChecksumMismatch, digest, layer.Digest)
+	}
+	if layer.Size > 0 && l.read != layer.Size {
+		return fmt.Errorf("%w: OCI layer %s has %d bytes, expected %d", ErrFileSizeMismatch, layer.Digest, l.read, layer.Size)
+	}
+	return nil
+}
+
+// Close closes the current layer.
+func (l *ociLayers) Close() error {
+	if l.current != nil {
+		return l.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOCIToken = "registry-token"
+
+// testOCIRegistry is a registry stub, serving the manifests and blobs of an OCI artifact repository, with
+// bearer token authorization.
+type testOCIRegistry struct {
+	srv       *httptest.Server
+	cert      string
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+func newTestOCIRegistry(t *testing.T) *testOCIRegistry {
+	registry := &testOCIRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	registry.srv = httptest.NewTLSServer(http.HandlerFunc(registry.handle))
+	t.Cleanup(registry.srv.Close)
+	registry.cert = filepath.Join(t.TempDir(), "registry.crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: registry.srv.Certificate().Raw})
+	if err := os.WriteFile(registry.cert, data, 0644); err != nil {
+		t.Fatalf("fail to write registry certificate: %v", err)
+	}
+	return registry
+}
+
+func (r *testOCIRegistry) handle(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("scope") != "repository:app/image:pull,push" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"token":"` + testOCIToken + `"}`))
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+testOCIToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.srv.URL+`/token",service="registry",scope="repository:app/image:pull,push"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if ref := strings.TrimPrefix(req.URL.Path, "/v2/app/image/manifests/"); ref != req.URL.Path {
+		if manifest, ok := r.manifests[ref]; ok {
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Write(manifest)
+			return
+		}
+	}
+	if digest := strings.TrimPrefix(req.URL.Path, "/v2/app/image/blobs/"); digest != req.URL.Path {
+		if blob, ok := r.blobs[digest]; ok {
+			w.Write(blob)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// push adds the layers of an artifact with the given tag and returns the manifest digest.
+func (r *testOCIRegistry) push(tag string, layers ...string) string {
+	manifest := &ociManifest{MediaType: "application/vnd.oci.image.manifest.v1+json"}
+	for _, layer := range layers {
+		digest := testOCIDigest([]byte(layer))
+		r.blobs[digest] = []byte(layer)
+		manifest.Layers = append(manifest.Layers, ociDescriptor{MediaType: "application/octet-stream", Digest: digest, Size: int64(len(layer))})
+	}
+	data, _ := json.Marshal(manifest)
+	digest := testOCIDigest(data)
+	r.manifests[tag], r.manifests[digest] = data, data
+	return digest
+}
+
+func (r *testOCIRegistry) link(reference string) string {
+	return "oci://" + strings.TrimPrefix(r.srv.URL, "https://") + "/app/image" + reference
+}
+
+func testOCIDigest(data []byte) string {
+	digest := sha256.Sum256(data)
+	return ociDigestPrefix + hex.EncodeToString(digest[:])
+}
+
+func testOCIModule(link string, content string) *Module {
+	hash := sha256.Sum256([]byte(content))
+	return &Module{Name: "name", Version: "1", Artifacts: []*Artifact{{FileName: "image.bin", Size: len(content),
+		Link: link, HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])}}}
+}
+
+// TestDownloadOCI tests the pull of multi-layer OCI artifacts, referenced by digest and by tag.
+func TestDownloadOCI(t *testing.T) {
+	registry := newTestOCIRegistry(t)
+	digest := registry.push("1.0", "first layer;", "second layer;", "third layer")
+	content := "first layer;second layer;third layer"
+
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	server := ServerConfig{Cert: registry.cert}
+
+	for i, reference := range []string{"@" + digest, ":1.0"} {
+		to := filepath.Join(dir, string(rune('a'+i)))
+		if err := store.DownloadModule(to, testOCIModule(registry.link(reference), content), nil, server, 0, 0, nil, nil); err != nil {
+			t.Fatalf("fail to pull OCI artifact %s: %v", reference, err)
+		}
+		if data, err := os.ReadFile(filepath.Join(to, "image.bin")); err != nil || string(data) != content {
+			t.Fatalf("unexpected OCI artifact %s content: %s, %v", reference, data, err)
+		}
+	}
+}
+
+// TestDownloadOCIDigestMismatch tests that OCI artifacts with layers or manifests, not matching their digests,
+// are not downloaded.
+func TestDownloadOCIDigestMismatch(t *testing.T) {
+	registry := newTestOCIRegistry(t)
+	digest := registry.push("1.0", "first layer;", "second layer")
+	content := "first layer;second layer"
+
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	server := ServerConfig{Cert: registry.cert}
+
+	// 1. Layer with tampered content of the same size.
+	registry.blobs[testOCIDigest([]byte("second layer"))] = []byte("second LAYER")
+	err = store.DownloadModule(filepath.Join(dir, "1"), testOCIModule(registry.link("@"+digest), content), nil, server, 0, 0, nil, nil)
+	if !errors.Is(err, ErrChecksumMismatch) || !strings.Contains(err.Error(), "OCI layer") {
+		t.Fatalf("expected layer digest mismatch: %v", err)
+	}
+
+	// 2. Manifest with other digest than the referenced one.
+	other := testOCIDigest([]byte("other manifest"))
+	registry.manifests[other] = registry.manifests["1.0"]
+	err = store.DownloadModule(filepath.Join(dir, "2"), testOCIModule(registry.link("@"+other), content), nil, server, 0, 0, nil, nil)
+	if !errors.Is(err, ErrChecksumMismatch) || !strings.Contains(err.Error(), "manifest") {
+		t.Fatalf("expected manifest digest mismatch: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2", "image.bin")); !os.IsNotExist(err) {
+		t.Fatalf("OCI artifact with digest mismatch is downloaded: %v", err)
+	}
+}
+
+// TestParseOCIReference tests the parsing of the OCI artifact references.
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		link     string
+		expected *ociReference
+	}{
+		{"oci://registry.io/app/image", &ociReference{"registry.io", "app/image", "latest"}},
+		{"oci://localhost:5000/image:1.0", &ociReference{"localhost:5000", "image", "1.0"}},
+		{"oci://registry.io/app/image@sha256:abc", &ociReference{"registry.io", "app/image", "sha256:abc"}},
+		{"oci://registry.io", nil},
+		{"oci://registry.io/", nil},
+		{"oci://registry.io/image@md5:abc", nil},
+	}
+	for _, test := range tests {
+		ref, err := parseOCIReference(test.link)
+		if !reflect.DeepEqual(ref, test.expected) || (test.expected == nil) != (err != nil) {
+			t.Errorf("unexpected reference of %s: %v, %v", test.link, ref, err)
+		}
+	}
+}
+
+// TestParseChallenge tests the parsing of the bearer authorization challenges.
+func TestParseChallenge(t *testing.T) {
+	params := parseChallenge(`Bearer realm="https://auth.io/token",service=registry.io,scope="repository:app:pull,push"`)
+	expected := map[string]string{"realm": "https://auth.io/token", "service": "registry.io", "scope": "repository:app:pull,push"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("unexpected challenge parameters: %v", params)
+	}
+	if params := parseChallenge(`Basic realm="registry"`); params != nil {
+		t.Errorf("unexpected basic challenge parameters: %v", params)
+	}
+}
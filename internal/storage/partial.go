@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// partialInfoSuffix is the file name suffix of the partial download information, stored next to the partial file.
+const partialInfoSuffix = ".partial"
+
+// RestartReason is the reason to abandon a partial download and restart it from the beginning.
+type RestartReason string
+
+const (
+	// RestartSizeMismatch is reported, when the partial file exceeds the expected artifact size or was
+	// downloaded for a different artifact size.
+	RestartSizeMismatch RestartReason = "size mismatch"
+	// RestartLinkMismatch is reported, when the partial file was downloaded from a different artifact link.
+	RestartLinkMismatch RestartReason = "link mismatch"
+	// RestartETagChanged is reported, when the artifact server returns a different entity tag than the one,
+	// returned when the partial download was started.
+	RestartETagChanged RestartReason = "entity tag changed"
+	// RestartCorrupted is reported, when the completely downloaded partial file is not valid.
+	RestartCorrupted RestartReason = "corrupted partial"
+	// RestartNotSupported is reported, when the artifact server does not support resuming the download.
+	RestartNotSupported RestartReason = "resume not supported"
+	// RestartChecksumMismatch is reported, when the completely downloaded artifact does not match its checksum
+	// and is downloaded again, as allowed by the checksum retries.
+	RestartChecksumMismatch RestartReason = "checksum mismatch"
+)
+
+// RestartListener is notified, when a partial download of the artifact is abandoned and restarted from the beginning.
+type RestartListener func(artifact *Artifact, reason RestartReason)
+
+// partialInfo is the information of a partial download, used to verify that it is still resumable.
+type partialInfo struct {
+	Link string `json:"link"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag,omitempty"`
+	// NoResume is set, if the partial download cannot be resumed, but only restarted from the beginning.
+	NoResume bool `json:"noResume,omitempty"`
+}
+
+// entityBody is a response body, carrying the entity tag of the response and the response itself for its trailers.
+type entityBody struct {
+	io.ReadCloser
+	etag     string
+	response *http.Response
+	// noResume is set, if the artifact server neither sends the response content length nor supports range requests.
+	noResume bool
+}
+
+// entityTag returns the entity tag of the downloaded resource, if provided by the artifact server.
+func entityTag(source io.ReadCloser) string {
+	if body, ok := source.(*entityBody); ok {
+		return body.etag
+	}
+	return ""
+}
+
+// resumable returns whether the download from the given source can be resumed. It cannot, if disabled by the
+// server configuration or if the artifact server neither sends the content length nor supports range requests.
+func resumable(server ServerConfig, source io.ReadCloser) bool {
+	if server.NoResume {
+		return false
+	}
+	body, ok := source.(*entityBody)
+	return !ok || !body.noResume
+}
+
+// writePartialInfo stores the information of the partial download, started from the given source.
+func writePartialInfo(fs FileSystem, to string, artifact *Artifact, server ServerConfig, source io.ReadCloser) {
+	file, err := fs.Create(to + partialInfoSuffix)
+	if err != nil {
+		logger.Errorf("failed to store partial download information of %s: %v", to, err)
+		return
+	}
+	defer file.Close()
+	info := &partialInfo{Link: artifact.Link, Size: maxSize(artifact), ETag: entityTag(source),
+		NoResume: !resumable(server, source)}
+	if err := json.NewEncoder(file).Encode(info); err != nil {
+		logger.Errorf("failed to store partial download information of %s: %v", to, err)
+	}
+}
+
+// readPartialInfo returns the stored information of the partial download or nil, if not available,
+// e.g. partial files, left by previous versions.
+func readPartialInfo(fs FileSystem, to string) *partialInfo {
+	file, err := fs.Open(to + partialInfoSuffix)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	info := &partialInfo{}
+	if err := json.NewDecoder(file).Decode(info); err != nil {
+		logger.Debugf("ignoring invalid partial download information of %s: %v", to, err)
+		return nil
+	}
+	return info
+}
+
+// hasPartialInfo returns whether the partial download information is stored, even if not readable.
+func hasPartialInfo(fs FileSystem, to string) bool {
+	_, err := fs.Stat(to + partialInfoSuffix)
+	return !os.IsNotExist(err)
+}
+
+// removePartialInfo removes the stored information of the partial download, if any.
+func removePartialInfo(fs FileSystem, to string) {
+	if hasPartialInfo(fs, to) {
+		if err := fs.Remove(to + partialInfoSuffix); err != nil {
+			logger.Debugf("failed to remove partial download information: %v", err)
+		}
+	}
+}
+
+// checkPartial verifies that the partial download of the given size can be resumed for the artifact and
+// returns the reason to restart it otherwise.
+func checkPartial(info *partialInfo, offset int64, artifact *Artifact) (RestartReason, error) {
+	if size := maxSize(artifact); (size > 0 || zeroByte(artifact)) && offset > size {
+		return RestartSizeMismatch, fmt.Errorf("partial file has %d bytes, expected at most %d", offset, size)
+	}
+	if info == nil {
+		return "", nil
+	}
+	if info.Link != artifact.Link {
+		return RestartLinkMismatch, fmt.Errorf("partial file is downloaded from %s", RedactLink(info.Link))
+	}
+	if size := maxSize(artifact); info.Size != size {
+		return RestartSizeMismatch, fmt.Errorf("partial file is downloaded for %d bytes, expected %d", info.Size, size)
+	}
+	return "", nil
+}
+
+// checkPartialETag verifies that the entity tag of the resumed download is the same as the one of the partial
+// download, if both are provided by the artifact server.
+func checkPartialETag(info *partialInfo, source io.ReadCloser) (RestartReason, error) {
+	if info == nil || info.ETag == "" {
+		return "", nil
+	}
+	if etag := entityTag(source); etag != "" && normalizeETag(etag) != normalizeETag(info.ETag) {
+		return RestartETagChanged, fmt.Errorf("entity tag %s, partial file is downloaded with %s", etag, info.ETag)
+	}
+	return "", nil
+}
+
+// notifyRestart reports that the partial download of the artifact is abandoned and restarted from the beginning.
+func notifyRestart(server ServerConfig, artifact *Artifact, reason RestartReason, cause error) {
+	logger.Warnf("restart download of artifact %s from the beginning, partial download is abandoned - %s: %v",
+		RedactLink(artifact.Link), reason, cause)
+	if server.OnRestart != nil {
+		server.OnRestart(artifact, reason)
+	}
+}
+
+// downloadContext is the state of a single artifact download, shared by its resumes, restarts and retries.
+type downloadContext struct {
+	// restarts counts the restarts of the artifact download from the beginning, to limit them.
+	restarts int
+}
+
+// countRestart counts the restart of the artifact download from the beginning, abandoning the downloaded data,
+// and returns ErrTooManyRestarts, if it is restarted more times than allowed by the server configuration.
+// The restarts of the downloads without context, e.g. into memory, are not limited.
+func (dc *downloadContext) countRestart(server ServerConfig, artifact *Artifact) error {
+	if dc == nil {
+		return nil
+	}
+	dc.restarts++
+	if server.MaxRestarts > 0 && dc.restarts > server.MaxRestarts {
+		logger.Errorf("download of artifact %s is restarted %d times, giving up", RedactLink(artifact.Link), server.MaxRestarts)
+		return fmt.Errorf("%w: %d restarts of %s", ErrTooManyRestarts, server.MaxRestarts, artifact.FileName)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// preallocate reserves the storage of the download file, written from the given offset, up to the maximal size
+// of the artifact, if enabled by the server configuration. The storage is allocated without changing the file size,
+// so that the resume offsets remain valid. The files are not preallocated, where this is not supported, as files
+// extended to their full size could be taken for complete downloads after a crash. The storage shortage is returned
+// as an error to fail fast, the other errors only disable the preallocation.
+func preallocate(fs FileSystem, file File, to string, offset int64, artifact *Artifact, server ServerConfig) error {
+	size := maxSize(artifact)
+	if !server.Preallocate || size <= offset {
+		return nil
+	}
+	pfs, ok := fs.(preallocatingFileSystem)
+	if !ok {
+		return nil
+	}
+	err := pfs.Preallocate(file, size)
+	if err == nil {
+		logger.Debugf("preallocated %d bytes for file: %s", size, to)
+		return nil
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("failed to preallocate %d bytes for file %s: %w", size, to, err)
+	}
+	logger.Debugf("failed to preallocate file %s: %v", to, err)
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is the FALLOC_FL_KEEP_SIZE mode of fallocate, which allocates the blocks without changing the file size.
+const fallocKeepSize = 0x1
+
+// fallocate allocates the blocks of the open file up to the given size, keeping its size, so that the resumed
+// downloads still continue from its end.
+func fallocate(file *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// preallocFileSystem is an operating system storage backend, which records its preallocations and
+// supports them only if enabled.
+type preallocFileSystem struct {
+	OSFileSystem
+	supported bool
+	sizes     []int64
+}
+
+func (fs *preallocFileSystem) Preallocate(file File, size int64) error {
+	if !fs.supported {
+		return errPreallocateNotSupported
+	}
+	fs.sizes = append(fs.sizes, size)
+	return fs.OSFileSystem.Preallocate(file, size)
+}
+
+// TestDownloadPreallocate tests that the downloads are preallocated to their full size by fallocate, without
+// extending their files, where not supported, and that the aborted preallocated downloads are still resumed
+// from their written size.
+func TestDownloadPreallocate(t *testing.T) {
+	body := strings.Repeat("preallocated content ", 512)
+	sum := md5.Sum([]byte(body))
+
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	art := &Artifact{
+		FileName: "test-prealloc.txt", Size: len(body), Link: srv.URL + "/test-prealloc.txt",
+		HashType:  "MD5",
+		HashValue: hex.EncodeToString(sum[:]),
+	}
+	for name, supported := range map[string]bool{"fallocate": true, "unsupported": false} {
+		t.Run(name, func(t *testing.T) {
+			ranges = nil
+			fs := &preallocFileSystem{supported: supported}
+			dir := t.TempDir()
+			file := filepath.Join(dir, art.FileName)
+			tmp := filepath.Join(dir, prefix+art.FileName)
+
+			// 1. Abort the download and keep its partial file with the written size.
+			policy, _ := sequencePolicy(DecisionContinue, DecisionContinue, DecisionAbort)
+			server := ServerConfig{Buffers: NewBufferPool(1024, 0), Continue: policy, ContinueInterval: time.Nanosecond,
+				Preallocate: true}
+			var extended int64
+			progress := func(int64) {
+				if stat, err := os.Stat(tmp); err == nil && stat.Size() > extended {
+					extended = stat.Size()
+				}
+			}
+			if err := downloadArtifact(fs, file, art, progress, server, 0, 0, nil, make(chan struct{})); err != ErrAborted {
+				t.Fatalf("expected aborted download, got: %v", err)
+			}
+			if extended >= int64(len(body)) {
+				t.Fatalf("the download file is extended to %d bytes", extended)
+			}
+			stat, err := os.Stat(tmp)
+			if err != nil {
+				t.Fatalf("partial file of the aborted download is not kept: %v", err)
+			}
+			if stat.Size() == 0 || stat.Size() >= int64(len(body)) {
+				t.Fatalf("unexpected partial file size: %d", stat.Size())
+			}
+
+			// 2. Resume the download from the written size.
+			server.Continue, _ = sequencePolicy(DecisionContinue)
+			if err := downloadArtifact(fs, file, art, nil, server, 0, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to resume preallocated download: %v", err)
+			}
+			if data, err := os.ReadFile(file); err != nil || string(data) != body {
+				t.Fatalf("unexpected downloaded content: %v", err)
+			}
+			if expected := "bytes=" + strconv.FormatInt(stat.Size(), 10) + "-"; len(ranges) != 2 || ranges[1] != expected {
+				t.Fatalf("expected resume with range %s, got requests %q", expected, ranges)
+			}
+			if supported {
+				if len(fs.sizes) != 2 || fs.sizes[0] != int64(len(body)) || fs.sizes[1] != int64(len(body)) {
+					t.Fatalf("expected preallocation of %d bytes for download and resume, got %v", len(body), fs.sizes)
+				}
+			}
+		})
+	}
+}
+
+// TestDownloadPreallocateDisabled tests that the downloads are not preallocated, if not enabled.
+func TestDownloadPreallocateDisabled(t *testing.T) {
+	body := "not preallocated content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	fs := &preallocFileSystem{supported: true}
+	art := &Artifact{FileName: "test-prealloc.txt", Size: len(body), Link: srv.URL + "/test-prealloc.txt"}
+	file := filepath.Join(t.TempDir(), art.FileName)
+	if err := downloadArtifact(fs, file, art, nil, ServerConfig{MissingChecksum: MissingChecksumSkip}, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download artifact: %v", err)
+	}
+	if len(fs.sizes) != 0 {
+		t.Fatalf("unexpected preallocations: %v", fs.sizes)
+	}
+}
+
+// BenchmarkDownloadPreallocate benchmarks the downloads with and without preallocation.
+func BenchmarkDownloadPreallocate(b *testing.B) {
+	body := strings.Repeat("0123456789abcdef", 1<<18)
+	sum := md5.Sum([]byte(body))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	art := &Artifact{
+		FileName: "bench-prealloc.bin", Size: len(body), Link: srv.URL + "/bench-prealloc.bin",
+		HashType:  "MD5",
+		HashValue: hex.EncodeToString(sum[:]),
+	}
+	for _, preallocate := range []bool{false, true} {
+		b.Run("preallocate="+strconv.FormatBool(preallocate), func(b *testing.B) {
+			dir := b.TempDir()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				file := filepath.Join(dir, strconv.Itoa(i)+art.FileName)
+				if err := downloadArtifact(OSFileSystem{}, file, art, nil, ServerConfig{Preallocate: preallocate},
+					0, 0, nil, make(chan struct{})); err != nil {
+					b.Fatalf("failed to download artifact: %v", err)
+				}
+				os.Remove(file)
+			}
+		})
+	}
+}
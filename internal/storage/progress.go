@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"sync"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// ProgressFanOut delivers the download progress to multiple listeners, e.g. the status reporting, a local UI
+// and a metrics exporter. Each listener is notified in its own goroutine with the latest progress, skipping the
+// intermediate updates it is too slow to receive, so that blocking or panicking listeners stall neither
+// the download nor the other listeners.
+type ProgressFanOut struct {
+	listeners []*progressListener
+}
+
+// progressUpdate is a progress notification.
+type progressUpdate struct {
+	percent int
+	written int64
+	total   int64
+}
+
+// progressListener delivers the latest pending progress update to a listener.
+type progressListener struct {
+	lock    sync.Mutex
+	notify  Progress
+	pending *progressUpdate
+	signal  chan struct{}
+	closed  bool
+}
+
+// NewProgressFanOut returns a new fan-out of the download progress to the given listeners.
+func NewProgressFanOut(listeners ...Progress) *ProgressFanOut {
+	fanOut := &ProgressFanOut{}
+	for _, notify := range listeners {
+		listener := &progressListener{notify: notify, signal: make(chan struct{}, 1)}
+		fanOut.listeners = append(fanOut.listeners, listener)
+		go listener.run()
+	}
+	return fanOut
+}
+
+// Update notifies the listeners with the download progress without waiting for them. It is a Progress callback.
+func (f *ProgressFanOut) Update(percent int, written int64, total int64) {
+	for _, listener := range f.listeners {
+		listener.update(&progressUpdate{percent: percent, written: written, total: total})
+	}
+}
+
+// Close stops the listener goroutines, once their pending progress updates are delivered.
+// The progress updates after close are ignored.
+func (f *ProgressFanOut) Close() {
+	for _, listener := range f.listeners {
+		listener.close()
+	}
+}
+
+func (l *progressListener) update(update *progressUpdate) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return
+	}
+	l.pending = update
+	select {
+	case l.signal <- struct{}{}:
+	default: // The listener is already signaled for the pending update.
+	}
+}
+
+func (l *progressListener) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.signal)
+	}
+}
+
+func (l *progressListener) run() {
+	for {
+		_, open := <-l.signal
+		l.lock.Lock()
+		update := l.pending
+		l.pending = nil
+		l.lock.Unlock()
+		if update != nil {
+			l.deliver(update)
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+// deliver notifies the listener, recovering from its panics, so that it keeps receiving the next updates.
+func (l *progressListener) deliver(update *progressUpdate) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("download progress listener failed: %v", r)
+		}
+	}()
+	l.notify(update.percent, update.written, update.total)
+}
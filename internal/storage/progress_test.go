@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// TestProgressFanOut tests that all listeners receive the download progress and that blocking or panicking
+// listeners do not stall the download and the other listeners.
+func TestProgressFanOut(t *testing.T) {
+	fast := make(chan int, 100)
+	slow := make(chan int, 100)
+	release := make(chan struct{})
+	fanOut := NewProgressFanOut(
+		func(percent int, written int64, total int64) {
+			fast <- percent
+		},
+		func(percent int, written int64, total int64) {
+			<-release
+			slow <- percent
+		},
+		func(percent int, written int64, total int64) {
+			panic("failed listener")
+		},
+	)
+
+	// 1. Notify the listeners without waiting for the blocked one.
+	updated := make(chan struct{})
+	go func() {
+		for percent := 10; percent <= 100; percent += 10 {
+			fanOut.Update(percent, int64(percent), 100)
+		}
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("progress updates are blocked by the slow listener")
+	}
+	for last := 0; last < 100; {
+		select {
+		case percent := <-fast:
+			if percent <= last {
+				t.Fatalf("unexpected progress of fast listener: %d after %d", percent, last)
+			}
+			last = percent
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing progress of fast listener")
+		}
+	}
+
+	// 2. Deliver the latest progress to the slow listener, skipping the intermediate updates.
+	fanOut.Close()
+	close(release)
+	var last int
+	for done := false; !done; {
+		select {
+		case percent := <-slow:
+			if percent <= last {
+				t.Fatalf("unexpected progress of slow listener: %d after %d", percent, last)
+			}
+			last = percent
+		case <-time.After(time.Second):
+			done = true
+		}
+	}
+	if last != 100 {
+		t.Fatalf("slow listener did not receive the latest progress: %d", last)
+	}
+
+	// 3. Ignore the updates after close.
+	fanOut.Update(100, 100, 100)
+	select {
+	case percent := <-fast:
+		t.Fatalf("unexpected progress after close: %d", percent)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxRedirects is the default maximal number of followed redirects of a download request.
+const DefaultMaxRedirects = 10
+
+// RedirectPolicy restricts the followed redirects of the download requests. The redirects, it does not allow,
+// fail with ErrRedirectNotAllowed.
+type RedirectPolicy struct {
+	// Max is the maximal number of redirects, DefaultMaxRedirects if not set. As with the standard HTTP client,
+	// the request is stopped at its Max-th redirect. Redirects are not followed, if negative.
+	Max int
+	// SameHost allows only the redirects to the host of the original request and to the AllowList entries.
+	// The redirects from https to http are not allowed to the same host.
+	SameHost bool
+	// AllowList allows only the redirects to the listed [scheme://]host[:port] entries and, if SameHost is set,
+	// to the host of the original request. All redirects are allowed, if empty and SameHost is not set.
+	// See ValidateAllowList for the entries format.
+	AllowList []string
+}
+
+// check verifies the redirect request against the policy, given the previous requests.
+func (p RedirectPolicy) check(req *http.Request, via []*http.Request) error {
+	max := p.Max
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+	if len(via) >= max || max < 0 {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrRedirectNotAllowed, len(via))
+	}
+	if !p.SameHost && len(p.AllowList) == 0 {
+		return nil
+	}
+	if p.SameHost && len(via) > 0 && strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) &&
+		!isDowngrade(via[0].URL.Scheme, req.URL.Scheme) {
+		return nil
+	}
+	if len(p.AllowList) > 0 && checkLink(req.URL.String(), p.AllowList) == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRedirectNotAllowed, req.URL.Redacted())
+}
+
+// isDowngrade reports whether the redirect from the original scheme to the redirected one drops TLS.
+func isDowngrade(from string, to string) bool {
+	return strings.EqualFold(from, "https") && !strings.EqualFold(to, "https")
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestDownloadRedirectPolicy tests the followed and the blocked redirects of the download requests.
+func TestDownloadRedirectPolicy(t *testing.T) {
+	body := []byte("redirected content")
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/file", http.StatusFound)
+		case "/twice":
+			http.Redirect(w, r, "/same", http.StatusFound)
+		case "/cross":
+			// The same server with another host name.
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/file", http.StatusFound)
+		default:
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(body)
+	tests := map[string]struct {
+		path    string
+		policy  RedirectPolicy
+		blocked bool
+	}{
+		"defaultCrossHost":    {path: "/cross"},
+		"sameHostAllowed":     {path: "/same", policy: RedirectPolicy{SameHost: true}},
+		"crossHostBlocked":    {path: "/cross", policy: RedirectPolicy{SameHost: true}, blocked: true},
+		"crossHostAllowList":  {path: "/cross", policy: RedirectPolicy{SameHost: true, AllowList: []string{"localhost"}}},
+		"allowListBlocked":    {path: "/same", policy: RedirectPolicy{AllowList: []string{"localhost"}}, blocked: true},
+		"maxRedirects":        {path: "/twice", policy: RedirectPolicy{Max: 3}},
+		"maxRedirectsBlocked": {path: "/twice", policy: RedirectPolicy{Max: 2}, blocked: true},
+		"noRedirects":         {path: "/same", policy: RedirectPolicy{Max: -1}, blocked: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			art := &Artifact{FileName: "redirect.txt", Size: len(body), Link: srv.URL + test.path,
+				HashType: "SHA256", HashValue: hex.EncodeToString(sum[:])}
+			name := filepath.Join(t.TempDir(), art.FileName)
+			err := downloadArtifact(OSFileSystem{}, name, art, nil, ServerConfig{Redirects: test.policy}, 3, 0, nil, make(chan struct{}))
+			if !test.blocked {
+				if err != nil {
+					t.Fatalf("failed to download redirected artifact: %v", err)
+				}
+				check(name, art.Size, t)
+				return
+			}
+			if !errors.Is(err, ErrRedirectNotAllowed) {
+				t.Fatalf("expected %v, got: %v", ErrRedirectNotAllowed, err)
+			}
+			if r := atomic.LoadInt32(&requests); r > 2 {
+				t.Fatalf("expected blocked redirect not to be retried, got %d requests", r)
+			}
+		})
+	}
+}
+
+// TestRedirectPolicyCheck tests the default redirect limit of the standard HTTP client and the rejected downgrades
+// of the same host redirects.
+func TestRedirectPolicyCheck(t *testing.T) {
+	request := func(link string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, link, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		return req
+	}
+	via := func(count int, link string) []*http.Request {
+		requests := make([]*http.Request, count)
+		for i := range requests {
+			requests[i] = request(link)
+		}
+		return requests
+	}
+
+	policy := RedirectPolicy{}
+	if err := policy.check(request("http://host/file"), via(DefaultMaxRedirects-1, "http://host/file")); err != nil {
+		t.Errorf("redirect below the default limit is not allowed: %v", err)
+	}
+	if err := policy.check(request("http://host/file"), via(DefaultMaxRedirects, "http://host/file")); !errors.Is(err, ErrRedirectNotAllowed) {
+		t.Errorf("redirect at the default limit is allowed: %v", err)
+	}
+
+	policy = RedirectPolicy{SameHost: true}
+	if err := policy.check(request("https://host/file"), via(1, "http://host/file")); err != nil {
+		t.Errorf("same host upgrade is not allowed: %v", err)
+	}
+	if err := policy.check(request("http://host/file"), via(1, "https://host/file")); !errors.Is(err, ErrRedirectNotAllowed) {
+		t.Errorf("same host downgrade is allowed: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ValidateRewrites checks that all link rewrites are in the form prefix=replacement, where both the link prefix and
+// its replacement are absolute links with scheme and host, e.g. https://cdn.example.com/=http://127.0.0.1:3128/.
+func ValidateRewrites(rewrites []string) error {
+	for _, rewrite := range rewrites {
+		if _, _, err := parseRewrite(rewrite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseRewrite(rewrite string) (string, string, error) {
+	prefix, replacement := rewrite, ""
+	if i := strings.Index(rewrite, "="); i >= 0 {
+		prefix, replacement = rewrite[:i], rewrite[i+1:]
+	}
+	for _, link := range []string{prefix, replacement} {
+		if u, err := url.Parse(link); err != nil || u.Scheme == "" || u.Host == "" {
+			return "", "", fmt.Errorf("invalid download rewrite - %s, must be in the form prefix=replacement with absolute links", rewrite)
+		}
+	}
+	return prefix, replacement, nil
+}
+
+// PrefixRewriter returns the link rewriter, which replaces the prefix of the links with the replacement of the first
+// matching prefix=replacement rewrite, or nil, if no rewrites are given. The rewrites must be valid.
+func PrefixRewriter(rewrites []string) LinkRewriter {
+	if len(rewrites) == 0 {
+		return nil
+	}
+	return func(link string) string {
+		for _, rewrite := range rewrites {
+			if prefix, replacement, err := parseRewrite(rewrite); err == nil && strings.HasPrefix(link, prefix) {
+				return replacement + strings.TrimPrefix(link, prefix)
+			}
+		}
+		return link
+	}
+}
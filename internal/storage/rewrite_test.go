@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import "testing"
+
+// TestValidateRewrites tests the valid and invalid download link rewrites.
+func TestValidateRewrites(t *testing.T) {
+	for rewrite, valid := range map[string]bool{
+		"https://cdn.example.com/=http://127.0.0.1:3128/":       true,
+		"https://cdn.example.com/images=http://proxy.local/cdn": true,
+		"https://cdn.example.com/":                              false,
+		"cdn.example.com=http://127.0.0.1:3128":                 false,
+		"https://cdn.example.com=/cache":                        false,
+		"=http://127.0.0.1:3128":                                false,
+	} {
+		if err := ValidateRewrites([]string{rewrite}); (err == nil) != valid {
+			t.Errorf("unexpected validation result of rewrite %s: %v", rewrite, err)
+		}
+	}
+}
+
+// TestPrefixRewriter tests that the links are rewritten by their first matching prefix only.
+func TestPrefixRewriter(t *testing.T) {
+	if PrefixRewriter(nil) != nil {
+		t.Fatal("expected no rewriter without rewrites")
+	}
+	rewriter := PrefixRewriter([]string{
+		"https://cdn.example.com/images/=http://127.0.0.1:3128/images/",
+		"https://cdn.example.com/=http://127.0.0.1:3128/cdn/",
+	})
+	for link, expected := range map[string]string{
+		"https://cdn.example.com/images/app.bin": "http://127.0.0.1:3128/images/app.bin",
+		"https://cdn.example.com/app.bin?v=1":    "http://127.0.0.1:3128/cdn/app.bin?v=1",
+		"https://other.example.com/app.bin":      "https://other.example.com/app.bin",
+	} {
+		if rewritten := rewriter(link); rewritten != expected {
+			t.Errorf("unexpected rewritten link of %s: %s != %s", link, rewritten, expected)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const (
+	schemeSFTP = "sftp"
+	// sftpDefaultPort is the SFTP server port, if not given by the link.
+	sftpDefaultPort = "22"
+	// sftpDialTimeout is the maximal time to establish the SSH connection to the SFTP server.
+	sftpDialTimeout = 30 * time.Second
+)
+
+// SFTPConfig defines the connection to the SFTP artifact servers. The server host keys are always verified.
+type SFTPConfig struct {
+	// KnownHosts is an OpenSSH known_hosts file with the trusted server host keys. SFTP downloads fail, if not set.
+	KnownHosts string
+	// Username is used to authenticate to the server, if the link does not provide one.
+	Username string
+	// Password authenticates to the server, if set.
+	Password string
+	// Key is a PEM encoded private key file, authenticating to the server, if set.
+	Key string
+}
+
+// Check verifies that the known hosts file and the private key file, if set, can be loaded.
+func (config SFTPConfig) Check() error {
+	_, err := sshClientConfig(&url.URL{}, config)
+	return err
+}
+
+// sftpFile is an opened file on an SFTP server, which closes its client and SSH connection on close.
+type sftpFile struct {
+	*sftp.File
+	client *sftp.Client
+	conn   *ssh.Client
+}
+
+// Close closes the file, the SFTP client and the SSH connection.
+func (f *sftpFile) Close() error {
+	err := f.File.Close()
+	f.client.Close()
+	f.conn.Close()
+	return err
+}
+
+// isSFTP reports whether the artifact link is on an SFTP server.
+func isSFTP(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && strings.EqualFold(u.Scheme, schemeSFTP)
+}
+
+// getSFTPInput opens the artifact file on the SFTP server at the given offset. The resume is always supported.
+func getSFTPInput(link string, offset int64, config SFTPConfig) (io.ReadCloser, bool, error) {
+	u, err := url.Parse(link)
+	if err != nil || u.Hostname() == "" || u.Path == "" {
+		return nil, false, fmt.Errorf("invalid SFTP link - %s", RedactLink(link))
+	}
+	clientConfig, err := sshClientConfig(u, config)
+	if err != nil {
+		return nil, false, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = sftpDefaultPort
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(u.Hostname(), port), clientConfig)
+	if err != nil {
+		return nil, false, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("error starting SFTP session with %s: %w", u.Host, err)
+	}
+	file, err := client.Open(u.Path)
+	if err == nil && offset > 0 {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		client.Close()
+		conn.Close()
+		return nil, false, fmt.Errorf("error opening SFTP file - %s: %w", RedactLink(link), err)
+	}
+	logger.Infof("opened SFTP artifact - %s", RedactLink(link))
+	return &sftpFile{File: file, client: client, conn: conn}, true, nil
+}
+
+// sshClientConfig returns the SSH client configuration with the password and the private key authentication,
+// and the host key verification against the known hosts file.
+func sshClientConfig(u *url.URL, config SFTPConfig) (*ssh.ClientConfig, error) {
+	if config.KnownHosts == "" {
+		return nil, fmt.Errorf("%w: no SFTP known hosts file is configured", ErrHostKeyRejected)
+	}
+	callback, err := knownhosts.New(config.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SFTP known hosts file - \"%s\": %v", config.KnownHosts, err)
+	}
+	var auth []ssh.AuthMethod
+	if config.Key != "" {
+		key, err := os.ReadFile(config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SFTP private key file - \"%s\": %v", config.Key, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing SFTP private key file - \"%s\": %v", config.Key, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	username := u.User.Username()
+	if username == "" {
+		username = config.Username
+	}
+	return &ssh.ClientConfig{
+		User: username,
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := callback(hostname, remote, key); err != nil {
+				logger.Errorf("host key of SFTP server %s is rejected: %v", hostname, err)
+				return fmt.Errorf("%w: %v", ErrHostKeyRejected, err)
+			}
+			return nil
+		},
+		Timeout: sftpDialTimeout,
+	}, nil
+}
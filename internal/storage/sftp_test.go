@@ -0,0 +1,245 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testSFTPUser     = "user"
+	testSFTPPassword = "secret"
+)
+
+// testSFTPServer is an embedded read-only SFTP server, authenticating a user with password or public key.
+type testSFTPServer struct {
+	addr        string
+	hostKey     ssh.Signer
+	connections int32
+}
+
+// newTestSFTPServer starts an embedded SFTP server, stopped on the test cleanup.
+func newTestSFTPServer(t *testing.T, userKey ssh.PublicKey) *testSFTPServer {
+	srv := &testSFTPServer{hostKey: newTestSigner(t)}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == testSFTPUser && string(password) == testSFTPPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == testSFTPUser && userKey != nil && bytes.Equal(key.Marshal(), userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(srv.hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to start SFTP server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	srv.addr = listener.Addr().String()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&srv.connections, 1)
+			go serveSFTP(conn, config)
+		}
+	}()
+	return srv
+}
+
+// serveSFTP serves the SFTP subsystem sessions of the SSH connection.
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(channelRequests)
+		go func() {
+			defer channel.Close()
+			server, err := sftp.NewServer(channel, sftp.ReadOnly())
+			if err != nil {
+				return
+			}
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// knownHosts writes a known hosts file, trusting the given host key for the server address.
+func (srv *testSFTPServer) knownHosts(t *testing.T, key ssh.PublicKey) string {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, key)+"\n"), 0600); err != nil {
+		t.Fatalf("fail to write known hosts file: %v", err)
+	}
+	return file
+}
+
+// link returns the SFTP link of the given local file.
+func (srv *testSFTPServer) link(file string) string {
+	return "sftp://" + testSFTPUser + "@" + srv.addr + filepath.ToSlash(file)
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("fail to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("fail to create signer: %v", err)
+	}
+	return signer
+}
+
+// newTestSFTPArtifact writes the artifact content to a local file, served by the SFTP server.
+func newTestSFTPArtifact(t *testing.T, srv *testSFTPServer, content []byte) *Artifact {
+	file := filepath.Join(t.TempDir(), "image.bin")
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatalf("fail to write artifact: %v", err)
+	}
+	hash := sha256.Sum256(content)
+	return &Artifact{FileName: "image.bin", Size: len(content), Link: srv.link(file),
+		HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])}
+}
+
+// TestDownloadSFTP tests the download of module artifacts from an SFTP server with password and public key
+// authentication, and the resume of partial downloads.
+func TestDownloadSFTP(t *testing.T) {
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("fail to generate user key: %v", err)
+	}
+	userSigner, _ := ssh.NewSignerFromKey(userKey)
+	srv := newTestSFTPServer(t, userSigner.PublicKey())
+	content := bytes.Repeat([]byte("sftp artifact;"), 10000)
+	artifact := newTestSFTPArtifact(t, srv, content)
+	knownHosts := srv.knownHosts(t, srv.hostKey.PublicKey())
+
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	// 1. Download with password authentication.
+	server := ServerConfig{SFTP: SFTPConfig{KnownHosts: knownHosts, Password: testSFTPPassword}}
+	module := &Module{Name: "name", Version: "1", Artifacts: []*Artifact{artifact}}
+	if err := store.DownloadModule(filepath.Join(dir, "1"), module, nil, server, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download SFTP artifact with password: %v", err)
+	}
+	check(filepath.Join(dir, "1", "image.bin"), len(content), t)
+
+	// 2. Download with public key authentication.
+	block, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatalf("fail to marshal user key: %v", err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("fail to write user key: %v", err)
+	}
+	server = ServerConfig{SFTP: SFTPConfig{KnownHosts: knownHosts, Key: keyFile}}
+	if err := store.DownloadModule(filepath.Join(dir, "2"), module, nil, server, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download SFTP artifact with public key: %v", err)
+	}
+	check(filepath.Join(dir, "2", "image.bin"), len(content), t)
+
+	// 3. Resume from offset.
+	input, resumeSupported, err := getSFTPInput(artifact.Link, 1000, server.SFTP)
+	if err != nil {
+		t.Fatalf("fail to open SFTP artifact at offset: %v", err)
+	}
+	defer input.Close()
+	data, err := io.ReadAll(input)
+	if err != nil || !resumeSupported || !bytes.Equal(data, content[1000:]) {
+		t.Fatalf("unexpected resumed SFTP artifact data: %d bytes, resume supported %v, error %v", len(data), resumeSupported, err)
+	}
+}
+
+// TestDownloadSFTPHostKeyRejected tests that the SFTP downloads fail without retries, if the server host key
+// does not match the known hosts or no known hosts are configured.
+func TestDownloadSFTPHostKeyRejected(t *testing.T) {
+	srv := newTestSFTPServer(t, nil)
+	artifact := newTestSFTPArtifact(t, srv, []byte("sftp artifact"))
+
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	module := &Module{Name: "name", Version: "1", Artifacts: []*Artifact{artifact}}
+
+	// 1. Host key mismatch.
+	server := ServerConfig{SFTP: SFTPConfig{KnownHosts: srv.knownHosts(t, newTestSigner(t).PublicKey()), Password: testSFTPPassword}}
+	err = store.DownloadModule(filepath.Join(dir, "1"), module, nil, server, 3, 0, nil, nil)
+	if !errors.Is(err, ErrHostKeyRejected) {
+		t.Fatalf("expected rejected host key error: %v", err)
+	}
+	if connections := atomic.LoadInt32(&srv.connections); connections != 1 {
+		t.Fatalf("rejected host key is retried: %d connections", connections)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1", "image.bin")); !os.IsNotExist(err) {
+		t.Fatalf("artifact is downloaded from server with rejected host key: %v", err)
+	}
+
+	// 2. No known hosts.
+	server.SFTP.KnownHosts = ""
+	if err := store.DownloadModule(filepath.Join(dir, "2"), module, nil, server, 0, 0, nil, nil); !errors.Is(err, ErrHostKeyRejected) {
+		t.Fatalf("expected rejected host key error without known hosts: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// DownloadSize returns the expected remaining download size in bytes of the operation, which modules are
+// downloaded to the numbered module directories of the given operation directory. The artifacts, already
+// available and valid in the module directory or in the archived module, are not counted and the resumable
+// partial downloads are counted only with their remaining bytes. The read-only local artifacts are not
+// downloaded at all.
+func (st *Storage) DownloadSize(dir string, updatable *Updatable, server ServerConfig) int64 {
+	var size int64
+	for i, module := range updatable.Modules {
+		size += st.moduleDownloadSize(filepath.Join(dir, strconv.Itoa(i)), module, server)
+	}
+	return size
+}
+
+// moduleDownloadSize returns the expected remaining download size in bytes of the module artifacts.
+func (st *Storage) moduleDownloadSize(toDir string, module *Module, server ServerConfig) int64 {
+	dirs := []string{toDir}
+	if !server.Force {
+		if archived := searchArchived(st.fs, st.ModulesPath, module); archived != "" {
+			dirs = append(dirs, archived)
+		}
+	}
+	var size int64
+	for _, sa := range module.Artifacts {
+		if sa.Local && !sa.Copy {
+			continue
+		}
+		remaining := int64(sa.Size)
+		for _, dir := range dirs {
+			if available := st.availableSize(filepath.Join(dir, sa.FileName), sa, server); available > 0 {
+				remaining -= available
+				break
+			}
+		}
+		if remaining > 0 {
+			size += remaining
+		}
+	}
+	logger.Debugf("remaining download size of module [%s:%s] is %d bytes", module.Name, module.Version, size)
+	return size
+}
+
+// availableSize returns the size in bytes of the artifact, which is not downloaded again: the whole artifact
+// size, if the artifact file is valid, or the size of the resumable partial download.
+func (st *Storage) availableSize(to string, artifact *Artifact, server ServerConfig) int64 {
+	if server.Force {
+		return 0
+	}
+	tmp := filepath.Join(filepath.Dir(to), prefix+filepath.Base(to))
+	if server.InPlace {
+		tmp = to
+	}
+	if _, err := st.fs.Stat(to); !os.IsNotExist(err) && !(server.InPlace && hasPartialInfo(st.fs, to)) {
+		if validate(st.fs, to, trust(artifact, server), server.Verify) == nil {
+			return int64(artifact.Size)
+		}
+		if server.InPlace {
+			return 0 // The invalid file is removed, before downloaded again.
+		}
+	}
+	stat, err := st.fs.Stat(tmp)
+	if err != nil {
+		return 0
+	}
+	info := readPartialInfo(st.fs, tmp)
+	if reason, _ := checkPartial(info, stat.Size(), artifact); reason != "" || server.NoResume || info != nil && info.NoResume {
+		return 0
+	}
+	return stat.Size()
+}
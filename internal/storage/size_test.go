@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownloadSize tests the remaining download size of an operation with cached, partial and missing artifacts.
+func TestDownloadSize(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	newArtifact := func(name string, content string) *Artifact {
+		sum := sha256.Sum256([]byte(content))
+		return &Artifact{FileName: name, Size: len(content), Link: "http://localhost/" + name,
+			HashType: "SHA256", HashValue: hex.EncodeToString(sum[:])}
+	}
+	write := func(name string, content string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	content := "0123456789"
+	cached, corrupted, partial, missing := newArtifact("cached.bin", content), newArtifact("corrupted.bin", content),
+		newArtifact("partial.bin", content), newArtifact("missing.bin", content)
+	oversized := newArtifact("oversized.bin", content)
+	local := newArtifact("local.bin", content)
+	local.Local = true
+	archivedArtifact := newArtifact("archived.bin", content)
+
+	dir := filepath.Join(store.DownloadPath, "0")
+	updatable := &Updatable{Modules: []*Module{
+		{Name: "first", Version: "1", Artifacts: []*Artifact{cached, corrupted, partial, missing, oversized, local}},
+		{Name: "second", Version: "1", Artifacts: []*Artifact{archivedArtifact}},
+	}}
+	write(filepath.Join(dir, "0", cached.FileName), content)
+	write(filepath.Join(dir, "0", corrupted.FileName), "9876543210")
+	write(filepath.Join(dir, "0", prefix+partial.FileName), content[:4])
+	write(filepath.Join(dir, "0", prefix+oversized.FileName), content+content)
+	archived := filepath.Join(store.ModulesPath, "0")
+	write(filepath.Join(archived, archivedArtifact.FileName), content)
+	write(filepath.Join(archived, InternalStatusName), "second:1")
+
+	tests := map[string]struct {
+		server   ServerConfig
+		expected int64
+	}{
+		// corrupted, remaining 6 bytes of partial, missing and oversized partial.
+		"resume": {expected: 10 + 6 + 10 + 10},
+		"force":  {server: ServerConfig{Force: true}, expected: 6 * 10},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if size := store.DownloadSize(dir, updatable, test.server); size != test.expected {
+				t.Fatalf("expected remaining download size %d, got: %d", test.expected, size)
+			}
+		})
+	}
+}
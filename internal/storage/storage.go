@@ -17,13 +17,14 @@ import (
 	"crypto/cipher"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math"
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
@@ -36,12 +37,80 @@ const ProtocolFile = "FILE"
 var (
 	// ErrCancel represents cancel operation error.
 	ErrCancel = errors.New("cancel operation")
+	// ErrCanceled represents operation canceled on request error.
+	ErrCanceled = errors.New("operation canceled")
 	// ErrFileSizeExceeded represents file size exceeded error.
 	ErrFileSizeExceeded = errors.New("file size exceeded")
+	// ErrFileSizeMismatch represents file size not matching the expected size or size range error.
+	ErrFileSizeMismatch = errors.New("file size does not match")
+	// ErrChecksumMismatch represents checksum mismatch error.
+	ErrChecksumMismatch = errors.New("checksum does not match")
+	// ErrBadStatus represents unsuccessful HTTP response status error.
+	ErrBadStatus = errors.New("http status code is not in the 2xx range")
+	// ErrETagMismatch represents HTTP response entity tag not matching the expected one error.
+	ErrETagMismatch = errors.New("entity tag does not match")
+	// ErrArtifactInvalid represents artifact rejected by the format or structure verification error.
+	ErrArtifactInvalid = errors.New("artifact format is not valid")
+	// ErrHostKeyRejected represents SFTP server host key, not matching the known hosts error.
+	ErrHostKeyRejected = errors.New("server host key is rejected")
+	// ErrLinkNotAllowed represents artifact link, not allowed by the download allow list error.
+	ErrLinkNotAllowed = errors.New("artifact link is not allowed")
+	// ErrRedirectNotAllowed represents redirect of the download request, not allowed by the redirect policy error.
+	ErrRedirectNotAllowed = errors.New("redirect is not allowed")
+	// ErrAborted represents operation aborted by the continue policy error.
+	ErrAborted = errors.New("operation aborted by the continue policy")
+	// ErrCircuitOpen represents download request, short-circuited by the circuit breaker of its host error.
+	ErrCircuitOpen = errors.New("artifact server host is short-circuited")
+	// ErrDuplicateFileName represents module artifacts with the same file name, rejected by the duplicates policy error.
+	ErrDuplicateFileName = errors.New("duplicate artifact file name")
+	// ErrTooManyRestarts represents artifact download, restarted from the beginning more times than allowed error.
+	ErrTooManyRestarts = errors.New("too many restarts of the artifact download")
+	// ErrStoredSizeMismatch represents final artifact file, reported by the file system with a size not matching
+	// the downloaded artifact size error.
+	ErrStoredSizeMismatch = errors.New("stored file size does not match")
 )
 
-// Progress represents a callback handler that is called on written file chunk.
-type Progress func(percent int)
+// ArtifactError represents a failed module artifact.
+type ArtifactError struct {
+	FileName string
+	Err      error
+}
+
+// ArtifactsError represents all failed artifacts of a module, reported at once after all module artifacts
+// are processed.
+type ArtifactsError struct {
+	Failed []*ArtifactError
+	Total  int
+}
+
+func (e *ArtifactsError) Error() string {
+	causes := make([]string, len(e.Failed))
+	for i, failed := range e.Failed {
+		causes[i] = fmt.Sprintf("%s - %v", failed.FileName, failed.Err)
+	}
+	return fmt.Sprintf("%d of %d artifacts failed: %s", len(e.Failed), e.Total, strings.Join(causes, "; "))
+}
+
+// Unwrap returns the cause of the first failed artifact.
+func (e *ArtifactsError) Unwrap() error {
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e.Failed[0].Err
+}
+
+// FileNames returns the file names of the failed artifacts.
+func (e *ArtifactsError) FileNames() []string {
+	names := make([]string, len(e.Failed))
+	for i, failed := range e.Failed {
+		names[i] = failed.FileName
+	}
+	return names
+}
+
+// Progress represents a callback handler that is called on written file chunk with the module download
+// percentage and the written and total bytes of the module.
+type Progress func(percent int, written int64, total int64)
 type progressBytes func(bytes int64)
 
 // Validation represents a callback handler, that validates a module's artifacts, called prior to download.
@@ -49,9 +118,10 @@ type Validation func() error
 
 // A Updatable represents a simplified SoftwareUpdateAction.
 type Updatable struct {
-	Operation     string    `json:"operation"`
-	CorrelationID string    `json:"correlationId"`
-	Modules       []*Module `json:"softwareModules,omitempty"`
+	Operation     string            `json:"operation"`
+	CorrelationID string            `json:"correlationId"`
+	Modules       []*Module         `json:"softwareModules,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
 }
 
 // Module represents a SoftwareModuleAction.
@@ -71,16 +141,69 @@ type Module struct {
 //  5. First downloadable download#links#md5url
 //
 // Links are simplified to simple list with download URIs without any links for MD5 hashes.
+// The hashValue is hex or base64 encoded, as given by hashEncoding or detected by its length, if not set.
+// The other provided checksums are kept as additional hashes and all of them are verified.
+// The checksums of the gzip decompressed artifact content, if provided, are verified as well, while the artifact
+// itself is stored compressed.
 type Artifact struct {
-	FileName  string `json:"fileName"`
-	Size      int    `json:"size"`
-	HashType  string `json:"hashType"`
-	HashValue string `json:"hashValue"`
-	Link      string `json:"link"`
-	Local     bool   `json:"local"`
-	Copy      bool   `json:"copy"`
+	FileName     string  `json:"fileName"`
+	Size         int     `json:"size"`
+	MinSize      int     `json:"minSize,omitempty"`
+	MaxSize      int     `json:"maxSize,omitempty"`
+	ETag         string  `json:"etag,omitempty"`
+	HashType     string  `json:"hashType"`
+	HashValue    string  `json:"hashValue"`
+	Hashes       []*Hash `json:"hashes,omitempty"`
+	Decompressed []*Hash `json:"decompressed,omitempty"`
+	HashEncoding string  `json:"hashEncoding,omitempty"`
+	Blocks       *Blocks `json:"blocks,omitempty"`
+	Priority     int     `json:"priority,omitempty"`
+	Link         string  `json:"link"`
+	Local        bool    `json:"local"`
+	Copy         bool    `json:"copy"`
+
+	// signed is set for the module manifest and its signature, verified with the manifest key instead of a checksum.
+	signed bool
+	// trusted is set for the local artifacts of trusted file systems, verified by their size only.
+	trusted bool
+}
+
+// Hash is an additional artifact hash, verified together with the artifact hashType and hashValue.
+type Hash struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Blocks are the hashes of the consecutive fixed-size blocks of the artifact. The blocks are verified as they
+// are downloaded and a corrupted block is downloaded again, without restarting the whole download.
+type Blocks struct {
+	// Size of the blocks in bytes. The last block can be shorter.
+	Size int `json:"size"`
+	// HashType of the block hashes.
+	HashType string `json:"hashType"`
+	// Hashes of the blocks in the artifact order, encoded as the artifact hash values.
+	Hashes []string `json:"hashes"`
 }
 
+// Encodings of the artifact hash values.
+const (
+	// HashEncodingHex is the hex encoding of the artifact hash values.
+	HashEncodingHex = "hex"
+	// HashEncodingBase64 is the standard base64 encoding of the artifact hash values, with or without padding.
+	HashEncodingBase64 = "base64"
+)
+
+// Cleanup policies of the successfully installed modules.
+const (
+	// CleanupKeep keeps the installed module artifacts, e.g. for a rollback or a reinstallation.
+	CleanupKeep = "keep"
+	// CleanupDeleteArtifacts deletes the installed module artifacts.
+	CleanupDeleteArtifacts = "delete-artifacts"
+	// CleanupDeleteOnNextSuccess keeps the installed module artifacts until another version of the module
+	// is successfully installed.
+	CleanupDeleteOnNextSuccess = "delete-on-next-success"
+)
+
 // A Storage for Script-Based SoftwareUpdatable.
 type Storage struct {
 	// DownloadPath represents the download directory location.
@@ -89,6 +212,17 @@ type Storage struct {
 	InstalledDepsPath string
 	// ModulesPath represents the downloaded modules directory location.
 	ModulesPath string
+	// BlobsPath represents the blob store directory location of the blob layout.
+	BlobsPath string
+	// Layout is the storage layout of the downloaded module artifacts, either LayoutModule or LayoutBlobs.
+	// The artifacts are stored in their module directories, if not set.
+	Layout string
+	// fs is the storage backend of the downloaded artifacts.
+	fs FileSystem
+	// blobLock serializes the blob store updates with its pruning.
+	blobLock sync.Mutex
+	// locationLock serializes the creation of the operation locations, so that each one is created exclusively.
+	locationLock sync.Mutex
 	// done is used to stop ongoing downloads.
 	done chan struct{}
 }
@@ -96,29 +230,43 @@ type Storage struct {
 const (
 	// InternalStatusName represents the name of the internal status file.
 	InternalStatusName = "internal-status"
+	// InstalledStatusName represents the name of the file, marking the archived module as installed. It contains
+	// the module name and version on separate lines.
+	InstalledStatusName = "installed-status"
 	// SoftwareUpdatableName represents the name of the software updatable file.
 	SoftwareUpdatableName = "updatable.json"
+	// OperationDeadlineName represents the name of the file next to the software updatable file, keeping the
+	// overall deadline and timeout of the started operation.
+	OperationDeadlineName = "operation-deadline"
 )
 
 // NewStorage for Script-Based SoftwareUpdatable is created.
 func NewStorage(location string) (*Storage, error) {
+	return NewStorageWithFileSystem(location, OSFileSystem{Root: location})
+}
+
+// NewStorageWithFileSystem for Script-Based SoftwareUpdatable is created, downloading the artifacts
+// to the given storage backend.
+func NewStorageWithFileSystem(location string, fileSystem FileSystem) (*Storage, error) {
 	logger.Infof("New Storage with location: %s", location)
 	this := &Storage{
 		DownloadPath:      filepath.Join(location, "download"),
 		InstalledDepsPath: filepath.Join(location, "installed-deps"),
 		ModulesPath:       filepath.Join(location, "modules"),
+		BlobsPath:         filepath.Join(location, "blobs"),
+		fs:                fileSystem,
 		done:              make(chan struct{}),
 	}
 	// Create Download directory.
-	if err := os.MkdirAll(this.DownloadPath, 0755); err != nil {
+	if err := fileSystem.MkdirAll(this.DownloadPath); err != nil {
 		return nil, fmt.Errorf("failed to create download directory: %v", err)
 	}
 	// Create Installed Dependencies directory.
-	if err := os.MkdirAll(this.InstalledDepsPath, 0755); err != nil {
+	if err := fileSystem.MkdirAll(this.InstalledDepsPath); err != nil {
 		return nil, fmt.Errorf("failed to create installed dependencies directory: %v", err)
 	}
 	// Create Modules directory.
-	if err := os.MkdirAll(this.ModulesPath, 0755); err != nil {
+	if err := fileSystem.MkdirAll(this.ModulesPath); err != nil {
 		return nil, fmt.Errorf("failed to create modules directory: %v", err)
 	}
 	return this, nil
@@ -129,6 +277,35 @@ func (st *Storage) Close() {
 	close(st.done)
 }
 
+// Done returns a channel, which is closed when the storage is closed.
+func (st *Storage) Done() <-chan struct{} {
+	return st.done
+}
+
+// Usage returns the size in bytes of the downloaded modules and installed dependencies in the storage.
+func (st *Storage) Usage() (int64, error) {
+	var size int64
+	for _, dir := range []string{st.DownloadPath, st.InstalledDepsPath, st.ModulesPath} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				var info fs.FileInfo
+				if info, err = d.Info(); err == nil {
+					size += info.Size()
+				}
+			}
+			// Ignore the files, removed during the walk by the running operations.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
 // LoadInstalledDeps from file system.
 func (st *Storage) LoadInstalledDeps() ([]*hawkbit.DependencyDescription, error) {
 	logger.Debug("Load installed dependencies")
@@ -163,29 +340,31 @@ func (st *Storage) LoadInstalledDeps() ([]*hawkbit.DependencyDescription, error)
 func (st *Storage) LoadSoftwareUpdatables() map[string]*Updatable {
 	logger.Debugf("Search for unfinished operations in %s", st.DownloadPath)
 	// Get all directories in Download direcotry.
-	paths, err := os.ReadDir(st.DownloadPath)
+	paths, err := st.fs.ReadDir(st.DownloadPath)
 	if err != nil {
 		logger.Errorf("failed to load operations: %v", err)
 		return map[string]*Updatable{}
 	}
 
-	// Convert ditectory names in Download directory to indexes and sort them.
-	ids := make([]int, 0, len(paths))
+	// Sort the operation directories in Download directory by their indexes.
+	names := make([]string, 0, len(paths))
+	indexes := map[string]int{}
 	for _, path := range paths {
-		if i, err := strconv.Atoi(path.Name()); err == nil {
-			ids = append(ids, i)
+		if i, ok := locationIndex(path.Name()); ok {
+			names = append(names, path.Name())
+			indexes[path.Name()] = i
 		}
 	}
-	sort.Ints(ids)
+	sort.Slice(names, func(i, j int) bool { return indexes[names[i]] < indexes[names[j]] })
 
 	updatables := map[string]*Updatable{}
-	for _, name := range ids {
-		dir := filepath.Join(st.DownloadPath, strconv.Itoa(name))
+	for _, name := range names {
+		dir := filepath.Join(st.DownloadPath, name)
 		to := filepath.Join(dir, SoftwareUpdatableName)
-		if _, err := os.Stat(to); os.IsNotExist(err) {
+		if _, err := st.fs.Stat(to); os.IsNotExist(err) {
 			continue
 		}
-		updatable, err := loadSoftwareUpdatable(to)
+		updatable, err := loadSoftwareUpdatable(st.fs, to)
 		if err != nil || updatable == nil {
 			logger.Debugf("fail to load [%s]: %v", to, err)
 			continue
@@ -203,119 +382,351 @@ func (st *Storage) MoveInstalledDeps(dir string, metadata map[string]string) err
 	}
 	idp = filepath.Join(dir, idp)
 
-	if _, err := os.Stat(idp); os.IsNotExist(err) {
+	if _, err := st.fs.Stat(idp); os.IsNotExist(err) {
 		logger.Infof("No predefined installed dependencies.")
 		return nil
 	}
 	logger.Infof("Move installed dependencies [%s] to [%s]", idp, st.InstalledDepsPath)
-	return move(idp, st.InstalledDepsPath)
+	return move(st.fs, idp, st.InstalledDepsPath)
 }
 
 // ArchiveModule to modules directory.
 func (st *Storage) ArchiveModule(dir string) error {
 	logger.Debugf("Archive module from directory: %s", dir)
-	path, err := FindAvailableLocation(st.ModulesPath)
+	path, err := st.CreateOperationLocation(st.ModulesPath, "")
+	if err != nil {
+		return err
+	}
+	return move(st.fs, dir, path)
+}
+
+// CleanupInstalledModule applies the cleanup policy to the successfully installed module in the given directory.
+// Kept modules are archived to the modules directory. The previously installed versions of the module are
+// deleted only on successful installation, so that they remain available to a rollback otherwise.
+func (st *Storage) CleanupInstalledModule(dir string, module *Module, policy string) error {
+	switch policy {
+	case CleanupKeep:
+	case CleanupDeleteOnNextSuccess:
+		st.removeInstalledModules(module)
+	default: // The module directory is removed along with the operation directory.
+		return nil
+	}
+	logger.Debugf("Keep installed module [%s:%s] according to cleanup policy %s", module.Name, module.Version, policy)
+	if err := st.writeFile(filepath.Join(dir, InstalledStatusName), []byte(module.Name+"\n"+module.Version)); err != nil {
+		return err
+	}
+	return st.ArchiveModule(dir)
+}
+
+// removeInstalledModules removes the archived modules with the same name, which are already installed.
+// Downloaded modules, which are not installed yet, are not removed.
+func (st *Storage) removeInstalledModules(module *Module) {
+	paths, err := st.fs.ReadDir(st.ModulesPath)
+	if err != nil {
+		logger.Warnf("failed to get archived modules names: %v", err)
+		return
+	}
+	for _, path := range paths {
+		dir := filepath.Join(st.ModulesPath, path.Name())
+		if !isInstalled(st.fs, dir, module) {
+			continue
+		}
+		logger.Infof("Remove previously installed module [%s] from directory: %s", module.Name, dir)
+		if err := st.fs.RemoveAll(dir); err != nil {
+			logger.Errorf("failed to remove installed module directory [%s]: %v", dir, err)
+		}
+	}
+	st.PruneBlobs()
+}
+
+// isInstalled returns true, if the archived module in the given directory is an installed version of the module
+// with the same name.
+func isInstalled(fs FileSystem, dir string, module *Module) bool {
+	name, err := readLn(fs, filepath.Join(dir, InstalledStatusName))
+	return err == nil && name == module.Name
+}
+
+// DownloadData downloads the artifact into memory instead of the local storage and returns its validated data.
+// The download fails with ErrFileSizeExceeded, if the artifact is bigger than limit bytes. Closing the cancel
+// channel stops the download with ErrCanceled.
+func (st *Storage) DownloadData(artifact *Artifact, limit int64, server ServerConfig,
+	retryCount int, retryInterval time.Duration, cancel chan struct{}) (data []byte, err error) {
+	logger.Tracef("Artifact: %v", artifact)
+	if err := checkLinks(&Module{Artifacts: []*Artifact{artifact}}, server); err != nil {
+		return nil, err
+	}
+	stop, finished := st.stopOn(cancel)
+	defer close(finished)
+	if data, err = downloadData(artifact, limit, server, retryCount, retryInterval, stop); err == ErrCancel && isClosed(cancel) {
+		err = ErrCanceled
+	}
+	return data, err
+}
+
+// decryption returns the post-processing of the downloaded artifacts, which decrypts them with the AES256 key
+// and initialization vector from the module metadata. Only CBC encryption is supported.
+func (st *Storage) decryption(metadata map[string]string) (postProcess, error) {
+	iv := metadata["AES256.iv"]
+	if iv == "" {
+		return nil, errors.New("AES256 key is provided, but initialization vector is missing. Only CBC encryption is supported")
+	}
+	format := metadata["AES256.format"]
+	encKeyDecoded, err := decodeString(format, metadata["AES256.key"])
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the provided key: %s", err)
+	}
+	ivDecoded, err := decodeString(format, iv)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the initialization vector (IV): %s", err)
+	}
+	block, err := aes.NewCipher([]byte(encKeyDecoded))
+	if err != nil {
+		return nil, err
+	}
+	return func(fileName string) error {
+		data, ppError := st.readFile(fileName)
+		if ppError != nil {
+			return ppError
+		}
+		cipherTextDecoded, ppError := decodeString(format, strings.TrimSpace(string(data)))
+		if ppError != nil {
+			return fmt.Errorf("unable to decode artifact: %s", ppError)
+		}
+		cipher.NewCBCDecrypter(block, []byte(ivDecoded)).CryptBlocks([]byte(cipherTextDecoded), []byte(cipherTextDecoded))
+		return st.writeFile(fileName, cipherTextDecoded)
+	}, nil
+}
+
+// readFile reads the named file from the storage backend.
+func (st *Storage) readFile(name string) ([]byte, error) {
+	file, err := st.fs.Open(name)
 	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// writeFile writes the data to the named file of the storage backend.
+func (st *Storage) writeFile(name string, data []byte) error {
+	file, err := st.fs.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
 		return err
 	}
-	if err := os.MkdirAll(path, 0755); err != nil {
+	return file.Close()
+}
+
+// stopOn returns a channel, which is closed on storage close or on operation cancel, until finished is closed.
+func (st *Storage) stopOn(cancel chan struct{}) (stop chan struct{}, finished chan struct{}) {
+	stop = make(chan struct{})
+	finished = make(chan struct{})
+	go func() {
+		select {
+		case <-st.done:
+		case <-cancel:
+		case <-finished:
+		}
+		close(stop)
+	}()
+	return stop, finished
+}
+
+// moduleSize returns the size in bytes of the module artifacts to be downloaded.
+func moduleSize(module *Module) int64 {
+	var size int64
+	for _, sa := range module.Artifacts {
+		if !sa.Local || sa.Copy {
+			size += int64(sa.Size)
+		}
+	}
+	return size
+}
+
+// moduleProgress returns the callback of the written artifact bytes, reporting the module download progress.
+// The extra size is the size of the other module files, e.g. its directory tree files.
+func moduleProgress(module *Module, progress Progress, extraSize int64) progressBytes {
+	if progress == nil {
+		return func(bytes int64) { /* This is a wrapper function, do nothing by default. */ }
+	}
+	totalSize := moduleSize(module) + extraSize
+	logger.Debugf("Total module size: %v", totalSize)
+
+	var totalWritten int64
+	var lProgress int
+	return func(bytes int64) {
+		totalWritten += bytes
+		cProgress := 0
+		if totalSize > 0 {
+			// Round down, so 100 percent is reported only when all bytes are written.
+			cProgress = int(math.Min(math.Floor(float64(totalWritten)/float64(totalSize)*100.0), 100))
+		}
+		if lProgress != cProgress {
+			lProgress = cProgress
+			progress(cProgress, totalWritten, totalSize)
+		}
+	}
+}
+
+// StreamModule streams the only artifact of the module to the writer, e.g. the standard input of the install
+// command, without storing it. The artifact size and checksum are verified on the fly and an error is returned
+// after its last byte, if they do not match, so the writer must not use the data before the stream is finished.
+// Closing the cancel channel stops the stream with ErrCanceled.
+func (st *Storage) StreamModule(module *Module, to io.Writer, progress Progress, server ServerConfig,
+	retryCount int, retryInterval time.Duration, cancel chan struct{}) (err error) {
+	if len(module.Artifacts) != 1 {
+		return fmt.Errorf("streamed modules must have exactly one artifact, but %d are provided", len(module.Artifacts))
+	}
+	if module.Metadata != nil && module.Metadata["AES256.key"] != "" {
+		return errors.New("encrypted artifacts cannot be streamed")
+	}
+	if err := checkLinks(module, server); err != nil {
 		return err
 	}
-	return move(dir, path)
+	logger.Tracef("Stream module: %v", module)
+
+	// Stop the stream on storage close or on operation cancel.
+	stop, finished := st.stopOn(cancel)
+	defer close(finished)
+	defer func() {
+		if err == ErrCancel && isClosed(cancel) {
+			err = ErrCanceled
+		}
+	}()
+	return streamArtifact(to, module.Artifacts[0], moduleProgress(module, progress, 0), server, retryCount, retryInterval, stop)
+}
+
+// VerifyModule verifies the size and the checksum of the module artifacts, downloaded to the directory, e.g. to detect
+// the artifacts modified or truncated externally, while staged for their installation. An ArtifactsError with every
+// missing or modified artifact is returned. The read-only local artifacts and the artifacts of encrypted modules,
+// decrypted after their checksum is validated, are not verified.
+func (st *Storage) VerifyModule(dir string, module *Module, server ServerConfig) error {
+	if module.Metadata != nil && module.Metadata["AES256.key"] != "" {
+		return nil
+	}
+	failed := &ArtifactsError{Total: len(module.Artifacts)}
+	for _, sa := range module.Artifacts {
+		if sa.Local && !sa.Copy {
+			continue
+		}
+		name := filepath.Join(dir, sa.FileName)
+		info, err := st.fs.Stat(name)
+		if err == nil {
+			err = checkSize(info.Size(), sa)
+		}
+		// The artifacts of modules with manifest can have no checksum, verified against the manifest instead.
+		if err == nil && (sa.HashType != "" || len(sa.Hashes) > 0) {
+			err = validate(st.fs, name, trust(sa, server), server.Verify)
+		}
+		if err != nil {
+			failed.Failed = append(failed.Failed, &ArtifactError{FileName: sa.FileName, Err: err})
+		}
+	}
+	if len(failed.Failed) > 0 {
+		return failed
+	}
+	return nil
 }
 
-// DownloadModule artifacts to local storage.
-func (st *Storage) DownloadModule(toDir string, module *Module, progress Progress, serverCert string,
-	retryCount int, retryInterval time.Duration, validation Validation) (err error) {
+// DownloadModule artifacts to local storage. Closing the cancel channel stops the download with ErrCanceled.
+// All module artifacts are downloaded and verified, before an ArtifactsError with every failed artifact is returned,
+// so that no module is installed with only part of its artifacts.
+func (st *Storage) DownloadModule(toDir string, module *Module, progress Progress, server ServerConfig,
+	retryCount int, retryInterval time.Duration, validation Validation, cancel chan struct{}) (err error) {
 	if validation != nil {
 		if err := validation(); err != nil {
 			return err
 		}
 	}
+	if err := checkLinks(module, server); err != nil {
+		return err
+	}
 	logger.Debugf("Download module to directory: [%s]", toDir)
 	logger.Tracef("Module: %v", module)
-	if err = os.MkdirAll(toDir, 0755); err != nil {
+	if err = st.fs.MkdirAll(toDir); err != nil {
 		return err
 	}
-	searchAndMove(st.ModulesPath, toDir, module)
+	if server.Force {
+		removeArchived(st.fs, st.ModulesPath, module)
+	} else {
+		searchAndMove(st.fs, st.ModulesPath, toDir, module)
+	}
 
-	callback := func(bytes int64) { /* This is a wrapper function, do nothing by default. */ }
-	if progress != nil {
-		var totalSize int64
-		for _, sa := range module.Artifacts {
-			if !sa.Local || sa.Copy {
-				totalSize += int64(sa.Size)
-			}
+	// Stop the download on storage close or on operation cancel.
+	stop, finished := st.stopOn(cancel)
+	defer close(finished)
+	defer func() {
+		if err == ErrCancel && isClosed(cancel) {
+			err = ErrCanceled
 		}
-		logger.Debugf("Total module size: %v", totalSize)
-
-		var totalWritten int64
-		var lProgress int
-		callback = func(bytes int64) {
-			totalWritten += bytes
-			cProgress := 0
-			if totalSize > 0 {
-				cProgress = int(math.Round(float64(totalWritten) / float64(totalSize) * 100.0))
-			}
-			if lProgress != cProgress {
-				lProgress = cProgress
-				progress(cProgress)
-			}
+	}()
+
+	var postProcess postProcess
+	if module.Metadata != nil && module.Metadata["AES256.key"] != "" {
+		if postProcess, err = st.decryption(module.Metadata); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil { // the cipher.NewCBCDecrypter panics against all good practices...
+			err = fmt.Errorf("error during decryption %v", r)
+		}
+	}()
+
+	// Sync the directory tree of the module, if any, reporting the progress of its files along with the artifacts.
+	var tree []*Artifact
+	if module.Metadata != nil && module.Metadata[MetadataTree] != "" {
+		if tree, err = st.downloadTree(toDir, module, server, retryCount, retryInterval, stop); err != nil {
+			return err
+		}
+	}
+	callback := moduleProgress(module, progress, treeSize(tree))
+
+	// Verify the other artifacts against the module manifest, if any.
+	artifacts := module.Artifacts
+	if module.Metadata != nil && module.Metadata[MetadataManifest] != "" {
+		if artifacts, err = st.downloadManifest(toDir, module, callback, server, retryCount, retryInterval, postProcess, stop); err != nil {
+			return err
 		}
 	}
 
 	onlyLocalNoCopyArtifacts := true
-	for _, sa := range module.Artifacts {
+	failed := &ArtifactsError{Total: len(module.Artifacts)}
+	for _, sa := range byPriority(artifacts) {
 		if sa.Local && !sa.Copy {
 			logger.Infof("read-only local artifact - [%s]", sa.Link)
 			continue
 		}
 		onlyLocalNoCopyArtifacts = false
-		var postProcess postProcess
-		if module.Metadata != nil && module.Metadata["AES256.key"] != "" {
-			var iv string
-			if iv = module.Metadata["AES256.iv"]; iv == "" {
-				return errors.New("AES256 key is provided, but initialization vector is missing. Only CBC encryption is supported")
-			}
-			postProcess = func(fileName string) error {
-				data, ppError := os.ReadFile(fileName)
-				if ppError != nil {
-					return ppError
-				}
-				format := module.Metadata["AES256.format"]
-				cipherTextDecoded, ppError := decodeString(format, strings.TrimSpace(string(data)))
-				if ppError != nil {
-					return fmt.Errorf("unable to decode artifact: %s", ppError)
-				}
-				encKeyDecoded, ppError := decodeString(format, module.Metadata["AES256.key"])
-				if ppError != nil {
-					return fmt.Errorf("unable to decode the provided key: %s", ppError)
-				}
-				ivDecoded, ppError := decodeString(format, iv)
-				if ppError != nil {
-					return fmt.Errorf("unable to decode the initialization vector (IV): %s", ppError)
-				}
-				block, ppError := aes.NewCipher([]byte(encKeyDecoded))
-				if ppError != nil {
-					return ppError
-				}
-				cipher.NewCBCDecrypter(block, []byte(ivDecoded)).CryptBlocks([]byte(cipherTextDecoded), []byte(cipherTextDecoded))
-				return os.WriteFile(fileName, cipherTextDecoded, 0755)
-
+		to := filepath.Join(toDir, sa.FileName)
+		blob := st.blob(sa, server, postProcess)
+		st.linkBlob(blob, to)
+		if err = downloadArtifact(st.fs, to, sa, callback, server, retryCount, retryInterval, postProcess, stop); err != nil {
+			if err == ErrCancel || err == ErrAborted {
+				return err
 			}
+			logger.Errorf("failed to download artifact [%s]: %v", sa.FileName, err)
+			failed.Failed = append(failed.Failed, &ArtifactError{FileName: sa.FileName, Err: err})
+		} else {
+			st.storeBlob(blob, to)
 		}
-		defer func() {
-			if r := recover(); r != nil { // the cipher.NewCBCDecrypter panics against all good practices...
-				err = fmt.Errorf("error during decryption %v", r)
-			}
-		}()
-		if err = downloadArtifact(filepath.Join(toDir, sa.FileName), sa, callback, serverCert, retryCount, retryInterval, postProcess, st.done); err != nil {
+	}
+	if len(failed.Failed) > 0 {
+		return failed
+	}
+	if tree != nil {
+		if err = st.syncTree(toDir, tree, callback, server, retryCount, retryInterval, stop); err != nil {
 			return err
 		}
+		onlyLocalNoCopyArtifacts = onlyLocalNoCopyArtifacts && len(tree) == 0
 	}
 
-	if progress != nil && onlyLocalNoCopyArtifacts {
-		progress(100)
+	// No bytes are written for the read-only local and the zero-byte artifacts.
+	if progress != nil && (onlyLocalNoCopyArtifacts || moduleSize(module)+treeSize(tree) == 0) {
+		progress(100, 0, 0)
 	}
 	return err
 }
@@ -21,16 +21,21 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -195,7 +200,7 @@ func TestLoadSoftwareUpdatables(t *testing.T) {
 	// Save valid updatable.
 	path := filepath.Join(store.DownloadPath, "0")
 	name := filepath.Join(path, SoftwareUpdatableName)
-	expected, err := SaveSoftwareUpdatable("install", "cid", name, hm(art))
+	expected, err := store.SaveSoftwareUpdatable("install", "cid", name, hm(art), nil, ModuleOptions{})
 	if err != nil {
 		t.Fatalf("fail to save updatable to file: %v", err)
 	}
@@ -242,7 +247,7 @@ func TestDownloadArchiveModule(t *testing.T) {
 	// 1. Download module without progress.
 	path := filepath.Join(store.DownloadPath, "0", "0")
 	m := &Module{Name: "name1", Version: "1", Artifacts: []*Artifact{art}}
-	if err := store.DownloadModule(path, m, nil, "", 0, 0, nil); err != nil {
+	if err := store.DownloadModule(path, m, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
 		t.Fatalf("fail to download module [Hash: %s, File: %s]: %v", art.HashValue, hex.EncodeToString(srv.data), err)
 	}
 	existence(filepath.Join(path, art.FileName), true, "[initial download]", t)
@@ -258,25 +263,25 @@ func TestDownloadArchiveModule(t *testing.T) {
 
 	// 3. Download previous module with progress.
 	path = filepath.Join(store.DownloadPath, "0", "1")
-	progress := func(percent int) { /* Do nothing. */ }
+	progress := func(percent int, written int64, total int64) { /* Do nothing. */ }
 
 	// Download with validation error
 	validationErr := fmt.Errorf("test validation error")
 	validationFail := func() error {
 		return validationErr
 	}
-	if err := store.DownloadModule(path, m, progress, "", 0, 0, validationFail); err != validationErr {
+	if err := store.DownloadModule(path, m, progress, ServerConfig{}, 0, 0, validationFail, nil); err != validationErr {
 		t.Errorf("unexpected validation error")
 	}
 
-	if err := store.DownloadModule(path, m, progress, "", 0, 0, nil); err != nil {
+	if err := store.DownloadModule(path, m, progress, ServerConfig{}, 0, 0, nil, nil); err != nil {
 		t.Errorf("fail to download module: %v", err)
 	}
 	existence(filepath.Join(store.ModulesPath, "0", art.FileName), false, "[archive]", t)
 	existence(filepath.Join(path, art.FileName), true, "[second download]", t)
 
 	// Extract module.
-	if err := ExtractArchive(path); err != nil {
+	if err := ExtractArchive(path, "", ""); err != nil {
 		t.Fatalf("fail to extract module [%s]: %v", path, err)
 	}
 
@@ -292,6 +297,380 @@ func TestDownloadArchiveModule(t *testing.T) {
 	}
 }
 
+// TestDownloadModulePriority tests that the module artifacts are downloaded in the order of their priority.
+func TestDownloadModulePriority(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, strings.TrimPrefix(r.URL.Path, "/"))
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	m := &Module{Name: "priority", Version: "1"}
+	for name, priority := range map[string]int{"image.bin": 0, "manifest.json": 10, "signature.sig": 5, "data.bin": 0} {
+		sum := md5.Sum([]byte("/" + name))
+		m.Artifacts = append(m.Artifacts, &Artifact{FileName: name, Size: len(name) + 1, Link: srv.URL + "/" + name,
+			HashType: "MD5", HashValue: hex.EncodeToString(sum[:]), Priority: priority})
+	}
+	sort.Slice(m.Artifacts, func(i, j int) bool { return m.Artifacts[i].FileName > m.Artifacts[j].FileName })
+
+	server := ServerConfig{Buffers: NewBufferPool(0, 1)}
+	if err := store.DownloadModule(filepath.Join(store.DownloadPath, "0", "0"), m, nil, server, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module: %v", err)
+	}
+	// The artifacts with the same priority are downloaded in their module order.
+	expected := []string{"manifest.json", "signature.sig", "image.bin", "data.bin"}
+	if !reflect.DeepEqual(requested, expected) {
+		t.Fatalf("unexpected download order: %v != %v", requested, expected)
+	}
+}
+
+// TestDownloadModuleForce tests that forced downloads discard the partial, available and archived artifacts.
+func TestDownloadModuleForce(t *testing.T) {
+	body := "fresh artifact content"
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	sum := md5.Sum([]byte(body))
+	art := &Artifact{FileName: "force.txt", Size: len(body), Link: srv.URL + "/force.txt",
+		HashType: "MD5", HashValue: hex.EncodeToString(sum[:])}
+	m := &Module{Name: "force", Version: "1", Artifacts: []*Artifact{art}}
+	path := filepath.Join(store.DownloadPath, "0", "0")
+
+	// 1. Seed an archived module with the same artifact and a partial download of the artifact.
+	archived := filepath.Join(store.ModulesPath, "0")
+	if err := os.MkdirAll(archived, 0755); err != nil {
+		t.Fatalf("fail to create archived module directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(archived, art.FileName), []byte(body), 0644); err != nil {
+		t.Fatalf("fail to write archived artifact: %v", err)
+	}
+	if err := WriteLn(filepath.Join(archived, InternalStatusName), m.Name+":"+m.Version); err != nil {
+		t.Fatalf("fail to write module id: %v", err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("fail to create download directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, prefix+art.FileName), []byte("fresh"), 0644); err != nil {
+		t.Fatalf("fail to write partial artifact: %v", err)
+	}
+
+	// 2. Download the module from the beginning, ignoring the partial and the archived artifacts.
+	if err := store.DownloadModule(path, m, nil, ServerConfig{Force: true}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module: %v", err)
+	}
+	if !reflect.DeepEqual(ranges, []string{""}) {
+		t.Fatalf("unexpected requested ranges: %q", ranges)
+	}
+	existence(archived, false, "[archived module]", t)
+	existence(filepath.Join(path, prefix+art.FileName), false, "[partial artifact]", t)
+	existence(filepath.Join(path, art.FileName), true, "[forced download]", t)
+
+	// 3. Download the module again, ignoring the available artifact.
+	if err := store.DownloadModule(path, m, nil, ServerConfig{Force: true}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module: %v", err)
+	}
+	if len(ranges) != 2 {
+		t.Fatalf("expected forced download of the available artifact, got %d requests", len(ranges))
+	}
+	if err := store.DownloadModule(path, m, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to download module: %v", err)
+	}
+	if len(ranges) != 2 {
+		t.Fatalf("expected the available artifact to be reused, got %d requests", len(ranges))
+	}
+}
+
+// TestDownloadModuleFailedArtifacts tests that all module artifacts are processed and all failed artifacts are
+// reported at once.
+func TestDownloadModuleFailedArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	m := &Module{Name: "name", Version: "1"}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		path := filepath.Join(dir, name)
+		save(path, name, t)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("fail to read artifact [%s]: %v", path, err)
+		}
+		hash := sha256.Sum256(data)
+		if name == "b.txt" || name == "d.txt" {
+			hash = sha256.Sum256([]byte("corrupted"))
+		}
+		m.Artifacts = append(m.Artifacts, &Artifact{FileName: name, Size: len(data), Link: path, Local: true, Copy: true,
+			HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])})
+	}
+
+	path := filepath.Join(store.DownloadPath, "0", "0")
+	err = store.DownloadModule(path, m, nil, ServerConfig{}, 0, 0, nil, nil)
+	var artifactsErr *ArtifactsError
+	if !errors.As(err, &artifactsErr) {
+		t.Fatalf("expected failed artifacts error: %v", err)
+	}
+	if names := artifactsErr.FileNames(); !reflect.DeepEqual(names, []string{"b.txt", "d.txt"}) || artifactsErr.Total != 4 {
+		t.Fatalf("unexpected failed artifacts: %v of %d", names, artifactsErr.Total)
+	}
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch cause: %v", err)
+	}
+	existence(filepath.Join(path, "a.txt"), true, "[verified]", t)
+	existence(filepath.Join(path, "b.txt"), false, "[failed]", t)
+	existence(filepath.Join(path, "c.txt"), true, "[verified]", t)
+	existence(filepath.Join(path, "d.txt"), false, "[failed]", t)
+}
+
+// TestDownloadConcurrentOperations tests that concurrent operations with same-named artifacts of different
+// content do not collide in the storage.
+func TestDownloadConcurrentOperations(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := strings.Repeat(r.URL.Query().Get("campaign"), 100000)
+		time.Sleep(10 * time.Millisecond) // Overlap the downloads of the operations
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	campaigns := []string{"a", "b"}
+	dirs := make([]string, len(campaigns))
+	errs := make([]error, len(campaigns))
+	var wg sync.WaitGroup
+	for i, campaign := range campaigns {
+		wg.Add(1)
+		go func(i int, campaign string) {
+			defer wg.Done()
+			if dirs[i], errs[i] = store.CreateOperationLocation(store.DownloadPath, "campaign-"+campaign); errs[i] != nil {
+				return
+			}
+			content := strings.Repeat(campaign, 100000)
+			hash := sha256.Sum256([]byte(content))
+			module := &Module{Name: "app", Version: "1", Artifacts: []*Artifact{{FileName: "image.bin", Size: len(content),
+				Link: srv.URL + "/image.bin?campaign=" + campaign, HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])}}}
+			errs[i] = store.DownloadModule(filepath.Join(dirs[i], "0"), module, nil, ServerConfig{}, 0, 0, nil, nil)
+		}(i, campaign)
+	}
+	wg.Wait()
+
+	for i, campaign := range campaigns {
+		if errs[i] != nil {
+			t.Fatalf("fail to download operation of campaign %s: %v", campaign, errs[i])
+		}
+		data, err := os.ReadFile(filepath.Join(dirs[i], "0", "image.bin"))
+		if err != nil || string(data) != strings.Repeat(campaign, 100000) {
+			t.Fatalf("artifact of campaign %s collides with another operation: %v", campaign, err)
+		}
+	}
+	if dirs[0] == dirs[1] {
+		t.Fatalf("operations share the working directory: %s", dirs[0])
+	}
+}
+
+// TestStreamModule tests streaming of the module artifact with checksum verification.
+func TestStreamModule(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	body := "streamed content"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	hash := sha256.Sum256([]byte(body))
+	art := &Artifact{FileName: "image.bin", Size: len(body), Link: srv.URL + "/image.bin",
+		HashType: "SHA256", HashValue: hex.EncodeToString(hash[:])}
+	m := &Module{Name: "name", Version: "1", Artifacts: []*Artifact{art}}
+
+	// 1. Stream the verified artifact.
+	var out bytes.Buffer
+	var percent int
+	if err := store.StreamModule(m, &out, func(p int, written int64, total int64) { percent = p }, ServerConfig{}, 0, 0, nil); err != nil {
+		t.Fatalf("fail to stream module: %v", err)
+	}
+	if out.String() != body || percent != 100 {
+		t.Fatalf("unexpected streamed content [%s] with progress %d", out.String(), percent)
+	}
+	if files, _ := os.ReadDir(store.DownloadPath); len(files) > 0 {
+		t.Fatalf("streamed artifact is stored: %v", files)
+	}
+
+	// 2. Stream artifact with checksum mismatch.
+	art.HashValue = hex.EncodeToString(make([]byte, sha256.Size))
+	if err := store.StreamModule(m, io.Discard, nil, ServerConfig{}, 0, 0, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch: %v", err)
+	}
+
+	// 3. Stream module with multiple artifacts.
+	m.Artifacts = append(m.Artifacts, art)
+	if err := store.StreamModule(m, io.Discard, nil, ServerConfig{}, 0, 0, nil); err == nil {
+		t.Fatal("streamed module with multiple artifacts")
+	}
+
+	// 4. Cancel the stream.
+	m.Artifacts = m.Artifacts[:1]
+	cancel := make(chan struct{})
+	close(cancel)
+	if err := store.StreamModule(m, io.Discard, nil, ServerConfig{}, 0, 0, cancel); err != ErrCanceled {
+		t.Fatalf("expected canceled stream: %v", err)
+	}
+}
+
+// TestCleanupInstalledModule tests CleanupInstalledModule with all cleanup policies.
+func TestCleanupInstalledModule(t *testing.T) {
+	tests := []struct {
+		policy       string
+		keepCurrent  bool
+		keepPrevious bool
+	}{
+		{policy: CleanupKeep, keepCurrent: true, keepPrevious: true},
+		{policy: CleanupDeleteArtifacts, keepCurrent: false, keepPrevious: true},
+		{policy: CleanupDeleteOnNextSuccess, keepCurrent: true, keepPrevious: false},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewStorage(dir)
+			if err != nil {
+				t.Fatalf("fail to initialize local storage: %v", err)
+			}
+			defer store.Close()
+
+			// Previously installed and downloaded, but not installed yet modules.
+			installed := filepath.Join(store.ModulesPath, "0")
+			save(filepath.Join(installed, "previous.txt"), "previous", t)
+			save(filepath.Join(installed, InternalStatusName), "name:1", t)
+			save(filepath.Join(installed, InstalledStatusName), "name\n1", t)
+			downloaded := filepath.Join(store.ModulesPath, "1")
+			save(filepath.Join(downloaded, "next.txt"), "next", t)
+			save(filepath.Join(downloaded, InternalStatusName), "name:3", t)
+			other := filepath.Join(store.ModulesPath, "2")
+			save(filepath.Join(other, "other.txt"), "other", t)
+			save(filepath.Join(other, InternalStatusName), "other:1", t)
+			save(filepath.Join(other, InstalledStatusName), "other\n1", t)
+			prefixed := filepath.Join(store.ModulesPath, "3")
+			save(filepath.Join(prefixed, "prefixed.txt"), "prefixed", t)
+			save(filepath.Join(prefixed, InternalStatusName), "name:beta:1", t)
+			save(filepath.Join(prefixed, InstalledStatusName), "name:beta\n1", t)
+
+			// Successfully installed module.
+			current := &Module{Name: "name", Version: "2"}
+			path := filepath.Join(store.DownloadPath, "0", "0")
+			save(filepath.Join(path, "current.txt"), "current", t)
+			save(filepath.Join(path, InternalStatusName), "name:2", t)
+
+			if err := store.CleanupInstalledModule(path, current, test.policy); err != nil {
+				t.Fatalf("fail to cleanup installed module: %v", err)
+			}
+			existence(filepath.Join(installed, "previous.txt"), test.keepPrevious, "[previous]", t)
+			existence(filepath.Join(downloaded, "next.txt"), true, "[not installed]", t)
+			existence(filepath.Join(other, "other.txt"), true, "[other module]", t)
+			existence(filepath.Join(prefixed, "prefixed.txt"), true, "[other module with the same name prefix]", t)
+			existence(filepath.Join(store.ModulesPath, "4", "current.txt"), test.keepCurrent, "[current]", t)
+
+			// Kept module is available to the next operation with the same module.
+			if test.keepCurrent {
+				next := filepath.Join(store.DownloadPath, "1", "0")
+				if err := os.MkdirAll(next, 0755); err != nil {
+					t.Fatalf("fail create module directory: %v", err)
+				}
+				searchAndMove(store.fs, store.ModulesPath, next, current)
+				existence(filepath.Join(next, "current.txt"), true, "[reused]", t)
+			}
+		})
+	}
+}
+
+// TestDownloadData tests DownloadData with successful in-memory download and with exceeding the maximal size.
+func TestDownloadData(t *testing.T) {
+	body := "authorized content"
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+
+	art := &Artifact{
+		FileName: "manifest.txt", Size: len(body), Link: srv.URL + "/manifest.txt",
+		HashType:  "MD5",
+		HashValue: "4e54acb9ed2abbed670f23cef3b80e57",
+	}
+
+	// 1. Download in memory.
+	data, err := store.DownloadData(art, 1024, ServerConfig{}, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("fail to download artifact in memory: %v", err)
+	}
+	if string(data) != body {
+		t.Fatalf("unexpected artifact data: %s != %s", data, body)
+	}
+	if files, _ := os.ReadDir(store.DownloadPath); len(files) > 0 {
+		t.Fatalf("unexpected files in the local storage: %v", files)
+	}
+
+	// 2. Exceed the maximal size, while downloading artifact with unknown size.
+	art.Size = 0
+	if _, err := store.DownloadData(art, int64(len(body)-1), ServerConfig{}, 3, 0, nil); !errors.Is(err, ErrFileSizeExceeded) {
+		t.Fatalf("expected %v, got: %v", ErrFileSizeExceeded, err)
+	}
+
+	// 3. Exceed the maximal size with the expected size, before the download.
+	requests = 0
+	art.Size = len(body)
+	if _, err := store.DownloadData(art, int64(len(body)-1), ServerConfig{}, 0, 0, nil); !errors.Is(err, ErrFileSizeExceeded) {
+		t.Fatalf("expected %v, got: %v", ErrFileSizeExceeded, err)
+	}
+	if requests > 0 {
+		t.Fatalf("unexpected download of artifact bigger than the maximal size")
+	}
+
+	// 4. Fail with wrong checksum after retries.
+	requests = 0
+	art.HashValue = "ab2ce340d36bbaafe17965a3a2c6ed5b"
+	if _, err := store.DownloadData(art, 1024, ServerConfig{}, 2, 0, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected %v, got: %v", ErrChecksumMismatch, err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 download attempts, got %d", requests)
+	}
+}
+
 // TestDownloadAESEncryptedModuleBase64 tests DownloadModule with AES encrypted artifacts.
 func TestDownloadAESEncryptedModuleBase64(t *testing.T) {
 	testDownloadAESEncryptedModule(t, "base64")
@@ -449,7 +828,7 @@ func testDownloadAESEncryptedModule(t *testing.T, format string) {
 		},
 	} {
 		t.Run(modules.name, func(t *testing.T) {
-			if err := store.DownloadModule(path, modules.m, nil, "", 0, 0, nil); err != nil {
+			if err := store.DownloadModule(path, modules.m, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
 				f, _ := strings.CutSuffix(format, "raw")
 				if modules.ee != nil && err.Error() == modules.ee[f].Error() {
 					return
@@ -458,7 +837,7 @@ func testDownloadAESEncryptedModule(t *testing.T, format string) {
 			}
 			existence(filepath.Join(path, art.FileName), true, "[initial download]", t)
 
-			if err := ExtractArchive(path); err != nil {
+			if err := ExtractArchive(path, "", ""); err != nil {
 				t.Fatalf("fail to extract module [%s]: %v", path, err)
 			}
 		})
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import "time"
+
+// Timings is the breakdown of an artifact download, e.g. for performance tuning and rollout analytics.
+type Timings struct {
+	// Connect is the time to open the artifact source, until the response headers are received, of all requests.
+	Connect time.Duration
+	// Transfer is the time to receive and write the artifact data.
+	Transfer time.Duration
+	// Verify is the time to validate the checksums of the artifact and to verify it.
+	Verify time.Duration
+	// Retries is the number of the retried requests and copies and of the downloads, retried on checksum mismatch.
+	Retries int
+	// Resumed is the number of bytes of the partial downloads, kept instead of downloaded again, on each resume,
+	// e.g. of a previous download or after a failed copy.
+	Resumed int64
+	// Downloaded is the number of the received bytes.
+	Downloaded int64
+}
+
+// TimingsListener is notified with the timing breakdown of each finished artifact download, successful or failed.
+// The canceled and aborted downloads are not reported.
+type TimingsListener func(artifact *Artifact, timings Timings)
+
+// connected adds the time since the start of a request to the connect time, if the timings are tracked.
+func (t *Timings) connected(start time.Time) {
+	if t != nil {
+		t.Connect += time.Since(start)
+	}
+}
+
+// transferred adds the time since the start of a copy and the copied bytes, if the timings are tracked.
+func (t *Timings) transferred(start time.Time, bytes int64) {
+	if t != nil {
+		t.Transfer += time.Since(start)
+		t.Downloaded += bytes
+	}
+}
+
+// verified adds the time since the start of a validation to the verify time, if the timings are tracked.
+func (t *Timings) verified(start time.Time) {
+	if t != nil {
+		t.Verify += time.Since(start)
+	}
+}
+
+// retried counts a retry, if the timings are tracked.
+func (t *Timings) retried() {
+	if t != nil {
+		t.Retries++
+	}
+}
+
+// resumed adds the bytes of a resumed partial download, if the timings are tracked.
+func (t *Timings) resumed(bytes int64) {
+	if t != nil {
+		t.Resumed += bytes
+	}
+}
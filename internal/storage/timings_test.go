@@ -0,0 +1,122 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDownloadTimings tests that the timing breakdown of the artifact downloads is notified with the connect,
+// transfer and verify times, the retries and the resumed and downloaded bytes.
+func TestDownloadTimings(t *testing.T) {
+	body := strings.Repeat("download timings test\n", 1000)
+	sum := md5.Sum([]byte(body))
+	offset := 100
+
+	tests := map[string]struct {
+		partial  bool
+		failures int
+		retries  int
+		resumed  int64
+	}{
+		"download": {},
+		"resume":   {partial: true, resumed: int64(offset)},
+		"retry":    {failures: 1, retries: 1},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests++; requests <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			art := &Artifact{
+				FileName: "test-timings.txt", Size: len(body), Link: srv.URL + "/test-timings.txt",
+				HashType:  "MD5",
+				HashValue: hex.EncodeToString(sum[:]),
+			}
+			file := filepath.Join(dir, art.FileName)
+			if test.partial {
+				tmp := filepath.Join(dir, prefix+art.FileName)
+				if err := os.WriteFile(tmp, []byte(body[:offset]), 0644); err != nil {
+					t.Fatalf("failed to write partial download: %v", err)
+				}
+				data, _ := json.Marshal(&partialInfo{Link: art.Link, Size: int64(len(body)), ETag: `"v1"`})
+				if err := os.WriteFile(tmp+partialInfoSuffix, data, 0644); err != nil {
+					t.Fatalf("failed to write partial download information: %v", err)
+				}
+			}
+
+			var notified []Timings
+			server := ServerConfig{OnTimings: func(artifact *Artifact, timings Timings) {
+				if artifact != art {
+					t.Errorf("unexpected artifact: %v", artifact)
+				}
+				notified = append(notified, timings)
+			}}
+			if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 1, 0, nil, make(chan struct{})); err != nil {
+				t.Fatalf("failed to download artifact: %v", err)
+			}
+			if len(notified) != 1 {
+				t.Fatalf("expected one timings notification, got: %v", notified)
+			}
+			timings := notified[0]
+			if timings.Connect <= 0 || timings.Transfer <= 0 || timings.Verify <= 0 {
+				t.Errorf("timings are not populated: %+v", timings)
+			}
+			if timings.Retries != test.retries {
+				t.Errorf("unexpected retries: %d != %d", timings.Retries, test.retries)
+			}
+			if timings.Resumed != test.resumed || timings.Downloaded != int64(len(body))-test.resumed {
+				t.Errorf("unexpected resumed and downloaded bytes: %+v", timings)
+			}
+		})
+	}
+
+	// The timings of an already available artifact have only the verify time.
+	t.Run("available", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "test-timings.txt")
+		if err := os.WriteFile(file, []byte(body), 0644); err != nil {
+			t.Fatalf("failed to write artifact: %v", err)
+		}
+		art := &Artifact{FileName: "test-timings.txt", Size: len(body), Link: "http://localhost:1/test-timings.txt",
+			HashType: "MD5", HashValue: hex.EncodeToString(sum[:])}
+		var timings *Timings
+		server := ServerConfig{OnTimings: func(artifact *Artifact, t Timings) { timings = &t }}
+		if err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 0, 0, nil, make(chan struct{})); err != nil {
+			t.Fatalf("failed to validate artifact: %v", err)
+		}
+		if timings == nil || timings.Verify <= 0 || timings.Connect != 0 || timings.Transfer != 0 || timings.Downloaded != 0 {
+			t.Fatalf("unexpected timings of available artifact: %+v", timings)
+		}
+	})
+}
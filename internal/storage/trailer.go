@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const (
+	// trailerGoogHash is the trailer with the comma separated type=base64 digests of a Google Cloud Storage object.
+	trailerGoogHash = "X-Goog-Hash"
+	// trailerAmzChecksum is the prefix of the trailers with the base64 digest of an Amazon S3 object,
+	// followed by the hash type, e.g. X-Amz-Checksum-Sha256.
+	trailerAmzChecksum = "X-Amz-Checksum-"
+	// trailerDigest is the trailer with the comma separated type=base64 instance digests of RFC 3230.
+	trailerDigest = "Digest"
+)
+
+// responseTrailer returns the trailers of the artifact server response, complete once its body is read to the end.
+func responseTrailer(source io.ReadCloser) http.Header {
+	if body, ok := source.(*entityBody); ok && body.response != nil {
+		return body.response.Trailer
+	}
+	return nil
+}
+
+// trailerDigests returns the base64 encoded digests of the downloaded artifact by their hash type, as sent by
+// the artifact server in the response trailers. The digests of unregistered hash types, e.g. CRC32C, are ignored.
+func trailerDigests(trailer http.Header) map[string]string {
+	digests := map[string]string{}
+	add := func(hashType string, value string) {
+		hashType = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(hashType), "-", ""))
+		if hashType == "SHA" {
+			hashType = "SHA1" // RFC 3230 name of SHA-1
+		}
+		if _, err := newHash(hashType); err == nil {
+			digests[hashType] = strings.TrimSpace(value)
+		}
+	}
+	addList := func(values []string) {
+		for _, value := range values {
+			for _, digest := range strings.Split(value, ",") {
+				if i := strings.Index(digest, "="); i > 0 {
+					add(digest[:i], digest[i+1:])
+				}
+			}
+		}
+	}
+	for key, values := range trailer {
+		switch key = http.CanonicalHeaderKey(key); {
+		case key == trailerGoogHash || key == trailerDigest:
+			addList(values)
+		case strings.HasPrefix(key, trailerAmzChecksum) && len(values) > 0:
+			add(strings.TrimPrefix(key, trailerAmzChecksum), values[0])
+		}
+	}
+	return digests
+}
+
+// checkTrailer cross-checks the downloaded artifact data against the digests, sent by the artifact server in
+// the trailers of the source response, if enabled by the server configuration. The data is read again only,
+// if the response has supported digest trailers. ErrChecksumMismatch is returned for a different or malformed digest,
+// even if the artifact hashes match.
+func checkTrailer(server ServerConfig, source io.ReadCloser, artifact *Artifact, open func() (io.ReadCloser, error)) error {
+	if !server.TrailerChecksum {
+		return nil
+	}
+	digests := trailerDigests(responseTrailer(source))
+	if len(digests) == 0 {
+		return nil
+	}
+	types := make([]string, 0, len(digests))
+	expected := make([][]byte, 0, len(digests))
+	actual := make([]hash.Hash, 0, len(digests))
+	writers := make([]io.Writer, 0, len(digests))
+	for hashType, value := range digests {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("%w: malformed %s trailer digest %q of artifact %s", ErrChecksumMismatch, hashType, value, artifact.FileName)
+		}
+		h, _ := newHash(hashType)
+		types, expected, actual, writers = append(types, hashType), append(expected, sum), append(actual, h), append(writers, h)
+	}
+	logger.Debugf("cross-check artifact %s with the %s trailer digests", artifact.FileName, strings.Join(types, ", "))
+
+	data, err := open()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	if _, err := io.Copy(io.MultiWriter(writers...), data); err != nil {
+		return err
+	}
+	for i, hashType := range types {
+		if sum := actual[i].Sum(nil); !bytes.Equal(sum, expected[i]) {
+			return fmt.Errorf("%w: %s trailer digest %s != %s", ErrChecksumMismatch, hashType,
+				base64.StdEncoding.EncodeToString(sum), digests[hashType])
+		}
+	}
+	return nil
+}
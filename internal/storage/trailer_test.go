@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownloadTrailerChecksum tests that the downloads are cross-checked against the correct and incorrect
+// digest trailers of the artifact server, only if enabled.
+func TestDownloadTrailerChecksum(t *testing.T) {
+	body := []byte("content with a digest trailer")
+	md5Sum := md5.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	wrong := base64.StdEncoding.EncodeToString(make([]byte, md5.Size))
+
+	tests := map[string]struct {
+		trailer  map[string]string
+		disabled bool
+		err      error
+	}{
+		"no-trailer":  {},
+		"goog-hash":   {trailer: map[string]string{"X-Goog-Hash": "crc32c=n03x6A==,md5=" + base64.StdEncoding.EncodeToString(md5Sum[:])}},
+		"amz-sha256":  {trailer: map[string]string{"X-Amz-Checksum-Sha256": base64.StdEncoding.EncodeToString(sha256Sum[:])}},
+		"digest":      {trailer: map[string]string{"Digest": "SHA-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:])}},
+		"unsupported": {trailer: map[string]string{"X-Amz-Checksum-Crc32": "AAAAAA=="}},
+		"goog-hash-mismatch": {trailer: map[string]string{"X-Goog-Hash": "md5=" + wrong},
+			err: ErrChecksumMismatch},
+		"digest-malformed":  {trailer: map[string]string{"Digest": "MD5=not base64"}, err: ErrChecksumMismatch},
+		"mismatch-disabled": {trailer: map[string]string{"X-Goog-Hash": "md5=" + wrong}, disabled: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key := range test.trailer {
+					w.Header().Add("Trailer", key)
+				}
+				w.Write(body)
+				for key, value := range test.trailer {
+					w.Header().Set(key, value)
+				}
+			}))
+			defer srv.Close()
+
+			art := &Artifact{
+				FileName: "test-trailer.txt", Size: len(body), Link: srv.URL + "/test-trailer.txt",
+				HashType:  "MD5",
+				HashValue: hex.EncodeToString(md5Sum[:]),
+			}
+			server := ServerConfig{TrailerChecksum: !test.disabled}
+
+			// 1. Download to file.
+			file := filepath.Join(t.TempDir(), art.FileName)
+			err := downloadArtifact(OSFileSystem{}, file, art, nil, server, 0, 0, nil, make(chan struct{}))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected file download error %v, got %v", test.err, err)
+			}
+			if _, statErr := os.Stat(file); (statErr == nil) != (test.err == nil) {
+				t.Fatalf("unexpected downloaded file: %v", statErr)
+			}
+
+			// 2. Download to memory.
+			data, err := downloadData(art, 1024, server, 0, 0, make(chan struct{}))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected data download error %v, got %v", test.err, err)
+			}
+			if test.err == nil && !bytes.Equal(data, body) {
+				t.Fatalf("unexpected downloaded data: %s", data)
+			}
+		})
+	}
+}
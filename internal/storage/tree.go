@@ -0,0 +1,257 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+const (
+	// MetadataTree is the module metadata with the file name of the file list artifact of a directory tree, served
+	// under the base URL of the file list. The file list has a "<hex SHA-256 digest> <size> <relative path>" line
+	// per file. The tree is synced to the TreeDirName directory of the module: only the changed files are downloaded
+	// and the files, which are not listed, are removed.
+	MetadataTree = "tree"
+	// TreeDirName is the name of the module directory, where the directory tree is synced.
+	TreeDirName = "tree"
+)
+
+// ErrTreeInvalid represents directory tree file list, which is not valid error.
+var ErrTreeInvalid = errors.New("directory tree file list is not valid")
+
+// treeLine is a "<hex SHA-256 digest> <size> <relative path>" line of the file list.
+var treeLine = regexp.MustCompile(`^(\S+)\s+(\d+)\s+(.+)$`)
+
+// parseTree returns the files of the directory tree, listed in the file list data, as artifacts with paths
+// relative to the tree directory and links relative to the file list link.
+func parseTree(data []byte, list *Artifact) ([]*Artifact, error) {
+	var files []*Artifact
+	paths := map[string]bool{}
+	for i, text := range strings.Split(string(data), "\n") {
+		line := i + 1
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := treeLine.FindStringSubmatch(text)
+		if fields == nil {
+			return nil, fmt.Errorf("invalid file list line %d, expected digest, size and path", line)
+		}
+		digest, name := strings.ToLower(fields[1]), strings.TrimSpace(fields[3])
+		if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 digest of %s on file list line %d", name, line)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of %s on file list line %d", name, line)
+		}
+		clean := path.Clean(name)
+		if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(clean, "\\") {
+			return nil, fmt.Errorf("invalid path %s on file list line %d, must be relative to the tree", name, line)
+		}
+		if paths[clean] {
+			return nil, fmt.Errorf("duplicate file list entry of %s on line %d", clean, line)
+		}
+		paths[clean] = true
+		link, err := treeLink(list, clean)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &Artifact{FileName: filepath.FromSlash(clean), Size: size, Link: link, Local: list.Local,
+			Copy: true, HashType: string(hawkbit.SHA256), HashValue: digest, HashEncoding: HashEncodingHex})
+	}
+	return files, nil
+}
+
+// treeLink returns the link of the tree file, relative to the file list link.
+func treeLink(list *Artifact, name string) (string, error) {
+	if list.Local {
+		return filepath.Join(filepath.Dir(list.Link), filepath.FromSlash(name)), nil
+	}
+	base, err := url.Parse(list.Link)
+	if err != nil {
+		return "", fmt.Errorf("invalid file list link %s", RedactLink(list.Link))
+	}
+	return base.ResolveReference(&url.URL{Path: name}).String(), nil
+}
+
+// downloadTree downloads the file list of the directory tree to the module directory and returns the listed files.
+// The tree directory is seeded with the tree of the installed module with the same name, if not available yet.
+func (st *Storage) downloadTree(toDir string, module *Module, server ServerConfig, retryCount int,
+	retryInterval time.Duration, done chan struct{}) ([]*Artifact, error) {
+	list := findArtifact(module, module.Metadata[MetadataTree])
+	if list == nil {
+		return nil, fmt.Errorf("%w: file list %q is not a module artifact", ErrTreeInvalid, module.Metadata[MetadataTree])
+	}
+	if err := downloadArtifact(st.fs, filepath.Join(toDir, list.FileName), list, nil, server, retryCount, retryInterval, nil, done); err != nil {
+		return nil, err
+	}
+	data, err := st.readFile(filepath.Join(toDir, list.FileName))
+	if err != nil {
+		return nil, err
+	}
+	files, err := parseTree(data, list)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTreeInvalid, err)
+	}
+	treeDir := filepath.Join(toDir, TreeDirName)
+	if _, err := st.fs.Stat(treeDir); os.IsNotExist(err) && !server.Force {
+		if installed := searchInstalledTree(st.fs, st.ModulesPath, module); installed != "" {
+			logger.Infof("Seed directory tree of module [%s:%s] from directory: %s", module.Name, module.Version, installed)
+			if err := copyTree(st.fs, installed, treeDir); err != nil {
+				logger.Errorf("failed to seed directory tree from [%s]: %v", installed, err)
+			}
+		}
+	}
+	logger.Infof("directory tree file list [%s] has %d files", list.FileName, len(files))
+	return files, nil
+}
+
+// syncTree downloads the changed files of the directory tree and removes the files, which are not listed.
+// The available files with matching digests are not downloaded again.
+func (st *Storage) syncTree(toDir string, files []*Artifact, progress progressBytes, server ServerConfig,
+	retryCount int, retryInterval time.Duration, done chan struct{}) error {
+	treeDir := filepath.Join(toDir, TreeDirName)
+	listed := map[string]bool{}
+	failed := &ArtifactsError{Total: len(files)}
+	for _, file := range files {
+		to := filepath.Join(treeDir, file.FileName)
+		listed[to] = true
+		if err := st.fs.MkdirAll(filepath.Dir(to)); err != nil {
+			return err
+		}
+		if err := downloadArtifact(st.fs, to, file, progress, server, retryCount, retryInterval, nil, done); err != nil {
+			if err == ErrCancel || err == ErrAborted {
+				return err
+			}
+			logger.Errorf("failed to download tree file [%s]: %v", file.FileName, err)
+			failed.Failed = append(failed.Failed, &ArtifactError{FileName: file.FileName, Err: err})
+		}
+	}
+	if len(failed.Failed) > 0 {
+		return failed
+	}
+	return removeUnlisted(st.fs, treeDir, listed)
+}
+
+// removeUnlisted removes the files of the tree directory, which are not listed, and the directories left empty.
+func removeUnlisted(fs FileSystem, dir string, listed map[string]bool) error {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := removeUnlisted(fs, name, listed); err != nil {
+				return err
+			}
+			// Remove the nested directories, left empty.
+			if entries, err := fs.ReadDir(name); err == nil && len(entries) == 0 {
+				fs.Remove(name)
+			}
+			continue
+		}
+		if !listed[name] {
+			logger.Debugf("remove tree file, which is not listed: %s", name)
+			if err := fs.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// searchInstalledTree returns the tree directory of the installed module with the same name or empty string,
+// if not available.
+func searchInstalledTree(fs FileSystem, inDir string, module *Module) string {
+	paths, err := fs.ReadDir(inDir)
+	if err != nil {
+		return ""
+	}
+	for _, path := range paths {
+		dir := filepath.Join(inDir, path.Name())
+		if !isInstalled(fs, dir, module) {
+			continue
+		}
+		if info, err := fs.Stat(filepath.Join(dir, TreeDirName)); err == nil && info.IsDir() {
+			return filepath.Join(dir, TreeDirName)
+		}
+	}
+	return ""
+}
+
+// copyTree copies the files of the source directory tree to the destination directory. The installed tree is
+// copied instead of moved, so that it remains available to a rollback.
+func copyTree(fs FileSystem, src string, dst string) error {
+	if err := fs.MkdirAll(dst); err != nil {
+		return err
+	}
+	entries, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		from, to := filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())
+		if entry.IsDir() {
+			err = copyTree(fs, from, to)
+		} else if entry.Type().IsRegular() {
+			err = copyFile(fs, from, to)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(fs FileSystem, src string, dst string) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// treeSize returns the total size in bytes of the files of the directory tree.
+func treeSize(files []*Artifact) int64 {
+	var size int64
+	for _, file := range files {
+		size += int64(file.Size)
+	}
+	return size
+}
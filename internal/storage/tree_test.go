@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestDownloadModuleTree tests the sync of a directory tree, where the unchanged files are skipped, the changed
+// and the new ones are downloaded and the files, which are not listed any more, are removed.
+func TestDownloadModuleTree(t *testing.T) {
+	served := map[string]string{
+		"a.txt":       "unchanged",
+		"dir/b.txt":   "changed content",
+		"dir/new.txt": "new",
+	}
+	list := "# directory tree\n"
+	var total int64
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/new.txt"} {
+		sum := sha256.Sum256([]byte(served[name]))
+		list += fmt.Sprintf("%s %d %s\n", hex.EncodeToString(sum[:]), len(served[name]), name)
+		total += int64(len(served[name]))
+	}
+	served["files.list"] = list
+	total += int64(len(list))
+	listSum := sha256.Sum256([]byte(list))
+
+	var lock sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/v2/")
+		lock.Lock()
+		requested = append(requested, name)
+		lock.Unlock()
+		w.Write([]byte(served[name]))
+	}))
+	defer srv.Close()
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	m := &Module{Name: "tree", Version: "2", Metadata: map[string]string{MetadataTree: "files.list"},
+		Artifacts: []*Artifact{{FileName: "files.list", Size: len(list), Link: srv.URL + "/v2/files.list",
+			HashType: "SHA256", HashValue: hex.EncodeToString(listSum[:])}}}
+
+	// The files of the previous tree on disk.
+	dir := filepath.Join(store.DownloadPath, "0", "0")
+	previous := map[string]string{
+		"a.txt":     "unchanged",
+		"dir/b.txt": "previous content",
+		"old.txt":   "removed",
+		"old/x.txt": "removed",
+	}
+	for name, content := range previous {
+		writeTreeFile(t, filepath.Join(dir, TreeDirName), name, content)
+	}
+
+	var progress []int
+	err = store.DownloadModule(dir, m, func(percent int, written int64, size int64) {
+		if size != total {
+			t.Errorf("unexpected total size: %d != %d", size, total)
+		}
+		progress = append(progress, percent)
+	}, ServerConfig{}, 0, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("fail to sync directory tree: %v", err)
+	}
+
+	sort.Strings(requested)
+	if expected := []string{"dir/b.txt", "dir/new.txt", "files.list"}; !reflect.DeepEqual(requested, expected) {
+		t.Fatalf("unexpected downloaded files: %v != %v", requested, expected)
+	}
+	assertTree(t, filepath.Join(dir, TreeDirName), served, "files.list")
+	if len(progress) == 0 || progress[len(progress)-1] != 100 {
+		t.Fatalf("unexpected aggregate progress: %v", progress)
+	}
+}
+
+// TestDownloadModuleTreeSeed tests that the directory tree is seeded with the tree of the installed module with
+// the same name, which is kept for a rollback.
+func TestDownloadModuleTreeSeed(t *testing.T) {
+	content := "seeded content"
+	sum := sha256.Sum256([]byte(content))
+	list := fmt.Sprintf("%s %d seeded.txt\n", hex.EncodeToString(sum[:]), len(content))
+	listSum := sha256.Sum256([]byte(list))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/files.list" {
+			t.Errorf("unexpected download of %s", r.URL.Path)
+		}
+		w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to initialize local storage: %v", err)
+	}
+	defer store.Close()
+	installed := filepath.Join(store.ModulesPath, "0")
+	writeTreeFile(t, filepath.Join(installed, TreeDirName), "seeded.txt", content)
+	if err := WriteLn(filepath.Join(installed, InstalledStatusName), "tree\n1"); err != nil {
+		t.Fatalf("failed to write installed status: %v", err)
+	}
+
+	m := &Module{Name: "tree", Version: "2", Metadata: map[string]string{MetadataTree: "files.list"},
+		Artifacts: []*Artifact{{FileName: "files.list", Size: len(list), Link: srv.URL + "/files.list",
+			HashType: "SHA256", HashValue: hex.EncodeToString(listSum[:])}}}
+	dir := filepath.Join(store.DownloadPath, "0", "0")
+	if err := store.DownloadModule(dir, m, nil, ServerConfig{}, 0, 0, nil, nil); err != nil {
+		t.Fatalf("fail to sync directory tree: %v", err)
+	}
+	assertTree(t, filepath.Join(dir, TreeDirName), map[string]string{"seeded.txt": content}, "")
+	assertTree(t, filepath.Join(installed, TreeDirName), map[string]string{"seeded.txt": content}, "")
+}
+
+// TestParseTree tests the parsing of the directory tree file list.
+func TestParseTree(t *testing.T) {
+	digest := strings.Repeat("ab", sha256.Size)
+	list := &Artifact{Link: "https://cdn.example.com/trees/v2/files.list"}
+	files, err := parseTree([]byte("# comment\n\n"+digest+" 10 bin/app\n"+digest+"  0  docs/read me.txt\n"), list)
+	if err != nil {
+		t.Fatalf("failed to parse file list: %v", err)
+	}
+	if len(files) != 2 || files[0].Link != "https://cdn.example.com/trees/v2/bin/app" || files[0].Size != 10 ||
+		files[1].Link != "https://cdn.example.com/trees/v2/docs/read%20me.txt" || files[1].FileName != filepath.Join("docs", "read me.txt") {
+		t.Fatalf("unexpected tree files: %+v, %+v", files[0], files[1])
+	}
+
+	for name, line := range map[string]string{
+		"missingSize":   digest + " bin/app",
+		"invalidDigest": "abc 10 bin/app",
+		"absolutePath":  digest + " 10 /etc/passwd",
+		"parentPath":    digest + " 10 ../escape",
+		"duplicatePath": digest + " 10 bin/app\n" + digest + " 10 bin/./app",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseTree([]byte(line), list); err == nil {
+				t.Fatal("expected invalid file list error")
+			}
+		})
+	}
+}
+
+// writeTreeFile writes the file with the slash separated path to the tree directory.
+func writeTreeFile(t *testing.T, treeDir string, name string, content string) {
+	file := filepath.Join(treeDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("failed to create directory of %s: %v", file, err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+}
+
+// assertTree asserts that the tree directory has exactly the expected files, except the excluded one.
+func assertTree(t *testing.T, treeDir string, expected map[string]string, exclude string) {
+	actual := map[string]string{}
+	err := filepath.Walk(treeDir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		rel, _ := filepath.Rel(treeDir, name)
+		actual[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read tree directory %s: %v", treeDir, err)
+	}
+	files := map[string]string{}
+	for name, content := range expected {
+		if name != exclude {
+			files[name] = content
+		}
+	}
+	if !reflect.DeepEqual(actual, files) {
+		t.Fatalf("unexpected tree files: %v != %v", actual, files)
+	}
+	if _, err := os.Stat(filepath.Join(treeDir, "old")); !os.IsNotExist(err) {
+		t.Fatalf("empty directory is not removed: %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// maxTUFMetadataSize is the maximal size in bytes of the fetched TUF metadata files.
+const maxTUFMetadataSize = 8 << 20
+
+// tufEnvelope is a signed TUF metadata file.
+type tufEnvelope struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// tufHeader is the common part of the signed TUF metadata.
+type tufHeader struct {
+	Type    string    `json:"_type"`
+	Version int       `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+type tufKey struct {
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+type tufRole struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+type tufRoot struct {
+	tufHeader
+	Keys  map[string]tufKey  `json:"keys"`
+	Roles map[string]tufRole `json:"roles"`
+}
+
+// tufFile is the length and the hashes of a metadata or a target file, as listed by the signed TUF metadata.
+type tufFile struct {
+	Version int               `json:"version,omitempty"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+type tufMeta struct {
+	tufHeader
+	Meta map[string]tufFile `json:"meta"`
+}
+
+type tufTargets struct {
+	tufHeader
+	Targets map[string]tufFile `json:"targets"`
+}
+
+// TUFVerifier verifies the downloaded artifacts against the signed targets of a repository of The Update Framework.
+// The timestamp, snapshot and targets metadata are fetched from the repository and verified with the keys of the
+// trusted root metadata, before each verification. The artifacts must be targets with their file name, length and
+// hashes. Root rotation and delegated targets are not supported.
+type TUFVerifier struct {
+	root       *tufRoot
+	repository string
+	server     ServerConfig
+	// versions are the last verified versions of the metadata, to reject rolled back ones.
+	versions map[string]int
+	lock     sync.Mutex
+}
+
+// NewTUFVerifier returns the verifier of the artifacts with the given trusted root metadata, signed by its root keys.
+// The repository is the base URL or the local directory of the timestamp.json, snapshot.json and targets.json
+// metadata, fetched with the server configuration.
+func NewTUFVerifier(root []byte, repository string, server ServerConfig) (*TUFVerifier, error) {
+	var envelope tufEnvelope
+	if err := json.Unmarshal(root, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid TUF root metadata: %v", err)
+	}
+	trusted := &tufRoot{}
+	if err := json.Unmarshal(envelope.Signed, trusted); err != nil {
+		return nil, fmt.Errorf("invalid TUF root metadata: %v", err)
+	}
+	if trusted.Type != "root" {
+		return nil, fmt.Errorf("invalid TUF root metadata type %q", trusted.Type)
+	}
+	if err := trusted.verify(&envelope, "root"); err != nil {
+		return nil, err
+	}
+	if repository == "" {
+		return nil, errors.New("no TUF repository is given")
+	}
+	return &TUFVerifier{root: trusted, repository: repository, server: server, versions: map[string]int{}}, nil
+}
+
+// Verify is an ArtifactVerifier, which verifies that the artifact is a signed target of the TUF repository and
+// that its length and hashes match the target ones.
+func (v *TUFVerifier) Verify(artifact *Artifact, data io.ReaderAt, size int64) error {
+	targets, err := v.targets()
+	if err != nil {
+		return err
+	}
+	target, ok := targets.Targets[artifact.FileName]
+	if !ok {
+		return fmt.Errorf("artifact %s is not a signed TUF target", artifact.FileName)
+	}
+	if target.Length != size {
+		return fmt.Errorf("artifact %s has %d bytes, signed TUF target has %d", artifact.FileName, size, target.Length)
+	}
+	if err := checkTUFHashes(io.NewSectionReader(data, 0, size), target.Hashes); err != nil {
+		return fmt.Errorf("artifact %s does not match signed TUF target: %v", artifact.FileName, err)
+	}
+	logger.Debugf("artifact %s is verified with signed TUF targets version %d", artifact.FileName, targets.Version)
+	return nil
+}
+
+// targets fetches and verifies the timestamp, snapshot and targets metadata, in this order, and returns the
+// verified targets.
+func (v *TUFVerifier) targets() (*tufTargets, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if err := v.checkExpires(&v.root.tufHeader, "root"); err != nil {
+		return nil, err
+	}
+	timestamp := &tufMeta{}
+	if err := v.fetch("timestamp", nil, timestamp); err != nil {
+		return nil, err
+	}
+	snapshotFile, ok := timestamp.Meta["snapshot.json"]
+	if !ok {
+		return nil, errors.New("TUF timestamp metadata does not list snapshot.json")
+	}
+	snapshot := &tufMeta{}
+	if err := v.fetch("snapshot", &snapshotFile, snapshot); err != nil {
+		return nil, err
+	}
+	targetsFile, ok := snapshot.Meta["targets.json"]
+	if !ok {
+		return nil, errors.New("TUF snapshot metadata does not list targets.json")
+	}
+	targets := &tufTargets{}
+	if err := v.fetch("targets", &targetsFile, targets); err != nil {
+		return nil, err
+	}
+	for role, version := range map[string]int{"timestamp": timestamp.Version, "snapshot": snapshot.Version,
+		"targets": targets.Version} {
+		v.versions[role] = version
+	}
+	return targets, nil
+}
+
+// fetch fetches the metadata of the role and verifies it against the expected file, as listed by the previous
+// metadata, its signatures, type, version and expiry, before it is decoded into signed.
+func (v *TUFVerifier) fetch(role string, expected *tufFile, signed interface{}) error {
+	data, err := v.read(role + ".json")
+	if err != nil {
+		return fmt.Errorf("failed to fetch TUF %s metadata: %v", role, err)
+	}
+	if expected != nil {
+		if expected.Length > 0 && int64(len(data)) != expected.Length {
+			return fmt.Errorf("TUF %s metadata has %d bytes, expected %d", role, len(data), expected.Length)
+		}
+		if err := checkTUFHashes(bytes.NewReader(data), expected.Hashes); err != nil && len(expected.Hashes) > 0 {
+			return fmt.Errorf("TUF %s metadata does not match: %v", role, err)
+		}
+	}
+	var envelope tufEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid TUF %s metadata: %v", role, err)
+	}
+	if err := v.root.verify(&envelope, role); err != nil {
+		return err
+	}
+	var header tufHeader
+	if err := json.Unmarshal(envelope.Signed, &header); err != nil {
+		return fmt.Errorf("invalid TUF %s metadata: %v", role, err)
+	}
+	if header.Type != role {
+		return fmt.Errorf("invalid TUF %s metadata type %q", role, header.Type)
+	}
+	if expected != nil && expected.Version != 0 && header.Version != expected.Version {
+		return fmt.Errorf("TUF %s metadata version %d, expected %d", role, header.Version, expected.Version)
+	}
+	if header.Version < v.versions[role] {
+		return fmt.Errorf("TUF %s metadata version %d is rolled back from %d", role, header.Version, v.versions[role])
+	}
+	if err := v.checkExpires(&header, role); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(envelope.Signed, signed); err != nil {
+		return fmt.Errorf("invalid TUF %s metadata: %v", role, err)
+	}
+	return nil
+}
+
+// read reads the metadata file from the local directory or the base URL of the repository.
+func (v *TUFVerifier) read(name string) ([]byte, error) {
+	u, err := url.Parse(v.repository)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		file, err := os.Open(filepath.Join(v.repository, name))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readTUFMetadata(file)
+	}
+	link := strings.TrimSuffix(v.repository, "/") + "/" + name
+	if err := checkLink(link, v.server.AllowList); err != nil {
+		return nil, err
+	}
+	response, err := requestDownload(link, 0, v.server)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %v", ErrBadStatus, response.StatusCode)
+	}
+	return readTUFMetadata(response.Body)
+}
+
+// checkExpires verifies that the metadata of the role is not expired.
+func (v *TUFVerifier) checkExpires(header *tufHeader, role string) error {
+	if !clock.Now().Before(header.Expires) {
+		return fmt.Errorf("TUF %s metadata is expired at %v", role, header.Expires)
+	}
+	return nil
+}
+
+// verify verifies that the metadata is signed by the threshold of distinct keys of the role.
+func (r *tufRoot) verify(envelope *tufEnvelope, role string) error {
+	keys, ok := r.Roles[role]
+	if !ok || keys.Threshold < 1 {
+		return fmt.Errorf("TUF root metadata has no valid %s role", role)
+	}
+	data, err := canonicalJSON(envelope.Signed)
+	if err != nil {
+		return fmt.Errorf("invalid TUF %s metadata: %v", role, err)
+	}
+	allowed := map[string]bool{}
+	for _, id := range keys.KeyIDs {
+		allowed[id] = true
+	}
+	valid := map[string]bool{}
+	for _, signature := range envelope.Signatures {
+		key, ok := r.Keys[signature.KeyID]
+		if !ok || !allowed[signature.KeyID] || valid[signature.KeyID] {
+			continue
+		}
+		sig, err := hex.DecodeString(signature.Sig)
+		if err == nil && key.verify(data, sig) {
+			valid[signature.KeyID] = true
+		}
+	}
+	if len(valid) < keys.Threshold {
+		return fmt.Errorf("TUF %s metadata has %d valid signatures, %d required", role, len(valid), keys.Threshold)
+	}
+	return nil
+}
+
+// verify verifies the signature of the data. Ed25519, ECDSA P-256 with SHA-256 and RSASSA-PSS with SHA-256 keys
+// are supported.
+func (k tufKey) verify(data []byte, sig []byte) bool {
+	if k.KeyType == "ed25519" {
+		public, err := hex.DecodeString(k.KeyVal.Public)
+		return err == nil && len(public) == ed25519.PublicKeySize && ed25519.Verify(public, data, sig)
+	}
+	key, err := ParsePublicKey([]byte(k.KeyVal.Public))
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(data)
+	switch public := key.(type) {
+	case *ecdsa.PublicKey:
+		return k.Scheme == "ecdsa-sha2-nistp256" && ecdsa.VerifyASN1(public, digest[:], sig)
+	case *rsa.PublicKey:
+		return k.Scheme == "rsassa-pss-sha256" && rsa.VerifyPSS(public, crypto.SHA256, digest[:], sig, nil) == nil
+	default:
+		return false
+	}
+}
+
+// canonicalJSON returns the canonical JSON form of the signed metadata: sorted keys without insignificant whitespace.
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// checkTUFHashes verifies the data against all supported hashes, SHA-256 and SHA-512, at least one of them is
+// required.
+func checkTUFHashes(data io.Reader, expected map[string]string) error {
+	hashes := map[string]hash.Hash{}
+	var writers []io.Writer
+	for algorithm := range expected {
+		var h hash.Hash
+		switch algorithm {
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			continue
+		}
+		hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+	if len(hashes) == 0 {
+		return errors.New("no supported hash, sha256 or sha512, is given")
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), data); err != nil {
+		return err
+	}
+	for algorithm, h := range hashes {
+		if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, expected[algorithm]) {
+			return fmt.Errorf("%s hash %s, expected %s", algorithm, actual, expected[algorithm])
+		}
+	}
+	return nil
+}
+
+// readTUFMetadata reads the metadata file, up to the maximal metadata size.
+func readTUFMetadata(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxTUFMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTUFMetadataSize {
+		return nil, fmt.Errorf("metadata exceeds %d bytes", maxTUFMetadataSize)
+	}
+	return data, nil
+}
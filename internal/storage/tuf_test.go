@@ -0,0 +1,237 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tufRoles = []string{"root", "timestamp", "snapshot", "targets"}
+
+// tufRepository publishes TUF metadata, signed with a key per role, for the tests.
+type tufRepository struct {
+	keys map[string]ed25519.PrivateKey
+}
+
+// tufOptions alter the published metadata, e.g. to not match the signed targets.
+type tufOptions struct {
+	version         int
+	target          string
+	targetData      string
+	snapshotVersion int
+	targetsHash     string
+	targetsKey      string
+	expired         bool
+}
+
+func newTUFRepository(t *testing.T) *tufRepository {
+	repo := &tufRepository{keys: map[string]ed25519.PrivateKey{}}
+	for _, role := range tufRoles {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		repo.keys[role] = key
+	}
+	return repo
+}
+
+func (r *tufRepository) sign(t *testing.T, signed map[string]interface{}, role string) []byte {
+	raw, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatalf("failed to marshal metadata: %v", err)
+	}
+	canonical, err := canonicalJSON(raw)
+	if err != nil {
+		t.Fatalf("failed to canonicalize metadata: %v", err)
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"signed": json.RawMessage(raw), "signatures": []map[string]string{
+		{"keyid": role + "-key", "sig": hex.EncodeToString(ed25519.Sign(r.keys[role], canonical))},
+	}}, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to marshal metadata: %v", err)
+	}
+	return data
+}
+
+func (r *tufRepository) root(t *testing.T) []byte {
+	keys, roles := map[string]interface{}{}, map[string]interface{}{}
+	for _, role := range tufRoles {
+		keys[role+"-key"] = map[string]interface{}{"keytype": "ed25519", "scheme": "ed25519",
+			"keyval": map[string]string{"public": hex.EncodeToString(r.keys[role].Public().(ed25519.PublicKey))}}
+		roles[role] = map[string]interface{}{"keyids": []string{role + "-key"}, "threshold": 1}
+	}
+	return r.sign(t, map[string]interface{}{"_type": "root", "version": 1, "expires": tufExpires(false),
+		"keys": keys, "roles": roles}, "root")
+}
+
+func (r *tufRepository) publish(t *testing.T, dir string, options tufOptions) {
+	if options.version == 0 {
+		options.version = 1
+	}
+	if options.target == "" {
+		options.target = "app.bin"
+	}
+	hash := sha256.Sum256([]byte(options.targetData))
+	targetsKey := options.targetsKey
+	if targetsKey == "" {
+		targetsKey = "targets"
+	}
+	targets := r.sign(t, map[string]interface{}{"_type": "targets", "version": options.version,
+		"expires": tufExpires(false), "targets": map[string]interface{}{
+			options.target: map[string]interface{}{"length": len(options.targetData),
+				"hashes": map[string]string{"sha256": hex.EncodeToString(hash[:])}},
+		}}, targetsKey)
+	targetsHash := sha256.Sum256(targets)
+	if options.targetsHash == "" {
+		options.targetsHash = hex.EncodeToString(targetsHash[:])
+	}
+	snapshot := r.sign(t, map[string]interface{}{"_type": "snapshot", "version": options.version,
+		"expires": tufExpires(false), "meta": map[string]interface{}{
+			"targets.json": map[string]interface{}{"version": options.version, "hashes": map[string]string{"sha256": options.targetsHash}},
+		}}, "snapshot")
+	if options.snapshotVersion == 0 {
+		options.snapshotVersion = options.version
+	}
+	timestamp := r.sign(t, map[string]interface{}{"_type": "timestamp", "version": options.version,
+		"expires": tufExpires(options.expired), "meta": map[string]interface{}{
+			"snapshot.json": map[string]interface{}{"version": options.snapshotVersion, "length": len(snapshot)},
+		}}, "timestamp")
+	for name, data := range map[string][]byte{"targets.json": targets, "snapshot.json": snapshot, "timestamp.json": timestamp} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatalf("failed to write metadata: %v", err)
+		}
+	}
+}
+
+func tufExpires(expired bool) string {
+	if expired {
+		return time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	}
+	return time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+}
+
+// TestTUFVerifier tests the verification of the artifacts against valid TUF metadata and against metadata or targets,
+// which do not match.
+func TestTUFVerifier(t *testing.T) {
+	body := "signed target content"
+	repo := newTUFRepository(t)
+	tests := map[string]struct {
+		options tufOptions
+		err     string
+	}{
+		"valid":           {options: tufOptions{targetData: body}},
+		"targetMismatch":  {options: tufOptions{targetData: strings.ToUpper(body)}, err: "does not match signed TUF target"},
+		"lengthMismatch":  {options: tufOptions{targetData: body + "!"}, err: "signed TUF target has"},
+		"notTarget":       {options: tufOptions{targetData: body, target: "other.bin"}, err: "is not a signed TUF target"},
+		"snapshotVersion": {options: tufOptions{targetData: body, snapshotVersion: 2}, err: "snapshot metadata version 1, expected 2"},
+		"targetsMismatch": {options: tufOptions{targetData: body, targetsHash: strings.Repeat("0", 64)}, err: "targets metadata does not match"},
+		"targetsKey":      {options: tufOptions{targetData: body, targetsKey: "snapshot"}, err: "targets metadata has 0 valid signatures"},
+		"expired":         {options: tufOptions{targetData: body, expired: true}, err: "timestamp metadata is expired"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			repo.publish(t, dir, test.options)
+			verifier, err := NewTUFVerifier(repo.root(t), dir, ServerConfig{})
+			if err != nil {
+				t.Fatalf("failed to create TUF verifier: %v", err)
+			}
+			err = verifier.Verify(&Artifact{FileName: "app.bin"}, strings.NewReader(body), int64(len(body)))
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("failed to verify signed target: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got: %v", test.err, err)
+			}
+		})
+	}
+}
+
+// TestTUFVerifierRoot tests that the trusted root metadata must be signed by its root keys.
+func TestTUFVerifierRoot(t *testing.T) {
+	repo := newTUFRepository(t)
+	root := repo.root(t)
+	if _, err := NewTUFVerifier(root, t.TempDir(), ServerConfig{}); err != nil {
+		t.Fatalf("failed to create TUF verifier: %v", err)
+	}
+	tampered := strings.Replace(string(root), `"version": 1`, `"version": 2`, 1)
+	if _, err := NewTUFVerifier([]byte(tampered), t.TempDir(), ServerConfig{}); err == nil ||
+		!strings.Contains(err.Error(), "root metadata has 0 valid signatures") {
+		t.Fatalf("tampered TUF root metadata is trusted: %v", err)
+	}
+	if _, err := NewTUFVerifier([]byte("{}"), t.TempDir(), ServerConfig{}); err == nil {
+		t.Fatal("invalid TUF root metadata is trusted")
+	}
+}
+
+// TestDownloadTUFVerifier tests the download of artifacts, verified with the metadata of a remote TUF repository,
+// after their checksum, and that rolled back metadata is rejected.
+func TestDownloadTUFVerifier(t *testing.T) {
+	body := "downloaded signed target"
+	metadata := t.TempDir()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/metadata/") {
+			http.ServeFile(w, r, filepath.Join(metadata, strings.TrimPrefix(r.URL.Path, "/metadata/")))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	repo := newTUFRepository(t)
+	verifier, err := NewTUFVerifier(repo.root(t), srv.URL+"/metadata", ServerConfig{})
+	if err != nil {
+		t.Fatalf("failed to create TUF verifier: %v", err)
+	}
+	hash := sha256.Sum256([]byte(body))
+	art := &Artifact{FileName: "app.bin", Size: len(body), Link: srv.URL + "/app.bin", HashType: "SHA256",
+		HashValue: hex.EncodeToString(hash[:])}
+	dir := t.TempDir()
+	server := ServerConfig{Verify: verifier.Verify}
+
+	// 1. Download a signed target.
+	repo.publish(t, metadata, tufOptions{version: 2, targetData: body})
+	if err := downloadArtifact(OSFileSystem{}, filepath.Join(dir, "1.bin"), art, nil, server, 0, 0, nil, make(chan struct{})); err != nil {
+		t.Fatalf("failed to download signed target: %v", err)
+	}
+
+	// 2. Reject an artifact with a valid checksum, not matching the signed target.
+	repo.publish(t, metadata, tufOptions{version: 3, targetData: "other content"})
+	err = downloadArtifact(OSFileSystem{}, filepath.Join(dir, "2.bin"), art, nil, server, 0, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrArtifactInvalid) {
+		t.Fatalf("expected invalid artifact error: %v", err)
+	}
+
+	// 3. Reject rolled back metadata.
+	repo.publish(t, metadata, tufOptions{version: 1, targetData: body})
+	err = downloadArtifact(OSFileSystem{}, filepath.Join(dir, "3.bin"), art, nil, server, 0, 0, nil, make(chan struct{}))
+	if !errors.Is(err, ErrArtifactInvalid) || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("expected rolled back metadata error: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/internal/logger"
+)
+
+// Upload sends the data to the link with the given HTTP method and content type, using the certificate,
+// authorization token, connection settings and allow list of the artifact downloads. Failed uploads are
+// retried retryCount times, each attempt sending the whole data. Closing the done channel stops the retries
+// with ErrCancel.
+func Upload(link string, method string, contentType string, data []byte, server ServerConfig, retryCount int,
+	retryInterval time.Duration, done chan struct{}) error {
+	if err := checkLink(link, server.AllowList); err != nil {
+		logger.Errorf("upload link is not in the download allow list: %s", RedactLink(link))
+		return err
+	}
+	for {
+		err := upload(link, method, contentType, data, server)
+		if err == nil {
+			logger.Infof("uploaded %d bytes to %s", len(data), RedactLink(link))
+			return nil
+		}
+		if !isRetryable(err) || retryCount <= 0 {
+			return err
+		}
+		retryCount--
+		logger.Errorf("error uploading to %s, remaining attempts - %d, cause: %v", RedactLink(link), retryCount, err)
+		logger.Infof("%v timeout until next attempt", retryInterval)
+		select {
+		case <-done:
+			return ErrCancel
+		case <-clock.After(retryInterval):
+		}
+	}
+}
+
+func upload(link string, method string, contentType string, data []byte, server ServerConfig) error {
+	request, err := http.NewRequest(method, link, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error doing http(s) request to %s", RedactLink(link))
+	}
+	request.Header.Set("Content-Type", contentType)
+	authorize(request, server)
+	client, err := httpClient(request.URL, server)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	// HTTP Status code is NOT in the 2xx range
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: %v", ErrBadStatus, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestUpload tests the upload of data with the configured method, content type and authorization.
+func TestUpload(t *testing.T) {
+	var method, contentType, auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, contentType, auth, body = r.Method, r.Header.Get("Content-Type"), r.Header.Get("Authorization"), string(data)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	if err := Upload(srv.URL+"/report/install.log", http.MethodPut, "text/plain", []byte("install output"),
+		ServerConfig{AuthToken: "token"}, 0, 0, nil); err != nil {
+		t.Fatalf("fail to upload: %v", err)
+	}
+	if method != http.MethodPut || contentType != "text/plain" || auth != "Bearer token" || body != "install output" {
+		t.Fatalf("unexpected upload request: %s, %s, %s, %s", method, contentType, auth, body)
+	}
+}
+
+// TestUploadRetry tests that failed uploads are retried, until they succeed or the retries are exhausted.
+func TestUploadRetry(t *testing.T) {
+	var requests, failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if data, _ := io.ReadAll(r.Body); string(data) != "install output" {
+			t.Errorf("unexpected upload body: %s", data)
+		}
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// 1. Retried, then succeeded.
+	fake := useFakeClock(t)
+	failures = 2
+	if err := Upload(srv.URL+"/install.log", http.MethodPost, "text/plain", []byte("install output"),
+		ServerConfig{}, 2, time.Minute, nil); err != nil {
+		t.Fatalf("fail to upload with retries: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("unexpected upload attempts: %d", requests)
+	}
+	if waits := fake.Waits(); !reflect.DeepEqual(waits, []time.Duration{time.Minute, time.Minute}) {
+		t.Fatalf("unexpected retry intervals: %v", waits)
+	}
+
+	// 2. Retries exhausted.
+	requests, failures = 0, 3
+	if err := Upload(srv.URL+"/install.log", http.MethodPost, "text/plain", []byte("install output"),
+		ServerConfig{}, 2, 0, nil); !errors.Is(err, ErrBadStatus) {
+		t.Fatalf("expected bad status error: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("unexpected upload attempts: %d", requests)
+	}
+}
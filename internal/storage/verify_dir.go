@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+const (
+	// FileIntact is the verification status of a listed file, matching its manifest digest.
+	FileIntact = "intact"
+	// FileCorrupt is the verification status of a listed file, not matching its manifest digest.
+	FileCorrupt = "corrupt"
+	// FileMissing is the verification status of a listed file, not available in the directory.
+	FileMissing = "missing"
+	// FileError is the verification status of a listed file, which cannot be read.
+	FileError = "error"
+)
+
+// DirReport is the report of the files in a storage directory, verified against a manifest.
+type DirReport struct {
+	Dir        string        `json:"dir"`
+	Files      []*FileReport `json:"files"`
+	Passed     int           `json:"passed"`
+	Failed     int           `json:"failed"`
+	DurationMs int64         `json:"durationMs"`
+}
+
+// FileReport is the verification status of a file, listed in the manifest.
+type FileReport struct {
+	FileName   string `json:"fileName"`
+	Status     string `json:"status"`
+	Size       int64  `json:"size"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// OK reports whether all listed files are intact.
+func (r *DirReport) OK() bool {
+	return r.Failed == 0
+}
+
+// VerifyDir verifies the files in the directory against the SHA-256 digests of the manifest, in the format of the
+// module manifests, e.g. to check the staged artifacts on a device. The files are hashed as they are read, at most
+// concurrency at the same time, or one by one, if not positive. The report lists the files in their name order.
+// An error is returned only for an invalid manifest or a missing directory, the failed files are reported instead.
+func VerifyDir(dir string, manifest []byte, concurrency int) (*DirReport, error) {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("storage directory %s is not available", dir)
+	}
+	digests, err := parseManifest(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifestInvalid, err)
+	}
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	started := time.Now()
+	report := &DirReport{Dir: dir, Files: make([]*FileReport, len(names))}
+	limit := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, name string) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			report.Files[i] = verifyListedFile(dir, name, digests[name])
+		}(i, name)
+	}
+	wg.Wait()
+	for _, file := range report.Files {
+		if file.Status == FileIntact {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+	}
+	report.DurationMs = time.Since(started).Milliseconds()
+	return report, nil
+}
+
+// verifyListedFile streams the listed file through the artifact validation with its manifest digest.
+func verifyListedFile(dir string, name string, digest string) *FileReport {
+	started := time.Now()
+	report := &FileReport{FileName: name}
+	defer func() {
+		report.DurationMs = time.Since(started).Milliseconds()
+	}()
+
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(clean, "\\") {
+		report.Status, report.Error = FileError, "path must be relative to the storage directory"
+		return report
+	}
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(clean)))
+	if err != nil {
+		report.Status = FileError
+		if os.IsNotExist(err) {
+			report.Status = FileMissing
+		}
+		report.Error = err.Error()
+		return report
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil {
+		report.Size = info.Size()
+	}
+
+	artifact := &Artifact{FileName: name, HashType: string(hawkbit.SHA256), HashValue: digest, HashEncoding: HashEncodingHex}
+	switch err = validateData(file, artifact); {
+	case err == nil:
+		report.Status = FileIntact
+	case errors.Is(err, ErrChecksumMismatch):
+		report.Status, report.Error = FileCorrupt, err.Error()
+	default:
+		report.Status, report.Error = FileError, err.Error()
+	}
+	return report
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifyDir tests the verification of a storage directory with intact, corrupt and missing files.
+func TestVerifyDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.bin":         "application binary",
+		"0/config.json":   "{}",
+		"1/firmware.img":  "firmware image",
+		"1/corrupted.bin": "original content",
+	}
+	var manifest strings.Builder
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		fmt.Fprintf(&manifest, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+		if name == "1/firmware.img" {
+			continue // missing
+		}
+		if name == "1/corrupted.bin" {
+			content = "modified content"
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest.WriteString("0000000000000000000000000000000000000000000000000000000000000000  ../escape.bin\n")
+
+	for _, concurrency := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("concurrency-%d", concurrency), func(t *testing.T) {
+			report, err := VerifyDir(dir, []byte(manifest.String()), concurrency)
+			if err != nil {
+				t.Fatalf("failed to verify directory: %v", err)
+			}
+			expected := map[string]string{
+				"0/config.json":   FileIntact,
+				"1/corrupted.bin": FileCorrupt,
+				"1/firmware.img":  FileMissing,
+				"../escape.bin":   FileError,
+				"app.bin":         FileIntact,
+			}
+			if len(report.Files) != len(expected) || report.Passed != 2 || report.Failed != 3 || report.OK() {
+				t.Fatalf("unexpected report: %+v", report)
+			}
+			for i, file := range report.Files {
+				if i > 0 && report.Files[i-1].FileName > file.FileName {
+					t.Errorf("files are not reported in their name order: %s", file.FileName)
+				}
+				if file.Status != expected[file.FileName] {
+					t.Errorf("unexpected status of %s: %s != %s (%s)", file.FileName, file.Status, expected[file.FileName], file.Error)
+				}
+				if (file.Error == "") != (file.Status == FileIntact) {
+					t.Errorf("unexpected error of %s: %s", file.FileName, file.Error)
+				}
+			}
+		})
+	}
+
+	// Invalid manifest and missing directory.
+	if _, err := VerifyDir(dir, []byte("not a digest app.bin"), 1); !errors.Is(err, ErrManifestInvalid) {
+		t.Errorf("expected invalid manifest error: %v", err)
+	}
+	if _, err := VerifyDir(filepath.Join(dir, "missing"), []byte(manifest.String()), 1); err == nil {
+		t.Error("expected missing directory error")
+	}
+}
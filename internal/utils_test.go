@@ -22,11 +22,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/eclipse-kanto/software-update/hawkbit"
 	"github.com/eclipse-kanto/software-update/internal/storage"
+	"github.com/eclipse-kanto/software-update/util/testutil"
 	"github.com/eclipse/ditto-clients-golang"
 	"github.com/eclipse/ditto-clients-golang/model"
 	"github.com/eclipse/ditto-clients-golang/protocol"
@@ -45,6 +47,7 @@ const (
 	flagCert            = "cert"
 	flagKey             = "key"
 	flagStorageLocation = "storageLocation"
+	flagThingID         = "thingId"
 	flagFeatureID       = "featureId"
 	flagModuleType      = "moduleType"
 	flagArtifactType    = "artifactType"
@@ -58,6 +61,8 @@ const (
 	flagRetryCount      = "downloadRetryCount"
 	flagRetryInterval   = "downloadRetryInterval"
 	flagInstallDirs     = "installDirs"
+	flagStatusQoS       = "statusQos"
+	flagCommandsQoS     = "commandsQos"
 	flagVersion         = "version"
 )
 
@@ -143,6 +148,28 @@ func mockScriptBasedSoftwareUpdatable(t *testing.T, tc *testConfig) (*ScriptBase
 	return feature, mc, nil
 }
 
+// mockOperationFeature returns a connected feature, with a temporary storage and lax local artifacts access, and
+// the fixture of the local artifacts of its operations. The feature is disconnected at the end of the test.
+func mockOperationFeature(t *testing.T) (*ScriptBasedSoftwareUpdatable, *mockedClient, *testutil.Fixture) {
+	fx := testutil.NewFixture(t)
+	feature, mc, err := mockScriptBasedSoftwareUpdatable(t, &testConfig{
+		clientConnected: true, featureID: NewDefaultConfig().FeatureID, storageLocation: t.TempDir(), mode: modeLax})
+	if err != nil {
+		t.Fatalf("failed to initialize ScriptBasedSoftwareUpdatable: %v", err)
+	}
+	t.Cleanup(func() { feature.Disconnect(true) })
+	return feature, mc, fx
+}
+
+// installAction returns an operation with the given correlation ID, running the install script of the fixture.
+func installAction(fx *testutil.Fixture, cid string) *hawkbit.SoftwareUpdateAction {
+	sua := prepareSoftwareUpdateAction([]*hawkbit.SoftwareArtifactAction{
+		convertLocalArtifact(fx.Install, "install.sh", fx.InstallHash, len(fx.InstallBody)),
+	}, "*")
+	sua.CorrelationID = cid
+	return sua
+}
+
 // mockSoftwareUpdatable create new mocked SoftwareUpdatable with mocked MQTT client.
 func mockSoftwareUpdatable(t *testing.T, cfg *hawkbit.Configuration, tc *testConfig) (*hawkbit.SoftwareUpdatable, *mockedClient) {
 	// Create mocked Ditto and MQTT clients.
@@ -169,6 +196,7 @@ func mockSoftwareUpdatable(t *testing.T, cfg *hawkbit.Configuration, tc *testCon
 func mockMqttClient(tc *testConfig) *mockedClient {
 	return &mockedClient{
 		payload:   make(chan interface{}, 1),
+		feature:   make(chan interface{}, 10),
 		connected: tc.clientConnected,
 	}
 }
@@ -177,7 +205,12 @@ func mockMqttClient(tc *testConfig) *mockedClient {
 type mockedClient struct {
 	err       error
 	payload   chan interface{}
+	feature   chan interface{}
 	connected bool
+
+	lock          sync.Mutex
+	commandsTopic string
+	commands      mqtt.MessageHandler
 }
 
 func (client *mockedClient) pullLastOperationStatus() map[string]interface{} {
@@ -194,6 +227,38 @@ func (client *mockedClient) pullLastOperationStatus() map[string]interface{} {
 	return nil
 }
 
+func (client *mockedClient) pullFeatureStatus() map[string]interface{} {
+	select {
+	case value := <-client.feature:
+		// Get feature status map.
+		if feature, ok := value.(map[string]interface{}); ok {
+			if properties, ok := feature["properties"].(map[string]interface{}); ok {
+				status, _ := properties["status"].(map[string]interface{})
+				return status
+			}
+		}
+	case <-time.After(10 * time.Second):
+		// Fail after the timeout.
+		return nil
+	}
+	return nil
+}
+
+// sendCommand delivers the command to the subscribed commands handler, as received with a request identifier.
+func (client *mockedClient) sendCommand(t *testing.T, env *protocol.Envelope) {
+	client.lock.Lock()
+	topic, commands := client.commandsTopic, client.commands
+	client.lock.Unlock()
+	if commands == nil {
+		t.Fatal("no subscription for the commands")
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("failed to marshal command: %v", err)
+	}
+	commands(client, &testMessage{topic: strings.TrimSuffix(topic, "#") + "request-id/" + string(env.Topic.Action), payload: payload})
+}
+
 // IsConnected returns true.
 func (client *mockedClient) IsConnected() bool {
 	return client.connected
@@ -214,7 +279,7 @@ func (client *mockedClient) Disconnect(quiesce uint) {
 	// Do nothing.
 }
 
-// Publish returns finished token and store lastOperation if found.
+// Publish returns finished token and store the feature or lastOperation if found.
 func (client *mockedClient) Publish(topic string, qos byte, retained bool, payload interface{}) (token mqtt.Token) {
 	token = &mockedToken{err: client.err}
 	// Convert the payload to ditto envelop.
@@ -226,6 +291,14 @@ func (client *mockedClient) Publish(topic string, qos byte, retained bool, paylo
 	if env.Topic.Namespace != testTopicNamespace || env.Topic.EntityID != testTopicEntryID {
 		return token
 	}
+	// Store the whole feature, if announced.
+	if env.Path == "/features/SoftwareUpdatable" {
+		select {
+		case client.feature <- env.Value:
+		default:
+		}
+		return token
+	}
 	// Validate its starting path.
 	if !strings.HasPrefix(env.Path, "/features/SoftwareUpdatable/properties/status/lastOperation") {
 		return token
@@ -235,8 +308,13 @@ func (client *mockedClient) Publish(topic string, qos byte, retained bool, paylo
 	return token
 }
 
-// Subscribe returns finished token.
+// Subscribe returns finished token and stores the commands handler.
 func (client *mockedClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	if callback != nil {
+		client.lock.Lock()
+		client.commandsTopic, client.commands = topic, callback
+		client.lock.Unlock()
+	}
 	return &mockedToken{err: client.err}
 }
 
@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package feature
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/eclipse-kanto/software-update/internal/storage"
+)
+
+// defaultVerifyConcurrency is the default number of files, verified at the same time by the storage verification.
+const defaultVerifyConcurrency = 4
+
+// VerifyStorage verifies the files in the verified directory, the storage location by default, against the SHA-256
+// digests of the verified manifest and prints the status of each listed file and a summary to the writer.
+// It reports whether all listed files are intact.
+func (cfg *BasicConfig) VerifyStorage(out io.Writer) (bool, error) {
+	manifest, err := os.ReadFile(cfg.VerifyManifest)
+	if err != nil {
+		return false, fmt.Errorf("failed to read manifest %s: %v", cfg.VerifyManifest, err)
+	}
+	dir := cfg.VerifyDir
+	if dir == "" {
+		dir = cfg.StorageLocation
+	}
+	report, err := storage.VerifyDir(dir, manifest, cfg.VerifyConcurrency)
+	if err != nil {
+		return false, err
+	}
+	for _, file := range report.Files {
+		result := "PASS"
+		if file.Status != storage.FileIntact {
+			result = "FAIL"
+		}
+		fmt.Fprintf(out, "%s %s [%s, %d bytes, %d ms]", result, file.FileName, file.Status, file.Size, file.DurationMs)
+		if file.Error != "" {
+			fmt.Fprintf(out, ": %s", file.Error)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "%d of %d files in %s passed, %d failed in %d ms\n", report.Passed, len(report.Files), report.Dir,
+		report.Failed, report.DurationMs)
+	return report.OK(), nil
+}
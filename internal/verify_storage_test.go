@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package feature
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifyStorage tests the pass/fail report of the storage verification against a manifest file.
+func TestVerifyStorage(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("intact"))
+	manifest := filepath.Join(t.TempDir(), "manifest.sha256")
+	if err := os.WriteFile(manifest, []byte(hex.EncodeToString(sum[:])+"  intact.bin\n"+
+		hex.EncodeToString(sum[:])+"  corrupt.bin\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "intact.bin"), []byte("intact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewDefaultConfig()
+	cfg.StorageLocation = dir
+	cfg.VerifyManifest = manifest
+
+	// 1. Report the corrupt and the missing file.
+	var out strings.Builder
+	ok, err := cfg.VerifyStorage(&out)
+	if err != nil || ok {
+		t.Fatalf("expected failed verification: %v", err)
+	}
+	for _, expected := range []string{"FAIL corrupt.bin [missing", "PASS intact.bin [intact, 6 bytes", "1 of 2 files in " + dir + " passed, 1 failed"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("missing %q in report:\n%s", expected, out.String())
+		}
+	}
+
+	// 2. Pass with the intact file.
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.bin"), []byte("intact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := cfg.VerifyStorage(&out); err != nil || !ok {
+		t.Fatalf("expected passed verification: %v", err)
+	}
+
+	// 3. Fail with a missing manifest.
+	cfg.VerifyManifest = filepath.Join(dir, "missing.sha256")
+	if _, err := cfg.VerifyStorage(&out); err == nil {
+		t.Fatal("expected missing manifest error")
+	}
+}
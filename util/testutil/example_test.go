@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build unit
+
+package testutil_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/util/testutil"
+)
+
+// ExampleHTTPServer shows how to serve an artifact and simulate a misbehaving artifacts server.
+func ExampleHTTPServer() {
+	srv := testutil.NewHTTPServer("", "test.txt", 10, &testing.T{})
+	srv.Host(false, false, "", "")
+	defer srv.Close()
+
+	get := func() {
+		resp, err := http.Get(srv.URL("test.txt"))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		fmt.Printf("%d %q %v\n", resp.StatusCode, body, err)
+	}
+
+	srv.SetBehavior(testutil.Behavior{BadStatusCount: 1})
+	get()
+	get()
+	srv.SetBehavior(testutil.Behavior{Truncate: true})
+	get()
+	srv.SetBehavior(testutil.Behavior{Corrupt: true})
+	get()
+	// Output:
+	// 400 "" <nil>
+	// 200 "1111111111" <nil>
+	// 200 "111" unexpected EOF
+	// 200 "0111111111" <nil>
+}
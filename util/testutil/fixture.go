@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package testutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Fixture is a temporary directory with the local artifacts of an operation test, including an install script,
+// which appends a line to the installed file of the directory on each run.
+type Fixture struct {
+	// Dir is the temporary directory of the fixture, removed at the end of the test.
+	Dir string
+	// Installed is the path of the file, appended by the install script.
+	Installed string
+	// Install is the path of the install script.
+	Install string
+	// InstallHash is the SHA256 hash of the install script.
+	InstallHash string
+	// InstallBody is the content of the install script.
+	InstallBody string
+}
+
+// NewFixture creates a new fixture in a temporary directory of the test. The test is skipped on windows, where
+// the install script is not supported.
+func NewFixture(t testing.TB) *Fixture {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("install script is not supported on windows")
+	}
+	fx := &Fixture{Dir: t.TempDir()}
+	fx.Installed = filepath.Join(fx.Dir, "installed")
+	fx.InstallBody = "echo installed >> " + fx.Installed
+	fx.Install, fx.InstallHash = fx.AddFile(t, "install.sh", fx.InstallBody)
+	return fx
+}
+
+// AddFile writes a file with the given name and content to the fixture directory and returns its path and
+// SHA256 hash.
+func (fx *Fixture) AddFile(t testing.TB, name string, body string) (string, string) {
+	t.Helper()
+
+	path := filepath.Join(fx.Dir, name)
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("failed to write fixture file %s: %v", path, err)
+	}
+	hash := sha256.Sum256([]byte(body))
+	return path, hex.EncodeToString(hash[:])
+}
+
+// Installs returns the number of the install script runs.
+func (fx *Fixture) Installs() int {
+	data, _ := os.ReadFile(fx.Installed)
+	return strings.Count(string(data), "installed")
+}
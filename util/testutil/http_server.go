@@ -0,0 +1,316 @@
+// Copyright (c) 2022 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+// Package testutil provides a fake artifacts download server and local artifacts fixtures for the tests of the
+// software update and its consumers.
+package testutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse-kanto/software-update/hawkbit"
+)
+
+// Behavior is the incorrect behavior of the test HTTP server, simulating a misbehaving artifacts server.
+type Behavior struct {
+	// BadStatusCount is the number of the next requests, failed with bad request status.
+	BadStatusCount int
+	// Truncate sends only the first third of the requested content.
+	Truncate bool
+	// Corrupt sends corrupted content.
+	Corrupt bool
+}
+
+// HTTPServer is a test HTTP(S) server, serving an artifact with the given name and size, with or without
+// range requests support, and install scripts or other artifacts with fixed content, registered as aliases.
+type HTTPServer struct {
+	name     string
+	size     int64
+	srv      *http.Server
+	mux      *http.ServeMux
+	listener net.Listener
+	t        testing.TB
+
+	lock     sync.Mutex
+	behavior Behavior
+	aliases  map[string]string
+	secure   bool
+	addr     string
+}
+
+// NewHTTPServer initializes a test HTTP(S) server on the given address, without starting it. A free port is
+// used, if the address is empty.
+func NewHTTPServer(addr string, name string, size int64, t testing.TB) *HTTPServer {
+	if addr == "" {
+		addr = "localhost:0"
+	}
+	mux := http.NewServeMux()
+	return &HTTPServer{name: name, size: size, srv: &http.Server{Addr: addr, Handler: mux}, mux: mux, t: t,
+		aliases: map[string]string{}}
+}
+
+// Host starts the HTTP(S) server, serving the artifact with or without range requests support. The secure
+// server uses the given PEM encoded certificate and key files.
+func (w *HTTPServer) Host(simple bool, secure bool, cert string, key string) {
+	w.mux.Handle(fmt.Sprintf("/%s", w.name), w.Handler(simple))
+
+	listener, err := net.Listen("tcp", w.srv.Addr)
+	if err != nil {
+		w.t.Fatalf("failed to listen on %s: %v", w.srv.Addr, err)
+	}
+	w.listener = listener
+	addr := listener.Addr().(*net.TCPAddr)
+	w.lock.Lock()
+	w.secure = secure
+	w.addr = net.JoinHostPort("localhost", strconv.Itoa(addr.Port))
+	w.lock.Unlock()
+
+	// Start HTTP server in separate goroute.
+	go func() {
+		if secure {
+			err = w.srv.ServeTLS(listener, cert, key)
+		} else {
+			err = w.srv.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			w.t.Errorf("failed to start http server: %v", err)
+		}
+	}()
+}
+
+// Handler returns the handler of the artifact requests, e.g. to be served by a custom server.
+func (w *HTTPServer) Handler(simple bool) http.Handler {
+	if simple {
+		return http.HandlerFunc(w.handlerSimple)
+	}
+	return http.HandlerFunc(w.handlerRange)
+}
+
+// SetBehavior sets the incorrect behavior of the next artifact requests.
+func (w *HTTPServer) SetBehavior(behavior Behavior) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.behavior = behavior
+}
+
+// URL returns the link of the artifact or the alias with the given name on the started server, always
+// addressed as localhost to match the host name of the test certificates.
+func (w *HTTPServer) URL(name string) string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	scheme := hawkbit.HTTP
+	if w.secure {
+		scheme = hawkbit.HTTPS
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, w.addr, name)
+}
+
+// SetAlias serves the given content with the alias name, without range requests support.
+func (w *HTTPServer) SetAlias(alias string, body string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, ok := w.aliases[alias]; !ok {
+		w.mux.HandleFunc(fmt.Sprintf("/%s", alias), w.handlerAlias)
+	}
+	w.aliases[alias] = body
+}
+
+// AddInstallScript serves the test install script of the current platform.
+func (w *HTTPServer) AddInstallScript() {
+	w.SetAlias(InstallScript())
+}
+
+// InstallScript returns the name and content of the test install script for windows/linux.
+func InstallScript() (string, string) {
+	if runtime.GOOS == "windows" {
+		return "install.bat", "@echo off\n(\necho message=My final message!) > status\nping 127.0.0.1\n"
+	}
+	return "install.sh", "#!/bin/sh\necho 'message=My final message!\n' > status\nsleep 5\n"
+}
+
+// Close closes the HTTP(S) server.
+func (w *HTTPServer) Close() {
+	if err := w.srv.Shutdown(context.Background()); err != nil {
+		w.t.Errorf("failed shutdown of http server: %v", err)
+	}
+	// The listener is not tracked until the server goroutine is running, release the address immediately.
+	if w.listener != nil {
+		w.listener.Close()
+	}
+}
+
+// nextBehavior returns the incorrect behavior of the current request and whether it fails with bad status.
+func (w *HTTPServer) nextBehavior() (Behavior, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.behavior.BadStatusCount > 0 {
+		w.behavior.BadStatusCount--
+		return w.behavior, true
+	}
+	return w.behavior, false
+}
+
+// handlerAlias handles incoming HTTP requests of the aliases without range header support.
+func (w *HTTPServer) handlerAlias(writer http.ResponseWriter, request *http.Request) {
+	alias := request.URL.Path[strings.LastIndex(request.URL.Path, "/")+1:]
+	w.lock.Lock()
+	body := w.aliases[alias]
+	w.lock.Unlock()
+
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, alias))
+	writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	writer.Write([]byte(body))
+}
+
+// handlerRange handles incoming HTTP requests with range header support.
+func (w *HTTPServer) handlerRange(writer http.ResponseWriter, request *http.Request) {
+	behavior, badStatus := w.nextBehavior()
+	if badStatus {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Set default headers for txt file.
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, w.name))
+	writer.Header().Set("Accept-Ranges", "bytes")
+
+	// Parse range header -> 'Range: bytes=<begin>-<end>'
+	r := request.Header.Get("range")
+	if r == "" { // No range is defined
+		writer.Header().Set("Content-Length", strconv.Itoa(int(w.size)))
+		write(writer, w.size, behavior)
+		return
+	}
+
+	// Client requests a part of the file
+	var err error
+	begin, end := int64(0), int64(w.size-1)
+	r = r[6:] // Strip the "bytes=", left over is now "begin-end"
+	pos := strings.Index(r, "-")
+	if pos < 0 {
+		http.Error(writer, "invalid values for header 'Range'", http.StatusBadRequest)
+		return
+	}
+
+	// Parse begin part of the range
+	sb := r[:pos]
+	if len(sb) > 0 {
+		begin, err = strconv.ParseInt(sb, 10, 64)
+		if err != nil || begin > w.size {
+			http.Error(writer, "invalid values for header 'Range' begin", http.StatusBadRequest)
+		}
+	}
+
+	// Parse end part of the range
+	se := r[pos+1:]
+	if len(se) > 0 {
+		end, err = strconv.ParseInt(se, 10, 64)
+		if err != nil || end > w.size || begin >= end {
+			http.Error(writer, "invalid values for header 'Range' end", http.StatusBadRequest)
+		}
+	}
+
+	writer.Header().Set("Content-Length", strconv.FormatInt(end-begin+1, 10))
+	writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", begin, end, w.size))
+	writer.WriteHeader(http.StatusPartialContent)
+
+	// Send the (end-begin) amount of bytes to the client
+	write(writer, end-begin+1, behavior)
+}
+
+// handlerSimple handles incoming HTTP requests without range header support.
+func (w *HTTPServer) handlerSimple(writer http.ResponseWriter, request *http.Request) {
+	behavior, badStatus := w.nextBehavior()
+	if badStatus {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, w.name))
+	writer.Header().Set("Content-Length", strconv.Itoa(int(w.size)))
+	write(writer, w.size, behavior)
+}
+
+// WriteContent writes the artifact content of the given size, as served by the test HTTP server.
+func WriteContent(writer io.Writer, size int64) {
+	write(writer, size, Behavior{})
+}
+
+// write file content of the given size, truncated or corrupted according to the behavior.
+func write(writer io.Writer, size int64, behavior Behavior) {
+	if behavior.Truncate {
+		size /= 3
+	}
+	b := []byte("11111111111111111111111111111111111111111111111111")
+	if behavior.Corrupt {
+		b[0] = '0'
+	}
+	s := int64(len(b))
+	for i := s; i < size; i += s {
+		writer.Write(b)
+	}
+	remainder := size % s
+	writer.Write(b[:remainder])
+}
+
+// GenerateSoftwareArtifacts generates array of SoftwareArtifactAction based on the registered HTTP aliases with the given names.
+func (w *HTTPServer) GenerateSoftwareArtifacts(names ...string) []*hawkbit.SoftwareArtifactAction {
+	res := make([]*hawkbit.SoftwareArtifactAction, len(names))
+	for i, name := range names {
+		// If alias name is "install", add its file extension: Windows = .bat | Linux/Mac/etc = .sh
+		alias := name
+		if alias == "install" {
+			if runtime.GOOS == "windows" {
+				alias += ".bat"
+			} else {
+				alias += ".sh"
+			}
+		}
+		w.lock.Lock()
+		body := w.aliases[alias]
+		protocol := hawkbit.HTTP
+		if w.secure {
+			protocol = hawkbit.HTTPS
+		}
+		w.lock.Unlock()
+
+		// Calculate alias SHA256 hash
+		hType := sha256.New()
+		hType.Write([]byte(body))
+		hash := hex.EncodeToString(hType.Sum(nil))
+
+		// Create SoftwareArtifactAction for coresponding alias
+		res[i] = &hawkbit.SoftwareArtifactAction{
+			Filename: alias,
+			Download: map[hawkbit.Protocol]*hawkbit.Links{
+				protocol: {URL: w.URL(alias)},
+			},
+			Checksums: map[hawkbit.Hash]string{
+				hawkbit.SHA256: hash,
+			},
+			Size: len(body),
+		}
+	}
+	return res
+}
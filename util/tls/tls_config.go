@@ -13,43 +13,186 @@
 package tls
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/youmark/pkcs8"
 )
 
-// NewTLSConfig initializes the TLS.
+// Config defines the TLS configuration of a client connection.
+type Config struct {
+	// CACert is a PEM encoded CA certificates file or a directory with such files. The system
+	// certificates are used, if not set.
+	CACert string
+	// Cert and Key are the PEM encoded client certificate and private key files.
+	Cert string
+	Key  string
+	// KeyPassphrase decrypts the client private key, if it is encrypted.
+	KeyPassphrase string
+	// MinVersion is the minimal TLS version: 1.2 or 1.3. TLS 1.2 is used, if not set.
+	MinVersion string
+	// PinnedKeys are the base64 encoded SHA-256 hashes of the trusted server public keys (SPKI).
+	// The server certificate chain must contain at least one of them, if set.
+	PinnedKeys []string
+	// ServerName is the expected host name of the server certificate, also sent as SNI. The host name of
+	// the connection address is verified, if not set.
+	ServerName string
+	// InsecureSkipVerify disables the verification of the server certificate chain and host name. It is meant
+	// only for testing with self-signed certificates, as the connection is open to man-in-the-middle attacks.
+	InsecureSkipVerify bool
+}
+
+// NewTLSConfig creates a TLS configuration with the given CA certificate file, client certificate and key files.
 func NewTLSConfig(rootCert, cert, key string) (*tls.Config, error) {
-	fmt.Println("NewTLS CONFIG!", rootCert)
-	caCert, err := os.ReadFile(rootCert)
+	caCertPool, err := newCertPool(rootCert)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load CA: %s", err)
+		return nil, err
 	}
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to parse CA %s", rootCert)
+	tlsConfig, err := NewConfig(&Config{Cert: cert, Key: key})
+	if err != nil {
+		return nil, err
 	}
+	tlsConfig.RootCAs = caCertPool
+	return tlsConfig, nil
+}
 
+// NewConfig creates a TLS configuration from the given client connection configuration.
+func NewConfig(cfg *Config) (*tls.Config, error) {
+	minVersion, err := ParseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: false,
-		RootCAs:            caCertPool,
-		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         minVersion,
 		MaxVersion:         tls.VersionTLS13,
 		CipherSuites:       supportedCipherSuites(),
+		ServerName:         cfg.ServerName,
 	}
-
-	if len(cert) > 0 || len(key) > 0 {
-		cert, err := tls.LoadX509KeyPair(cert, key)
+	if len(cfg.CACert) > 0 {
+		if tlsConfig.RootCAs, err = newCertPool(cfg.CACert); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Cert) > 0 || len(cfg.Key) > 0 {
+		cert, err := loadKeyPair(cfg.Cert, cfg.Key, cfg.KeyPassphrase)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load X509 key pair: %s", err)
 		}
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-
+	if len(cfg.PinnedKeys) > 0 {
+		pins := make(map[string]bool, len(cfg.PinnedKeys))
+		for _, pin := range cfg.PinnedKeys {
+			if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("invalid public key pin %s, must be base64 encoded SHA-256 hash", pin)
+			}
+			pins[pin] = true
+		}
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPinnedKeys(cs.PeerCertificates, pins)
+		}
+	}
 	return tlsConfig, nil
 }
 
+// ParseVersion returns the TLS version identifier of 1.2 or 1.3. TLS 1.2 is returned for empty version.
+func ParseVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %s, must be 1.2 or 1.3", version)
+	}
+}
+
+// PublicKeyPin returns the base64 encoded SHA-256 hash of the certificate public key (SPKI).
+func PublicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func verifyPinnedKeys(certs []*x509.Certificate, pins map[string]bool) error {
+	for _, cert := range certs {
+		if pins[PublicKeyPin(cert)] {
+			return nil
+		}
+	}
+	return fmt.Errorf("server certificate chain does not contain any of the pinned public keys")
+}
+
+// loadKeyPair loads the client certificate and private key. An encrypted PKCS#8 private key is decrypted with the
+// passphrase. A legacy encrypted PEM private key (RFC 1423) is rejected, as its encryption is insecure by design.
+func loadKeyPair(certFile, keyFile, passphrase string) (tls.Certificate, error) {
+	if passphrase == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if block, _ := pem.Decode(keyPEM); block != nil {
+		if _, legacy := block.Headers["DEK-Info"]; legacy {
+			return tls.Certificate{}, fmt.Errorf("legacy encrypted PEM private key is not supported, " +
+				"convert it to an encrypted PKCS#8 private key, e.g. with 'openssl pkcs8 -topk8'")
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("failed to decrypt private key: %v", err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("failed to decrypt private key: %v", err)
+			}
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		}
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// newCertPool loads the CA certificates from the given file or from all files in the given directory.
+func newCertPool(rootCert string) (*x509.CertPool, error) {
+	files := []string{rootCert}
+	if info, err := os.Stat(rootCert); err == nil && info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(rootCert, "*")); err != nil {
+			return nil, fmt.Errorf("failed to load CA: %s", err)
+		}
+	}
+	caCertPool := x509.NewCertPool()
+	loaded := false
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			continue
+		}
+		caCert, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA: %s", err)
+		}
+		if caCertPool.AppendCertsFromPEM(caCert) {
+			loaded = true
+		} else if file == rootCert {
+			return nil, fmt.Errorf("failed to parse CA %s", rootCert)
+		}
+	}
+	if !loaded {
+		return nil, fmt.Errorf("failed to parse CA %s", rootCert)
+	}
+	return caCertPool, nil
+}
+
 func supportedCipherSuites() []uint16 {
 	cs := tls.CipherSuites()
 	cid := make([]uint16, len(cs))
@@ -14,10 +14,16 @@ package tls
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/youmark/pkcs8"
 )
 
 const (
@@ -102,3 +108,137 @@ func TestNewTLSConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestNewConfig(t *testing.T) {
+	caPEM, err := os.ReadFile(caCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(caPEM)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		Config        *Config
+		MinVersion    uint16
+		ExpectedError string
+	}{
+		"system_ca":           {Config: &Config{}, MinVersion: tls.VersionTLS12},
+		"ca_directory":        {Config: &Config{CACert: "testdata"}, MinVersion: tls.VersionTLS12},
+		"min_version_1_3":     {Config: &Config{CACert: caCertPath, MinVersion: "1.3"}, MinVersion: tls.VersionTLS13},
+		"client_credentials":  {Config: &Config{Cert: certPath, Key: keyPath}, MinVersion: tls.VersionTLS12},
+		"pinned_key":          {Config: &Config{PinnedKeys: []string{PublicKeyPin(ca)}}, MinVersion: tls.VersionTLS12},
+		"server_name":         {Config: &Config{ServerName: "updates.example.com"}, MinVersion: tls.VersionTLS12},
+		"insecure":            {Config: &Config{InsecureSkipVerify: true}, MinVersion: tls.VersionTLS12},
+		"unsupported_version": {Config: &Config{MinVersion: "1.1"}, ExpectedError: "unsupported TLS version 1.1"},
+		"invalid_pinned_key":  {Config: &Config{PinnedKeys: []string{"invalid"}}, ExpectedError: "invalid public key pin invalid"},
+		"empty_ca_directory":  {Config: &Config{CACert: t.TempDir()}, ExpectedError: "failed to parse CA"},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg, err := NewConfig(testCase.Config)
+			if testCase.ExpectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.ExpectedError) {
+					t.Fatalf("expected error : %s, got: %v", testCase.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.MinVersion != testCase.MinVersion {
+				t.Fatalf("invalid min TLS version %d", cfg.MinVersion)
+			}
+			if (len(testCase.Config.CACert) > 0) != (cfg.RootCAs != nil) {
+				t.Fatalf("unexpected root CAs: %v", cfg.RootCAs)
+			}
+			if (len(testCase.Config.PinnedKeys) > 0) != (cfg.VerifyConnection != nil) {
+				t.Fatal("unexpected pinned keys verification")
+			}
+			if cfg.InsecureSkipVerify != testCase.Config.InsecureSkipVerify {
+				t.Fatalf("unexpected insecure skip verify: %v", cfg.InsecureSkipVerify)
+			}
+			if cfg.ServerName != testCase.Config.ServerName {
+				t.Fatalf("unexpected server name: %s", cfg.ServerName)
+			}
+		})
+	}
+}
+
+func TestVerifyPinnedKeys(t *testing.T) {
+	caPEM, err := os.ReadFile(caCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(caPEM)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfig(&Config{PinnedKeys: []string{PublicKeyPin(ca)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{ca}}); err != nil {
+		t.Fatalf("pinned key not matched: %v", err)
+	}
+	if err := cfg.VerifyConnection(tls.ConnectionState{}); err == nil {
+		t.Fatal("expected error for missing pinned key")
+	}
+}
+
+func TestNewConfigEncryptedKey(t *testing.T) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := pkcs8.MarshalPrivateKey(pair.PrivateKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKeyPath := filepath.Join(t.TempDir(), "encrypted.pem")
+	if err := os.WriteFile(encryptedKeyPath, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	legacy := &pem.Block{Type: "RSA PRIVATE KEY", Headers: map[string]string{
+		"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00000000000000000000000000000000"}, Bytes: encrypted}
+	legacyKeyPath := filepath.Join(t.TempDir(), "legacy.pem")
+	if err := os.WriteFile(legacyKeyPath, pem.EncodeToMemory(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		Key           string
+		KeyPassphrase string
+		ExpectedError string
+	}{
+		"encrypted_key":          {Key: encryptedKeyPath, KeyPassphrase: "secret"},
+		"unencrypted_key":        {Key: keyPath, KeyPassphrase: "secret"},
+		"wrong_passphrase":       {Key: encryptedKeyPath, KeyPassphrase: "wrong", ExpectedError: "failed to decrypt private key"},
+		"missing_passphrase":     {Key: encryptedKeyPath, ExpectedError: "failed to load X509 key pair"},
+		"legacy_encrypted_key":   {Key: legacyKeyPath, KeyPassphrase: "secret", ExpectedError: "legacy encrypted PEM private key is not supported"},
+		"passphrase_missing_key": {Key: nonExisting, KeyPassphrase: "secret", ExpectedError: "failed to load X509 key pair"},
+	}
+
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg, err := NewConfig(&Config{Cert: certPath, Key: testCase.Key, KeyPassphrase: testCase.KeyPassphrase})
+			if testCase.ExpectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.ExpectedError) {
+					t.Fatalf("expected error : %s, got: %v", testCase.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Certificates) != 1 {
+				t.Fatalf("expected client certificate, got: %v", cfg.Certificates)
+			}
+		})
+	}
+}